@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// adhocVault is recorded in the metadata of files encrypted with --adhoc,
+// which belong to no vault.
+const adhocVault = "adhoc"
+
+// encryptAdhocFile encrypts an arbitrary file for explicit recipients without
+// registering it or touching .gitignore.
+func encryptAdhocFile(filePath string) error {
+	if encryptMode != config.ModeValues && encryptMode != config.ModeFull {
+		return fmt.Errorf("invalid mode: %s (must be 'values' or 'full')", encryptMode)
+	}
+
+	// Cached vault keys are a convenience; ad-hoc encryption also works
+	// outside a shhh project using only the local keyring.
+	s, err := store.GetStore()
+	if err != nil {
+		s = nil
+	}
+
+	recipients, err := adhocRecipients(s)
+	if err != nil {
+		return err
+	}
+
+	if len(recipients) == 0 {
+		return fmt.Errorf("--adhoc requires --recipients or --recipients-file")
+	}
+
+	gpg := crypto.GetProvider()
+	for _, r := range recipients {
+		keyInfo, err := gpg.LookupKey(r)
+		if err != nil {
+			return fmt.Errorf("recipient %s: %w", r, err)
+		}
+		if crypto.IsExpired(keyInfo.ExpiresAt) {
+			return fmt.Errorf("recipient %s: key has expired", r)
+		}
+	}
+
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	opts := crypto.EncryptOptions{
+		Vault:      adhocVault,
+		Mode:       encryptMode,
+		Recipients: recipients,
+		Verify:     true,
+	}
+
+	encrypted, err := crypto.EncryptFileContent(content, filePath, opts)
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	outPath := encryptOutput
+	if outPath == "" {
+		outPath = filePath + ".enc"
+	}
+
+	if outPath == "-" {
+		_, err := os.Stdout.Write(encrypted)
+		return err
+	}
+
+	if err := os.WriteFile(outPath, encrypted, 0600); err != nil {
+		return fmt.Errorf("failed to write encrypted file: %w", err)
+	}
+
+	fmt.Printf("Encrypted %s -> %s\n", filePath, outPath)
+	fmt.Printf("  Recipients: %s\n", strings.Join(recipients, ", "))
+	return nil
+}
+
+// adhocRecipients combines --recipients and --recipients-file. Fingerprints
+// are resolved through the users of any vault in the current project.
+func adhocRecipients(s *store.Store) ([]string, error) {
+	recipients := append([]string{}, encryptRecipients...)
+	if encryptRecipientsFile != "" {
+		fromFile, err := config.ReadRecipientsFile(encryptRecipientsFile)
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, fromFile...)
+	}
+
+	var resolved []string
+	seen := make(map[string]bool)
+	for _, r := range recipients {
+		email := r
+		if !strings.Contains(r, "@") {
+			user, err := findUserByFingerprint(s, r)
+			if err != nil {
+				return nil, err
+			}
+			email = user.Email
+		}
+		if !seen[email] {
+			seen[email] = true
+			resolved = append(resolved, email)
+		}
+	}
+
+	return resolved, nil
+}
+
+func findUserByFingerprint(s *store.Store, fingerprint string) (*config.User, error) {
+	if s != nil {
+		vaults, err := s.ListVaults()
+		if err != nil {
+			return nil, err
+		}
+		for _, vaultName := range vaults {
+			vault, err := config.LoadVault(s, vaultName)
+			if err != nil {
+				continue
+			}
+			if user := vault.GetUserByFingerprint(fingerprint); user != nil {
+				return user, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("fingerprint %s does not match any vault user", fingerprint)
+}
+
+// decryptAdhocFile decrypts any shhh-encrypted file, registered or not.
+func decryptAdhocFile(filePath string) error {
+	encPath := filePath
+	if !strings.HasSuffix(encPath, ".enc") {
+		encPath += ".enc"
+	}
+	plainPath := strings.TrimSuffix(encPath, ".enc")
+
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+
+	outPath := decryptOutput
+	if outPath == "" {
+		outPath = plainPath
+	}
+
+	if err := checkPlaintextWrite(outPath); err != nil {
+		return err
+	}
+
+	if !confirmOverwrite(outPath) {
+		return nil
+	}
+
+	decrypted, err := crypto.DecryptFileContent(content, plainPath, parser.Spec{})
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	if err := writePlaintextOutput(outPath, decrypted); err != nil {
+		return err
+	}
+
+	if outPath != "-" {
+		fmt.Printf("Decrypted %s -> %s\n", encPath, outPath)
+	}
+	return nil
+}
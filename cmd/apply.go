@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply <file> [patch]",
+	Short: "Set and remove values of an encrypted file from a patch",
+	Long: `Apply a YAML or JSON patch to a registered file, read from the patch
+file or stdin, and re-encrypt it once with every change:
+
+  set:
+    database.password: n3w-s3cret
+    api.keys.0: k-123
+  remove:
+    - legacy.token
+
+Keys are the dotted paths shown by 'shhh file show --values' (whole keys
+in .env files). Missing keys are created, and a list index one past the
+end appends. If any change cannot be applied, or the result fails the
+file's schema, nothing is written.
+
+The plaintext in the working tree is updated too when it matches the .enc;
+otherwise it is left alone with a warning. Meant for rotation tooling, e.g.
+'rotate-creds | shhh apply config/app.yaml'.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runApply,
+}
+
+func runApply(cmd *cobra.Command, args []string) error {
+	s, fileReg, err := findRegisteredFile(args[0])
+	if err != nil {
+		return err
+	}
+	vault, _, err := config.FindFileVault(s, fileReg.Path)
+	if err != nil {
+		return err
+	}
+	if err := config.CheckVaultWritable(s, vault); err != nil {
+		return err
+	}
+
+	var patchData []byte
+	if len(args) == 2 && args[1] != "-" {
+		patchData, err = os.ReadFile(args[1])
+	} else {
+		patchData, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read patch: %w", err)
+	}
+	patch, err := parser.ParsePatch(patchData)
+	secmem.Wipe(patchData)
+	if err != nil {
+		return err
+	}
+
+	return patchEncryptedFile(s, vault, fileReg, patch, nil,
+		fmt.Sprintf("Applied %d change(s) to %s.enc", len(patch.Set)+len(patch.Remove), fileReg.Path))
+}
+
+// patchEncryptedFile applies patch to a registered file's .enc in one
+// re-encryption, printing done once it is written, and updates the
+// plaintext in the working tree when it matches the .enc. Keys in rotated
+// must exist and are recorded as rotated now.
+func patchEncryptedFile(s *store.Store, vault string, fileReg *config.RegisteredFile, patch *parser.Patch, rotated []string, done string) error {
+	encPath := filepath.Join(s.Root(), fileReg.Path) + ".enc"
+	encContent, err := os.ReadFile(encPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("encrypted file does not exist: %s.enc", fileReg.Path)
+		}
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+
+	decrypted, err := crypto.DecryptFileContent(encContent, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return decryptionError(s, fileReg.Path, err)
+	}
+	defer secmem.Protect(decrypted)()
+
+	if len(rotated) > 0 {
+		values, err := secretValues(fileReg, decrypted)
+		if err != nil {
+			return err
+		}
+		existing := make(map[string]bool, len(values))
+		for _, kv := range values {
+			existing[kv.Key] = true
+		}
+		for _, key := range rotated {
+			if !existing[key] {
+				return fmt.Errorf("key %s not found in %s", key, fileReg.Path)
+			}
+		}
+	}
+
+	patched, err := parser.ApplyPatch(decrypted, fileReg.Path, fileReg.Spec(), patch)
+	if err != nil {
+		return err
+	}
+	defer secmem.Protect(patched)()
+
+	if err := validateSchema(s, fileReg, patched); err != nil {
+		return err
+	}
+	if err := validatePlaintext(s, vault, fileReg, patched); err != nil {
+		return err
+	}
+
+	recipients, err := config.EncryptionRecipients(s, vault, fileReg)
+	if err != nil {
+		return fmt.Errorf("failed to get recipients: %w", err)
+	}
+	opts := encryptOptions(s, vault, fileReg, recipients)
+	if meta, err := crypto.ReadFileMetadata(encContent, encPath, fileReg.Path, fileReg.Spec()); err == nil && meta != nil {
+		opts.FileMode = meta.FileMode
+	}
+	if len(rotated) > 0 {
+		rotations := make(map[string]time.Time, len(opts.Rotations)+len(rotated))
+		for k, at := range opts.Rotations {
+			rotations[k] = at
+		}
+		now := time.Now()
+		for _, key := range rotated {
+			rotations[key] = now
+		}
+		opts.Rotations = rotations
+	}
+
+	encrypted, err := crypto.EncryptFileContent(patched, fileReg.Path, opts)
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+	if err := writeEncFile(s, encPath, fileReg.Path, fileReg.Spec(), encrypted); err != nil {
+		return err
+	}
+
+	fmt.Println(done)
+
+	plainPath := filepath.Join(s.Root(), fileReg.Path)
+	if plaintext, err := os.ReadFile(plainPath); err == nil {
+		if !crypto.SamePlaintext(plaintext, decrypted, fileReg.Path, fileReg.Spec()) {
+			fmt.Fprintf(os.Stderr, "Warning: %s has unencrypted changes and was not updated\n", fileReg.Path)
+		} else if err := checkPlaintextWrite(plainPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s was not updated: %v\n", fileReg.Path, err)
+		} else if err := writePlaintext(s, fileReg.Path, fileReg.Spec(), encrypted, patched); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			fmt.Printf("  Updated %s\n", fileReg.Path)
+		}
+		secmem.Wipe(plaintext)
+	}
+
+	writeBackup(s, vault, fileReg, patched, recipients)
+	syncExample(s, fileReg, patched)
+	return nil
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/backup"
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var backupVault string
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	backupCmd.AddCommand(backupVerifyCmd)
+
+	backupCmd.PersistentFlags().StringVarP(&backupVault, "vault", "v", "", "Only files in this vault")
+}
+
+var backupCmd = &cobra.Command{
+	Use:   "backup [file]...",
+	Short: "Write native .gpg backups of registered files",
+	Long: `Write a .gpg copy of each registered file that standard gpg can decrypt
+without shhh. The copy is encrypted for the file's recipients from the
+content of its .enc file.
+
+Without arguments every file with gpg_copy enabled is backed up; named
+files are backed up regardless of gpg_copy. 'shhh encrypt' and
+'shhh reencrypt' also refresh the backups of files with gpg_copy.
+
+Configure backups with:
+  backup_dir       directory for backups, mirroring registered paths
+  backup_armor     ASCII-armored (true) or binary (false) output
+  backup_metadata  write <backup>.meta with vault, recipients and time`,
+	RunE: runBackup,
+}
+
+var backupVerifyCmd = &cobra.Command{
+	Use:   "verify [file]...",
+	Short: "Check that backups exist and match the .enc files",
+	Long: `Check the backup of every file with gpg_copy enabled (or the named
+files): it must exist, decrypt to the same content as the .enc file, and,
+when backup metadata is written, be encrypted for the file's current vault
+and recipients. Content is only compared when you can decrypt.`,
+	RunE: runBackupVerify,
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return err
+	}
+
+	files, err := backupTargets(s, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("No files to back up (enable gpg_copy or name files)")
+		return nil
+	}
+
+	var errs []error
+	for _, f := range files {
+		if err := backupFile(s, cfg, f); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.file.Path, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+		}
+		return fmt.Errorf("%d file(s) failed to back up", len(errs))
+	}
+	return nil
+}
+
+func backupFile(s *store.Store, cfg *config.Config, f vaultFile) error {
+	encPath := filepath.Join(s.Root(), filepath.FromSlash(f.file.Path)) + ".enc"
+	encContent, err := os.ReadFile(encPath)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+
+	plaintext, err := crypto.DecryptFileContent(encContent, f.file.Path, f.file.Spec())
+	if err != nil {
+		return decryptionError(s, f.file.Path, err)
+	}
+
+	recipients, err := config.EncryptionRecipients(s, f.vault, f.file)
+	if err != nil {
+		return fmt.Errorf("failed to get recipients: %w", err)
+	}
+
+	path, err := backup.Write(s, cfg, f.vault, f.file.Path, plaintext, recipients)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backed up %s -> %s\n", f.file.Path, strings.TrimPrefix(path, s.Root()+string(os.PathSeparator)))
+	return nil
+}
+
+// writeBackup refreshes the backup of a file with gpg_copy enabled after it
+// was encrypted. Failures are reported but do not fail the encryption.
+func writeBackup(s *store.Store, vault string, fileReg *config.RegisteredFile, plaintext []byte, recipients []string) {
+	if !config.GetEffectiveGPGCopy(s, fileReg) {
+		return
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		cfg = config.NewConfig()
+	}
+
+	path, err := backup.Write(s, cfg, vault, fileReg.Path, plaintext, recipients)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: GPG backup of %s failed: %v\n", fileReg.Path, err)
+		return
+	}
+	fmt.Printf("  Updated GPG backup: %s\n", strings.TrimPrefix(path, s.Root()+string(os.PathSeparator)))
+}
+
+func runBackupVerify(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return err
+	}
+
+	files, err := backupTargets(s, args)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Println("No backups to verify (enable gpg_copy or name files)")
+		return nil
+	}
+
+	failed := 0
+	for _, f := range files {
+		r := backup.Verify(s, cfg, f.vault, f.file)
+		status := "ok"
+		if !r.OK() {
+			status = "FAILED"
+			failed++
+		}
+		fmt.Printf("%-6s %s (%s)\n", status, r.Path, r.Backup)
+		for _, p := range r.Problems {
+			fmt.Printf("       - %s\n", p)
+		}
+		for _, w := range r.Warnings {
+			fmt.Printf("       ! %s\n", w)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d backup(s) failed verification", failed, len(files))
+	}
+	fmt.Printf("\nVerified %d backup(s)\n", len(files))
+	return nil
+}
+
+// backupTargets returns the named files, or every file with gpg_copy
+// enabled when none are named, limited to --vault when set.
+func backupTargets(s *store.Store, args []string) ([]vaultFile, error) {
+	var files []vaultFile
+
+	if len(args) > 0 {
+		for _, arg := range args {
+			relPath, err := projectRelPath(s, strings.TrimSuffix(arg, ".enc"))
+			if err != nil {
+				return nil, err
+			}
+			vault, fileReg, err := config.FindFileVault(s, relPath)
+			if err != nil {
+				return nil, err
+			}
+			if backupVault == "" || vault == backupVault {
+				files = append(files, vaultFile{vault: vault, file: fileReg})
+			}
+		}
+		return files, nil
+	}
+
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+	for _, vaultName := range vaults {
+		if backupVault != "" && vaultName != backupVault {
+			continue
+		}
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+		for i := range vault.Files {
+			if config.GetEffectiveGPGCopy(s, &vault.Files[i]) {
+				files = append(files, vaultFile{vault: vaultName, file: &vault.Files[i]})
+			}
+		}
+	}
+	return files, nil
+}
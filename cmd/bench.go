@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	benchVault      string
+	benchKeys       []int
+	benchValueSize  int
+	benchIterations int
+	benchDecrypt    bool
+)
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().StringVarP(&benchVault, "vault", "v", "", "Vault whose users are the recipients (default: default vault)")
+	benchCmd.Flags().IntSliceVar(&benchKeys, "keys", []int{1, 10, 100}, "Key counts of the synthetic files")
+	benchCmd.Flags().IntVar(&benchValueSize, "value-size", 32, "Size of each synthetic value in bytes")
+	benchCmd.Flags().IntVarP(&benchIterations, "iterations", "n", 3, "Runs per measurement")
+	benchCmd.Flags().BoolVar(&benchDecrypt, "decrypt", true, "Also measure decryption (needs a private key for a recipient)")
+}
+
+var benchCmd = &cobra.Command{
+	Use:   "bench",
+	Short: "Measure encryption throughput",
+	Long: `Encrypt synthetic YAML files of varying key counts in values mode and
+full mode with the active GPG provider, and report time per file, values
+per second, and throughput. Nothing is written to disk.
+
+Values mode runs one public-key operation per value, full mode one per file,
+so the numbers help decide between modes for large files.`,
+	RunE: runBench,
+}
+
+type benchResult struct {
+	encrypt time.Duration
+	decrypt time.Duration
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	if benchIterations < 1 {
+		return fmt.Errorf("--iterations must be at least 1")
+	}
+	// Synthetic content holds no secrets worth auditing.
+	crypto.SetDecryptionRecorder(nil)
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	vaultName := benchVault
+	if vaultName == "" {
+		vaultName = cfg.DefaultVault
+	}
+	vault, err := config.LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	recipients := vault.Emails()
+	if len(recipients) == 0 {
+		return fmt.Errorf("vault %q has no users to encrypt for", vaultName)
+	}
+
+	fmt.Printf("Recipients: %d (vault %s), %d run(s) each\n\n", len(recipients), vaultName, benchIterations)
+	fmt.Printf("%-6s %6s %10s %12s %12s %12s %12s\n", "MODE", "KEYS", "SIZE", "ENCRYPT", "VALUES/S", "THROUGHPUT", "DECRYPT")
+
+	decrypt := benchDecrypt
+	for _, keys := range benchKeys {
+		if keys < 1 {
+			return fmt.Errorf("--keys must be positive")
+		}
+		content := syntheticYAML(keys, benchValueSize)
+
+		for _, mode := range []string{config.ModeValues, config.ModeFull} {
+			opts := crypto.EncryptOptions{Vault: vaultName, Mode: mode, Recipients: recipients}
+			r, err := benchFile(content, opts, decrypt)
+			if err != nil {
+				return fmt.Errorf("%s mode, %d keys: %w", mode, keys, err)
+			}
+
+			dec := "-"
+			if decrypt {
+				if r.decrypt < 0 {
+					fmt.Println("Note: decryption skipped (no private key for any recipient)")
+					decrypt = false
+				} else {
+					dec = formatDuration(r.decrypt)
+				}
+			}
+
+			perSecond := float64(keys) / r.encrypt.Seconds()
+			throughput := float64(len(content)) / r.encrypt.Seconds()
+			fmt.Printf("%-6s %6d %10s %12s %12.0f %10s/s %12s\n",
+				mode, keys, formatBytes(int64(len(content))), formatDuration(r.encrypt), perSecond,
+				formatBytes(int64(throughput)), dec)
+		}
+	}
+
+	return nil
+}
+
+// benchFile returns the mean encryption (and decryption) time of content
+// over benchIterations runs. decrypt is negative when decryption fails.
+func benchFile(content []byte, opts crypto.EncryptOptions, decrypt bool) (benchResult, error) {
+	var r benchResult
+	for i := 0; i < benchIterations; i++ {
+		start := time.Now()
+		encrypted, err := crypto.EncryptFileContent(content, "bench.yaml", opts)
+		if err != nil {
+			return r, err
+		}
+		r.encrypt += time.Since(start)
+
+		if decrypt && r.decrypt >= 0 {
+			start = time.Now()
+			if _, err := crypto.DecryptFileContent(encrypted, "bench.yaml", parser.Spec{}); err != nil {
+				r.decrypt = -1
+				continue
+			}
+			r.decrypt += time.Since(start)
+		}
+	}
+
+	r.encrypt /= time.Duration(benchIterations)
+	if r.decrypt > 0 {
+		r.decrypt /= time.Duration(benchIterations)
+	}
+	return r, nil
+}
+
+// syntheticYAML builds a flat YAML document of keys random hex values.
+func syntheticYAML(keys, valueSize int) []byte {
+	var b strings.Builder
+	buf := make([]byte, (valueSize+1)/2)
+	for i := 0; i < keys; i++ {
+		rand.Read(buf)
+		fmt.Fprintf(&b, "key_%d: %s\n", i, hex.EncodeToString(buf)[:valueSize])
+	}
+	return []byte(b.String())
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	case d >= time.Millisecond:
+		return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+	default:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	}
+}
@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(blameCmd)
+}
+
+var blameCmd = &cobra.Command{
+	Use:   "blame <file>",
+	Short: "Show who last changed each value of an encrypted file",
+	Long: `Show, for every key of a registered file, the commit that last changed its
+decrypted value: hash, author, date and subject.
+
+git blame on an .enc file is not useful, since re-encryption rewrites every
+value. Instead, each committed version of the .enc file is decrypted in
+memory and values are compared, so re-encryptions and recipient changes are
+skipped. Only digests of the values are kept, and a value whose ciphertext
+is unchanged from an earlier version is not decrypted again. Keys whose
+value in the working tree differs from the last commit are shown as
+"Not committed". Versions you cannot decrypt are skipped with a
+warning, and their changes are attributed to the next readable commit.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBlame,
+}
+
+// blameEntry is the change that gave a key its current value.
+type blameEntry struct {
+	commit *git.Commit
+	digest string
+}
+
+func runBlame(cmd *cobra.Command, args []string) error {
+	s, fileReg, err := findRegisteredFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	encRel := fileReg.Path + ".enc"
+	commits, err := git.FileLog(s.Root(), filepath.FromSlash(encRel))
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return fmt.Errorf("%s has no git history", encRel)
+	}
+
+	blame := make(map[string]blameEntry)
+	var order []string
+	for i := len(commits) - 1; i >= 0; i-- {
+		c := &commits[i]
+		content, err := git.Show(s.Root(), c.Hash, filepath.FromSlash(encRel))
+		if err != nil {
+			// The file was deleted in this commit.
+			blame = make(map[string]blameEntry)
+			order = nil
+			continue
+		}
+		values, err := valueDigests(fileReg, content)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", shortHash(c.Hash), err)
+			continue
+		}
+		blame, order = applyVersion(blame, values, c)
+	}
+
+	// Attribute uncommitted changes in the working tree.
+	if content, err := os.ReadFile(filepath.Join(s.Root(), filepath.FromSlash(encRel))); err == nil {
+		if head, err := git.Show(s.Root(), "HEAD", filepath.FromSlash(encRel)); err != nil || !bytes.Equal(content, head) {
+			if values, err := valueDigests(fileReg, content); err == nil {
+				blame, order = applyVersion(blame, values, nil)
+			}
+		}
+	}
+
+	for _, key := range order {
+		e := blame[key]
+		if e.commit == nil {
+			fmt.Printf("%-8s %-10s %-20s %s\n", "00000000", "", "Not committed", key)
+			continue
+		}
+		fmt.Printf("%-8s %-10s %-20s %s  (%s)\n",
+			shortHash(e.commit.Hash), e.commit.Date.Format("2006-01-02"), e.commit.Author, key, e.commit.Subject)
+	}
+	return nil
+}
+
+// applyVersion attributes keys that are new or changed in values to c and
+// drops keys that were removed. It returns the keys in document order.
+func applyVersion(blame map[string]blameEntry, values []parser.KeyValue, c *git.Commit) (map[string]blameEntry, []string) {
+	next := make(map[string]blameEntry, len(values))
+	order := make([]string, 0, len(values))
+	for _, kv := range values {
+		if prev, ok := blame[kv.Key]; ok && prev.digest == kv.Value {
+			next[kv.Key] = prev
+		} else {
+			next[kv.Key] = blameEntry{commit: c, digest: kv.Value}
+		}
+		order = append(order, kv.Key)
+	}
+	return next, order
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
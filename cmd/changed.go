@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+type vaultFile struct {
+	vault string
+	file  *config.RegisteredFile
+}
+
+// changedRegisteredFiles returns registered files whose plaintext or .enc was
+// touched according to git (working tree changes, plus the branch diff
+// against base when set). Plaintext is normally gitignored, so with
+// includeLocal a file also counts as changed when its plaintext is newer than
+// its .enc or the .enc does not exist yet.
+func changedRegisteredFiles(s *store.Store, vaultName, base string, includeLocal bool) ([]vaultFile, error) {
+	changed, err := git.ChangedFiles(s.Root(), base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files: %w", err)
+	}
+
+	var vaults []string
+	if vaultName != "" {
+		if !s.VaultExists(vaultName) {
+			return nil, fmt.Errorf("vault %q does not exist", vaultName)
+		}
+		vaults = []string{vaultName}
+	} else {
+		vaults, err = s.ListVaults()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var files []vaultFile
+	for _, v := range vaults {
+		vault, err := config.LoadVault(s, v)
+		if err != nil {
+			continue
+		}
+		for i := range vault.Files {
+			f := &vault.Files[i]
+			if changed[f.Path] || changed[f.Path+".enc"] || (includeLocal && plaintextNewer(s, f)) {
+				files = append(files, vaultFile{vault: v, file: f})
+			}
+		}
+	}
+
+	return files, nil
+}
+
+func plaintextNewer(s *store.Store, fileReg *config.RegisteredFile) bool {
+	plainPath := filepath.Join(s.Root(), fileReg.Path)
+
+	plainInfo, err := os.Stat(plainPath)
+	if err != nil {
+		return false
+	}
+
+	encInfo, err := os.Stat(plainPath + ".enc")
+	if err != nil {
+		return true
+	}
+
+	return plainInfo.ModTime().After(encInfo.ModTime())
+}
@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ciExportFormat  string
+	ciExportPrefix  string
+	ciExportAll     bool
+	ciVerifyChanged bool
+	ciVerifyBase    string
+	ciVerifyBare    string
+	ciVerifyRev     string
+)
+
+func init() {
+	rootCmd.AddCommand(ciCmd)
+	ciCmd.AddCommand(ciExportCmd)
+	ciCmd.AddCommand(ciVerifyCmd)
+
+	ciExportCmd.Flags().StringVarP(&ciExportFormat, "format", "f", "github", "CI system: github or gitlab")
+	ciExportCmd.Flags().StringVarP(&ciExportPrefix, "prefix", "p", "", "Prefix for generated variable names")
+	ciExportCmd.Flags().BoolVarP(&ciExportAll, "all", "a", false, "Export all registered files")
+
+	ciVerifyCmd.Flags().BoolVar(&ciVerifyChanged, "changed", false, "Only verify registered files changed according to git")
+	ciVerifyCmd.Flags().StringVar(&ciVerifyBase, "base", "", "With --changed, include files changed on this branch since <base> (e.g. origin/main)")
+	ciVerifyCmd.Flags().StringVar(&ciVerifyBare, "bare", "", "Verify a commit of this (bare) git repository instead of the working copy")
+	ciVerifyCmd.Flags().StringVar(&ciVerifyRev, "rev", "HEAD", "With --bare, the commit to verify")
+}
+
+var ciCmd = &cobra.Command{
+	Use:   "ci",
+	Short: "CI/CD integration helpers",
+	Long:  `Commands for integrating shhh-managed secrets with CI/CD systems.`,
+}
+
+var ciExportCmd = &cobra.Command{
+	Use:   "export [file]...",
+	Short: "Print commands that sync secrets into CI variables",
+	Long: `Decrypt registered files and print the commands that store each value
+as a CI variable. Values are piped in from the printf shell builtin, so
+they never appear in the arguments of a process:
+
+  github: printf '%s' VALUE | gh secret set NAME
+  gitlab: printf '%s' VALUE | glab variable set NAME --masked
+
+Variable names are derived from key paths (database.password becomes
+DATABASE_PASSWORD). Review the output, then pipe it to a shell to apply it.
+GitLab only masks values of 8+ characters from a restricted alphabet; values
+that cannot be masked are emitted without --masked and flagged in a comment.`,
+	RunE: runCIExport,
+}
+
+var ciVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check that registered files are safely encrypted",
+	Long: `Verify every registered file without needing a private key:
+
+- the .enc file exists and carries shhh metadata
+- values-mode files contain no plaintext values
+- the .enc was encrypted for the current recipients
+- the plaintext file is not tracked by git, in the index or in HEAD
+
+Use --changed to only check files touched in git, and --base <ref> to
+include everything changed on the branch (e.g. --base origin/main).
+Exits non-zero if any check fails.
+
+Use --bare <repo.git> --rev <sha> to verify a commit straight from a git
+repository, e.g. in a pre-receive hook on the server, where there is no
+working copy. The project must be at the repository root, and recipients
+are not checked since public keys may not be available there:
+
+  while read old new ref; do
+    [ "$new" = 0000000000000000000000000000000000000000 ] && continue
+    shhh ci verify --bare . --rev "$new" || exit 1
+  done`,
+	RunE: runCIVerify,
+}
+
+func runCIExport(cmd *cobra.Command, args []string) error {
+	if ciExportFormat != "github" && ciExportFormat != "gitlab" {
+		return fmt.Errorf("invalid format: %s (must be 'github' or 'gitlab')", ciExportFormat)
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var files []*config.RegisteredFile
+	if ciExportAll {
+		vaults, err := s.ListVaults()
+		if err != nil {
+			return err
+		}
+		for _, vaultName := range vaults {
+			vault, err := config.LoadVault(s, vaultName)
+			if err != nil {
+				return fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+			}
+			for i := range vault.Files {
+				files = append(files, &vault.Files[i])
+			}
+		}
+	} else {
+		if len(args) == 0 {
+			return fmt.Errorf("specify a file or --all")
+		}
+		for _, arg := range args {
+			_, fileReg, err := resolveRegisteredFile(s, arg)
+			if err != nil {
+				return err
+			}
+			files = append(files, fileReg)
+		}
+	}
+
+	seen := make(map[string]string)
+	for _, fileReg := range files {
+		decrypted, err := decryptRegisteredFile(s, fileReg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileReg.Path, err)
+		}
+
+		values, err := secretValues(fileReg, decrypted)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileReg.Path, err)
+		}
+
+		fmt.Printf("# %s\n", fileReg.Path)
+		for _, v := range values {
+			name := ciVariableName(ciExportPrefix + v.Key)
+			if prev, ok := seen[name]; ok {
+				return fmt.Errorf("variable name collision for %s (%s and %s)", name, prev, fileReg.Path)
+			}
+			seen[name] = fileReg.Path
+
+			// printf is a shell builtin, so the value reaches the CLI on
+			// stdin without appearing in any process's arguments.
+			value := fmt.Sprintf("printf '%%s' %s | ", shellQuote(v.Value))
+			switch ciExportFormat {
+			case "github":
+				fmt.Printf("%sgh secret set %s\n", value, name)
+			case "gitlab":
+				if gitlabMaskable(v.Value) {
+					fmt.Printf("%sglab variable set %s --masked\n", value, name)
+				} else {
+					fmt.Printf("# %s cannot be masked by GitLab (needs 8+ characters from [A-Za-z0-9+/=@:.~_-])\n", name)
+					fmt.Printf("%sglab variable set %s\n", value, name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func runCIVerify(cmd *cobra.Command, args []string) error {
+	if ciVerifyBare != "" {
+		if ciVerifyChanged {
+			return fmt.Errorf("--bare cannot be combined with --changed")
+		}
+		return verifyBareRepo(ciVerifyBare, ciVerifyRev)
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var files []vaultFile
+	if ciVerifyChanged {
+		files, err = changedRegisteredFiles(s, "", ciVerifyBase, false)
+		if err != nil {
+			return err
+		}
+	} else {
+		vaults, err := s.ListVaults()
+		if err != nil {
+			return err
+		}
+		for _, vaultName := range vaults {
+			vault, err := config.LoadVault(s, vaultName)
+			if err != nil {
+				return fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+			}
+			for i := range vault.Files {
+				files = append(files, vaultFile{vault: vaultName, file: &vault.Files[i]})
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		fmt.Println("No files to verify")
+		return nil
+	}
+
+	tracked := gitTrackedFiles(s)
+	var committed []string
+	results := make([]verifyResult, len(files))
+	for i, f := range files {
+		results[i] = verifyResult{path: f.file.Path, problems: verifyRegisteredFile(s, f.vault, f.file, tracked)}
+		if tracked[filepath.ToSlash(f.file.Path)] {
+			committed = append(committed, f.file.Path)
+		}
+	}
+	if len(committed) > 0 {
+		fireHook(s, hooks.Event{Name: hooks.PlaintextCommitted, Files: committed})
+	}
+	return reportVerification(results)
+}
+
+// verifyResult holds the problems found with one registered file.
+type verifyResult struct {
+	path     string
+	problems []string
+}
+
+// reportVerification prints the ci verify results and fails if any file
+// has problems.
+func reportVerification(results []verifyResult) error {
+	failed := 0
+	for _, r := range results {
+		if len(r.problems) == 0 {
+			fmt.Printf("✓ %s\n", r.path)
+			continue
+		}
+		failed++
+		for _, p := range r.problems {
+			fmt.Printf("✗ %s: %s\n", r.path, p)
+		}
+	}
+
+	fmt.Printf("\nVerified %d file(s), %d failed\n", len(results), failed)
+	if failed > 0 {
+		return fmt.Errorf("%d file(s) failed verification", failed)
+	}
+	return nil
+}
+
+// verifyRegisteredFile runs the ci verify checks for one file and returns a
+// description of each problem found.
+func verifyRegisteredFile(s *store.Store, vault string, fileReg *config.RegisteredFile, tracked map[string]bool) []string {
+	var problems []string
+
+	if tracked[filepath.ToSlash(fileReg.Path)] {
+		problems = append(problems, "plaintext is tracked by git")
+	}
+
+	encPath := filepath.Join(s.Root(), fileReg.Path) + ".enc"
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		return append(problems, "missing .enc file")
+	}
+
+	meta, err := crypto.ReadFileMetadata(content, encPath, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		meta = nil
+	}
+	problems = append(problems, encryptionProblems(content, meta, fileReg.Path, fileReg.Spec())...)
+	if meta == nil {
+		return problems
+	}
+
+	if stale, err := hasStaleRecipients(s, vault, fileReg); err == nil && stale {
+		problems = append(problems, "encrypted for outdated recipients (run 'shhh reencrypt')")
+	}
+
+	return problems
+}
+
+// encryptionProblems checks that an .enc file carries shhh metadata and, in
+// values mode, holds no plaintext values.
+func encryptionProblems(content []byte, meta *crypto.FileMetadata, relPath string, spec parser.Spec) []string {
+	if meta == nil {
+		return []string{"not a shhh-encrypted file"}
+	}
+	if crypto.IsFullyEncrypted(content) {
+		return nil
+	}
+
+	var problems []string
+	values, err := parser.FlattenFile(content, relPath, spec)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to parse: %v", err))
+	}
+	for _, v := range values {
+		if v.Value != "" && !v.Literal && !parser.IsEncrypted(v.Value) {
+			problems = append(problems, fmt.Sprintf("plaintext value at %s", v.Key))
+		}
+	}
+	return problems
+}
+
+// verifyBareRepo runs the ci verify checks against the tree of rev in a git
+// repository, reading the vaults and .enc files from git objects.
+func verifyBareRepo(repo, rev string) error {
+	if strings.HasPrefix(rev, "-") {
+		return fmt.Errorf("invalid revision %q", rev)
+	}
+
+	tree, err := git.TreeFiles(repo, rev)
+	if err != nil {
+		return err
+	}
+	inTree := make(map[string]bool, len(tree))
+	for _, f := range tree {
+		inTree[f] = true
+	}
+
+	vaultsDir := path.Join(store.ShhhDir, store.VaultsDir) + "/"
+	initialized := false
+	var results []verifyResult
+	for _, f := range tree {
+		if strings.HasPrefix(f, store.ShhhDir+"/") {
+			initialized = true
+		}
+		rest, ok := strings.CutPrefix(f, vaultsDir)
+		if !ok || strings.Count(rest, "/") != 1 || path.Base(rest) != store.VaultFile {
+			continue
+		}
+
+		vaultName := path.Dir(rest)
+		data, err := git.ShowBlob(repo, rev, f)
+		if err != nil {
+			return err
+		}
+		vault, err := config.ParseVault(vaultName, data)
+		if err != nil {
+			return fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		for _, fileReg := range vault.Files {
+			results = append(results, verifyResult{path: fileReg.Path, problems: verifyCommittedFile(repo, rev, inTree, fileReg.Path, fileReg.Spec())})
+		}
+	}
+
+	if !initialized {
+		return fmt.Errorf("no %s directory at the root of %s at %s", store.ShhhDir, repo, rev)
+	}
+	if len(results) == 0 {
+		fmt.Println("No files to verify")
+		return nil
+	}
+	return reportVerification(results)
+}
+
+// verifyCommittedFile runs the ci verify checks for one registered file in
+// the tree of rev, except the recipient check.
+func verifyCommittedFile(repo, rev string, inTree map[string]bool, relPath string, spec parser.Spec) []string {
+	var problems []string
+
+	if inTree[relPath] {
+		problems = append(problems, "plaintext is committed")
+	}
+
+	encPath := relPath + ".enc"
+	if !inTree[encPath] {
+		return append(problems, "missing .enc file")
+	}
+	content, err := git.ShowBlob(repo, rev, encPath)
+	if err != nil {
+		return append(problems, fmt.Sprintf("failed to read .enc file: %v", err))
+	}
+
+	meta, err := crypto.GetFileMetadata(content, relPath, spec)
+	if err == nil && meta == nil && inTree[crypto.SidecarPath(encPath)] {
+		if data, err := git.ShowBlob(repo, rev, crypto.SidecarPath(encPath)); err == nil {
+			meta, _ = crypto.ParseSidecarMetadata(data)
+		}
+	}
+	if err != nil {
+		meta = nil
+	}
+	return append(problems, encryptionProblems(content, meta, relPath, spec)...)
+}
+
+var ciNameInvalid = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// ciVariableName converts a key path into an environment-style variable name.
+func ciVariableName(key string) string {
+	name := ciNameInvalid.ReplaceAllString(strings.ToUpper(key), "_")
+	name = strings.Trim(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+var gitlabMaskablePattern = regexp.MustCompile(`^[A-Za-z0-9+/=@:.~_-]{8,}$`)
+
+func gitlabMaskable(value string) bool {
+	return gitlabMaskablePattern.MatchString(value)
+}
+
+// shellQuote quotes a value for safe use as a single POSIX shell word.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -2,9 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"os"
 	"sort"
+	"strconv"
 
 	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/hooks"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
@@ -75,6 +80,43 @@ func runConfigSet(cmd *cobra.Command, args []string) error {
 	}
 
 	key, value := args[0], args[1]
+	if key == "default_vault" {
+		if !s.VaultExists(value) {
+			return fmt.Errorf("vault %q does not exist", value)
+		}
+		if err := config.CheckVaultWritable(s, value); err != nil {
+			return err
+		}
+	}
+	if key == "metadata_privacy" && value != crypto.PrivacyNone && value != crypto.PrivacyHash && value != crypto.PrivacyOmit {
+		return fmt.Errorf("invalid metadata_privacy %q (use none, hash, or omit)", value)
+	}
+	if key == "encrypted_at" && value != crypto.TimestampPrecise && value != crypto.TimestampDay && value != crypto.TimestampOmit && value != crypto.TimestampCounter {
+		return fmt.Errorf("invalid encrypted_at %q (use precise, day, omit, or counter)", value)
+	}
+	if key == "compression" && value != crypto.CompressionNone && value != crypto.CompressionZstd {
+		return fmt.Errorf("invalid compression %q (use none or zstd)", value)
+	}
+	if key == "metadata" && value != config.MetadataEmbedded && value != config.MetadataSidecar {
+		return fmt.Errorf("invalid metadata %q (use embedded or sidecar)", value)
+	}
+	if key == "provider" && value != crypto.ProviderAuto && value != crypto.ProviderNative && value != crypto.ProviderCLI {
+		return fmt.Errorf("invalid provider %q (use auto, native, or cli)", value)
+	}
+	if key == "edit_tmpfile" && value != config.EditTmpfileAuto && value != config.EditTmpfileDir && value != config.EditTmpfileMemfd {
+		return fmt.Errorf("invalid edit_tmpfile %q (use auto, dir, or memfd)", value)
+	}
+	if key == "readonly" && value != "true" && value != "false" && value != config.ReadonlyCI {
+		return fmt.Errorf("invalid readonly %q (use true, false, or ci)", value)
+	}
+	if key == "store_git" && value != config.StoreGitTracked && value != config.StoreGitIgnored && value != config.StoreGitAny {
+		return fmt.Errorf("invalid store_git %q (use tracked, ignored, or any)", value)
+	}
+	if key == "rotation_days" || key == "expiry_warning_days" {
+		if days, err := strconv.Atoi(value); err != nil || days < 0 {
+			return fmt.Errorf("invalid %s %q (use a number of days, or 0 to disable)", key, value)
+		}
+	}
 	if !cfg.Set(key, value) {
 		return fmt.Errorf("unknown or read-only config key: %s", key)
 	}
@@ -83,7 +125,23 @@ func runConfigSet(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
+	if key == "git_merge_driver" {
+		if err := gitignore.EnsureAttributes(s.Root(), cfg.GitMergeDriver); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update .gitattributes: %v\n", err)
+		}
+	}
+
 	fmt.Printf("Set %s = %s\n", key, value)
+
+	// Whoever sets a command setting has reviewed it, so it is trusted on
+	// this machine like a hook set with 'shhh file set-hook'.
+	for _, cs := range cfg.CommandSettings() {
+		if cs.Key == key {
+			if err := hooks.Trust(s.Root(), cs.String()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to trust %s on this machine: %v\n", key, err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,219 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/scan"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	coverageJSON   bool
+	coverageReason string
+)
+
+func init() {
+	rootCmd.AddCommand(coverageCmd)
+	coverageCmd.AddCommand(coverageAllowCmd)
+
+	coverageCmd.Flags().BoolVar(&coverageJSON, "json", false, "Output as JSON")
+	coverageAllowCmd.Flags().StringVarP(&coverageReason, "reason", "r", "", "Why the files are not secrets, recorded next to the pattern")
+}
+
+var coverageCmd = &cobra.Command{
+	Use:   "coverage [dir]",
+	Short: "Check that every secret-looking file is managed by shhh",
+	Long: `Compare the files 'shhh scan' flags as secret-looking against the
+registered files and fail if any of them is neither registered nor on the
+allowlist. Run it in CI so a new service cannot add a plaintext secret
+without anyone noticing.
+
+The allowlist, .shhh/coverage-allow, holds one gitignore-style pattern per
+line for files that look like secrets but are not, such as test fixtures,
+optionally followed by " # reason". Add to it with 'shhh coverage allow'.
+Unlike .shhhignore, allowed files are still scanned and listed, and entries
+that no longer match any file are reported so the list does not rot.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runCoverage,
+}
+
+var coverageAllowCmd = &cobra.Command{
+	Use:   "allow <pattern>...",
+	Short: "Accept secret-looking files that are not secrets",
+	Long: `Add gitignore-style patterns to .shhh/coverage-allow, so 'shhh coverage'
+accepts the files they match without registration. Commit the allowlist so
+CI uses it too.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runCoverageAllow,
+}
+
+type coverageFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type coverageReport struct {
+	Registered int            `json:"registered"`
+	Unmanaged  []coverageFile `json:"unmanaged"`
+	Allowed    []coverageFile `json:"allowed"`
+	Unused     []string       `json:"unused_allowlist_entries"`
+	Coverage   float64        `json:"coverage"`
+}
+
+func runCoverage(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	relDir, err := projectRelPath(s, dir)
+	if err != nil {
+		return err
+	}
+
+	report, err := buildCoverageReport(s, relDir)
+	if err != nil {
+		return err
+	}
+
+	if coverageJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		printCoverageReport(report)
+	}
+
+	if len(report.Unmanaged) > 0 {
+		return fmt.Errorf("%d secret-looking file(s) are not managed by shhh", len(report.Unmanaged))
+	}
+	return nil
+}
+
+func buildCoverageReport(s *store.Store, relDir string) (*coverageReport, error) {
+	registered, err := registeredPaths(s)
+	if err != nil {
+		return nil, err
+	}
+	allowlist, err := scan.LoadAllowlist(s)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &coverageReport{Unmanaged: []coverageFile{}, Allowed: []coverageFile{}, Unused: []string{}}
+	prefix := config.NormalizePath(relDir) + "/"
+	for path := range registered {
+		if relDir == "." || strings.HasPrefix(path, prefix) {
+			report.Registered++
+		}
+	}
+
+	err = scan.Walk(s.Root(), relDir, scan.LoadIgnore(s.Root()), func(relPath string) error {
+		if registered[config.NormalizePath(relPath)] {
+			return nil
+		}
+		reason, ok := scan.Candidate(s.Root(), relPath)
+		if !ok {
+			return nil
+		}
+		if entry, ok := allowlist.Match(filepath.ToSlash(relPath)); ok {
+			allowed := entry.Reason
+			if allowed == "" {
+				allowed = "allowed by " + entry.Pattern
+			}
+			report.Allowed = append(report.Allowed, coverageFile{Path: relPath, Reason: allowed})
+			return nil
+		}
+		report.Unmanaged = append(report.Unmanaged, coverageFile{Path: relPath, Reason: reason})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", relDir, err)
+	}
+
+	// Entries for other directories are not checked when only part of the
+	// project was scanned.
+	if relDir == "." {
+		for _, entry := range allowlist.Unused() {
+			report.Unused = append(report.Unused, entry.Pattern)
+		}
+	}
+
+	if total := report.Registered + len(report.Unmanaged); total > 0 {
+		report.Coverage = float64(report.Registered) / float64(total)
+	} else {
+		report.Coverage = 1
+	}
+	return report, nil
+}
+
+func printCoverageReport(r *coverageReport) {
+	fmt.Printf("Registered: %d file(s)\n", r.Registered)
+	fmt.Printf("Coverage:   %.0f%%\n", r.Coverage*100)
+
+	if len(r.Allowed) > 0 {
+		fmt.Printf("\nAllowed (%d):\n", len(r.Allowed))
+		for _, f := range r.Allowed {
+			fmt.Printf("  %s (%s)\n", f.Path, f.Reason)
+		}
+	}
+	if len(r.Unused) > 0 {
+		fmt.Printf("\nAllowlist entries matching no file (%d):\n", len(r.Unused))
+		for _, pattern := range r.Unused {
+			fmt.Printf("  %s\n", pattern)
+		}
+	}
+	if len(r.Unmanaged) > 0 {
+		fmt.Printf("\nNot managed by shhh (%d):\n", len(r.Unmanaged))
+		for _, f := range r.Unmanaged {
+			fmt.Printf("  %s (%s)\n", f.Path, f.Reason)
+		}
+		fmt.Println("\nRegister them with 'shhh register <file>', or accept them with 'shhh coverage allow <file> --reason ...'")
+	}
+}
+
+func runCoverageAllow(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	if strings.Contains(coverageReason, "\n") {
+		return fmt.Errorf("reason must be a single line")
+	}
+	allowlist, err := scan.LoadAllowlist(s)
+	if err != nil {
+		return err
+	}
+	for _, pattern := range args {
+		if strings.ContainsAny(pattern, "\n#") {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+		duplicate := false
+		for _, entry := range allowlist.Entries {
+			if entry.Pattern == pattern {
+				duplicate = true
+			}
+		}
+		if duplicate {
+			fmt.Printf("%s is already allowed\n", pattern)
+			continue
+		}
+		if err := scan.AddAllowed(s, pattern, coverageReason); err != nil {
+			return err
+		}
+		fmt.Printf("Allowed %s\n", pattern)
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,14 +10,20 @@ import (
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/schema"
+	"github.com/cychiuae/shhh/internal/secmem"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
 var (
-	decryptVault string
-	decryptAll   bool
-	decryptForce bool
+	decryptVault  string
+	decryptAll    bool
+	decryptForce  bool
+	decryptOutput string
+	decryptAdhoc  bool
 )
 
 func init() {
@@ -25,6 +32,9 @@ func init() {
 	decryptCmd.Flags().StringVarP(&decryptVault, "vault", "v", "", "Decrypt files in specific vault")
 	decryptCmd.Flags().BoolVarP(&decryptAll, "all", "a", false, "Decrypt all registered files")
 	decryptCmd.Flags().BoolVarP(&decryptForce, "force", "f", false, "Overwrite existing plaintext files")
+	decryptCmd.Flags().StringVarP(&decryptOutput, "output", "o", "", "Write plaintext to this path instead of next to the .enc ('-' for stdout)")
+	decryptCmd.Flags().BoolVar(&decryptAdhoc, "adhoc", false, "Decrypt an unregistered .enc file")
+	addReportFlag(decryptCmd)
 }
 
 var decryptCmd = &cobra.Command{
@@ -33,17 +43,35 @@ var decryptCmd = &cobra.Command{
 	Long: `Decrypt an encrypted file to its plaintext form.
 
 Use --vault to decrypt all files in a specific vault.
-Use --all to decrypt all registered files across all vaults.
-Use --force to overwrite existing plaintext files without prompting.`,
+Use --all to decrypt all registered files across all vaults. Both end with a
+table of each file's outcome; use --report to also write it as JSON.
+Use --force to overwrite existing plaintext files without prompting.
+Use --output to write a single file's plaintext elsewhere ('-' for stdout),
+e.g. into tmpfs for deployments.
+Use --adhoc to decrypt a file produced by 'shhh encrypt --adhoc'.`,
 	RunE: runDecrypt,
 }
 
 func runDecrypt(cmd *cobra.Command, args []string) error {
+	if decryptAdhoc {
+		if len(args) != 1 {
+			return fmt.Errorf("--adhoc requires exactly one file")
+		}
+		return decryptAdhocFile(args[0])
+	}
+
 	s, err := store.GetStore()
 	if err != nil {
 		return err
 	}
 
+	if decryptOutput != "" && (decryptAll || decryptVault != "") {
+		return fmt.Errorf("--output can only be used with a single file")
+	}
+	if bulkReport != "" && !decryptAll && decryptVault == "" {
+		return fmt.Errorf("--report requires --vault or --all")
+	}
+
 	if decryptAll {
 		return decryptAllFiles(s)
 	}
@@ -77,6 +105,10 @@ func decryptSingleFile(s *store.Store, filePath string) error {
 		return err
 	}
 
+	if decryptOutput != "" {
+		return decryptFileToOutput(s, fileReg, decryptOutput)
+	}
+
 	return decryptFile(s, vault, fileReg)
 }
 
@@ -128,21 +160,11 @@ func decryptVaultFiles(s *store.Store, vaultName string) error {
 		}
 	}
 
-	var errs []error
+	results := newBulkResults("decrypt", "decrypt")
 	for _, entry := range toDecrypt {
-		if err := decryptFileNoPrompt(s, entry.vault, entry.fileReg); err != nil {
-			errs = append(errs, fmt.Errorf("%s: %w", entry.fileReg.Path, err))
-		}
-	}
-
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
-		}
-		return fmt.Errorf("%d file(s) failed to decrypt", len(errs))
+		results.record(entry.vault, entry.fileReg.Path, decryptFileNoPrompt(s, entry.vault, entry.fileReg))
 	}
-
-	return nil
+	return results.finish()
 }
 
 func decryptAllFiles(s *store.Store) error {
@@ -196,21 +218,11 @@ func decryptAllFiles(s *store.Store) error {
 		}
 	}
 
-	var errs []error
+	results := newBulkResults("decrypt", "decrypt")
 	for _, entry := range toDecrypt {
-		if err := decryptFileNoPrompt(s, entry.vault, entry.fileReg); err != nil {
-			errs = append(errs, fmt.Errorf("%s (%s): %w", entry.fileReg.Path, entry.vault, err))
-		}
+		results.record(entry.vault, entry.fileReg.Path, decryptFileNoPrompt(s, entry.vault, entry.fileReg))
 	}
-
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
-		}
-		return fmt.Errorf("%d file(s) failed to decrypt", len(errs))
-	}
-
-	return nil
+	return results.finish()
 }
 
 func decryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile) error {
@@ -220,6 +232,9 @@ func decryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile) e
 	if _, err := os.Stat(encPath); os.IsNotExist(err) {
 		return fmt.Errorf("encrypted file does not exist: %s.enc", fileReg.Path)
 	}
+	if err := checkPlaintextWrite(plainPath); err != nil {
+		return err
+	}
 
 	if !decryptForce {
 		if _, err := os.Stat(plainPath); err == nil {
@@ -234,22 +249,31 @@ func decryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile) e
 		}
 	}
 
+	if err := hooks.RunFileHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, hooks.PreDecrypt); err != nil {
+		return err
+	}
+
 	content, err := os.ReadFile(encPath)
 	if err != nil {
 		return fmt.Errorf("failed to read encrypted file: %w", err)
 	}
 
-	decrypted, err := crypto.DecryptFileContent(content, fileReg.Path)
+	decrypted, err := crypto.DecryptFileContent(content, fileReg.Path, fileReg.Spec())
 	if err != nil {
-		return fmt.Errorf("decryption failed: %w", err)
+		return decryptionError(s, fileReg.Path, err)
 	}
+	defer secmem.Protect(decrypted)()
 
-	if err := os.WriteFile(plainPath, decrypted, 0600); err != nil {
-		return fmt.Errorf("failed to write plaintext file: %w", err)
+	if err := validateSchema(s, fileReg, decrypted); err != nil {
+		return err
+	}
+
+	if err := writePlaintext(s, fileReg.Path, fileReg.Spec(), content, decrypted); err != nil {
+		return err
 	}
 
 	fmt.Printf("Decrypted %s.enc -> %s\n", fileReg.Path, fileReg.Path)
-	return nil
+	return hooks.RunFileHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, hooks.PostDecrypt)
 }
 
 func decryptFileNoPrompt(s *store.Store, vault string, fileReg *config.RegisteredFile) error {
@@ -259,21 +283,285 @@ func decryptFileNoPrompt(s *store.Store, vault string, fileReg *config.Registere
 	if _, err := os.Stat(encPath); os.IsNotExist(err) {
 		return fmt.Errorf("encrypted file does not exist: %s.enc", fileReg.Path)
 	}
+	if err := checkPlaintextWrite(plainPath); err != nil {
+		return err
+	}
+
+	if err := hooks.RunFileHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, hooks.PreDecrypt); err != nil {
+		return err
+	}
 
 	content, err := os.ReadFile(encPath)
 	if err != nil {
 		return fmt.Errorf("failed to read encrypted file: %w", err)
 	}
 
-	decrypted, err := crypto.DecryptFileContent(content, fileReg.Path)
+	decrypted, err := crypto.DecryptFileContent(content, fileReg.Path, fileReg.Spec())
 	if err != nil {
-		return fmt.Errorf("decryption failed: %w", err)
+		return decryptionError(s, fileReg.Path, err)
 	}
+	defer secmem.Protect(decrypted)()
 
-	if err := os.WriteFile(plainPath, decrypted, 0600); err != nil {
-		return fmt.Errorf("failed to write plaintext file: %w", err)
+	if err := validateSchema(s, fileReg, decrypted); err != nil {
+		return err
+	}
+
+	if err := writePlaintext(s, fileReg.Path, fileReg.Spec(), content, decrypted); err != nil {
+		return err
 	}
 
 	fmt.Printf("Decrypted %s.enc -> %s\n", fileReg.Path, fileReg.Path)
+	return hooks.RunFileHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, hooks.PostDecrypt)
+}
+
+// decryptFileToOutput decrypts a registered file to an arbitrary path, or to
+// stdout when outPath is "-".
+func decryptFileToOutput(s *store.Store, fileReg *config.RegisteredFile, outPath string) error {
+	if err := checkPlaintextWrite(outPath); err != nil {
+		return err
+	}
+	if !confirmOverwrite(outPath) {
+		return nil
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+	defer secmem.Protect(decrypted)()
+
+	if err := writePlaintextOutput(outPath, decrypted); err != nil {
+		return err
+	}
+
+	if outPath != "-" {
+		fmt.Printf("Decrypted %s.enc -> %s\n", fileReg.Path, outPath)
+	}
+	return nil
+}
+
+// confirmOverwrite asks before replacing an existing output file unless
+// --force is set. Stdout ("-") never needs confirmation.
+func confirmOverwrite(outPath string) bool {
+	if outPath == "-" || decryptForce {
+		return true
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return true
+	}
+
+	fmt.Printf("File %s already exists. Overwrite? [y/N] ", outPath)
+	reader := bufio.NewReader(os.Stdin)
+	answer, _ := reader.ReadString('\n')
+	answer = strings.TrimSpace(strings.ToLower(answer))
+	if answer != "y" && answer != "yes" {
+		fmt.Printf("Skipped %s\n", outPath)
+		return false
+	}
+	return true
+}
+
+func writePlaintextOutput(outPath string, data []byte) error {
+	if outPath == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(outPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write plaintext file: %w", err)
+	}
 	return nil
 }
+
+// resolveRegisteredFile maps a user-supplied path (with or without the .enc
+// suffix) to the vault and registration that manage it.
+func resolveRegisteredFile(s *store.Store, filePath string) (string, *config.RegisteredFile, error) {
+	filePath = strings.TrimSuffix(filePath, ".enc")
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	relPath, err := filepath.Rel(s.Root(), absPath)
+	if err != nil {
+		return "", nil, fmt.Errorf("file must be within project directory: %w", err)
+	}
+
+	return config.FindFileVault(s, relPath)
+}
+
+// decryptRegisteredFile decrypts a registered file's .enc counterpart in
+// memory without touching the plaintext path.
+func decryptRegisteredFile(s *store.Store, fileReg *config.RegisteredFile) ([]byte, error) {
+	encPath := filepath.Join(s.Root(), fileReg.Path) + ".enc"
+
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("encrypted file does not exist: %s.enc", fileReg.Path)
+		}
+		return nil, fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+
+	decrypted, err := crypto.DecryptFileContent(content, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return nil, decryptionError(s, fileReg.Path, err)
+	}
+
+	if err := validateSchema(s, fileReg, decrypted); err != nil {
+		return nil, err
+	}
+
+	return decrypted, nil
+}
+
+// secretValues flattens a decrypted registered file into key paths and values.
+// Files without a structured format yield a single value named after the file.
+func secretValues(fileReg *config.RegisteredFile, decrypted []byte) ([]parser.KeyValue, error) {
+	format := fileReg.Spec().FormatOf(fileReg.Path)
+	if format == parser.FormatUnknown {
+		return []parser.KeyValue{{Key: filepath.Base(fileReg.Path), Value: string(decrypted)}}, nil
+	}
+
+	values, err := parser.FlattenFile(decrypted, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values: %w", err)
+	}
+	return values, nil
+}
+
+// valueDigests is secretValues for one version of a registered file's .enc,
+// with each value replaced by its digest. The tokens of values-mode files
+// go through the process-wide digest cache, so a token seen before, e.g. in
+// an earlier commit, is not decrypted again.
+func valueDigests(fileReg *config.RegisteredFile, content []byte) ([]parser.KeyValue, error) {
+	if !crypto.IsFullyEncrypted(content) && fileReg.Spec().FormatOf(fileReg.Path) != parser.FormatUnknown {
+		values, err := crypto.EncryptedValues(content, fileReg.Path, fileReg.Spec())
+		if err == nil && !hasEncryptedKeys(values) {
+			for i := range values {
+				digest, err := crypto.ValueDigest(values[i].Value, fileReg.Path)
+				if err != nil {
+					return nil, fmt.Errorf("decryption failed: %w", err)
+				}
+				values[i].Value = digest
+			}
+			return values, nil
+		}
+	}
+
+	decrypted, err := crypto.DecryptFileContent(content, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	defer secmem.Protect(decrypted)()
+
+	values, err := secretValues(fileReg, decrypted)
+	if err != nil {
+		return nil, err
+	}
+	for i := range values {
+		values[i].Value = crypto.PlaintextDigest(values[i].Value)
+	}
+	return values, nil
+}
+
+// hasEncryptedKeys reports whether any key path holds an ENC token, as in
+// files with obfuscate_keys.
+func hasEncryptedKeys(values []parser.KeyValue) bool {
+	for _, kv := range values {
+		if strings.Contains(kv.Key, parser.EncPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// writePlaintext writes a decrypted registered file with 0600 permissions, or
+// with the owner bits of the mode recorded in its .enc file when
+// preserve_permissions is set.
+func writePlaintext(s *store.Store, relPath string, spec parser.Spec, encContent, decrypted []byte) error {
+	plainPath := filepath.Join(s.Root(), relPath)
+
+	mode := os.FileMode(0600)
+	preserve := false
+	if config.PreservePermissions(s) {
+		if meta, err := crypto.ReadFileMetadata(encContent, plainPath+".enc", relPath, spec); err == nil && meta != nil && meta.PlaintextMode() != 0 {
+			mode = meta.PlaintextMode()
+			preserve = true
+		}
+	}
+
+	if err := os.WriteFile(plainPath, decrypted, mode); err != nil {
+		return fmt.Errorf("failed to write plaintext file: %w", err)
+	}
+
+	// WriteFile leaves the mode of an existing file alone and is subject to
+	// the umask, so apply the recorded mode explicitly.
+	if preserve {
+		if err := os.Chmod(plainPath, mode); err != nil {
+			return fmt.Errorf("failed to set file permissions: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateSchema checks decrypted content against the file's JSON Schema, if
+// one is set. Violations name the offending paths but never the values.
+func validateSchema(s *store.Store, fileReg *config.RegisteredFile, decrypted []byte) error {
+	if fileReg.Schema == "" {
+		return nil
+	}
+
+	sch, err := schema.Load(filepath.Join(s.Root(), fileReg.Schema))
+	if err != nil {
+		return err
+	}
+
+	doc, err := schema.DecodeFile(decrypted, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return err
+	}
+
+	errs := sch.Validate(doc)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, e := range errs {
+		lines = append(lines, "  "+e.Error())
+	}
+	return fmt.Errorf("%s does not match schema %s:\n%s", fileReg.Path, fileReg.Schema, strings.Join(lines, "\n"))
+}
+
+// decryptionError explains a failure to decrypt a registered file using its
+// vault's canary: either you have no access to the vault at all, or the
+// file was not encrypted for you although you are a vault user.
+func decryptionError(s *store.Store, relPath string, err error) error {
+	if !errors.Is(err, crypto.ErrNoPrivateKey) {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	vault, _, findErr := config.FindFileVault(s, relPath)
+	if findErr != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	// The keys the file was encrypted for, next to the ones you have. The
+	// cached public keys name their owners.
+	hint := ""
+	var noKey *crypto.NoKeyError
+	if errors.As(err, &noKey) {
+		hint = "\n  " + noKey.Hint()
+	}
+
+	switch access, _ := config.CheckVaultAccess(s, vault); access {
+	case config.AccessDenied:
+		return fmt.Errorf("you do not have access to vault %s: none of your keys can decrypt it (ask a vault user to run 'shhh user add <email>' and 'shhh reencrypt')%s", vault, hint)
+	case config.AccessGranted:
+		return fmt.Errorf("%s was not encrypted for you although you have access to vault %s; it may have per-file recipients or predate your access (ask a vault user to run 'shhh reencrypt %s')%s", relPath, vault, relPath, hint)
+	default:
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+}
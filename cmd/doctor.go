@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var doctorFix bool
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolVar(&doctorFix, "fix", false, "Add canaries to vaults that have none")
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check which vaults you can decrypt",
+	Long: `Check your access to every vault by decrypting its canary: a small known
+value encrypted for the vault's users whenever users are added or removed.
+
+This tells "you are not a user of this vault" apart from other decryption
+failures, which decrypt also reports using the canary. Vaults created
+before canaries were introduced have none; run with --fix to add them.
+Use 'shhh fsck' to check the store's metadata.`,
+	RunE: runDoctor,
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	fmt.Println("Vault access:")
+	for _, vaultName := range vaults {
+		access, err := config.CheckVaultAccess(s, vaultName)
+		if access == config.AccessUnknown && doctorFix {
+			if err = config.RefreshCanary(s, vaultName); err == nil {
+				access, err = config.CheckVaultAccess(s, vaultName)
+			}
+		}
+
+		switch {
+		case access == config.AccessGranted:
+			fmt.Printf("  %-8s %s: you can decrypt this vault\n", "ok", vaultName)
+		case access == config.AccessDenied:
+			fmt.Printf("  %-8s %s: none of your keys can decrypt this vault\n", "denied", vaultName)
+		case access == config.AccessUnknown:
+			fmt.Printf("  %-8s %s: no canary (run 'shhh doctor --fix')\n", "unknown", vaultName)
+		default:
+			failed++
+			fmt.Printf("  %-8s %s: %v\n", "error", vaultName, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d vault(s) could not be checked", failed)
+	}
+	return nil
+}
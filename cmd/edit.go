@@ -1,20 +1,31 @@
 package cmd
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
+var editValuesOnly bool
+
 func init() {
 	rootCmd.AddCommand(editCmd)
+
+	editCmd.Flags().BoolVar(&editValuesOnly, "values-only", false, "Edit only the key paths and values, as a flat YAML mapping")
 }
 
 var editCmd = &cobra.Command{
@@ -24,7 +35,29 @@ var editCmd = &cobra.Command{
 and re-encrypt when the editor closes.
 
 The original encrypted file is only updated if changes were made.
-Temporary files are securely cleaned up.`,
+Temporary files are securely cleaned up.
+
+The editor is the project's editor.<type> or editor setting, e.g.
+"code --wait" for GUI editors that would otherwise return at once, or
+$VISUAL or $EDITOR. "{file}" in the command stands for the path to edit;
+a command starting with "|", such as "|vipe", edits stdin to stdout.
+
+On Linux the plaintext is held in an anonymous in-memory file that never
+has a directory entry, and the editor opens it through /proc. The editor
+must then save in place, as vim, nano and VS Code do; editors known to save
+by renaming a new file over the old one, such as emacs, gedit and kate, get
+a file in a private temp directory instead, as do systems without
+memfd_create. Set edit_tmpfile to dir or memfd to always use one of them.
+
+With --values-only, structured files are presented as a flat YAML mapping
+of key paths to values, such as "database.password: s3cret", which suits
+large files where the secrets are lost among other settings. Changed and
+added lines are set in the file, and keys whose lines were deleted are
+removed; the rest of the file is left as it was.
+
+When the file has a validate hook (see 'shhh file set-hook'), the edited
+content must pass it before it is encrypted; otherwise the editor can be
+opened again, or the changes are discarded.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runEdit,
 }
@@ -35,10 +68,6 @@ func runEdit(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if err := crypto.LoadCachedPublicKeys(s.PubkeysPath()); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to load cached keys: %v\n", err)
-	}
-
 	filePath := strings.TrimSuffix(args[0], ".enc")
 
 	absPath, err := filepath.Abs(filePath)
@@ -55,6 +84,19 @@ func runEdit(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	if err := config.CheckVaultWritable(s, vault); err != nil {
+		return err
+	}
+	// Checked before editing, so edits are not lost to a recipient
+	// template violation.
+	recipients, err := config.EncryptionRecipients(s, vault, fileReg)
+	if err != nil {
+		return fmt.Errorf("failed to get recipients: %w", err)
+	}
+
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients available")
+	}
 
 	encPath := filepath.Join(s.Root(), relPath) + ".enc"
 	if _, err := os.Stat(encPath); os.IsNotExist(err) {
@@ -66,14 +108,103 @@ func runEdit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to read encrypted file: %w", err)
 	}
 
-	decrypted, err := crypto.DecryptFileContent(encContent, relPath)
+	decrypted, err := crypto.DecryptFileContent(encContent, relPath, fileReg.Spec())
+	if err != nil {
+		return decryptionError(s, relPath, err)
+	}
+	defer secmem.Protect(decrypted)()
+
+	toEdit, editName, editSpec := decrypted, relPath, fileReg.Spec()
+	var values []parser.KeyValue
+	if editValuesOnly {
+		if fileReg.Spec().FormatOf(relPath) == parser.FormatUnknown {
+			return fmt.Errorf("--values-only needs a YAML, JSON, INI or ENV file")
+		}
+		if values, err = parser.FlattenFile(decrypted, relPath, fileReg.Spec()); err != nil {
+			return fmt.Errorf("failed to read values: %w", err)
+		}
+		header := fmt.Sprintf("Values of %s. Edit, add or delete lines; the rest of the file is kept.", relPath)
+		if toEdit, err = parser.FormatValuesView(values, header); err != nil {
+			return err
+		}
+		defer secmem.Protect(toEdit)()
+		editName, editSpec = relPath+".values.yaml", parser.Spec{}
+	}
+
+	editor := getEditor(s, editName, editSpec)
+	if editor == "" {
+		return fmt.Errorf("no editor found (set $EDITOR or $VISUAL, or the editor config key)")
+	}
+
+	// Edited until the content passes the file's validate hook, or the
+	// user gives up.
+	var editedContent []byte
+	for {
+		started := time.Now()
+		edited, inMemory, err := editPlaintext(config.EditTmpfile(s), editor, editName, toEdit)
+		if err != nil {
+			return err
+		}
+		defer secmem.Protect(edited)()
+
+		editedContent = edited
+		if editValuesOnly {
+			editedContent, err = applyValuesView(relPath, fileReg.Spec(), decrypted, values, edited)
+			if editedContent != nil {
+				defer secmem.Protect(editedContent)()
+			}
+		}
+
+		if err == nil && bytes.Equal(editedContent, decrypted) {
+			fmt.Println("No changes made")
+			if time.Since(started) < editorForkTime && !strings.HasPrefix(editor, "|") {
+				fmt.Fprintf(os.Stderr, "Note: The editor exited immediately; GUI editors need a flag to wait, e.g. 'shhh config set editor \"code --wait\"'\n")
+			} else if inMemory {
+				fmt.Fprintf(os.Stderr, "Note: The file was edited in memory; if the editor could not save it, run 'shhh config set edit_tmpfile dir'\n")
+			}
+			return nil
+		}
+		if err == nil {
+			err = validatePlaintext(s, vault, fileReg, editedContent)
+		}
+		if err == nil {
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if !term.IsTerminal(int(os.Stdin.Fd())) || !confirm(bufio.NewReader(os.Stdin), "Edit again?") {
+			return fmt.Errorf("changes to %s discarded", relPath)
+		}
+		toEdit = edited
+	}
+
+	opts := encryptOptions(s, vault, fileReg, recipients)
+	if meta, err := crypto.ReadFileMetadata(encContent, encPath, relPath, fileReg.Spec()); err == nil && meta != nil {
+		opts.FileMode = meta.FileMode
+	}
+
+	encrypted, err := crypto.EncryptFileContent(editedContent, relPath, opts)
 	if err != nil {
-		return fmt.Errorf("decryption failed: %w", err)
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	if err := writeEncFile(s, encPath, relPath, fileReg.Spec(), encrypted); err != nil {
+		return err
 	}
 
+	fmt.Printf("Updated %s.enc\n", relPath)
+	writeBackup(s, vault, fileReg, editedContent, recipients)
+	syncExample(s, fileReg, editedContent)
+	return nil
+}
+
+// editInTempDir opens the plaintext in a private temp directory and returns
+// the edited content. The file is overwritten before the directory is
+// removed.
+func editInTempDir(editor, relPath string, plaintext []byte) ([]byte, error) {
 	tmpDir, err := os.MkdirTemp("", "shhh-edit-*")
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer func() {
 		if err := os.RemoveAll(tmpDir); err != nil {
@@ -82,67 +213,150 @@ func runEdit(cmd *cobra.Command, args []string) error {
 	}()
 
 	if err := os.Chmod(tmpDir, 0700); err != nil {
-		return fmt.Errorf("failed to set temp directory permissions: %w", err)
+		return nil, fmt.Errorf("failed to set temp directory permissions: %w", err)
 	}
 
 	tmpFile := filepath.Join(tmpDir, filepath.Base(relPath))
-	if err := os.WriteFile(tmpFile, decrypted, 0600); err != nil {
-		return fmt.Errorf("failed to write temp file: %w", err)
+	defer secmem.ShredFile(tmpFile)
+	if err := os.WriteFile(tmpFile, plaintext, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write temp file: %w", err)
 	}
 
-	editor := getEditor()
-	if editor == "" {
-		return fmt.Errorf("no editor found (set $EDITOR or $VISUAL)")
+	if err := runEditor(editor, tmpFile); err != nil {
+		return nil, err
 	}
 
-	editorCmd := exec.Command(editor, tmpFile)
-	editorCmd.Stdin = os.Stdin
-	editorCmd.Stdout = os.Stdout
-	editorCmd.Stderr = os.Stderr
+	edited, err := os.ReadFile(tmpFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read edited file: %w", err)
+	}
+	return edited, nil
+}
 
-	if err := editorCmd.Run(); err != nil {
-		return fmt.Errorf("editor failed: %w", err)
+// editPlaintext opens the plaintext in the editor as edit_tmpfile mode
+// says and returns the edited content, and whether it was edited in an
+// anonymous in-memory file. In auto mode, editors known to save by renaming
+// and systems without memfd_create get a private temp directory instead.
+func editPlaintext(mode, editor, relPath string, plaintext []byte) ([]byte, bool, error) {
+	if mode == config.EditTmpfileDir || (mode == config.EditTmpfileAuto && savesByRename(editor)) {
+		edited, err := editInTempDir(editor, relPath, plaintext)
+		return edited, false, err
 	}
 
-	editedContent, err := os.ReadFile(tmpFile)
+	mf, err := secmem.NewMemFile("shhh-edit-"+filepath.Base(relPath), plaintext)
 	if err != nil {
-		return fmt.Errorf("failed to read edited file: %w", err)
+		if mode == config.EditTmpfileAuto {
+			edited, err := editInTempDir(editor, relPath, plaintext)
+			return edited, false, err
+		}
+		return nil, false, fmt.Errorf("edit_tmpfile is memfd: %w", err)
 	}
+	edited, err := editInMemFile(editor, mf)
+	return edited, true, err
+}
 
-	if string(editedContent) == string(decrypted) {
-		fmt.Println("No changes made")
-		return nil
+// renamingEditors save by writing a new file and renaming it over the old
+// one, which an in-memory file opened through /proc does not allow.
+var renamingEditors = []string{"emacs", "emacsclient", "gedit", "gnome-text-editor", "kate", "kwrite"}
+
+// savesByRename reports whether an editor command runs one of the
+// renamingEditors. Filters are written in place by shhh itself.
+func savesByRename(editor string) bool {
+	if strings.HasPrefix(editor, "|") {
+		return false
+	}
+	args := strings.Fields(editor)
+	return len(args) > 0 && slices.Contains(renamingEditors, filepath.Base(args[0]))
+}
+
+// editInMemFile opens the plaintext in an anonymous in-memory file and
+// returns the edited content.
+func editInMemFile(editor string, mf *secmem.MemFile) ([]byte, error) {
+	defer mf.Close()
+
+	if err := runEditor(editor, mf.Path()); err != nil {
+		return nil, err
 	}
 
-	recipients, err := config.GetEffectiveRecipients(s, vault, fileReg)
+	edited, err := mf.ReadAll()
 	if err != nil {
-		return fmt.Errorf("failed to get recipients: %w", err)
+		return nil, fmt.Errorf("failed to read edited file: %w", err)
 	}
+	return edited, nil
+}
 
-	if len(recipients) == 0 {
-		return fmt.Errorf("no recipients available")
+// editorForkTime is how quickly an editor that made no changes must exit
+// to be suspected of forking into the background.
+const editorForkTime = time.Second
+
+// runEditor runs an editor command on path: the command split on
+// whitespace, with {file} replaced by path or path appended. A command
+// starting with "|" is a filter that gets the file on stdin and writes the
+// edited file to stdout.
+func runEditor(editor, path string) error {
+	filter, isFilter := strings.CutPrefix(editor, "|")
+	if isFilter {
+		editor = filter
+	}
+	args := strings.Fields(editor)
+	if len(args) == 0 {
+		return fmt.Errorf("editor command is empty")
+	}
+	hasFile := false
+	for i, arg := range args {
+		if strings.Contains(arg, "{file}") {
+			args[i] = strings.ReplaceAll(arg, "{file}", path)
+			hasFile = true
+		}
+	}
+	if !hasFile && !isFilter {
+		args = append(args, path)
 	}
 
-	opts := crypto.EncryptOptions{
-		Vault:      vault,
-		Mode:       fileReg.Mode,
-		Recipients: recipients,
+	editorCmd := exec.Command(args[0], args[1:]...)
+	editorCmd.Stderr = os.Stderr
+	if !isFilter {
+		editorCmd.Stdin = os.Stdin
+		editorCmd.Stdout = os.Stdout
+		if err := editorCmd.Run(); err != nil {
+			return fmt.Errorf("editor failed: %w", err)
+		}
+		return nil
 	}
 
-	encrypted, err := crypto.EncryptFileContent(editedContent, relPath, opts)
+	in, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("encryption failed: %w", err)
+		return fmt.Errorf("failed to open file for editor: %w", err)
 	}
-
-	if err := os.WriteFile(encPath, encrypted, 0600); err != nil {
-		return fmt.Errorf("failed to write encrypted file: %w", err)
+	defer in.Close()
+	editorCmd.Stdin = in
+	edited, err := editorCmd.Output()
+	defer secmem.Wipe(edited)
+	if err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+	// Written in place, which memfd files require.
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write edited file: %w", err)
+	}
+	defer out.Close()
+	if _, err := out.Write(edited); err != nil {
+		return fmt.Errorf("failed to write edited file: %w", err)
 	}
-
-	fmt.Printf("Updated %s.enc\n", relPath)
 	return nil
 }
 
-func getEditor() string {
+// getEditor returns the editor command for a file: the project's editor
+// for its file type, its editor, $VISUAL, $EDITOR, or the first common
+// editor installed. The project's editors are only used once trusted.
+func getEditor(s *store.Store, relPath string, spec parser.Spec) string {
+	if cfg, err := config.Load(s); err == nil {
+		editor := cfg.EditorSetting(editorFileType(relPath, spec))
+		if editor.Value != "" && trustedSetting(s, editor.Key, editor.Value) {
+			return editor.Value
+		}
+	}
 	if editor := os.Getenv("VISUAL"); editor != "" {
 		return editor
 	}
@@ -159,3 +373,26 @@ func getEditor() string {
 
 	return ""
 }
+
+// editorFileType is the file type editors are configured by: the format of
+// structured files, or the extension of others.
+func editorFileType(relPath string, spec parser.Spec) string {
+	if format := spec.FormatOf(relPath); format != parser.FormatUnknown {
+		return string(format)
+	}
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(relPath), "."))
+}
+
+// applyValuesView applies the changes made to a --values-only view to the
+// decrypted file. It returns decrypted itself when nothing changed.
+func applyValuesView(relPath string, spec parser.Spec, decrypted []byte, values []parser.KeyValue, view []byte) ([]byte, error) {
+	edited, err := parser.ParseValuesView(view)
+	if err != nil {
+		return nil, fmt.Errorf("edited values: %w", err)
+	}
+	patch := parser.DiffValues(values, edited)
+	if len(patch.Set) == 0 && len(patch.Remove) == 0 {
+		return decrypted, nil
+	}
+	return parser.ApplyPatch(decrypted, relPath, spec, patch)
+}
@@ -4,16 +4,28 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
 var (
-	encryptVault string
-	encryptAll   bool
+	encryptVault          string
+	encryptAll            bool
+	encryptOutput         string
+	encryptAdhoc          bool
+	encryptRecipients     []string
+	encryptMode           string
+	encryptRecipientsFile string
+	encryptChanged        bool
+	encryptBase           string
+	encryptResolve        bool
 )
 
 func init() {
@@ -21,6 +33,15 @@ func init() {
 
 	encryptCmd.Flags().StringVarP(&encryptVault, "vault", "v", "", "Encrypt files in specific vault")
 	encryptCmd.Flags().BoolVarP(&encryptAll, "all", "a", false, "Encrypt all registered files")
+	encryptCmd.Flags().BoolVar(&encryptChanged, "changed", false, "Only encrypt registered files changed according to git")
+	encryptCmd.Flags().StringVar(&encryptBase, "base", "", "With --changed, also include files changed on this branch since <base>")
+	encryptCmd.Flags().BoolVar(&encryptResolve, "resolve", false, "Run the commands of !shhh-exec values and encrypt their output")
+	encryptCmd.Flags().StringVarP(&encryptOutput, "output", "o", "", "Write ciphertext to this path instead of <file>.enc ('-' for stdout)")
+	encryptCmd.Flags().BoolVar(&encryptAdhoc, "adhoc", false, "Encrypt an unregistered file for specific recipients")
+	encryptCmd.Flags().StringSliceVarP(&encryptRecipients, "recipients", "r", nil, "Recipients for --adhoc encryption")
+	encryptCmd.Flags().StringVar(&encryptRecipientsFile, "recipients-file", "", "Read --adhoc recipients (one email or fingerprint per line) from a file")
+	encryptCmd.Flags().StringVarP(&encryptMode, "mode", "m", "full", "Encryption mode for --adhoc: values or full")
+	addReportFlag(encryptCmd)
 }
 
 var encryptCmd = &cobra.Command{
@@ -29,18 +50,60 @@ var encryptCmd = &cobra.Command{
 	Long: `Encrypt a registered file to its .enc counterpart.
 
 Use --vault to encrypt all files in a specific vault.
-Use --all to encrypt all registered files across all vaults.`,
+Use --all to encrypt all registered files across all vaults.
+Use --output to write a single file's ciphertext elsewhere ('-' for stdout).
+Encrypting several files ends with a table of each file's outcome; use
+--report to also write it as JSON.
+Use --changed to only encrypt files touched in git (or whose plaintext is
+newer than the .enc); add --base <ref> to include the whole branch diff.
+
+Use --resolve to source YAML values from upstream systems. A value tagged
+!shhh-exec is a shell command, run in the project root, whose output is
+encrypted in its place. The plaintext keeps the command, but decrypting
+writes the resolved value:
+
+  database:
+    password: !shhh-exec "vault kv get -field=pass db"
+
+Files with !shhh-exec values are only encrypted with --resolve, so commands
+never run unasked and are never stored as values.
+
+Use --adhoc with --recipients to encrypt any file without registering it,
+e.g. to share a one-off secret with specific teammates:
+
+  shhh encrypt --adhoc dump.sql --recipients alice@example.com,bob@example.com`,
 	RunE: runEncrypt,
 }
 
 func runEncrypt(cmd *cobra.Command, args []string) error {
+	if encryptAdhoc {
+		if len(args) != 1 {
+			return fmt.Errorf("--adhoc requires exactly one file")
+		}
+		return encryptAdhocFile(args[0])
+	}
+	// Registered files take their mode and recipients from the vault.
+	if cmd.Flags().Changed("mode") {
+		return fmt.Errorf("--mode can only be used with --adhoc (use 'shhh file set-mode' for a registered file)")
+	}
+	if cmd.Flags().Changed("recipients") || cmd.Flags().Changed("recipients-file") {
+		return fmt.Errorf("--recipients can only be used with --adhoc (use 'shhh file set-recipients' for a registered file)")
+	}
+
 	s, err := store.GetStore()
 	if err != nil {
 		return err
 	}
 
-	if err := crypto.LoadCachedPublicKeys(s.PubkeysPath()); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to load cached keys: %v\n", err)
+	if encryptOutput != "" && (encryptAll || encryptVault != "" || encryptChanged) {
+		return fmt.Errorf("--output can only be used with a single file")
+	}
+	if bulkReport != "" && !encryptAll && encryptVault == "" && !encryptChanged {
+		return fmt.Errorf("--report requires --vault, --all, or --changed")
+	}
+
+	if encryptChanged {
+		return encryptChangedFiles(s)
 	}
 
 	if encryptAll {
@@ -74,14 +137,40 @@ func encryptSingleFile(s *store.Store, filePath string) error {
 		return err
 	}
 
+	if encryptOutput != "" {
+		return encryptFileToOutput(s, vault, fileReg, encryptOutput)
+	}
+
 	return encryptFile(s, vault, fileReg)
 }
 
+func encryptChangedFiles(s *store.Store) error {
+	files, err := changedRegisteredFiles(s, encryptVault, encryptBase, true)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		fmt.Println("No changed files to encrypt")
+		return nil
+	}
+
+	results := newBulkResults("encrypt", "encrypt")
+	for _, f := range files {
+		results.record(f.vault, f.file.Path, encryptFile(s, f.vault, f.file))
+	}
+	return results.finish()
+}
+
 func encryptVaultFiles(s *store.Store, vaultName string) error {
 	if !s.VaultExists(vaultName) {
 		return fmt.Errorf("vault %q does not exist", vaultName)
 	}
 
+	if err := config.CheckVaultWritable(s, vaultName); err != nil {
+		return err
+	}
+
 	vault, err := config.LoadVault(s, vaultName)
 	if err != nil {
 		return err
@@ -92,21 +181,11 @@ func encryptVaultFiles(s *store.Store, vaultName string) error {
 		return nil
 	}
 
-	var errs []error
+	results := newBulkResults("encrypt", "encrypt")
 	for _, f := range vault.Files {
-		if err := encryptFile(s, vaultName, &f); err != nil {
-			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
-		}
-	}
-
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
-		}
-		return fmt.Errorf("%d file(s) failed to encrypt", len(errs))
+		results.record(vaultName, f.Path, encryptFile(s, vaultName, &f))
 	}
-
-	return nil
+	return results.finish()
 }
 
 func encryptAllFiles(s *store.Store) error {
@@ -115,9 +194,7 @@ func encryptAllFiles(s *store.Store) error {
 		return err
 	}
 
-	totalFiles := 0
-	var errs []error
-
+	results := newBulkResults("encrypt", "encrypt")
 	for _, vaultName := range vaults {
 		vault, err := config.LoadVault(s, vaultName)
 		if err != nil {
@@ -125,77 +202,245 @@ func encryptAllFiles(s *store.Store) error {
 		}
 
 		for _, f := range vault.Files {
-			totalFiles++
-			if err := encryptFile(s, vaultName, &f); err != nil {
-				errs = append(errs, fmt.Errorf("%s (%s): %w", f.Path, vaultName, err))
+			if vault.Archived {
+				results.skip(vaultName, f.Path, "vault is archived")
+				continue
 			}
+			results.record(vaultName, f.Path, encryptFile(s, vaultName, &f))
 		}
 	}
 
-	if totalFiles == 0 {
+	if len(results.Files) == 0 {
 		fmt.Println("No files registered")
 		return nil
 	}
 
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
-		}
-		return fmt.Errorf("%d file(s) failed to encrypt", len(errs))
+	return results.finish()
+}
+
+func encryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile) error {
+	plainPath := filepath.Join(s.Root(), fileReg.Path)
+	encPath := plainPath + ".enc"
+
+	if err := hooks.RunFileHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, hooks.PreEncrypt); err != nil {
+		return err
+	}
+
+	content, encrypted, recipients, err := encryptPlaintext(s, vault, fileReg)
+	if err != nil {
+		return err
+	}
+	defer secmem.Protect(content)()
+
+	if err := writeEncFile(s, encPath, fileReg.Path, fileReg.Spec(), encrypted); err != nil {
+		return err
+	}
+
+	fmt.Printf("Encrypted %s -> %s.enc\n", fileReg.Path, fileReg.Path)
+
+	writeBackup(s, vault, fileReg, content, recipients)
+	syncExample(s, fileReg, content)
+	return hooks.RunFileHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, hooks.PostEncrypt)
+}
+
+// encryptFileToOutput encrypts a registered file to an arbitrary path, or to
+// stdout when outPath is "-". GPG backups are only written by encryptFile.
+func encryptFileToOutput(s *store.Store, vault string, fileReg *config.RegisteredFile, outPath string) error {
+	content, encrypted, _, err := encryptPlaintext(s, vault, fileReg)
+	if err != nil {
+		return err
+	}
+	secmem.Wipe(content)
+
+	if outPath == "-" {
+		_, err := os.Stdout.Write(encrypted)
+		return err
+	}
+
+	if err := writeEncFile(s, outPath, fileReg.Path, fileReg.Spec(), encrypted); err != nil {
+		return err
 	}
 
+	fmt.Printf("Encrypted %s -> %s\n", fileReg.Path, outPath)
 	return nil
 }
 
-func encryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile) error {
+// encryptPlaintext reads a registered file's plaintext and returns it together
+// with its encrypted form and the recipients it was encrypted for.
+func encryptPlaintext(s *store.Store, vault string, fileReg *config.RegisteredFile) ([]byte, []byte, []string, error) {
+	if err := config.CheckVaultWritable(s, vault); err != nil {
+		return nil, nil, nil, err
+	}
+
 	plainPath := filepath.Join(s.Root(), fileReg.Path)
-	encPath := plainPath + ".enc"
 
-	if _, err := os.Stat(plainPath); os.IsNotExist(err) {
-		return fmt.Errorf("source file does not exist")
+	info, err := os.Stat(plainPath)
+	if os.IsNotExist(err) {
+		return nil, nil, nil, fmt.Errorf("source file does not exist")
 	}
 
 	content, err := os.ReadFile(plainPath)
 	if err != nil {
-		return fmt.Errorf("failed to read file: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	recipients, err := config.GetEffectiveRecipients(s, vault, fileReg)
+	content, err = resolveExecValues(s, fileReg, content)
 	if err != nil {
-		return fmt.Errorf("failed to get recipients: %w", err)
+		return nil, nil, nil, err
+	}
+	if err := validatePlaintext(s, vault, fileReg, content); err != nil {
+		return nil, nil, nil, err
+	}
+
+	recipients, err := config.EncryptionRecipients(s, vault, fileReg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get recipients: %w", err)
 	}
 
 	if len(recipients) == 0 {
-		return fmt.Errorf("no recipients available (add users to vault)")
+		return nil, nil, nil, fmt.Errorf("no recipients available (add users to vault)")
 	}
 
-	opts := crypto.EncryptOptions{
-		Vault:      vault,
-		Mode:       fileReg.Mode,
-		Recipients: recipients,
+	opts := encryptOptions(s, vault, fileReg, recipients)
+	if info != nil && config.PreservePermissions(s) {
+		opts.FileMode = info.Mode().Perm()
 	}
 
 	encrypted, err := crypto.EncryptFileContent(content, fileReg.Path, opts)
 	if err != nil {
-		return fmt.Errorf("encryption failed: %w", err)
+		return nil, nil, nil, fmt.Errorf("encryption failed: %w", err)
+	}
+
+	return content, encrypted, recipients, nil
+}
+
+// resolveExecValues replaces the !shhh-exec values of a plaintext with the
+// output of their commands when --resolve is given, and refuses to encrypt
+// them otherwise.
+func resolveExecValues(s *store.Store, fileReg *config.RegisteredFile, content []byte) ([]byte, error) {
+	execs, err := parser.ExecValues(content, fileReg.Path, fileReg.Spec())
+	if err != nil || len(execs) == 0 {
+		return content, err
+	}
+	if !encryptResolve {
+		return nil, fmt.Errorf("%s has %d %s value(s); encrypt with --resolve to run their commands", fileReg.Path, len(execs), parser.ExecTag)
+	}
+
+	patch := &parser.Patch{}
+	for _, kv := range execs {
+		value, err := hooks.Generate(s.Root(), kv.Value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", kv.Key, err)
+		}
+		patch.Set = append(patch.Set, parser.KeyValue{Key: kv.Key, Value: string(value)})
+		secmem.Wipe(value)
+	}
+
+	resolved, err := parser.ApplyPatch(content, fileReg.Path, fileReg.Spec(), patch)
+	if err != nil {
+		return nil, err
+	}
+	secmem.Wipe(content)
+	return resolved, nil
+}
+
+// validatePlaintext runs a file's validate hook on plaintext about to be
+// encrypted. A failure is an error, or only a warning with --warn.
+func validatePlaintext(s *store.Store, vault string, fileReg *config.RegisteredFile, content []byte) error {
+	err := hooks.RunValidateHook(s.Root(), vault, fileReg.Path, fileReg.Hooks, content)
+	if err == nil {
+		return nil
+	}
+	if fileReg.Hooks.ValidateWarn {
+		fmt.Fprintf(os.Stderr, "Warning: %s failed validation: %v\n", fileReg.Path, err)
+		return nil
+	}
+	return fmt.Errorf("%s failed validation: %w", fileReg.Path, err)
+}
+
+// encryptOptions builds the options for encrypting a registered file,
+// applying project-wide settings from the config.
+func encryptOptions(s *store.Store, vault string, fileReg *config.RegisteredFile, recipients []string) crypto.EncryptOptions {
+	opts := crypto.EncryptOptions{
+		Vault:         vault,
+		Mode:          fileReg.Mode,
+		Recipients:    recipients,
+		ObfuscateKeys: fileReg.ObfuscateKeys,
+		Spec:          fileReg.Spec(),
+	}
+	cfg, err := config.Load(s)
+	if err != nil {
+		cfg = config.NewConfig()
+	}
+	opts.DedupeValues = cfg.DedupeValues
+	opts.Verify = cfg.VerifyEncrypt
+	opts.MetadataPrivacy = cfg.MetadataPrivacy
+	opts.AnnotateKeyIDs = cfg.ValueKeyIDs
+	opts.Timestamp = cfg.EncryptedAtValue()
+	opts.Compression = cfg.CompressionValue()
+
+	// Carry the revision and rotation times over from the current .enc.
+	opts.Revision = 1
+	if meta := currentMetadata(s, fileReg); meta != nil {
+		opts.Revision = meta.Revision + 1
+		opts.Rotations = meta.Rotations
+	}
+	return opts
+}
+
+// currentMetadata returns the metadata of a file's current .enc, or nil.
+func currentMetadata(s *store.Store, fileReg *config.RegisteredFile) *crypto.FileMetadata {
+	encPath := filepath.Join(s.Root(), fileReg.Path) + ".enc"
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		return nil
+	}
+	meta, err := crypto.ReadFileMetadata(content, encPath, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return nil
+	}
+	return meta
+}
+
+// writeEncFile writes an encrypted registered file. With the "sidecar"
+// metadata setting, the metadata is moved out of the document into
+// <file>.enc.meta; otherwise any leftover sidecar is removed. Unless
+// encrypted_at is precise, the exact time is recorded in the audit log.
+func writeEncFile(s *store.Store, encPath, relPath string, spec parser.Spec, encrypted []byte) error {
+	cfg, err := config.Load(s)
+	if err != nil {
+		cfg = config.NewConfig()
+	}
+
+	var meta []byte
+	if cfg.Metadata == config.MetadataSidecar {
+		doc, m, err := crypto.SplitMetadata(encrypted, relPath, spec)
+		if err != nil {
+			return err
+		}
+		encrypted, meta = doc, m
 	}
 
 	if err := os.WriteFile(encPath, encrypted, 0600); err != nil {
 		return fmt.Errorf("failed to write encrypted file: %w", err)
 	}
 
-	fmt.Printf("Encrypted %s -> %s.enc\n", fileReg.Path, fileReg.Path)
+	if cfg.EncryptedAtValue() != crypto.TimestampPrecise && encPath == filepath.Join(s.Root(), relPath)+".enc" {
+		if err := config.RecordEncryption(s, relPath, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update audit log: %v\n", err)
+		}
+	}
 
-	if config.GetEffectiveGPGCopy(s, fileReg) {
-		gpgPath := plainPath + ".gpg"
-		gpg := crypto.GetProvider()
-		gpgEncrypted, err := gpg.Encrypt(content, recipients)
-		if err == nil {
-			if err := os.WriteFile(gpgPath, gpgEncrypted, 0600); err == nil {
-				fmt.Printf("  Created GPG backup: %s.gpg\n", fileReg.Path)
-			}
+	sidecarPath := crypto.SidecarPath(encPath)
+	if meta == nil {
+		if err := os.Remove(sidecarPath); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove %s: %v\n", sidecarPath, err)
 		}
+		return nil
 	}
 
+	if err := os.WriteFile(sidecarPath, meta, 0600); err != nil {
+		return fmt.Errorf("failed to write sidecar metadata: %w", err)
+	}
 	return nil
 }
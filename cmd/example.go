@@ -0,0 +1,188 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+const exampleSuffix = ".example"
+
+var exampleDisable bool
+
+func init() {
+	rootCmd.AddCommand(exampleCmd)
+	exampleCmd.Flags().BoolVar(&exampleDisable, "disable", false, "Stop keeping the example in sync (the file is kept)")
+}
+
+var exampleCmd = &cobra.Command{
+	Use:   "example [file]...",
+	Short: "Generate sanitized example files",
+	Long: `Write <file>.example: a copy of a registered file with the same structure
+where every value is replaced by its key path, e.g.
+
+  database:
+    password: <database.password>
+
+Comments are dropped as they may contain secrets. The example is meant to
+be committed, so newcomers can see which settings an app needs.
+
+Once generated, the example is rewritten whenever the file is encrypted or
+edited with shhh. Without arguments, every example is regenerated; use
+--disable to stop syncing a file's example.`,
+	RunE: runExample,
+}
+
+func runExample(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var files []vaultFile
+	if len(args) == 0 {
+		if exampleDisable {
+			return fmt.Errorf("specify the files to disable examples for")
+		}
+		vaults, err := s.ListVaults()
+		if err != nil {
+			return err
+		}
+		for _, vaultName := range vaults {
+			vault, err := config.LoadVault(s, vaultName)
+			if err != nil {
+				continue
+			}
+			for i := range vault.Files {
+				if vault.Files[i].Example {
+					files = append(files, vaultFile{vault: vaultName, file: &vault.Files[i]})
+				}
+			}
+		}
+		if len(files) == 0 {
+			fmt.Println("No examples configured (run 'shhh example <file>')")
+			return nil
+		}
+	}
+
+	for _, arg := range args {
+		relPath, err := projectRelPath(s, strings.TrimSuffix(arg, ".enc"))
+		if err != nil {
+			return err
+		}
+		vault, fileReg, err := config.FindFileVault(s, relPath)
+		if err != nil {
+			return err
+		}
+		if exampleDisable {
+			if err := config.SetFileExample(s, vault, fileReg.Path, false); err != nil {
+				return err
+			}
+			fmt.Printf("Stopped syncing %s%s\n", fileReg.Path, exampleSuffix)
+			continue
+		}
+		files = append(files, vaultFile{vault: vault, file: fileReg})
+	}
+
+	var errs []error
+	for _, f := range files {
+		if err := generateExample(s, f); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.file.Path, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+		}
+		return fmt.Errorf("%d example(s) failed", len(errs))
+	}
+	return nil
+}
+
+// generateExample writes a file's example from its plaintext, or from its
+// .enc when the plaintext is not present, and enables syncing.
+func generateExample(s *store.Store, f vaultFile) error {
+	content, err := readPlaintext(s, f.file.Path, f.file.Spec())
+	if err != nil {
+		return err
+	}
+
+	if _, err := writeExampleFile(s, f.file.Path, f.file.Spec(), content); err != nil {
+		return err
+	}
+	if !f.file.Example {
+		if err := config.SetFileExample(s, f.vault, f.file.Path, true); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Wrote %s%s\n", f.file.Path, exampleSuffix)
+	return nil
+}
+
+// readPlaintext returns the plaintext of a registered file, decrypting its
+// .enc when the plaintext is not present.
+func readPlaintext(s *store.Store, relPath string, spec parser.Spec) ([]byte, error) {
+	plainPath := filepath.Join(s.Root(), filepath.FromSlash(relPath))
+
+	content, err := os.ReadFile(plainPath)
+	if os.IsNotExist(err) {
+		encContent, readErr := os.ReadFile(plainPath + ".enc")
+		if readErr != nil {
+			return nil, fmt.Errorf("neither plaintext nor .enc found")
+		}
+		content, err = crypto.DecryptFileContent(encContent, relPath, spec)
+		if err != nil {
+			return nil, decryptionError(s, relPath, err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return content, nil
+}
+
+// syncExample rewrites the example of a file with examples enabled after
+// it was encrypted or edited. Failures are reported but do not fail the
+// command.
+func syncExample(s *store.Store, fileReg *config.RegisteredFile, plaintext []byte) {
+	if !fileReg.Example {
+		return
+	}
+	changed, err := writeExampleFile(s, fileReg.Path, fileReg.Spec(), plaintext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update %s%s: %v\n", fileReg.Path, exampleSuffix, err)
+		return
+	}
+	if changed {
+		fmt.Printf("  Updated example: %s%s\n", fileReg.Path, exampleSuffix)
+	}
+}
+
+// writeExampleFile writes the example of relPath and reports whether its
+// content changed.
+func writeExampleFile(s *store.Store, relPath string, spec parser.Spec, plaintext []byte) (bool, error) {
+	example, err := parser.ExampleFile(plaintext, relPath, spec)
+	if err != nil {
+		return false, err
+	}
+
+	path := filepath.Join(s.Root(), filepath.FromSlash(relPath)) + exampleSuffix
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, example) {
+		return false, nil
+	}
+
+	// Examples hold no secrets and are meant to be committed.
+	if err := os.WriteFile(path, example, 0644); err != nil {
+		return false, fmt.Errorf("failed to write example: %w", err)
+	}
+	return true, nil
+}
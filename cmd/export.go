@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/spf13/cobra"
+)
+
+var (
+	exportKey       string
+	exportOverrides []string
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportJSONCmd)
+
+	exportJSONCmd.Flags().StringVarP(&exportKey, "key", "k", "", "Only export the value at this key path, or the values under it")
+	addOverrideFlag(exportJSONCmd, &exportOverrides)
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export decrypted secrets for other tools",
+	Long:  `Commands that print decrypted secrets in formats consumed by other tools.`,
+}
+
+var exportJSONCmd = &cobra.Command{
+	Use:   "json <file>",
+	Short: "Print decrypted values as a flat JSON object",
+	Long: `Decrypt a registered file in memory and print its values as a flat JSON
+object of dotted key paths to strings:
+
+  {"database.password":"s3cret","database.port":"5432"}
+
+This is the format Terraform's external data source expects:
+
+  data "external" "db" {
+    program = ["shhh", "export", "json", "db.yaml", "--key", "database"]
+  }
+
+  # data.external.db.result["database.password"]
+
+Use --key to limit the output to one value or the values under a path.
+Use --override key.path=value, or SHHH_OVERRIDE_KEY_PATH=value in the
+environment, to print a different value without changing the encrypted
+file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExportJSON,
+}
+
+func runExportJSON(cmd *cobra.Command, args []string) error {
+	s, fileReg, err := findRegisteredFile(args[0])
+	if err != nil {
+		return err
+	}
+	overrides, err := loadOverrides(exportOverrides)
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+	defer secmem.Protect(decrypted)()
+	if decrypted, err = overrides.apply(fileReg, decrypted); err != nil {
+		return err
+	}
+	defer secmem.Wipe(decrypted)
+	if err := overrides.checkUsed(); err != nil {
+		return err
+	}
+
+	values, err := secretValues(fileReg, decrypted)
+	if err != nil {
+		return err
+	}
+
+	result := make(map[string]string, len(values))
+	for _, kv := range values {
+		if exportKey == "" || kv.Key == exportKey || strings.HasPrefix(kv.Key, exportKey+".") {
+			result[kv.Key] = kv.Value
+		}
+	}
+	if exportKey != "" && len(result) == 0 {
+		return fmt.Errorf("key %s not found in %s", exportKey, fileReg.Path)
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	defer secmem.Wipe(out)
+	if _, err := os.Stdout.Write(out); err != nil {
+		return err
+	}
+	fmt.Println()
+	return nil
+}
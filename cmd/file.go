@@ -1,13 +1,19 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/schema"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
@@ -21,7 +27,20 @@ func init() {
 	fileCmd.AddCommand(fileSetModeCmd)
 	fileCmd.AddCommand(fileSetGPGCopyCmd)
 	fileCmd.AddCommand(fileClearGPGCopyCmd)
+	fileCmd.AddCommand(fileSetObfuscateKeysCmd)
+	fileCmd.AddCommand(fileSetSchemaCmd)
+	fileCmd.AddCommand(fileClearSchemaCmd)
+	fileCmd.AddCommand(fileSetHookCmd)
+	fileCmd.AddCommand(fileClearHookCmd)
 	fileCmd.AddCommand(fileShowCmd)
+	fileCmd.AddCommand(fileMoveCmd)
+
+	fileSetRecipientsCmd.Flags().StringVar(&fileRecipientsFile, "recipients-file", "", "Read recipients (one email or fingerprint per line) from a file")
+	fileSetHookCmd.Flags().BoolVar(&fileHookWarn, "warn", false, "For the validate stage: only warn when validation fails")
+	fileMoveCmd.Flags().StringVar(&fileMoveFrom, "from", "", "Vault whose registration to keep when the file is registered in several")
+	fileShowCmd.Flags().BoolVar(&fileShowMask, "mask", false, "Also list values, showing only their first and last two characters")
+	fileShowCmd.Flags().BoolVar(&fileShowJSON, "json", false, "Output the registration and status as JSON")
+	fileShowCmd.Flags().BoolVar(&fileShowValues, "values", false, "List the recipients each value is encrypted to (needs value_key_ids)")
 }
 
 var fileCmd = &cobra.Command{
@@ -30,16 +49,35 @@ var fileCmd = &cobra.Command{
 	Long:  `Configure per-file encryption settings including recipients, mode, and GPG backup.`,
 }
 
+var fileRecipientsFile string
+
 var fileSetRecipientsCmd = &cobra.Command{
-	Use:   "set-recipients <file> <email>...",
+	Use:   "set-recipients <file> [email]...",
 	Short: "Set specific recipients for a file",
 	Long: `Restrict encryption to specific recipients instead of all vault users.
 
-Recipients must be users in the file's vault.`,
-	Args: cobra.MinimumNArgs(2),
+Recipients must be users in the file's vault. Use --recipients-file to read
+them (one email or fingerprint per line) from a reviewed text file.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE: runFileSetRecipients,
 }
 
+var fileMoveFrom string
+
+var fileMoveCmd = &cobra.Command{
+	Use:   "move <file> <vault>",
+	Short: "Move a file's registration to another vault",
+	Long: `Move a file's registration, with its mode, recipients and other settings,
+to another vault and remove it from every other vault.
+
+This also resolves files registered in several vaults (reported by
+'shhh fsck'): pass --from to choose which vault's settings to keep, unless
+the file is already registered in the target vault. Run 'shhh reencrypt'
+afterwards so the file is encrypted for the new vault's users.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runFileMove,
+}
+
 var fileClearRecipientsCmd = &cobra.Command{
 	Use:   "clear-recipients <file>",
 	Short: "Clear per-file recipients",
@@ -99,11 +137,156 @@ var fileClearGPGCopyCmd = &cobra.Command{
 	RunE:  runFileClearGPGCopy,
 }
 
+var fileSetObfuscateKeysCmd = &cobra.Command{
+	Use:   "set-obfuscate-keys <file> <true|false>",
+	Short: "Encrypt mapping keys as well as values",
+	Long: `Encrypt the keys of a values-mode file too, so names such as
+'stripe_production_key' do not appear in the committed .enc file.
+
+Supported for YAML and JSON files. Decryption restores the original keys
+in their original order.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runFileSetObfuscateKeys,
+}
+
+var fileSetSchemaCmd = &cobra.Command{
+	Use:   "set-schema <file> <schema.json>",
+	Short: "Validate decrypted content against a JSON Schema",
+	Long: `Set a JSON Schema that the decrypted file must satisfy. Decryption fails
+when the content does not match, so a corrupted or incomplete config never
+reaches a deploy.
+
+INI files are validated as an object of sections and ENV files as an object
+of string values. The schema path is stored relative to the project root.`,
+	Args: cobra.ExactArgs(2),
+	RunE: runFileSetSchema,
+}
+
+var fileClearSchemaCmd = &cobra.Command{
+	Use:   "clear-schema <file>",
+	Short: "Remove the JSON Schema from a file",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runFileClearSchema,
+}
+
+var fileHookWarn bool
+
+var fileSetHookCmd = &cobra.Command{
+	Use:   "set-hook <file> <stage> <command>",
+	Short: "Run a command before or after a file is encrypted or decrypted",
+	Long: `Declare a command to run at a stage: pre_encrypt, post_encrypt,
+pre_decrypt or post_decrypt (dashes work too). For example, lint before
+encrypting or apply a manifest after decrypting:
+
+  shhh file set-hook k8s/secret.yaml post-decrypt 'kubectl apply -f "$SHHH_FILE_PATH"'
+
+The command runs through sh in the project root with SHHH_HOOK, SHHH_FILE,
+SHHH_FILE_PATH, SHHH_ENC_PATH, SHHH_VAULT and SHHH_STATE set. A failing
+pre hook stops the encryption or decryption of the file.
+
+The validate stage checks plaintext before it is encrypted by encrypt, edit
+or apply. It gets the plaintext on stdin, so validators must read it from
+there, and its output is only shown when it fails:
+
+  shhh file set-hook config/app.json validate 'jq .'
+  shhh file set-hook config/app.yaml validate 'yamllint -'
+
+A failing validate hook refuses to encrypt, and 'shhh edit' offers to edit
+again; with --warn it only prints a warning.
+
+Hooks are stored in the vault file, which anyone who can push to the
+repository can change, so a hook only runs on a machine once its user has
+reviewed and trusted it with 'shhh hooks trust'; until then it is skipped
+with a warning. Setting a hook trusts it for you. Set SHHH_NO_HOOKS=1 to
+disable all hooks.`,
+	Args: cobra.ExactArgs(3),
+	RunE: runFileSetHook,
+}
+
+var fileClearHookCmd = &cobra.Command{
+	Use:   "clear-hook <file> <stage>",
+	Short: "Remove a file hook",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runFileClearHook,
+}
+
+var (
+	fileShowMask   bool
+	fileShowJSON   bool
+	fileShowValues bool
+)
+
 var fileShowCmd = &cobra.Command{
 	Use:   "show <file>",
 	Short: "Show file settings and status",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runFileShow,
+	Long: `Show a file's registration, recipients and encryption status.
+
+With --mask, the .enc file is decrypted in memory and every value is listed
+with only its first and last two characters shown (e.g. "sk****9f"), to
+confirm which credential is configured without exposing it.
+
+With --values, each value of a values-mode file is listed with the users it
+is encrypted to, read from the key IDs recorded in its ENC token when
+value_key_ids is enabled; no private key is needed. Values encrypted to
+other users than the file's current recipients, e.g. after an interrupted
+re-encryption, are flagged.
+
+With --json, gpg_copy is null when the file inherits the global gpg_copy
+setting; gpg_copy_effective is the value that applies.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runFileShow,
+}
+
+// fileInfo is the JSON form of a registered file, used by 'file show --json'
+// and 'list --json'.
+type fileInfo struct {
+	Path  string `json:"path"`
+	Vault string `json:"vault"`
+	Mode  string `json:"mode"`
+	// EncryptedMode is the mode of the .enc, which differs from Mode for
+	// unsupported formats in values mode and after a mode change.
+	EncryptedMode string `json:"encrypted_mode,omitempty"`
+	FullFallback  bool   `json:"full_fallback,omitempty"`
+	State         string `json:"state"`
+	// GPGCopy is the per-file override, or nil to inherit the global
+	// gpg_copy setting.
+	GPGCopy          *bool            `json:"gpg_copy"`
+	GPGCopyEffective bool             `json:"gpg_copy_effective"`
+	Recipients       []string         `json:"recipients,omitempty"`
+	Readers          []string         `json:"readers"`
+	Format           string           `json:"format,omitempty"`
+	Schema           string           `json:"schema,omitempty"`
+	ObfuscateKeys    bool             `json:"obfuscate_keys,omitempty"`
+	Example          bool             `json:"example,omitempty"`
+	Hooks            *hooks.FileHooks `json:"hooks,omitempty"`
+	RegisteredAt     time.Time        `json:"registered_at"`
+}
+
+func newFileInfo(s *store.Store, vault string, fileReg *config.RegisteredFile) fileInfo {
+	info := fileInfo{
+		Path:             fileReg.Path,
+		Vault:            vault,
+		Mode:             fileReg.Mode,
+		EncryptedMode:    encryptedMode(s.Root(), fileReg.Path),
+		FullFallback:     fileReg.FullFallback,
+		State:            getFileStatus(s.Root(), fileReg.Path),
+		GPGCopy:          fileReg.GPGCopy,
+		GPGCopyEffective: config.GetEffectiveGPGCopy(s, fileReg),
+		Recipients:       fileReg.Recipients,
+		Readers:          []string{},
+		Format:           fileReg.Format,
+		Schema:           fileReg.Schema,
+		ObfuscateKeys:    fileReg.ObfuscateKeys,
+		Example:          fileReg.Example,
+		RegisteredAt:     fileReg.RegisteredAt,
+	}
+	if !fileReg.Hooks.IsZero() {
+		info.Hooks = fileReg.Hooks
+	}
+	if readers, err := config.GetEffectiveRecipients(s, vault, fileReg); err == nil {
+		info.Readers = readers
+	}
+	return info
 }
 
 func runFileSetRecipients(cmd *cobra.Command, args []string) error {
@@ -130,6 +313,15 @@ func runFileSetRecipients(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	recipients, err = collectRecipients(s, vault, recipients, fileRecipientsFile)
+	if err != nil {
+		return err
+	}
+
+	if len(recipients) == 0 {
+		return fmt.Errorf("specify recipients or --recipients-file")
+	}
+
 	if err := config.SetFileRecipients(s, vault, relPath, recipients); err != nil {
 		return err
 	}
@@ -139,6 +331,29 @@ func runFileSetRecipients(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runFileMove(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	filePath := strings.TrimSuffix(args[0], ".enc")
+	toVault := args[1]
+
+	relPath, err := projectRelPath(s, filePath)
+	if err != nil {
+		return err
+	}
+
+	if err := config.MoveFile(s, relPath, fileMoveFrom, toVault); err != nil {
+		return err
+	}
+
+	fmt.Printf("Moved %s to vault %s\n", relPath, toVault)
+	fmt.Println("Note: Run 'shhh reencrypt' to apply the new vault's recipients")
+	return nil
+}
+
 func runFileClearRecipients(cmd *cobra.Command, args []string) error {
 	s, err := store.GetStore()
 	if err != nil {
@@ -344,6 +559,165 @@ func runFileClearGPGCopy(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func runFileSetObfuscateKeys(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	filePath := args[0]
+	valueStr := strings.ToLower(args[1])
+
+	obfuscate := valueStr == "true" || valueStr == "1" || valueStr == "yes"
+
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	relPath, err := filepath.Rel(s.Root(), absPath)
+	if err != nil {
+		return fmt.Errorf("file must be within project directory: %w", err)
+	}
+
+	vault, fileReg, err := config.FindFileVault(s, relPath)
+	if err != nil {
+		return err
+	}
+
+	if obfuscate {
+		if format := fileReg.Spec().FormatOf(relPath); format != parser.FormatYAML && format != parser.FormatJSON {
+			return fmt.Errorf("obfuscate_keys is only supported for YAML and JSON files")
+		}
+	}
+
+	if err := config.SetFileObfuscateKeys(s, vault, relPath, obfuscate); err != nil {
+		return err
+	}
+
+	if obfuscate {
+		fmt.Printf("Enabled key obfuscation for %s\n", relPath)
+	} else {
+		fmt.Printf("Disabled key obfuscation for %s\n", relPath)
+	}
+	fmt.Println("Note: Run 'shhh reencrypt' to apply the change")
+
+	return nil
+}
+
+func runFileSetSchema(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	relPath, err := projectRelPath(s, args[0])
+	if err != nil {
+		return err
+	}
+
+	schemaPath, err := projectRelPath(s, args[1])
+	if err != nil {
+		return err
+	}
+
+	vault, fileReg, err := config.FindFileVault(s, relPath)
+	if err != nil {
+		return err
+	}
+
+	if fileReg.Spec().FormatOf(relPath) == parser.FormatUnknown {
+		return fmt.Errorf("schema validation is only supported for YAML, JSON, INI and ENV files")
+	}
+
+	if _, err := schema.Load(filepath.Join(s.Root(), schemaPath)); err != nil {
+		return err
+	}
+
+	if err := config.SetFileSchema(s, vault, relPath, schemaPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Set schema for %s: %s\n", relPath, schemaPath)
+	return nil
+}
+
+func runFileSetHook(cmd *cobra.Command, args []string) error {
+	return setFileHook(args[0], args[1], args[2])
+}
+
+func runFileClearHook(cmd *cobra.Command, args []string) error {
+	return setFileHook(args[0], args[1], "")
+}
+
+func setFileHook(file, stageName, command string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	stage, err := hooks.ParseStage(stageName)
+	if err != nil {
+		return err
+	}
+
+	relPath, err := projectRelPath(s, file)
+	if err != nil {
+		return err
+	}
+
+	vault, _, err := config.FindFileVault(s, relPath)
+	if err != nil {
+		return err
+	}
+
+	if fileHookWarn && stage != hooks.Validate {
+		return fmt.Errorf("--warn only applies to the %s stage", hooks.Validate)
+	}
+	if stage == hooks.Validate {
+		err = config.SetValidateHook(s, vault, relPath, command, fileHookWarn)
+	} else {
+		err = config.SetFileHook(s, vault, relPath, stage, command)
+	}
+	if err != nil {
+		return err
+	}
+
+	if command == "" {
+		fmt.Printf("Cleared %s hook for %s\n", stage, relPath)
+		return nil
+	}
+	fmt.Printf("Set %s hook for %s: %s\n", stage, relPath, command)
+	if err := hooks.Trust(s.Root(), command); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to trust the hook on this machine: %v\n", err)
+	}
+	return nil
+}
+
+func runFileClearSchema(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	relPath, err := projectRelPath(s, args[0])
+	if err != nil {
+		return err
+	}
+
+	vault, _, err := config.FindFileVault(s, relPath)
+	if err != nil {
+		return err
+	}
+
+	if err := config.SetFileSchema(s, vault, relPath, ""); err != nil {
+		return err
+	}
+
+	fmt.Printf("Cleared schema for %s\n", relPath)
+	return nil
+}
+
 func runFileShow(cmd *cobra.Command, args []string) error {
 	s, err := store.GetStore()
 	if err != nil {
@@ -367,6 +741,18 @@ func runFileShow(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if fileShowJSON {
+		if fileShowMask {
+			return fmt.Errorf("--json and --mask cannot be used together")
+		}
+		data, err := json.MarshalIndent(newFileInfo(s, vault, fileReg), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
 	fmt.Printf("File: %s\n\n", relPath)
 
 	fmt.Printf("Registration:\n")
@@ -381,6 +767,38 @@ func runFileShow(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  GPG Copy: %v (from global config)\n", effectiveGPGCopy)
 	}
 
+	if fileReg.ObfuscateKeys {
+		fmt.Println("  Obfuscate Keys: true")
+	}
+	if fileReg.Example {
+		fmt.Printf("  Example: %s%s (kept in sync)\n", relPath, exampleSuffix)
+	}
+	if fileReg.Format != "" {
+		fmt.Printf("  Format: %s (override)\n", fileReg.Format)
+	}
+	if fileReg.FullFallback {
+		fmt.Println("  Format: not supported by values mode; the whole file is encrypted")
+	}
+	if o := fileReg.LineOptions; o != nil {
+		if o.Delimiter != "" {
+			fmt.Printf("  Delimiter: %q\n", o.Delimiter)
+		}
+		if len(o.Comments) > 0 {
+			fmt.Printf("  Comments: %s\n", strings.Join(o.Comments, " "))
+		}
+	}
+	if fileReg.Schema != "" {
+		fmt.Printf("  Schema: %s\n", fileReg.Schema)
+	}
+	for _, stage := range hooks.Stages {
+		if command := fileReg.Hooks.Get(stage); command != "" {
+			if stage == hooks.Validate && fileReg.Hooks.ValidateWarn {
+				command += " (warn only)"
+			}
+			fmt.Printf("  Hook %s: %s\n", stage, command)
+		}
+	}
+
 	fmt.Printf("  Registered: %s\n", fileReg.RegisteredAt.Format("2006-01-02 15:04:05"))
 	fmt.Println()
 
@@ -423,18 +841,139 @@ func runFileShow(cmd *cobra.Command, args []string) error {
 
 		content, err := os.ReadFile(encPath)
 		if err == nil {
-			meta, _ := crypto.GetFileMetadata(content, relPath)
+			meta, _ := crypto.ReadFileMetadata(content, encPath, relPath, fileReg.Spec())
 			if meta != nil {
 				fmt.Printf("    Version: %s\n", meta.Version)
-				fmt.Printf("    Encrypted: %s\n", meta.EncryptedAt.Format("2006-01-02 15:04:05"))
-				if len(meta.Recipients) > 0 {
+				if !meta.EncryptedAt.IsZero() {
+					fmt.Printf("    Encrypted: %s\n", meta.EncryptedAt.Format("2006-01-02 15:04:05"))
+				}
+				if meta.Revision > 0 {
+					fmt.Printf("    Revision: %d\n", meta.Revision)
+				}
+				keys := make([]string, 0, len(meta.Rotations))
+				for k := range meta.Rotations {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					fmt.Printf("    Rotated %s: %s\n", k, meta.Rotations[k].Format("2006-01-02 15:04:05"))
+				}
+				if len(meta.Recipients) > 0 && meta.Privacy != crypto.PrivacyHash {
 					fmt.Printf("    Recipients: %s\n", strings.Join(meta.Recipients, ", "))
 				}
+				if meta.Privacy != "" {
+					fmt.Printf("    Metadata privacy: %s\n", meta.Privacy)
+				}
+				if meta.Compression != "" {
+					fmt.Printf("    Compression: %s\n", meta.Compression)
+				}
 			}
 		}
 	} else {
 		fmt.Printf("not present\n")
 	}
 
+	if fileShowValues {
+		fmt.Println()
+		fmt.Printf("Value recipients:\n")
+		if !encExists {
+			fmt.Printf("  (no .enc file)\n")
+		} else if err := showValueRecipients(s, vault, fileReg, encPath); err != nil {
+			return err
+		}
+	}
+
+	if fileShowMask {
+		fmt.Println()
+		fmt.Printf("Values:\n")
+		if !encExists {
+			fmt.Printf("  (no .enc file)\n")
+			return nil
+		}
+		decrypted, err := decryptRegisteredFile(s, fileReg)
+		if err != nil {
+			return err
+		}
+		values, err := secretValues(fileReg, decrypted)
+		if err != nil {
+			return err
+		}
+		for _, kv := range values {
+			fmt.Printf("  %s: %s\n", kv.Key, parser.PartialMask(kv.Value))
+		}
+	}
+
+	return nil
+}
+
+// showValueRecipients lists the users each value of a file is encrypted to,
+// from the key IDs recorded in its ENC tokens.
+func showValueRecipients(s *store.Store, vault string, fileReg *config.RegisteredFile, encPath string) error {
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+	if crypto.IsFullyEncrypted(content) {
+		fmt.Printf("  (full-mode file: encrypted as a whole)\n")
+		return nil
+	}
+
+	values, err := crypto.EncryptedValues(content, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return fmt.Errorf("failed to read values: %w", err)
+	}
+
+	v, err := config.LoadVault(s, vault)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	names := make(map[string]string)
+	for _, u := range v.Users {
+		names[crypto.LongKeyID(u.Fingerprint)] = u.Email
+	}
+
+	var want []string
+	if recipients, err := config.GetEffectiveRecipients(s, vault, fileReg); err == nil {
+		for _, r := range recipients {
+			if u := v.GetUser(r); u != nil {
+				want = append(want, crypto.LongKeyID(u.Fingerprint))
+			}
+		}
+	}
+	sort.Strings(want)
+
+	outdated, unannotated := 0, 0
+	for _, kv := range values {
+		if !parser.IsEncrypted(kv.Value) {
+			continue
+		}
+		keyIDs := parser.ValueKeyIDs(kv.Value)
+		if keyIDs == nil {
+			unannotated++
+			fmt.Printf("  ? %s: (no key IDs recorded)\n", kv.Key)
+			continue
+		}
+
+		readers := make([]string, len(keyIDs))
+		for i, id := range keyIDs {
+			readers[i] = id
+			if email, ok := names[id]; ok {
+				readers[i] = email
+			}
+		}
+		icon := "✓"
+		if strings.Join(keyIDs, ",") != strings.Join(want, ",") {
+			icon = "!"
+			outdated++
+		}
+		fmt.Printf("  %s %s: %s\n", icon, kv.Key, strings.Join(readers, ", "))
+	}
+
+	if outdated > 0 {
+		fmt.Printf("\n  %d value(s) are not encrypted to the current recipients (run 'shhh reencrypt')\n", outdated)
+	}
+	if unannotated > 0 {
+		fmt.Printf("\n  %d value(s) have no key IDs (enable value_key_ids and re-encrypt)\n", unannotated)
+	}
 	return nil
 }
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var fsckFix bool
+
+func init() {
+	rootCmd.AddCommand(fsckCmd)
+	fsckCmd.Flags().BoolVar(&fsckFix, "fix", false, "Repair what can be repaired safely")
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Validate and repair the .shhh store",
+	Long: `Check config.yaml and every vault.yaml for unknown keys, invalid values,
+duplicate users and files, files registered in several vaults, missing
+public key caches, and registrations whose files are gone.
+
+With --fix, shhh creates missing vault files, normalizes registered paths,
+drops duplicate entries within a vault, and re-exports missing public keys
+from your keyring. Files registered in several vaults must be resolved by
+hand with 'shhh file move' or 'shhh unregister --vault'.`,
+	RunE: runFsck,
+}
+
+func runFsck(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	issues, err := config.Fsck(s, fsckFix)
+	if err != nil {
+		return err
+	}
+
+	if len(issues) == 0 {
+		fmt.Println("No problems found")
+		return nil
+	}
+
+	var errs, warnings, fixed int
+	for _, issue := range issues {
+		kind := "error"
+		if issue.Warning {
+			kind = "warning"
+		}
+		suffix := ""
+		switch {
+		case issue.Fixed:
+			suffix = " (fixed)"
+			fixed++
+		case issue.Warning:
+			warnings++
+		default:
+			errs++
+		}
+		fmt.Printf("%-7s %s: %s%s\n", kind, issue.File, issue.Message, suffix)
+	}
+
+	fmt.Printf("\n%d error(s), %d warning(s), %d fixed\n", errs, warnings, fixed)
+	if errs > 0 {
+		if !fsckFix {
+			return fmt.Errorf("store has problems (run 'shhh fsck --fix' to repair what can be repaired)")
+		}
+		return fmt.Errorf("store has problems that must be fixed by hand")
+	}
+	return nil
+}
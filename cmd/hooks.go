@@ -0,0 +1,284 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+func init() {
+	rootCmd.AddCommand(hooksCmd)
+	hooksCmd.AddCommand(hooksListCmd)
+	hooksCmd.AddCommand(hooksTestCmd)
+	hooksCmd.AddCommand(hooksTrustCmd)
+	hooksCmd.AddCommand(hooksUntrustCmd)
+
+	hooksTrustCmd.Flags().BoolVarP(&hooksTrustYes, "yes", "y", false, "Trust the listed commands without asking")
+}
+
+var hooksTrustYes bool
+
+var hooksCmd = &cobra.Command{
+	Use:   "hooks",
+	Short: "Manage notification hooks",
+	Long: `Hooks in .shhh/hooks.yaml run a command or post to a webhook when
+the project changes, e.g. to notify a chat channel or trigger a pipeline:
+
+  hooks:
+    - events: [user_added, user_removed]
+      url: https://hooks.slack.com/services/...
+      format: slack
+    - events: [stale_detected]
+      exec: ./scripts/open-ticket.sh
+
+Events: user_added and user_removed (shhh user), reencrypt_completed
+(shhh reencrypt), stale_detected (shhh sync finding files encrypted for
+outdated recipients), and plaintext_committed (shhh sync or ci verify
+finding registered plaintext tracked by git). A hook without events fires
+for all of them. Exec hooks run through sh in the project root with
+the event as JSON on stdin and in SHHH_EVENT, SHHH_VAULT, SHHH_USER,
+SHHH_FILES, and SHHH_SUMMARY. Webhooks receive the event JSON, or
+{"text": ...} with format: slack.
+
+hooks.yaml is committed, so anyone who can push to the repository can
+change it. An exec hook therefore only runs on a machine once its user has
+reviewed and trusted it with 'shhh hooks trust', and is skipped with a
+warning until then. Set SHHH_NO_HOOKS=1 to disable all hooks.`,
+}
+
+var hooksTrustCmd = &cobra.Command{
+	Use:   "trust",
+	Short: "Review the project's hook commands and allow them on this machine",
+	Long: `List the hook commands of the project that have not been trusted on
+this machine and, once confirmed, allow them to run.
+
+Hook commands come from committed files that anyone who can push to the
+repository can change, so none of them runs until you trust it. The same
+goes for config.yaml settings that run a program (gpg_binary, gpg_args,
+editor and editor.<type>, and gnupg_home, whose gpg.conf can), which are
+ignored until trusted. Trust is
+recorded per project and command, as hashes in a file under your user
+config directory ($XDG_CONFIG_HOME/shhh/trusted-hooks on Linux) that is
+never committed. A changed command is untrusted again until reviewed.`,
+	Args: cobra.NoArgs,
+	RunE: runHooksTrust,
+}
+
+var hooksUntrustCmd = &cobra.Command{
+	Use:   "untrust",
+	Short: "Stop the project's hook commands from running on this machine",
+	Args:  cobra.NoArgs,
+	RunE:  runHooksUntrust,
+}
+
+var hooksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List configured hooks",
+	RunE:  runHooksList,
+}
+
+var hooksTestCmd = &cobra.Command{
+	Use:   "test <event>",
+	Short: "Fire the hooks for an event with sample data",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runHooksTest,
+}
+
+func runHooksList(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	f, err := hooks.Load(s)
+	if err != nil {
+		return err
+	}
+
+	if len(f.Hooks) == 0 {
+		fmt.Printf("No hooks configured (see 'shhh hooks --help')\n")
+		return nil
+	}
+
+	for _, h := range f.Hooks {
+		events := "all events"
+		if len(h.Events) > 0 {
+			events = strings.Join(h.Events, ", ")
+		}
+		if h.Exec != "" {
+			trust := ""
+			if !hooks.Trusted(s.Root(), h.Exec) {
+				trust = ", not trusted"
+			}
+			fmt.Printf("  exec %s (%s%s)\n", h.Exec, events, trust)
+		} else {
+			// Only the host: webhook paths usually carry a token.
+			fmt.Printf("  webhook %s (%s)\n", webhookHost(h.URL), events)
+		}
+	}
+	return nil
+}
+
+func runHooksTest(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	event := hooks.Event{
+		Name:  args[0],
+		Vault: store.DefaultVault,
+		User:  "test@example.com",
+		Files: []string{"example.yaml"},
+	}
+	if !containsString(hooks.Events, event.Name) {
+		return fmt.Errorf("unknown event %q (use %s)", event.Name, strings.Join(hooks.Events, ", "))
+	}
+
+	if errs := hooks.Fire(s, event); len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+		}
+		return fmt.Errorf("%d hook(s) failed", len(errs))
+	}
+
+	fmt.Printf("Fired %s\n", event.Name)
+	return nil
+}
+
+// hookCommand is a command a project runs as a hook, with where it is
+// declared.
+type hookCommand struct {
+	Source  string
+	Command string
+}
+
+// projectHookCommands lists every hook command of the project: the exec
+// hooks of hooks.yaml, the command settings of config.yaml and the hooks of
+// each registered file.
+func projectHookCommands(s *store.Store) ([]hookCommand, error) {
+	f, err := hooks.Load(s)
+	if err != nil {
+		return nil, err
+	}
+	var commands []hookCommand
+	for _, h := range f.Hooks {
+		if h.Exec != "" {
+			commands = append(commands, hookCommand{Source: store.HooksFile, Command: h.Exec})
+		}
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, cs := range cfg.CommandSettings() {
+		commands = append(commands, hookCommand{Source: store.ConfigFile, Command: cs.String()})
+	}
+
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range vaults {
+		vault, err := config.LoadVault(s, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", name, err)
+		}
+		for _, f := range vault.Files {
+			for _, stage := range hooks.Stages {
+				if command := f.Hooks.Get(stage); command != "" {
+					commands = append(commands, hookCommand{Source: f.Path + " " + stage, Command: command})
+				}
+			}
+		}
+	}
+	return commands, nil
+}
+
+func runHooksTrust(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+	commands, err := projectHookCommands(s)
+	if err != nil {
+		return err
+	}
+
+	var untrusted []string
+	for _, c := range commands {
+		if hooks.Trusted(s.Root(), c.Command) || containsString(untrusted, c.Command) {
+			continue
+		}
+		if len(untrusted) == 0 {
+			fmt.Println("Commands not trusted on this machine:")
+		}
+		fmt.Printf("  %s: %s\n", c.Source, c.Command)
+		untrusted = append(untrusted, c.Command)
+	}
+	if len(untrusted) == 0 {
+		fmt.Println("All commands are trusted")
+		return nil
+	}
+
+	if !hooksTrustYes {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("no terminal to confirm on; review the commands, then run 'shhh hooks trust --yes'")
+		}
+		if !confirm(bufio.NewReader(os.Stdin), fmt.Sprintf("Allow these %d command(s) to run on this machine?", len(untrusted))) {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+	if err := hooks.Trust(s.Root(), untrusted...); err != nil {
+		return fmt.Errorf("failed to record trusted hooks: %w", err)
+	}
+	fmt.Printf("Trusted %d command(s)\n", len(untrusted))
+	return nil
+}
+
+func runHooksUntrust(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+	commands, err := projectHookCommands(s)
+	if err != nil {
+		return err
+	}
+
+	list := make([]string, len(commands))
+	for i, c := range commands {
+		list[i] = c.Command
+	}
+	if err := hooks.Untrust(s.Root(), list...); err != nil {
+		return fmt.Errorf("failed to update trusted hooks: %w", err)
+	}
+	fmt.Println("Hook commands and command settings of this project will not be used on this machine until trusted again")
+	return nil
+}
+
+// fireHook runs the hooks for an event, reporting failures as warnings so
+// they never fail the command itself.
+func fireHook(s *store.Store, event hooks.Event) {
+	for _, err := range hooks.Fire(s, event) {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+func webhookHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid url)"
+	}
+	return u.Host
+}
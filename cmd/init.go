@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 
 	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/gitignore"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
@@ -31,6 +32,13 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get working directory: %w", err)
 	}
 
+	if rootDir != "" {
+		cwd, err = filepath.Abs(rootDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve root: %w", err)
+		}
+	}
+
 	s := store.New(cwd)
 
 	if s.IsInitialized() {
@@ -53,9 +61,14 @@ func runInit(cmd *cobra.Command, args []string) error {
 
 	isGit := isGitRepo(cwd)
 
+	if err := gitignore.EnsureAttributes(cwd, cfg.GitMergeDriver); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update .gitattributes: %v\n", err)
+	}
+
 	fmt.Println("Initialized shhh in", cwd)
 	fmt.Println("  Created .shhh/ directory")
 	fmt.Println("  Created default vault")
+	fmt.Println("  Marked *.enc as binary in .gitattributes")
 	if isGit {
 		fmt.Println("  Detected git repository")
 	}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/k8s"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	k8sName      string
+	k8sNamespace string
+	k8sOutput    string
+	k8sCert      string
+	k8sScope     string
+	k8sStore     string
+	k8sStoreKind string
+	k8sRemoteKey string
+)
+
+func init() {
+	rootCmd.AddCommand(k8sCmd)
+	k8sCmd.AddCommand(k8sSealCmd)
+	k8sCmd.AddCommand(k8sExternalSecretCmd)
+
+	k8sCmd.PersistentFlags().StringVar(&k8sName, "name", "", "Secret name (default: derived from the file name)")
+	k8sCmd.PersistentFlags().StringVarP(&k8sNamespace, "namespace", "n", "default", "Secret namespace")
+	k8sCmd.PersistentFlags().StringVarP(&k8sOutput, "output", "o", "", "Write the manifest to a file instead of stdout")
+
+	k8sSealCmd.Flags().StringVar(&k8sCert, "cert", "", "Sealed-secrets controller certificate (kubeseal --fetch-cert)")
+	k8sSealCmd.Flags().StringVar(&k8sScope, "scope", k8s.ScopeStrict, "Sealing scope: strict, namespace-wide, or cluster-wide")
+	k8sSealCmd.MarkFlagRequired("cert")
+
+	k8sExternalSecretCmd.Flags().StringVar(&k8sStore, "store", "", "SecretStore name to reference")
+	k8sExternalSecretCmd.Flags().StringVar(&k8sStoreKind, "store-kind", "SecretStore", "SecretStore kind: SecretStore or ClusterSecretStore")
+	k8sExternalSecretCmd.Flags().StringVar(&k8sRemoteKey, "remote-key", "", "Key of the remote secret holding the values (default: secret name)")
+	k8sExternalSecretCmd.MarkFlagRequired("store")
+}
+
+var k8sCmd = &cobra.Command{
+	Use:   "k8s",
+	Short: "Convert registered files into Kubernetes secret resources",
+	Long:  `Bridge shhh-managed files with in-cluster secret controllers.`,
+}
+
+var k8sSealCmd = &cobra.Command{
+	Use:   "seal <file>",
+	Short: "Convert a registered file into a Bitnami SealedSecret",
+	Long: `Decrypt a registered file and seal each value for the sealed-secrets
+controller, producing a SealedSecret manifest that is safe to commit.
+
+Each value becomes one Secret key named after its key path. Plaintext never
+leaves memory; only the controller can unseal the result.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runK8sSeal,
+}
+
+var k8sExternalSecretCmd = &cobra.Command{
+	Use:   "external-secret <file>",
+	Short: "Generate an ExternalSecret referencing a registered file's keys",
+	Long: `Generate an External Secrets Operator ExternalSecret whose data entries
+map each key path of a registered file to a property of one remote secret.
+
+The manifest contains no secret values; load the values into the referenced
+store separately.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runK8sExternalSecret,
+}
+
+func runK8sSeal(cmd *cobra.Command, args []string) error {
+	certData, err := os.ReadFile(k8sCert)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	pubKey, err := k8s.ParsePublicKey(certData)
+	if err != nil {
+		return err
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	_, fileReg, err := resolveRegisteredFile(s, args[0])
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+
+	values, err := secretValues(fileReg, decrypted)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := k8s.NewSealedSecret(pubKey, k8sResourceName(fileReg.Path), k8sNamespace, k8sScope, values)
+	if err != nil {
+		return err
+	}
+
+	return writeK8sManifest(sealed)
+}
+
+func runK8sExternalSecret(cmd *cobra.Command, args []string) error {
+	if k8sStoreKind != "SecretStore" && k8sStoreKind != "ClusterSecretStore" {
+		return fmt.Errorf("invalid store kind: %s (must be SecretStore or ClusterSecretStore)", k8sStoreKind)
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	_, fileReg, err := resolveRegisteredFile(s, args[0])
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+
+	values, err := secretValues(fileReg, decrypted)
+	if err != nil {
+		return err
+	}
+
+	name := k8sResourceName(fileReg.Path)
+	remoteKey := k8sRemoteKey
+	if remoteKey == "" {
+		remoteKey = name
+	}
+
+	return writeK8sManifest(k8s.NewExternalSecret(name, k8sNamespace, k8sStore, k8sStoreKind, remoteKey, values))
+}
+
+func k8sResourceName(path string) string {
+	if k8sName != "" {
+		return k8sName
+	}
+	base := filepath.Base(path)
+	return k8s.ResourceName(strings.TrimSuffix(base, filepath.Ext(base)))
+}
+
+func writeK8sManifest(manifest interface{}) error {
+	data, err := k8s.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+
+	if k8sOutput == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(k8sOutput, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", k8sOutput)
+	return nil
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keygenEmail   string
+	keygenName    string
+	keygenAlgo    string
+	keygenExpires int
+	keygenForce   bool
+)
+
+func init() {
+	rootCmd.AddCommand(keygenCmd)
+	keygenCmd.Flags().StringVar(&keygenEmail, "email", "", "Email address for the key (required)")
+	keygenCmd.Flags().StringVar(&keygenName, "name", "", "Name for the key (default: the part of the email before @)")
+	keygenCmd.Flags().StringVar(&keygenAlgo, "algo", "ed25519", "Key algorithm: "+strings.Join(crypto.KeyAlgorithms, ", "))
+	keygenCmd.Flags().IntVar(&keygenExpires, "expires", 730, "Days until the key expires (0 for never)")
+	keygenCmd.Flags().BoolVar(&keygenForce, "force", false, "Generate a key even if one already exists for the email")
+	keygenCmd.MarkFlagRequired("email")
+}
+
+var keygenCmd = &cobra.Command{
+	Use:   "keygen --email <email>",
+	Short: "Generate an OpenPGP key pair for a new user",
+	Long: `Generate an OpenPGP key pair without using gpg directly.
+
+The private key is added to your local keyring (imported with gpg when it is
+installed, otherwise written to $GNUPGHOME/secring.gpg). It has no
+passphrase, so keep the keyring private; with gpg installed you can add one
+later with 'gpg --passwd <email>'. A keyring inside the project is added to
+.gitignore first, and one git tracks files in is refused.
+
+Inside a shhh project the public key is also exported to
+.shhh/pubkeys/<email>.asc, so a vault admin can add you once it is
+committed.`,
+	RunE: runKeygen,
+}
+
+func runKeygen(cmd *cobra.Command, args []string) error {
+	if err := config.ValidateEmail(keygenEmail); err != nil {
+		return err
+	}
+	if keygenExpires < 0 {
+		return fmt.Errorf("--expires must not be negative")
+	}
+
+	if !keygenForce {
+		if _, err := crypto.GetProvider().LookupKey(keygenEmail); err == nil {
+			return fmt.Errorf("a key for %s already exists (use --force to generate another)", keygenEmail)
+		}
+	}
+
+	name := keygenName
+	if name == "" {
+		name = keygenEmail[:strings.Index(keygenEmail, "@")]
+	}
+
+	// A keyring inside the project, such as gnupg_home ./ci/keyring, would
+	// otherwise be committed along with the unprotected private key.
+	if s, err := store.GetStore(); err == nil {
+		if err := ignoreProjectKeyring(s, crypto.GnuPGHome()); err != nil {
+			return err
+		}
+	}
+
+	entity, err := crypto.GenerateKey(name, keygenEmail, keygenAlgo, time.Duration(keygenExpires)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	location, err := crypto.StorePrivateKey(entity)
+	if err != nil {
+		return fmt.Errorf("failed to store private key: %w", err)
+	}
+
+	fingerprint := strings.ToUpper(fmt.Sprintf("%x", entity.PrimaryKey.Fingerprint))
+	fmt.Printf("Generated %s key for %s\n", keygenAlgo, keygenEmail)
+	fmt.Printf("  Fingerprint: %s\n", fingerprint)
+	if keygenExpires > 0 {
+		fmt.Printf("  Expires: %s\n", time.Now().AddDate(0, 0, keygenExpires).Format("2006-01-02"))
+	} else {
+		fmt.Println("  Expires: never")
+	}
+	fmt.Printf("  Private key: %s\n", location)
+
+	s, err := store.GetStore()
+	if err != nil {
+		fmt.Println("\nNot in a shhh project; export the public key with")
+		fmt.Printf("  gpg --armor --export %s\n", keygenEmail)
+		return nil
+	}
+
+	pubKey, err := crypto.ArmorPublicKey(entity)
+	if err != nil {
+		return err
+	}
+	pubPath := s.PubkeyPath(keygenEmail)
+	if err := s.WritePubkey(keygenEmail, pubKey); err != nil {
+		return fmt.Errorf("failed to export public key: %w", err)
+	}
+	relPub := strings.TrimPrefix(pubPath, s.Root()+string(os.PathSeparator))
+	fmt.Printf("  Public key: %s\n", relPub)
+
+	fmt.Println("\nNext steps:")
+	fmt.Printf("  1. Commit %s and open a pull request\n", relPub)
+	fmt.Printf("  2. A vault admin runs 'shhh user add %s' and 'shhh reencrypt --all'\n", keygenEmail)
+	fmt.Println("  3. After pulling, run 'shhh decrypt --all'")
+	fmt.Println("Back up your private key; without it you cannot decrypt anything.")
+	return nil
+}
+
+// ignoreProjectKeyring makes git ignore a GnuPG home inside the project
+// before a private key is written to it, and refuses one git already
+// tracks files in.
+func ignoreProjectKeyring(s *store.Store, home string) error {
+	abs, err := filepath.Abs(home)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s.Root(), abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return nil
+	}
+	if rel == "." || git.IsTracked(s.Root(), rel) {
+		return fmt.Errorf("refusing to write a private key to %s, which git tracks; use a GnuPG home outside the project (--gnupg-home)", home)
+	}
+	if err := gitignore.EnsureIgnored(s.Root(), rel); err != nil {
+		return fmt.Errorf("refusing to write a private key to %s inside the project: %w", rel, err)
+	}
+	fmt.Printf("Ignored %s in .gitignore, so the private key is not committed\n", filepath.ToSlash(rel))
+	return nil
+}
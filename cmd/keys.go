@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	keysVault     string
+	keysKeyserver string
+)
+
+func init() {
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(keysRefreshCmd)
+
+	keysRefreshCmd.Flags().StringVarP(&keysVault, "vault", "v", "", "Only refresh the users of this vault (default: every vault)")
+	keysRefreshCmd.Flags().StringVar(&keysKeyserver, "keyserver", "", "Fetch the users' keys from this keyserver first, e.g. hkps://keys.openpgp.org")
+}
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Maintain the public keys of vault users",
+}
+
+var keysRefreshCmd = &cobra.Command{
+	Use:   "refresh",
+	Short: "Pick up renewed keys of vault users",
+	Long: `Re-read the key of every vault user from the local keyring and record
+its current expiry date in the vault and its current public key, with any new
+subkeys, in .shhh/pubkeys/.
+
+With --keyserver, the users' keys are fetched by fingerprint with
+'gpg --recv-keys' first, so renewals published there are picked up.
+
+Users whose key is still missing, expired, revoked or expiring within
+expiry_warning_days, or whose keyring key has a different fingerprint, are
+reported and make the command fail. A different key is never adopted
+automatically; verify it and run 'shhh user add' again.`,
+	Args: cobra.NoArgs,
+	RunE: runKeysRefresh,
+}
+
+func runKeysRefresh(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var vaults []string
+	if keysVault != "" {
+		if !s.VaultExists(keysVault) {
+			return fmt.Errorf("vault %q does not exist", keysVault)
+		}
+		vaults = []string{keysVault}
+	} else if vaults, err = s.ListVaults(); err != nil {
+		return err
+	}
+
+	if keysKeyserver != "" {
+		fingerprints, err := vaultFingerprints(s, vaults)
+		if err != nil {
+			return err
+		}
+		if len(fingerprints) > 0 {
+			fmt.Printf("Fetching %d key(s) from %s\n", len(fingerprints), keysKeyserver)
+			if err := crypto.ReceiveKeys(keysKeyserver, fingerprints); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+		}
+	}
+
+	updated, needAction := 0, 0
+	for _, vaultName := range vaults {
+		results, err := config.RefreshUserKeys(s, vaultName)
+		if err != nil {
+			return fmt.Errorf("vault %s: %w", vaultName, err)
+		}
+		if len(results) == 0 {
+			continue
+		}
+
+		fmt.Printf("\nVault %s:\n", vaultName)
+		vaultUpdated := false
+		for _, r := range results {
+			line := fmt.Sprintf("  %s %s: %s", keyStatusIcon(r.Status), r.Email, r.Message)
+			if len(r.Changes) > 0 {
+				line += fmt.Sprintf(" (%s)", strings.Join(r.Changes, ", "))
+				updated++
+				vaultUpdated = true
+			}
+			fmt.Println(line)
+			if r.Status != "valid" {
+				needAction++
+			}
+		}
+
+		if vaultUpdated {
+			if err := config.RefreshCanary(s, vaultName); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to update vault canary: %v\n", err)
+			}
+		}
+	}
+
+	fmt.Printf("\nRefreshed %d user key(s)\n", updated)
+	if updated > 0 {
+		fmt.Println("Note: Run 'shhh reencrypt' if keys gained new subkeys, so existing secrets use them")
+	}
+	if needAction > 0 {
+		return fmt.Errorf("%d user key(s) still need action", needAction)
+	}
+	return nil
+}
+
+// vaultFingerprints returns the distinct key fingerprints of the users of
+// the given vaults.
+func vaultFingerprints(s *store.Store, vaults []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var fingerprints []string
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		for _, u := range vault.Users {
+			if u.Fingerprint != "" && !seen[u.Fingerprint] {
+				seen[u.Fingerprint] = true
+				fingerprints = append(fingerprints, u.Fingerprint)
+			}
+		}
+	}
+	return fingerprints, nil
+}
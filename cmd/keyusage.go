@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// auditCommand is the command being run, recorded with each decryption.
+var auditCommand string
+
+// recordedDecryptions holds what this process has already logged, so a
+// file or value decrypted repeatedly with the same keys is logged once.
+var recordedDecryptions struct {
+	sync.Mutex
+	seen map[string]bool
+}
+
+// recordDecryption logs which private keys decrypted a file to the local
+// audit log, so access to credentials on a shared machine can be traced
+// back to a key and the command that used it. Decryptions outside a
+// project are not logged.
+func recordDecryption(what string, keys []crypto.DecryptionKey) {
+	s, err := store.GetStore()
+	if err != nil {
+		return
+	}
+
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.String()
+	}
+	entry := what + "\t" + strings.Join(names, ", ")
+
+	recordedDecryptions.Lock()
+	defer recordedDecryptions.Unlock()
+	if recordedDecryptions.seen[entry] {
+		return
+	}
+	if recordedDecryptions.seen == nil {
+		recordedDecryptions.seen = make(map[string]bool)
+	}
+	recordedDecryptions.seen[entry] = true
+
+	if err := config.RecordDecryption(s, what, names, auditCommand, time.Now()); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record decryption in audit log: %v\n", err)
+	}
+}
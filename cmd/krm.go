@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cychiuae/shhh/internal/k8s"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(krmCmd)
+}
+
+var krmCmd = &cobra.Command{
+	Use:   "krm",
+	Short: "Generate Kubernetes Secrets as a kustomize exec plugin",
+	Long: `Run as a KRM function: read a ResourceList on stdin and print it back
+with a Secret holding the decrypted values of the listed registered files,
+one data key per key path.
+
+Reference a generator config from kustomization.yaml:
+
+  generators:
+    - secret-generator.yaml
+
+  # secret-generator.yaml
+  apiVersion: shhh.dev/v1
+  kind: SecretGenerator
+  metadata:
+    name: app-secrets
+    namespace: prod
+    annotations:
+      config.kubernetes.io/function: |
+        exec:
+          path: shhh
+          args: [krm]
+  files:
+    - config/app.yaml
+  type: Opaque                  # default
+  disableNameSuffixHash: false  # default; kustomize adds a hash suffix
+
+and build with 'kustomize build --enable-exec'. Files are resolved from the
+directory kustomize runs the plugin in, the kustomization's directory.`,
+	Args: cobra.NoArgs,
+	RunE: runKRM,
+}
+
+func runKRM(cmd *cobra.Command, args []string) error {
+	input, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read ResourceList: %w", err)
+	}
+
+	rl, gen, err := k8s.ParseResourceList(input)
+	if err != nil {
+		return err
+	}
+
+	var values []parser.KeyValue
+	for _, file := range gen.Files {
+		s, fileReg, err := findRegisteredFile(file)
+		if err != nil {
+			return err
+		}
+		decrypted, err := decryptRegisteredFile(s, fileReg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileReg.Path, err)
+		}
+		fileValues, err := secretValues(fileReg, decrypted)
+		secmem.Wipe(decrypted)
+		if err != nil {
+			return fmt.Errorf("%s: %w", fileReg.Path, err)
+		}
+		values = append(values, fileValues...)
+	}
+
+	secret, err := k8s.NewSecret(gen, values)
+	if err != nil {
+		return err
+	}
+	if err := rl.Append(secret); err != nil {
+		return fmt.Errorf("failed to encode Secret: %w", err)
+	}
+
+	data, err := k8s.Marshal(rl)
+	if err != nil {
+		return fmt.Errorf("failed to encode ResourceList: %w", err)
+	}
+	defer secmem.Wipe(data)
+	_, err = os.Stdout.Write(data)
+	return err
+}
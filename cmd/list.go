@@ -1,27 +1,52 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
 
 	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
-var listVault string
+var (
+	listVault      string
+	listSort       string
+	listMode       string
+	listRecipients string
+	listMismatch   bool
+	listJSON       bool
+)
 
 func init() {
 	rootCmd.AddCommand(listCmd)
 	listCmd.Flags().StringVarP(&listVault, "vault", "v", "", "List files in specific vault (default: all vaults)")
+	listCmd.Flags().StringVar(&listSort, "sort", "registered", "Sort files by path, registered, or mode")
+	listCmd.Flags().StringVar(&listMode, "mode", "", "Only files with this encryption mode (values or full)")
+	listCmd.Flags().StringVar(&listRecipients, "recipients", "", "Only files encrypted for this user")
+	listCmd.Flags().BoolVar(&listMismatch, "mode-mismatch", false, "Only files whose .enc was encrypted in a different mode than configured")
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Output as JSON")
 }
 
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List registered files",
-	Long:  `List all files registered for encryption across all vaults or a specific vault.`,
-	RunE:  runList,
+	Long: `List all files registered for encryption across all vaults or a specific vault.
+
+Files are grouped by vault in the order they were registered; use
+--sort path to list them alphabetically, or --sort mode to group values and
+full files. --mode and --recipients narrow the list, e.g. to the files a
+user can read.
+
+--mode-mismatch lists the files whose .enc does not use their configured
+mode: values-mode files whose format is not supported, which are encrypted
+whole, and files whose mode was changed without encrypting them again.`,
+	RunE: runList,
 }
 
 func runList(cmd *cobra.Command, args []string) error {
@@ -30,6 +55,13 @@ func runList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if listSort != "path" && listSort != "registered" && listSort != "mode" {
+		return fmt.Errorf("invalid sort %q (use path, registered, or mode)", listSort)
+	}
+	if listMode != "" && listMode != config.ModeValues && listMode != config.ModeFull {
+		return fmt.Errorf("invalid mode %q (use values or full)", listMode)
+	}
+
 	var vaults []string
 	if listVault != "" {
 		if !s.VaultExists(listVault) {
@@ -44,6 +76,7 @@ func runList(cmd *cobra.Command, args []string) error {
 	}
 
 	totalFiles := 0
+	infos := []fileInfo{}
 
 	for _, vaultName := range vaults {
 		vault, err := config.LoadVault(s, vaultName)
@@ -52,14 +85,23 @@ func runList(cmd *cobra.Command, args []string) error {
 			continue
 		}
 
-		if len(vault.Files) == 0 {
+		files := filterFiles(s, vault)
+		if len(files) == 0 {
+			continue
+		}
+		sortFiles(files)
+
+		if listJSON {
+			for i := range files {
+				infos = append(infos, newFileInfo(s, vaultName, &files[i]))
+			}
 			continue
 		}
 
 		fmt.Printf("Vault: %s\n", vaultName)
 		fmt.Println()
 
-		for _, f := range vault.Files {
+		for _, f := range files {
 			totalFiles++
 
 			status := getFileStatus(s.Root(), f.Path)
@@ -69,19 +111,91 @@ func runList(cmd *cobra.Command, args []string) error {
 				recipientStr = fmt.Sprintf("%d specific", recipientCount)
 			}
 
+			gpgCopyStr := "off"
+			if config.GetEffectiveGPGCopy(s, &f) {
+				gpgCopyStr = "on"
+			}
+			if f.GPGCopy != nil {
+				gpgCopyStr += " (per-file)"
+			}
+
 			fmt.Printf("  %s\n", f.Path)
-			fmt.Printf("    Mode: %s | Recipients: %s | Status: %s\n", f.Mode, recipientStr, status)
+			fmt.Printf("    Mode: %s | Recipients: %s | GPG copy: %s | Status: %s\n", f.Mode, recipientStr, gpgCopyStr, status)
+			if encMode := encryptedMode(s.Root(), f.Path); encMode != "" && encMode != f.Mode {
+				reason := "encrypt again to apply the configured mode"
+				if f.FullFallback {
+					reason = "format not supported by values mode"
+				}
+				fmt.Printf("    Encrypted as: %s (%s)\n", encMode, reason)
+			}
 		}
 		fmt.Println()
 	}
 
+	if listJSON {
+		data, err := json.MarshalIndent(infos, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
 	if totalFiles == 0 {
-		fmt.Println("No files registered")
+		if listMode != "" || listRecipients != "" || listMismatch {
+			fmt.Println("No matching files")
+		} else {
+			fmt.Println("No files registered")
+		}
 	}
 
 	return nil
 }
 
+// filterFiles returns the files of a vault matching --mode, --recipients
+// and --mode-mismatch.
+func filterFiles(s *store.Store, vault *config.Vault) []config.RegisteredFile {
+	var files []config.RegisteredFile
+	for _, f := range vault.Files {
+		if listMode != "" && f.Mode != listMode {
+			continue
+		}
+		if listMismatch {
+			if encMode := encryptedMode(s.Root(), f.Path); encMode == "" || encMode == f.Mode {
+				continue
+			}
+		}
+		if listRecipients != "" {
+			recipients := f.Recipients
+			if len(recipients) == 0 {
+				recipients = vault.Emails()
+			}
+			if !slices.Contains(recipients, listRecipients) {
+				continue
+			}
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func sortFiles(files []config.RegisteredFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		switch listSort {
+		case "registered":
+			if !a.RegisteredAt.Equal(b.RegisteredAt) {
+				return a.RegisteredAt.Before(b.RegisteredAt)
+			}
+		case "mode":
+			if a.Mode != b.Mode {
+				return a.Mode < b.Mode
+			}
+		}
+		return a.Path < b.Path
+	})
+}
+
 func getFileStatus(root, path string) string {
 	plainPath := filepath.Join(root, path)
 	encPath := plainPath + ".enc"
@@ -101,6 +215,19 @@ func getFileStatus(root, path string) string {
 	}
 }
 
+// encryptedMode returns the mode a file's .enc was encrypted in, or "" when
+// it has none.
+func encryptedMode(root, path string) string {
+	content, err := os.ReadFile(filepath.Join(root, path) + ".enc")
+	if err != nil {
+		return ""
+	}
+	if crypto.IsFullyEncrypted(content) {
+		return config.ModeFull
+	}
+	return config.ModeValues
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	matrixVault string
+	matrixJSON  bool
+)
+
+func init() {
+	rootCmd.AddCommand(matrixCmd)
+	matrixCmd.Flags().StringVarP(&matrixVault, "vault", "v", "", "Only show this vault (default: every vault)")
+	matrixCmd.Flags().BoolVar(&matrixJSON, "json", false, "Output as JSON")
+}
+
+var matrixCmd = &cobra.Command{
+	Use:   "matrix",
+	Short: "Show which users can decrypt which files",
+	Long: `Print a table per vault of registered files against users, showing who
+can decrypt each file. Each cell compares the file's configured recipients
+with the recipients recorded in its .enc:
+
+  ✓  can decrypt, as configured
+  ✗  cannot decrypt, as configured
+  +  can still decrypt, but is no longer a recipient (re-encrypt the file)
+  !  is a recipient, but cannot decrypt until the file is encrypted again
+  ?  unknown, because metadata_privacy omits recipients from the .enc
+
+Recipients recorded in a .enc who are no longer vault users get a column of
+their own, marked "(former)". Files without a .enc show what the next
+encryption will do. Only metadata is read; nothing is decrypted.`,
+	Args: cobra.NoArgs,
+	RunE: runMatrix,
+}
+
+// Cells of the access matrix.
+const (
+	accessYes     = "yes"
+	accessNo      = "no"
+	accessStale   = "stale"
+	accessPending = "pending"
+	accessUnknown = "unknown"
+)
+
+var accessIcons = map[string]string{
+	accessYes:     "✓",
+	accessNo:      "✗",
+	accessStale:   "+",
+	accessPending: "!",
+	accessUnknown: "?",
+}
+
+type vaultMatrix struct {
+	Vault string              `json:"vault"`
+	Users []string            `json:"users"`
+	Files []string            `json:"files"`
+	Cells map[string][]string `json:"access"`
+
+	former map[string]bool
+}
+
+func runMatrix(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var vaults []string
+	if matrixVault != "" {
+		if !s.VaultExists(matrixVault) {
+			return fmt.Errorf("vault %q does not exist", matrixVault)
+		}
+		vaults = []string{matrixVault}
+	} else if vaults, err = s.ListVaults(); err != nil {
+		return err
+	}
+
+	matrices := []*vaultMatrix{}
+	for _, vaultName := range vaults {
+		m, err := buildVaultMatrix(s, vaultName)
+		if err != nil {
+			return fmt.Errorf("vault %s: %w", vaultName, err)
+		}
+		if len(m.Files) > 0 {
+			matrices = append(matrices, m)
+		}
+	}
+
+	if matrixJSON {
+		data, err := json.MarshalIndent(matrices, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(matrices) == 0 {
+		fmt.Println("No files registered")
+		return nil
+	}
+	for i, m := range matrices {
+		if i > 0 {
+			fmt.Println()
+		}
+		printVaultMatrix(m)
+	}
+	fmt.Println("\n✓ can decrypt  ✗ cannot  + no longer a recipient  ! not encrypted for yet  ? unknown")
+	return nil
+}
+
+func buildVaultMatrix(s *store.Store, vaultName string) (*vaultMatrix, error) {
+	vault, err := config.LoadVault(s, vaultName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &vaultMatrix{Vault: vaultName, Users: []string{}, Files: []string{}, Cells: map[string][]string{}, former: map[string]bool{}}
+	for _, u := range vault.Users {
+		m.Users = append(m.Users, u.Email)
+	}
+
+	metas := make([]*crypto.FileMetadata, len(vault.Files))
+	for i := range vault.Files {
+		f := &vault.Files[i]
+		encPath := filepath.Join(s.Root(), f.Path) + ".enc"
+		content, err := os.ReadFile(encPath)
+		if err != nil {
+			continue
+		}
+		meta, err := crypto.ReadFileMetadata(content, encPath, f.Path, f.Spec())
+		if err != nil || meta == nil {
+			continue
+		}
+		metas[i] = meta
+		if meta.Privacy != "" {
+			continue
+		}
+		for _, r := range meta.Recipients {
+			if !slices.ContainsFunc(m.Users, func(u string) bool { return strings.EqualFold(u, r) }) {
+				m.Users = append(m.Users, r)
+				m.former[r] = true
+			}
+		}
+	}
+
+	for i := range vault.Files {
+		f := &vault.Files[i]
+		effective, err := config.GetEffectiveRecipients(s, vaultName, f)
+		if err != nil {
+			return nil, err
+		}
+
+		row := make([]string, len(m.Users))
+		for j, user := range m.Users {
+			configured := slices.ContainsFunc(effective, func(r string) bool { return strings.EqualFold(r, user) })
+			row[j] = accessCell(configured, metas[i], user, f.Path)
+		}
+		m.Files = append(m.Files, f.Path)
+		m.Cells[f.Path] = row
+	}
+	return m, nil
+}
+
+// accessCell compares whether user is a configured recipient of a file with
+// whether its .enc was encrypted for them. Without a .enc, the configured
+// recipients are what the next encryption will use.
+func accessCell(configured bool, meta *crypto.FileMetadata, user, relPath string) string {
+	if meta == nil {
+		if configured {
+			return accessPending
+		}
+		return accessNo
+	}
+	actual, known := meta.HasRecipient(user, relPath)
+	switch {
+	case !known:
+		return accessUnknown
+	case actual && configured:
+		return accessYes
+	case actual:
+		return accessStale
+	case configured:
+		return accessPending
+	default:
+		return accessNo
+	}
+}
+
+func printVaultMatrix(m *vaultMatrix) {
+	fmt.Printf("Vault: %s\n\n", m.Vault)
+
+	width := len("File")
+	for _, path := range m.Files {
+		width = max(width, utf8.RuneCountInString(path))
+	}
+	headers := make([]string, len(m.Users))
+	for j, user := range m.Users {
+		headers[j] = user
+		if m.former[user] {
+			headers[j] += " (former)"
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "  %-*s", width, "File")
+	for _, h := range headers {
+		fmt.Fprintf(&b, "  %s", h)
+	}
+	fmt.Println(b.String())
+
+	for _, path := range m.Files {
+		b.Reset()
+		fmt.Fprintf(&b, "  %-*s", width, path)
+		for j, cell := range m.Cells[path] {
+			// Center the cell under its header.
+			pad := utf8.RuneCountInString(headers[j]) - 1
+			fmt.Fprintf(&b, "  %s%s%s", strings.Repeat(" ", pad/2), accessIcons[cell], strings.Repeat(" ", pad-pad/2))
+		}
+		fmt.Println(strings.TrimRight(b.String(), " "))
+	}
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var metricsTextfile string
+
+func init() {
+	rootCmd.AddCommand(metricsCmd)
+	metricsCmd.Flags().StringVar(&metricsTextfile, "textfile", "", "Write metrics to a file for the node_exporter textfile collector")
+}
+
+var metricsCmd = &cobra.Command{
+	Use:   "metrics",
+	Short: "Print Prometheus metrics about the project",
+	Long: `Print gauges in the Prometheus text exposition format so fleet
+monitoring can alert on drift:
+
+  shhh_files                   registered files
+  shhh_files_pending           files not yet encrypted
+  shhh_files_modified          plaintext changed after encryption
+  shhh_files_stale             files encrypted for outdated recipients
+  shhh_files_rotation_overdue  files older than rotation_days
+  shhh_keys_expired            vault users with expired keys
+  shhh_keys_expiring           vault users whose keys expire within expiry_warning_days
+
+Each gauge is labelled with the project directory and vault. With
+--textfile the metrics are written atomically for node_exporter's textfile
+collector, e.g.
+'shhh metrics --textfile /var/lib/node_exporter/shhh.prom'.`,
+	RunE: runMetrics,
+}
+
+type metricDef struct {
+	name string
+	help string
+}
+
+var metricDefs = []metricDef{
+	{"shhh_files", "Registered files."},
+	{"shhh_files_pending", "Registered files that have not been encrypted."},
+	{"shhh_files_modified", "Files whose plaintext changed after encryption."},
+	{"shhh_files_stale", "Files encrypted for outdated recipients."},
+	{"shhh_files_rotation_overdue", "Files encrypted longer ago than rotation_days."},
+	{"shhh_keys_expired", "Vault users with expired keys."},
+	{"shhh_keys_expiring", "Vault users whose keys expire within expiry_warning_days."},
+}
+
+func runMetrics(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	values, err := collectMetrics(s, cfg.RotationDays)
+	if err != nil {
+		return err
+	}
+	out := formatMetrics(s.Root(), values)
+
+	if metricsTextfile == "" {
+		fmt.Print(out)
+		return nil
+	}
+
+	// Write to a temporary file and rename it, so the collector never reads
+	// a partial file.
+	tmp := metricsTextfile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(out), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := os.Rename(tmp, metricsTextfile); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+// collectMetrics returns, per vault, the value of each gauge in metricDefs.
+func collectMetrics(s *store.Store, rotationDays int) (map[string]map[string]int, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tracked := gitTrackedFiles(s)
+	values := make(map[string]map[string]int)
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+
+		m := make(map[string]int)
+		for _, d := range metricDefs {
+			m[d.name] = 0
+		}
+
+		days := config.ExpiryWarningDays(s, vault)
+		for _, u := range vault.Users {
+			if crypto.IsExpired(u.ExpiresAt) {
+				m["shhh_keys_expired"]++
+			} else if crypto.IsExpiringSoon(u.ExpiresAt, days) {
+				m["shhh_keys_expiring"]++
+			}
+		}
+
+		for i := range vault.Files {
+			f := &vault.Files[i]
+			status := statusForFile(s, vaultName, f, tracked)
+			m["shhh_files"]++
+			if status.State == "pending" {
+				m["shhh_files_pending"]++
+			}
+			if status.Modified {
+				m["shhh_files_modified"]++
+			}
+			if status.Stale {
+				m["shhh_files_stale"]++
+			}
+			if at := lastEncrypted(s, f); rotationDays > 0 && at != nil && now.Sub(*at) > time.Duration(rotationDays)*24*time.Hour {
+				m["shhh_files_rotation_overdue"]++
+			}
+		}
+
+		values[vaultName] = m
+	}
+
+	return values, nil
+}
+
+func formatMetrics(root string, values map[string]map[string]int) string {
+	var vaults []string
+	for v := range values {
+		vaults = append(vaults, v)
+	}
+	sort.Strings(vaults)
+
+	project := escapeLabel(filepath.Base(root))
+
+	var b strings.Builder
+	for _, d := range metricDefs {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", d.name, d.help, d.name)
+		for _, v := range vaults {
+			fmt.Fprintf(&b, "%s{project=\"%s\",vault=\"%s\"} %d\n", d.name, project, escapeLabel(v), values[v][d.name])
+		}
+	}
+	return b.String()
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	mountDir       string
+	mountOwner     string
+	mountAllowDisk bool
+	mountOverrides []string
+)
+
+func init() {
+	rootCmd.AddCommand(mountCmd)
+
+	mountCmd.Flags().StringVarP(&mountDir, "dir", "d", "/run/shhh", "Directory to decrypt the files into")
+	mountCmd.Flags().StringVar(&mountOwner, "owner", "", "Give the files to this user[:group], e.g. the service user")
+	mountCmd.Flags().BoolVar(&mountAllowDisk, "allow-disk", false, "Decrypt into --dir even if it is not on tmpfs")
+	addOverrideFlag(mountCmd, &mountOverrides)
+}
+
+var mountCmd = &cobra.Command{
+	Use:   "mount <file>... --dir <dir>",
+	Short: "Decrypt files into a tmpfs directory while running",
+	Long: `Decrypt registered files into a directory kept in memory, for services
+that read secrets from files, and remove them again when shhh exits.
+
+Each file is written below --dir at its registered path, with 0600
+permissions, or its recorded mode when preserve_permissions is set, in 0700
+directories. shhh then waits until it receives SIGTERM, SIGINT or SIGHUP and
+removes the files and the directories it created.
+
+--dir must be on tmpfs or ramfs, such as /run, so the plaintext never
+reaches a disk; --allow-disk writes elsewhere anyway. Use --owner to hand
+the files to the user a service runs as, which requires root. --override
+key.path=value and SHHH_OVERRIDE_KEY_PATH variables replace values in the
+mounted files, as for 'shhh render-tree'.
+
+For example, as a systemd unit the service depends on:
+
+  [Service]
+  ExecStart=/usr/local/bin/shhh mount config/prod.yaml --dir /run/shhh --owner app
+  WorkingDirectory=/srv/app`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runMount,
+}
+
+func runMount(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	if err := checkPlaintextWrite(mountDir); err != nil {
+		return err
+	}
+	uid, gid, err := parseOwner(mountOwner)
+	if err != nil {
+		return err
+	}
+	overrides, err := loadOverrides(mountOverrides)
+	if err != nil {
+		return err
+	}
+
+	m := &mountedFiles{}
+	defer m.remove()
+
+	if err := m.mkdirAll(mountDir); err != nil {
+		return err
+	}
+	inMemory, err := secmem.InMemoryFS(mountDir)
+	if err != nil {
+		return err
+	}
+	if !inMemory && !mountAllowDisk {
+		return fmt.Errorf("%s is not on tmpfs (use a directory below /run, or --allow-disk)", mountDir)
+	}
+
+	for _, arg := range args {
+		_, fileReg, err := resolveRegisteredFile(s, arg)
+		if err != nil {
+			return err
+		}
+		decrypted, err := decryptRegisteredFile(s, fileReg)
+		if err != nil {
+			return err
+		}
+		content, err := overrides.apply(fileReg, decrypted)
+		if err != nil {
+			secmem.Wipe(decrypted)
+			return err
+		}
+
+		mode := os.FileMode(store.FilePerms)
+		if meta := currentMetadata(s, fileReg); meta != nil && meta.PlaintextMode() != 0 && config.PreservePermissions(s) {
+			mode = meta.PlaintextMode()
+		}
+		err = m.write(filepath.Join(mountDir, fileReg.Path), content, mode, uid, gid)
+		secmem.Wipe(decrypted)
+		secmem.Wipe(content)
+		if err != nil {
+			return err
+		}
+	}
+	if err := overrides.checkUsed(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Mounted %d file(s) in %s\n", len(m.files), mountDir)
+	fmt.Println("Waiting for SIGTERM to remove them")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	sig := <-signals
+	fmt.Printf("Received %s, removing %d file(s)\n", sig, len(m.files))
+	return nil
+}
+
+// mountedFiles tracks what 'shhh mount' wrote, so exactly that is removed
+// again.
+type mountedFiles struct {
+	files []string
+	dirs  []string
+}
+
+// mkdirAll creates dir and any missing parents, remembering the ones it
+// created.
+func (m *mountedFiles) mkdirAll(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if err := m.mkdirAll(filepath.Dir(dir)); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, store.DirPerms); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	m.dirs = append(m.dirs, dir)
+	return nil
+}
+
+func (m *mountedFiles) write(path string, content []byte, mode os.FileMode, uid, gid int) error {
+	if err := m.mkdirAll(filepath.Dir(path)); err != nil {
+		return err
+	}
+	m.files = append(m.files, path)
+	if err := os.WriteFile(path, content, mode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	// WriteFile is subject to the umask and leaves the mode of an existing
+	// file alone.
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+	if uid >= 0 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return fmt.Errorf("failed to change owner of %s: %w", path, err)
+		}
+		for _, dir := range m.dirs {
+			if err := os.Chown(dir, uid, gid); err != nil {
+				return fmt.Errorf("failed to change owner of %s: %w", dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+// remove deletes the files written and then the directories created, the
+// deepest first. Directories that gained other files are kept.
+func (m *mountedFiles) remove() {
+	for _, path := range m.files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "Warning: failed to remove %s: %v\n", path, err)
+		}
+	}
+	for i := len(m.dirs) - 1; i >= 0; i-- {
+		os.Remove(m.dirs[i])
+	}
+}
+
+// parseOwner resolves a user[:group] of names or numeric IDs. Without a
+// group, the user's primary group is used. An empty owner returns -1, -1.
+func parseOwner(owner string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+
+	userName, groupName, hasGroup := strings.Cut(owner, ":")
+	u, err := user.Lookup(userName)
+	if err != nil {
+		if u, err = user.LookupId(userName); err != nil {
+			return 0, 0, fmt.Errorf("unknown user %q", userName)
+		}
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %q has no numeric ID", userName)
+	}
+
+	gidStr := u.Gid
+	if hasGroup {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return 0, 0, fmt.Errorf("unknown group %q", groupName)
+			}
+		}
+		gidStr = g.Gid
+	}
+	gid, err := strconv.Atoi(gidStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("group of %q has no numeric ID", owner)
+	}
+	return uid, gid, nil
+}
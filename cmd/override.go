@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/spf13/cobra"
+)
+
+// overrideEnvPrefix marks environment variables that override values, e.g.
+// SHHH_OVERRIDE_DATABASE_PASSWORD for database.password.
+const overrideEnvPrefix = "SHHH_OVERRIDE_"
+
+// addOverrideFlag registers --override on a command that outputs decrypted
+// content.
+func addOverrideFlag(cmd *cobra.Command, overrides *[]string) {
+	cmd.Flags().StringArrayVar(overrides, "override", nil, "Output this value instead of the encrypted one, as key.path=value (repeatable)")
+}
+
+// valueOverrides substitutes values in decrypted content as it is output,
+// so CI can replace some of them without changing the encrypted files.
+// Overrides given as flags are matched by key path and must each apply to
+// some file; those from SHHH_OVERRIDE_* variables are matched by the
+// variable name 'shhh ci export' derives from a key path and may go unused.
+type valueOverrides struct {
+	byKey map[string]string
+	byVar map[string]string
+	used  map[string]bool
+}
+
+// loadOverrides reads --override flags and SHHH_OVERRIDE_* variables.
+func loadOverrides(flags []string) (*valueOverrides, error) {
+	o := &valueOverrides{byKey: map[string]string{}, byVar: map[string]string{}, used: map[string]bool{}}
+	for _, flag := range flags {
+		key, value, ok := strings.Cut(flag, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid override %q (use key.path=value)", flag)
+		}
+		o.byKey[key] = value
+	}
+	for _, env := range os.Environ() {
+		name, value, _ := strings.Cut(env, "=")
+		if name, ok := strings.CutPrefix(name, overrideEnvPrefix); ok && name != "" {
+			o.byVar[name] = value
+		}
+	}
+	return o, nil
+}
+
+// apply returns decrypted content with the overridden values replaced. Only
+// key paths the file already has are replaced; content without overrides
+// is returned as it is.
+func (o *valueOverrides) apply(fileReg *config.RegisteredFile, decrypted []byte) ([]byte, error) {
+	if len(o.byKey) == 0 && len(o.byVar) == 0 {
+		return decrypted, nil
+	}
+	if fileReg.Spec().FormatOf(fileReg.Path) == parser.FormatUnknown {
+		return decrypted, nil
+	}
+
+	values, err := parser.FlattenFile(decrypted, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values: %w", err)
+	}
+
+	patch := &parser.Patch{}
+	for _, kv := range values {
+		value, ok := o.byKey[kv.Key]
+		if ok {
+			o.used[kv.Key] = true
+		} else if value, ok = o.byVar[ciVariableName(kv.Key)]; !ok {
+			continue
+		}
+		if value != kv.Value {
+			patch.Set = append(patch.Set, parser.KeyValue{Key: kv.Key, Value: value})
+		}
+	}
+	if len(patch.Set) == 0 {
+		return decrypted, nil
+	}
+
+	patched, err := parser.ApplyPatch(decrypted, fileReg.Path, fileReg.Spec(), patch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply overrides: %w", err)
+	}
+	return patched, nil
+}
+
+// checkUsed fails when an --override matched no key path, which is
+// usually a typo.
+func (o *valueOverrides) checkUsed() error {
+	var unused []string
+	for key := range o.byKey {
+		if !o.used[key] {
+			unused = append(unused, key)
+		}
+	}
+	if len(unused) > 0 {
+		sort.Strings(unused)
+		return fmt.Errorf("override for %s matches no value", strings.Join(unused, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var promptAlways bool
+
+func init() {
+	rootCmd.AddCommand(promptCmd)
+
+	promptCmd.Flags().BoolVar(&promptAlways, "always", false, "Print \"shhh\" even when nothing needs attention")
+}
+
+var promptCmd = &cobra.Command{
+	Use:   "prompt",
+	Short: "Print a compact status token for shell prompts",
+	Long: `Print a short token describing files that need attention, for shell
+prompts:
+
+  shhh:2!    2 files have plaintext not encrypted yet (pending or modified)
+  shhh:1~    1 file is encrypted for outdated recipients (stale)
+  shhh:2!1~  both
+
+Nothing is printed when every file is up to date, or outside a project. The
+check only compares file times and .enc metadata; it never runs git or GPG,
+so it returns within milliseconds. For example, in ~/.bashrc:
+
+  PS1='$(shhh prompt) '"$PS1"`,
+	Args: cobra.NoArgs,
+	RunE: runPrompt,
+}
+
+func runPrompt(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return nil
+	}
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil
+	}
+
+	unencrypted, stale := 0, 0
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+		for i := range vault.Files {
+			f := &vault.Files[i]
+			status := getFileStatusDetailed(s.Root(), f.Path)
+			if status.State == "pending" || status.Modified {
+				unencrypted++
+			}
+			if status.State == "encrypted" || status.State == "decrypted" {
+				if isStale, err := hasStaleRecipients(s, vaultName, f); err == nil && isStale {
+					stale++
+				}
+			}
+		}
+	}
+
+	fmt.Print(promptToken(unencrypted, stale))
+	return nil
+}
+
+// promptToken formats the counts of files with unencrypted changes and of
+// stale files, e.g. "shhh:2!1~".
+func promptToken(unencrypted, stale int) string {
+	var b strings.Builder
+	if unencrypted > 0 {
+		fmt.Fprintf(&b, "%d!", unencrypted)
+	}
+	if stale > 0 {
+		fmt.Fprintf(&b, "%d~", stale)
+	}
+	switch {
+	case b.Len() > 0:
+		return "shhh:" + b.String()
+	case promptAlways:
+		return "shhh"
+	default:
+		return ""
+	}
+}
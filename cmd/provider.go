@@ -0,0 +1,315 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(providerCmd)
+	providerCmd.AddCommand(providerInfoCmd)
+	providerCmd.AddCommand(providerTestCmd)
+}
+
+var providerCmd = &cobra.Command{
+	Use:   "provider",
+	Short: "Inspect the GPG provider",
+	Long: `shhh encrypts with a native OpenPGP implementation and falls back to
+the gpg CLI when the native one cannot find a key, e.g. in GnuPG 2.1+
+keybox keyrings. Use 'shhh config set provider native|cli|auto' to force
+one of them, 'shhh config set provider_fallback false' to have auto mode use
+one of them for a whole run, and set SHHH_DEBUG=1 to have any command report
+which one handled each operation.`,
+}
+
+var providerInfoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show which provider and keyrings are used",
+	Long: `Show the provider mode, the GnuPG home and gpg binary, the keyring
+files the native provider could read, and how each vault user's key is
+found, including why the gpg CLI fallback was needed.`,
+	RunE: runProviderInfo,
+}
+
+func runProviderInfo(cmd *cobra.Command, args []string) error {
+	mode := crypto.ProviderMode()
+	switch mode {
+	case crypto.ProviderNative:
+		fmt.Println("Provider: native (go-crypto only)")
+	case crypto.ProviderCLI:
+		fmt.Println("Provider: cli (gpg binary only)")
+	default:
+		fmt.Println("Provider: auto (native, falling back to the gpg CLI)")
+	}
+	fmt.Printf("GnuPG home: %s\n", crypto.GnuPGHome())
+
+	binary := crypto.GPGBinary()
+	if path, err := exec.LookPath(binary); err != nil {
+		fmt.Printf("gpg CLI: %s (not found)\n", binary)
+	} else if version, err := crypto.GPGVersion(); err != nil {
+		fmt.Printf("gpg CLI: %s (failed to run: %v)\n", path, err)
+	} else {
+		fmt.Printf("gpg CLI: %s (%s)\n", path, version)
+	}
+
+	if native := crypto.NativeKeyring(); native != nil {
+		fmt.Println("\nNative keyrings:")
+		sources := native.Sources()
+		if len(sources) == 0 {
+			fmt.Println("  none found")
+		}
+		for _, src := range sources {
+			if src.Error != "" {
+				fmt.Printf("  %s: %s\n", src.Path, src.Error)
+			} else {
+				fmt.Printf("  %s: %d key(s), %d with private key\n", src.Path, src.Keys, src.PrivateKeys)
+			}
+		}
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return nil
+	}
+
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("\nVault users:")
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+		for _, u := range vault.Users {
+			fmt.Printf("  %s (%s): %s\n", u.Email, vaultName, describeKeySource(u.Email))
+		}
+	}
+
+	return nil
+}
+
+var providerTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check that the GPG backends can encrypt and decrypt for the vault users",
+	Long: `Run health checks against each backend the provider mode can use, the
+native provider and the gpg CLI: read the keyring, look up every vault user's
+key, encrypt a test message to them and decrypt it again. Decryption is
+skipped when no vault user's private key is available locally.
+
+The command fails when an operation fails on every backend the provider can
+use: in auto mode with fallback either one will do, otherwise the one in use
+must pass.`,
+	Args: cobra.NoArgs,
+	RunE: runProviderTest,
+}
+
+// backendCheck holds the operations a backend failed in 'provider test'.
+type backendCheck struct {
+	name   string
+	failed map[string]bool
+}
+
+func runProviderTest(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	emails, err := allVaultEmails(s)
+	if err != nil {
+		return err
+	}
+	if len(emails) == 0 {
+		return fmt.Errorf("no vault users to test with (add one with 'shhh user add')")
+	}
+
+	var backends []crypto.GPGProvider
+	if crypto.ProviderMode() != crypto.ProviderCLI {
+		native := crypto.NewNativeGPG()
+		if err := native.LoadCachedPublicKeys(s.PubkeysPath()); err != nil {
+			fmt.Printf("Warning: failed to load cached keys: %v\n", err)
+		}
+		backends = append(backends, native)
+	}
+	if crypto.ProviderMode() != crypto.ProviderNative {
+		backends = append(backends, crypto.NewCLIGPG())
+	}
+
+	var checks []backendCheck
+	for _, backend := range backends {
+		checks = append(checks, testBackend(backend, emails))
+	}
+
+	// Only the backend in use counts, unless auto mode can fall back.
+	inUse := crypto.BackendName(crypto.GetProvider())
+	var usable []backendCheck
+	for _, c := range checks {
+		if inUse == "auto" || c.name == inUse {
+			usable = append(usable, c)
+		}
+	}
+	if inUse == "auto" {
+		fmt.Printf("\nProvider auto uses native, falling back to the gpg CLI\n")
+	} else {
+		fmt.Printf("\nProvider %s uses the %s backend\n", crypto.ProviderMode(), inUse)
+	}
+
+	var broken []string
+	for _, op := range []string{"lookup", "encrypt", "decrypt"} {
+		failedEverywhere := len(usable) > 0
+		for _, c := range usable {
+			failedEverywhere = failedEverywhere && c.failed[op]
+		}
+		if failedEverywhere {
+			broken = append(broken, op)
+		}
+	}
+	if len(broken) > 0 {
+		return fmt.Errorf("provider %s cannot %s", crypto.ProviderMode(), strings.Join(broken, ", "))
+	}
+	return nil
+}
+
+// testBackend runs the health checks against one backend, printing each
+// outcome.
+func testBackend(backend crypto.GPGProvider, emails []string) backendCheck {
+	check := backendCheck{name: crypto.BackendName(backend), failed: make(map[string]bool)}
+	report := func(op, status, format string, args ...any) {
+		if status == "failed" {
+			check.failed[op] = true
+		}
+		fmt.Printf("  %-8s %s: %s\n", status, op, fmt.Sprintf(format, args...))
+	}
+
+	fmt.Printf("\n%s:\n", check.name)
+	if native, ok := backend.(*crypto.NativeGPG); ok {
+		keys, private := 0, 0
+		var errs []string
+		for _, src := range native.Sources() {
+			if src.Error != "" {
+				errs = append(errs, src.Path+": "+src.Error)
+			}
+			keys += src.Keys
+			private += src.PrivateKeys
+		}
+		// Unreadable keyrings only matter if lookups fail, e.g. when the
+		// keys are cached in the project.
+		switch {
+		case len(native.Sources()) == 0:
+			report("keyring", "warning", "no keyring found in %s", crypto.GnuPGHome())
+		case len(errs) > 0:
+			report("keyring", "warning", "%s", strings.Join(errs, "; "))
+		default:
+			report("keyring", "ok", "%d key(s), %d with private key", keys, private)
+		}
+	} else {
+		path, err := exec.LookPath(crypto.GPGBinary())
+		var version string
+		if err == nil {
+			version, err = crypto.GPGVersion()
+		}
+		if err != nil {
+			report("binary", "failed", "cannot run %s: %v", crypto.GPGBinary(), err)
+			for _, op := range []string{"lookup", "encrypt", "decrypt"} {
+				report(op, "failed", "gpg CLI unavailable")
+			}
+			return check
+		}
+		report("binary", "ok", "%s (%s)", path, version)
+	}
+
+	var found, missing []string
+	for _, email := range emails {
+		if _, err := backend.LookupKey(email); err != nil {
+			missing = append(missing, email)
+		} else {
+			found = append(found, email)
+		}
+	}
+	if len(missing) > 0 {
+		report("lookup", "failed", "%d/%d vault user key(s) found; missing %s", len(found), len(emails), strings.Join(missing, ", "))
+	} else {
+		report("lookup", "ok", "%d/%d vault user key(s) found", len(found), len(emails))
+	}
+	if len(found) == 0 {
+		report("encrypt", "failed", "no key to encrypt to")
+		report("decrypt", "skipped", "nothing to decrypt")
+		return check
+	}
+
+	plaintext := []byte("shhh provider test")
+	encrypted, err := backend.Encrypt(plaintext, found)
+	if err != nil {
+		report("encrypt", "failed", "%v", err)
+		report("decrypt", "skipped", "nothing to decrypt")
+		return check
+	}
+	report("encrypt", "ok", "encrypted for %d key(s)", len(found))
+
+	decrypted, err := backend.Decrypt(encrypted)
+	switch {
+	case errors.Is(err, crypto.ErrNoPrivateKey):
+		report("decrypt", "skipped", "no vault user's private key is available")
+	case err != nil:
+		report("decrypt", "failed", "%v", err)
+	case string(decrypted) != string(plaintext):
+		report("decrypt", "failed", "decrypted message does not match")
+	default:
+		report("decrypt", "ok", "decrypted the test message")
+	}
+	return check
+}
+
+// allVaultEmails returns the distinct users of every vault.
+func allVaultEmails(s *store.Store) ([]string, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var emails []string
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		for _, email := range vault.Emails() {
+			if !seen[email] {
+				seen[email] = true
+				emails = append(emails, email)
+			}
+		}
+	}
+	return emails, nil
+}
+
+// describeKeySource reports which provider finds a user's key.
+func describeKeySource(email string) string {
+	var nativeErr error
+	if native := crypto.NativeKeyring(); native != nil {
+		if _, nativeErr = native.LookupKey(email); nativeErr == nil {
+			return "native"
+		}
+		if crypto.ProviderMode() == crypto.ProviderNative {
+			return fmt.Sprintf("not found (%v)", nativeErr)
+		}
+	}
+
+	if _, err := crypto.NewCLIGPG().LookupKey(email); err != nil {
+		return fmt.Sprintf("not found (%v)", err)
+	}
+	if nativeErr != nil {
+		return fmt.Sprintf("gpg CLI (native: %v)", nativeErr)
+	}
+	return "gpg CLI"
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/backup"
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pruneDryRun     bool
+	pruneForce      bool
+	pruneReregister bool
+)
+
+func init() {
+	rootCmd.AddCommand(pruneCmd)
+
+	pruneCmd.Flags().BoolVarP(&pruneDryRun, "dry-run", "n", false, "Only list what would be pruned")
+	pruneCmd.Flags().BoolVarP(&pruneForce, "force", "f", false, "Apply changes without prompting")
+	pruneCmd.Flags().BoolVar(&pruneReregister, "reregister", false, "With --force, re-register orphaned files instead of deleting them")
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Clean up orphaned encrypted files and stale registrations",
+	Long: `Find leftovers that no longer match the registry:
+
+- .enc files (and their .gpg backups and .enc.meta sidecars) with no
+  corresponding registration
+- registrations whose plaintext and .enc files are both gone
+
+For each orphaned file you are asked whether to delete it or re-register it
+(using the vault and mode recorded in its metadata). Stale registrations can
+be unregistered. Files created with 'encrypt --adhoc' are never touched.
+
+Use --dry-run to only list findings, and --force to apply the default action
+(delete, or re-register with --reregister) without prompting.`,
+	RunE: runPrune,
+}
+
+type orphanFile struct {
+	path  string // registrable path, relative to the root, without .enc
+	extra []string
+	meta  *crypto.FileMetadata
+}
+
+type staleRegistration struct {
+	vault string
+	path  string
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	orphans, err := findOrphanedFiles(s)
+	if err != nil {
+		return err
+	}
+
+	stale, err := findStaleRegistrations(s)
+	if err != nil {
+		return err
+	}
+
+	if len(orphans) == 0 && len(stale) == 0 {
+		fmt.Println("Nothing to prune")
+		return nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	deleted, reregistered, unregistered := 0, 0, 0
+	// failed counts orphans left with files that could not be removed.
+	failed := 0
+
+	for _, o := range orphans {
+		files := append([]string{o.path + ".enc"}, o.extra...)
+		fmt.Printf("Orphaned: %s\n", strings.Join(files, ", "))
+
+		if pruneDryRun {
+			continue
+		}
+
+		action := "d"
+		if pruneReregister {
+			action = "r"
+		}
+		if !pruneForce {
+			fmt.Print("  [d]elete, [r]e-register, or [s]kip? [s] ")
+			answer, _ := reader.ReadString('\n')
+			action = strings.TrimSpace(strings.ToLower(answer))
+		}
+
+		switch action {
+		case "d", "delete":
+			removed := true
+			for _, f := range files {
+				if err := os.Remove(filepath.Join(s.Root(), f)); err != nil && !os.IsNotExist(err) {
+					fmt.Fprintf(os.Stderr, "Warning: failed to delete %s: %v\n", f, err)
+					removed = false
+					continue
+				}
+				fmt.Printf("  Deleted %s\n", f)
+			}
+			if !removed {
+				failed++
+				continue
+			}
+			deleted++
+		case "r", "re-register", "reregister":
+			if err := reregisterOrphan(s, o); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to re-register %s: %v\n", o.path, err)
+				continue
+			}
+			reregistered++
+		default:
+			fmt.Println("  Skipped")
+		}
+	}
+
+	for _, r := range stale {
+		fmt.Printf("Missing: %s (vault %s) has neither plaintext nor .enc\n", r.path, r.vault)
+
+		if pruneDryRun {
+			continue
+		}
+
+		if !pruneForce {
+			fmt.Print("  Unregister? [y/N] ")
+			answer, _ := reader.ReadString('\n')
+			answer = strings.TrimSpace(strings.ToLower(answer))
+			if answer != "y" && answer != "yes" {
+				fmt.Println("  Skipped")
+				continue
+			}
+		}
+
+		if err := config.UnregisterFile(s, r.vault, r.path); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to unregister %s: %v\n", r.path, err)
+			continue
+		}
+		if err := gitignore.RemoveIgnored(s.Root(), r.path); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to update .gitignore: %v\n", err)
+		}
+		fmt.Printf("  Unregistered %s\n", r.path)
+		unregistered++
+	}
+
+	fmt.Println()
+	if pruneDryRun {
+		fmt.Printf("Found %d orphaned file(s) and %d missing registration(s)\n", len(orphans), len(stale))
+		return nil
+	}
+	fmt.Printf("Deleted %d, re-registered %d, unregistered %d\n", deleted, reregistered, unregistered)
+	if failed > 0 {
+		return fmt.Errorf("%d orphaned file(s) could not be fully deleted", failed)
+	}
+	return nil
+}
+
+// findOrphanedFiles walks the project for shhh-encrypted .enc files that no
+// vault has registered. A .gpg backup is only reported alongside its .enc,
+// since a lone .gpg file may belong to something else entirely.
+func findOrphanedFiles(s *store.Store) ([]orphanFile, error) {
+	registered, err := registeredPaths(s)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		cfg = config.NewConfig()
+	}
+
+	var orphans []orphanFile
+	err = filepath.WalkDir(s.Root(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name := d.Name(); name == ".git" || name == store.ShhhDir || store.IsNestedRoot(s.Root(), path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".enc") {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.Root(), strings.TrimSuffix(path, ".enc"))
+		if err != nil || registered[config.NormalizePath(relPath)] {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		meta, err := crypto.ReadFileMetadata(content, path, relPath, parser.Spec{})
+		if err != nil || meta == nil || meta.Vault == adhocVault {
+			return nil
+		}
+
+		o := orphanFile{path: relPath, meta: meta}
+		if fileExists(crypto.SidecarPath(path)) {
+			o.extra = append(o.extra, relPath+".enc"+crypto.SidecarSuffix)
+		}
+		backupPath := backup.Path(s, cfg, relPath)
+		for _, extra := range []string{backupPath, backupPath + backup.MetaSuffix} {
+			if rel, err := filepath.Rel(s.Root(), extra); err == nil && fileExists(extra) {
+				o.extra = append(o.extra, rel)
+			}
+		}
+		orphans = append(orphans, o)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan project: %w", err)
+	}
+
+	return orphans, nil
+}
+
+func findStaleRegistrations(s *store.Store) ([]staleRegistration, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []staleRegistration
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		for _, f := range vault.Files {
+			plainPath := filepath.Join(s.Root(), f.Path)
+			if !fileExists(plainPath) && !fileExists(plainPath+".enc") {
+				stale = append(stale, staleRegistration{vault: vaultName, path: f.Path})
+			}
+		}
+	}
+
+	return stale, nil
+}
+
+func registeredPaths(s *store.Store) (map[string]bool, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool)
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		for _, f := range vault.Files {
+			paths[f.Path] = true
+		}
+	}
+
+	return paths, nil
+}
+
+// reregisterOrphan registers an orphan in the vault and mode recorded in its
+// metadata, falling back to the default vault and values mode.
+func reregisterOrphan(s *store.Store, o orphanFile) error {
+	vault := o.meta.Vault
+	if vault == "" || !s.VaultExists(vault) {
+		cfg, err := config.Load(s)
+		if err != nil {
+			return err
+		}
+		vault = cfg.DefaultVault
+
+		// A hashed vault name can still be matched against existing vaults.
+		if names, err := s.ListVaults(); err == nil {
+			for _, name := range names {
+				if o.meta.MatchesVault(name, o.path) {
+					vault = name
+					break
+				}
+			}
+		}
+	}
+
+	mode := o.meta.Mode
+	if mode != config.ModeValues && mode != config.ModeFull {
+		mode = config.ModeValues
+	}
+
+	if err := config.RegisterFile(s, vault, o.path, mode, nil); err != nil {
+		return err
+	}
+
+	if err := gitignore.EnsureIgnored(s.Root(), o.path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to add to .gitignore: %v\n", err)
+	}
+
+	fmt.Printf("  Re-registered %s in vault %s (mode: %s)\n", o.path, vault, mode)
+	return nil
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// checkPlaintextWrite refuses to write plaintext to path in readonly mode
+// when path is inside the project. Stdout ("-") and paths outside the
+// project, such as a tmpfs, are always allowed.
+func checkPlaintextWrite(path string) error {
+	if path == "-" {
+		return nil
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		// Outside a project only SHHH_READONLY applies, to every path.
+		if os.Getenv(config.ReadonlyEnv) == "" {
+			return nil
+		}
+	} else if !config.IsReadonly(s) || !insideDir(s.Root(), path) {
+		return nil
+	}
+
+	return fmt.Errorf("readonly mode: refusing to write plaintext to %s (use 'shhh cat', 'shhh get' or --output -)", path)
+}
+
+// insideDir reports whether path is dir or below it.
+func insideDir(dir, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return true
+	}
+	rel, err := filepath.Rel(dir, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var redactOutput string
+
+func init() {
+	rootCmd.AddCommand(redactCmd)
+	redactCmd.Flags().StringVarP(&redactOutput, "output", "o", "", "Write the redacted copy to this path instead of stdout")
+}
+
+var redactCmd = &cobra.Command{
+	Use:   "redact <file>",
+	Short: "Print a redacted copy of a file for sharing",
+	Long: `Print a copy of a registered file with every value masked and its length
+kept, e.g.
+
+  database:
+    password: '********'
+
+Keys and structure are kept so the copy can be attached to tickets or sent
+to vendors; comments are dropped as they may contain secrets. The file is
+decrypted in memory when its plaintext is not present, and the copy is
+checked to contain none of the original values before it is written.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRedact,
+}
+
+func runRedact(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	relPath, err := projectRelPath(s, strings.TrimSuffix(args[0], ".enc"))
+	if err != nil {
+		return err
+	}
+	_, fileReg, err := config.FindFileVault(s, relPath)
+	if err != nil {
+		return err
+	}
+
+	plaintext, err := readPlaintext(s, relPath, fileReg.Spec())
+	if err != nil {
+		return err
+	}
+
+	redacted, err := parser.RedactFile(plaintext, relPath, fileReg.Spec())
+	if err != nil {
+		return fmt.Errorf("failed to redact %s: %w", relPath, err)
+	}
+
+	if redactOutput == "" || redactOutput == "-" {
+		_, err := os.Stdout.Write(redacted)
+		return err
+	}
+	// The copy holds no secrets and is meant to be shared.
+	if err := os.WriteFile(redactOutput, redacted, 0644); err != nil {
+		return fmt.Errorf("failed to write redacted copy: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote redacted copy of %s to %s\n", relPath, redactOutput)
+	return nil
+}
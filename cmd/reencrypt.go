@@ -1,19 +1,24 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
 var (
-	reencryptVault string
-	reencryptAll   bool
+	reencryptVault  string
+	reencryptAll    bool
+	reencryptForce  bool
+	reencryptResume bool
 )
 
 func init() {
@@ -21,6 +26,9 @@ func init() {
 
 	reencryptCmd.Flags().StringVarP(&reencryptVault, "vault", "v", "", "Re-encrypt files in specific vault")
 	reencryptCmd.Flags().BoolVarP(&reencryptAll, "all", "a", false, "Re-encrypt all registered files")
+	reencryptCmd.Flags().BoolVar(&reencryptForce, "force", false, "Re-encrypt every value, even those already encrypted to the current keys")
+	reencryptCmd.Flags().BoolVar(&reencryptResume, "resume", false, "Skip files re-encrypted by an interrupted --vault or --all run")
+	addReportFlag(reencryptCmd)
 }
 
 var reencryptCmd = &cobra.Command{
@@ -34,7 +42,21 @@ This is useful after:
 - Rotating encryption keys
 
 Use --vault to re-encrypt all files in a specific vault.
-Use --all to re-encrypt all registered files.`,
+Use --all to re-encrypt all registered files. Both end with a table of each
+file's outcome; use --report to also write it as JSON.
+
+Bulk runs record each file they finish in .shhh/reencrypt.journal. If one
+fails midway, e.g. because the gpg agent is locked, run it again with
+--resume to skip the files already done and retry the rest. Files whose
+recipients changed since are re-encrypted again. The journal is removed once
+no file failed.
+
+With value_key_ids enabled, values-mode files are re-encrypted in place:
+only values whose recorded key IDs differ from the recipients' current keys
+are decrypted and encrypted again, and files that are already up to date are
+left untouched. Use --force to re-encrypt every value, e.g. to apply changed
+dedupe_values or metadata_privacy settings. Files with gpg_copy or
+obfuscate_keys are always re-encrypted in full.`,
 	RunE: runReencrypt,
 }
 
@@ -44,8 +66,11 @@ func runReencrypt(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if err := crypto.LoadCachedPublicKeys(s.PubkeysPath()); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to load cached keys: %v\n", err)
+	if bulkReport != "" && !reencryptAll && reencryptVault == "" {
+		return fmt.Errorf("--report requires --vault or --all")
+	}
+	if reencryptResume && !reencryptAll && reencryptVault == "" {
+		return fmt.Errorf("--resume requires --vault or --all")
 	}
 
 	if reencryptAll {
@@ -79,7 +104,12 @@ func reencryptSingleFile(s *store.Store, filePath string) error {
 		return err
 	}
 
-	return reencryptFile(s, vault, fileReg)
+	if err := reencryptFile(s, vault, fileReg); err != nil {
+		return err
+	}
+
+	fireHook(s, hooks.Event{Name: hooks.ReencryptCompleted, Vault: vault, Files: []string{fileReg.Path}})
+	return nil
 }
 
 func reencryptVaultFiles(s *store.Store, vaultName string) error {
@@ -87,6 +117,10 @@ func reencryptVaultFiles(s *store.Store, vaultName string) error {
 		return fmt.Errorf("vault %q does not exist", vaultName)
 	}
 
+	if err := config.CheckVaultWritable(s, vaultName); err != nil {
+		return err
+	}
+
 	vault, err := config.LoadVault(s, vaultName)
 	if err != nil {
 		return err
@@ -97,27 +131,20 @@ func reencryptVaultFiles(s *store.Store, vaultName string) error {
 		return nil
 	}
 
-	var errs []error
-	successCount := 0
+	journal, err := openReencryptJournal(s)
+	if err != nil {
+		return err
+	}
 
+	results := newBulkResults("reencrypt", "re-encrypt")
 	for _, f := range vault.Files {
-		if err := reencryptFile(s, vaultName, &f); err != nil {
-			errs = append(errs, fmt.Errorf("%s: %w", f.Path, err))
-		} else {
-			successCount++
-		}
+		reencryptJournaled(s, journal, results, vaultName, &f)
 	}
 
-	fmt.Printf("\nRe-encrypted %d file(s) in vault %s\n", successCount, vaultName)
-
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
-		}
-		return fmt.Errorf("%d file(s) failed to re-encrypt", len(errs))
+	if done := results.done(); len(done) > 0 {
+		fireHook(s, hooks.Event{Name: hooks.ReencryptCompleted, Vault: vaultName, Files: done})
 	}
-
-	return nil
+	return finishReencrypt(s, results)
 }
 
 func reencryptAllFiles(s *store.Store) error {
@@ -126,10 +153,12 @@ func reencryptAllFiles(s *store.Store) error {
 		return err
 	}
 
-	totalFiles := 0
-	successCount := 0
-	var errs []error
+	journal, err := openReencryptJournal(s)
+	if err != nil {
+		return err
+	}
 
+	results := newBulkResults("reencrypt", "re-encrypt")
 	for _, vaultName := range vaults {
 		vault, err := config.LoadVault(s, vaultName)
 		if err != nil {
@@ -137,33 +166,81 @@ func reencryptAllFiles(s *store.Store) error {
 		}
 
 		for _, f := range vault.Files {
-			totalFiles++
-			if err := reencryptFile(s, vaultName, &f); err != nil {
-				errs = append(errs, fmt.Errorf("%s (%s): %w", f.Path, vaultName, err))
-			} else {
-				successCount++
+			if vault.Archived {
+				results.skip(vaultName, f.Path, "vault is archived")
+				continue
 			}
+			reencryptJournaled(s, journal, results, vaultName, &f)
 		}
 	}
 
-	if totalFiles == 0 {
+	if len(results.Files) == 0 {
 		fmt.Println("No files registered")
 		return nil
 	}
 
-	fmt.Printf("\nRe-encrypted %d of %d file(s)\n", successCount, totalFiles)
+	if done := results.done(); len(done) > 0 {
+		fireHook(s, hooks.Event{Name: hooks.ReencryptCompleted, Files: done})
+	}
+	return finishReencrypt(s, results)
+}
+
+// openReencryptJournal returns the files to skip with --resume. Without it,
+// a fresh journal is started.
+func openReencryptJournal(s *store.Store) (config.ReencryptJournal, error) {
+	if !reencryptResume {
+		return config.ReencryptJournal{}, config.ClearReencryptJournal(s)
+	}
 
-	if len(errs) > 0 {
-		for _, e := range errs {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+	journal, found, err := config.LoadReencryptJournal(s)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		fmt.Println("No interrupted re-encryption to resume; re-encrypting every file")
+	}
+	return journal, nil
+}
+
+// reencryptJournaled re-encrypts a file of a bulk run unless the journal
+// shows it was done for its current recipients, and journals it on success.
+func reencryptJournaled(s *store.Store, journal config.ReencryptJournal, results *bulkResults, vault string, fileReg *config.RegisteredFile) {
+	recipients, _ := config.GetEffectiveRecipients(s, vault, fileReg)
+	if len(recipients) > 0 && journal.Done(vault, fileReg.Path, recipients) {
+		results.skip(vault, fileReg.Path, "re-encrypted before the interruption")
+		return
+	}
+
+	err := reencryptFile(s, vault, fileReg)
+	results.record(vault, fileReg.Path, err)
+	if err != nil {
+		return
+	}
+	if err := config.RecordReencrypted(s, vault, fileReg.Path, recipients); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// finishReencrypt summarises a bulk run. The journal is kept while files
+// still need retrying.
+func finishReencrypt(s *store.Store, results *bulkResults) error {
+	if results.Failed == 0 {
+		if err := config.ClearReencryptJournal(s); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 		}
-		return fmt.Errorf("%d file(s) failed to re-encrypt", len(errs))
+		return results.finish()
 	}
 
-	return nil
+	err := results.finish()
+	fmt.Println("Note: Run again with --resume to retry only the files that were not re-encrypted")
+	return err
 }
 
 func reencryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile) error {
+	if err := config.CheckVaultWritable(s, vault); err != nil {
+		return err
+	}
+
 	encPath := filepath.Join(s.Root(), fileReg.Path) + ".enc"
 
 	if _, err := os.Stat(encPath); os.IsNotExist(err) {
@@ -175,12 +252,7 @@ func reencryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile)
 		return fmt.Errorf("failed to read encrypted file: %w", err)
 	}
 
-	decrypted, err := crypto.DecryptFileContent(encContent, fileReg.Path)
-	if err != nil {
-		return fmt.Errorf("decryption failed: %w", err)
-	}
-
-	recipients, err := config.GetEffectiveRecipients(s, vault, fileReg)
+	recipients, err := config.EncryptionRecipients(s, vault, fileReg)
 	if err != nil {
 		return fmt.Errorf("failed to get recipients: %w", err)
 	}
@@ -189,10 +261,18 @@ func reencryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile)
 		return fmt.Errorf("no recipients available")
 	}
 
-	opts := crypto.EncryptOptions{
-		Vault:      vault,
-		Mode:       fileReg.Mode,
-		Recipients: recipients,
+	opts := encryptOptions(s, vault, fileReg, recipients)
+	if meta, err := crypto.ReadFileMetadata(encContent, encPath, fileReg.Path, fileReg.Spec()); err == nil && meta != nil {
+		opts.FileMode = meta.FileMode
+	}
+
+	if canReencryptInPlace(s, fileReg, encContent, opts) {
+		return reencryptInPlace(s, fileReg, encPath, encContent, opts)
+	}
+
+	decrypted, err := crypto.DecryptFileContent(encContent, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		return decryptionError(s, fileReg.Path, err)
 	}
 
 	encrypted, err := crypto.EncryptFileContent(decrypted, fileReg.Path, opts)
@@ -200,22 +280,45 @@ func reencryptFile(s *store.Store, vault string, fileReg *config.RegisteredFile)
 		return fmt.Errorf("encryption failed: %w", err)
 	}
 
-	if err := os.WriteFile(encPath, encrypted, 0600); err != nil {
-		return fmt.Errorf("failed to write encrypted file: %w", err)
+	if err := writeEncFile(s, encPath, fileReg.Path, fileReg.Spec(), encrypted); err != nil {
+		return err
 	}
 
 	fmt.Printf("Re-encrypted %s.enc\n", fileReg.Path)
 
-	if config.GetEffectiveGPGCopy(s, fileReg) {
-		gpgPath := filepath.Join(s.Root(), fileReg.Path) + ".gpg"
-		gpg := crypto.GetProvider()
-		gpgEncrypted, err := gpg.Encrypt(decrypted, recipients)
-		if err == nil {
-			if err := os.WriteFile(gpgPath, gpgEncrypted, 0600); err == nil {
-				fmt.Printf("  Updated GPG backup: %s.gpg\n", fileReg.Path)
-			}
-		}
+	writeBackup(s, vault, fileReg, decrypted, recipients)
+	return nil
+}
+
+// canReencryptInPlace reports whether only a file's stale values need to be
+// re-encrypted: its values must carry key IDs, and nothing may need the
+// whole plaintext.
+func canReencryptInPlace(s *store.Store, fileReg *config.RegisteredFile, encContent []byte, opts crypto.EncryptOptions) bool {
+	return !reencryptForce &&
+		opts.AnnotateKeyIDs &&
+		fileReg.Mode == config.ModeValues &&
+		!fileReg.ObfuscateKeys &&
+		!config.GetEffectiveGPGCopy(s, fileReg) &&
+		!crypto.IsFullyEncrypted(encContent) &&
+		parser.GetParserForFile(fileReg.Path, fileReg.Spec()) != nil
+}
+
+func reencryptInPlace(s *store.Store, fileReg *config.RegisteredFile, encPath string, encContent []byte, opts crypto.EncryptOptions) error {
+	encrypted, changed, err := crypto.ReencryptStaleValues(encContent, fileReg.Path, opts)
+	if errors.Is(err, crypto.ErrNoPrivateKey) {
+		return decryptionError(s, fileReg.Path, err)
+	} else if err != nil {
+		return err
+	}
+	if changed == 0 {
+		fmt.Printf("Up to date: %s.enc\n", fileReg.Path)
+		return nil
+	}
+
+	if err := writeEncFile(s, encPath, fileReg.Path, fileReg.Spec(), encrypted); err != nil {
+		return err
 	}
 
+	fmt.Printf("Re-encrypted %d value(s) in %s.enc\n", changed, fileReg.Path)
 	return nil
 }
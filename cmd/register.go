@@ -4,18 +4,31 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/cychiuae/shhh/internal/backup"
 	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
 	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/scan"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
 var (
-	registerVault      string
-	registerMode       string
-	registerRecipients []string
-	registerNoEncrypt  bool
+	registerVault             string
+	registerMode              string
+	registerRecipients        []string
+	registerNoEncrypt         bool
+	registerReplace           bool
+	registerRecipientsFile    string
+	registerDir               string
+	registerFormat            string
+	registerDelimiter         string
+	registerComments          []string
+	unregisterDeleteEnc       bool
+	unregisterDeletePlaintext bool
 )
 
 func init() {
@@ -23,15 +36,23 @@ func init() {
 	rootCmd.AddCommand(unregisterCmd)
 
 	registerCmd.Flags().StringVarP(&registerVault, "vault", "v", "", "Vault to register file in")
-	registerCmd.Flags().StringVarP(&registerMode, "mode", "m", "values", "Encryption mode: values or full")
+	registerCmd.Flags().StringVarP(&registerMode, "mode", "m", "", "Encryption mode: values or full (default: values, or the current mode when re-registering)")
 	registerCmd.Flags().StringSliceVarP(&registerRecipients, "recipients", "r", nil, "Specific recipients (default: all vault users)")
+	registerCmd.Flags().StringVar(&registerRecipientsFile, "recipients-file", "", "Read recipients (one email or fingerprint per line) from a file")
+	registerCmd.Flags().StringVar(&registerDir, "dir", "", "Register every file in a directory (respects .shhhignore)")
+	registerCmd.Flags().StringVar(&registerFormat, "format", "", "Parse the file as yaml, json, ini, or env regardless of its extension")
+	registerCmd.Flags().StringVar(&registerDelimiter, "delimiter", "", "Key/value delimiter for line-based files (default \"=\"; e.g. \":\" for Procfile-style files)")
+	registerCmd.Flags().StringSliceVar(&registerComments, "comment", nil, "Comment prefixes for line-based files (default \"#\")")
 	registerCmd.Flags().BoolVar(&registerNoEncrypt, "no-encrypt", false, "Skip automatic encryption after registration")
+	registerCmd.Flags().BoolVar(&registerReplace, "replace", false, "Reset all settings of an existing registration")
 
 	unregisterCmd.Flags().StringVarP(&registerVault, "vault", "v", "", "Vault to unregister file from")
+	unregisterCmd.Flags().BoolVar(&unregisterDeleteEnc, "delete-enc", false, "Also delete the .enc (and .gpg) file")
+	unregisterCmd.Flags().BoolVar(&unregisterDeletePlaintext, "delete-plaintext", false, "Also delete the plaintext file")
 }
 
 var registerCmd = &cobra.Command{
-	Use:   "register <file>",
+	Use:   "register <file> | --dir <dir>",
 	Short: "Register a file for encryption",
 	Long: `Register a file to be managed by shhh.
 
@@ -39,16 +60,31 @@ The file will be encrypted automatically after registration.
 Use --no-encrypt to skip automatic encryption.
 The file will be added to .gitignore automatically.
 By default, all vault users can decrypt the file.
-Use --recipients to restrict access to specific users.`,
-	Args: cobra.ExactArgs(1),
+Use --recipients to restrict access to specific users, or --recipients-file
+to read them (one email or fingerprint per line) from a reviewed text file.
+Use --dir to register every file in a directory; paths matching .shhhignore
+are skipped. Use --format for files whose extension does not reveal their
+format (e.g. --format env for notes.txt); it is stored in the registration.
+Line-based files with other syntax can set --delimiter and --comment, e.g.
+'shhh register Procfile --delimiter ":"'; these imply --format env.
+
+Registering a file that is already registered keeps its settings (mode,
+recipients, hooks, GPG copy and so on) and only changes what is given on the
+command line. Use --replace to reset every setting.`,
+	Args: cobra.MaximumNArgs(1),
 	RunE: runRegister,
 }
 
 var unregisterCmd = &cobra.Command{
 	Use:   "unregister <file>",
 	Short: "Unregister a file",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runUnregister,
+	Long: `Stop managing a file with shhh.
+
+The file's .gitignore entry is removed. Use --delete-enc to also delete the
+encrypted .enc (and .gpg) files, and --delete-plaintext to delete the
+plaintext file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runUnregister,
 }
 
 func runRegister(cmd *cobra.Command, args []string) error {
@@ -57,6 +93,45 @@ func runRegister(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if registerDir == "" && len(args) != 1 {
+		return fmt.Errorf("specify a file or --dir")
+	}
+	if registerDir != "" && len(args) > 0 {
+		return fmt.Errorf("--dir cannot be combined with a file argument")
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return err
+	}
+
+	vault := registerVault
+	if vault == "" {
+		vault = cfg.DefaultVault
+	}
+
+	if !s.VaultExists(vault) {
+		return fmt.Errorf("vault %q does not exist", vault)
+	}
+
+	if registerFormat != "" {
+		if _, err := parser.ParseFormat(registerFormat); err != nil {
+			return err
+		}
+	}
+	if (registerDelimiter != "" || len(registerComments) > 0) && registerFormat != "" && !strings.EqualFold(registerFormat, string(parser.FormatENV)) {
+		return fmt.Errorf("--delimiter and --comment only apply to env files")
+	}
+
+	recipients, err := collectRecipients(s, vault, registerRecipients, registerRecipientsFile)
+	if err != nil {
+		return err
+	}
+
+	if registerDir != "" {
+		return registerDirectory(s, cfg, vault, registerDir, recipients)
+	}
+
 	filePath := args[0]
 
 	absPath, err := filepath.Abs(filePath)
@@ -73,45 +148,154 @@ func runRegister(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("file does not exist: %s", filePath)
 	}
 
-	vault := registerVault
-	if vault == "" {
-		cfg, err := config.Load(s)
-		if err != nil {
-			return err
+	return registerPath(s, cfg, vault, relPath, recipients)
+}
+
+// registerDirectory registers every file under dir that is not excluded by
+// .shhhignore and not already registered.
+func registerDirectory(s *store.Store, cfg *config.Config, vault, dir string, recipients []string) error {
+	relDir, err := projectRelPath(s, dir)
+	if err != nil {
+		return err
+	}
+
+	registered, err := registeredPaths(s)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	err = scan.Walk(s.Root(), relDir, scan.LoadIgnore(s.Root()), func(relPath string) error {
+		if !registered[config.NormalizePath(relPath)] {
+			paths = append(paths, relPath)
 		}
-		vault = cfg.DefaultVault
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", dir, err)
 	}
 
-	if !s.VaultExists(vault) {
-		return fmt.Errorf("vault %q does not exist", vault)
+	if len(paths) == 0 {
+		fmt.Printf("No unregistered files found in %s\n", dir)
+		return nil
+	}
+
+	var errs []error
+	for _, relPath := range paths {
+		if err := registerPath(s, cfg, vault, relPath, recipients); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", relPath, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+		}
+		return fmt.Errorf("%d file(s) failed to register", len(errs))
+	}
+
+	fmt.Printf("\nRegistered %d file(s) from %s\n", len(paths), dir)
+	return nil
+}
+
+// projectRelPath resolves a command-line path relative to the project root,
+// rejecting paths outside it.
+func projectRelPath(s *store.Store, path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	relPath, err := filepath.Rel(s.Root(), absPath)
+	if err != nil || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path must be within project directory: %s", path)
 	}
 
-	if err := config.RegisterFile(s, vault, relPath, registerMode, registerRecipients); err != nil {
+	return relPath, nil
+}
+
+func registerPath(s *store.Store, cfg *config.Config, vault, relPath string, recipients []string) error {
+	if v, err := config.LoadVault(s, vault); err == nil && v.GetFile(relPath) != nil {
+		if registerReplace {
+			fmt.Fprintf(os.Stderr, "Warning: replacing the registration of %s; its previous settings are reset\n", relPath)
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s is already registered in vault %s; keeping its settings (use --replace to reset them)\n", relPath, vault)
+		}
+	}
+
+	register := config.RegisterFile
+	if registerReplace {
+		register = config.ReplaceFileRegistration
+	}
+	if err := register(s, vault, relPath, registerMode, recipients); err != nil {
 		return err
 	}
 
+	format := parser.DetectFormat(relPath)
+	if registerFormat != "" {
+		format, _ = parser.ParseFormat(registerFormat)
+	}
+
+	lineOpts := parser.LineOptions{Delimiter: registerDelimiter, Comments: registerComments}
+	if lineOpts.Delimiter != "" || len(lineOpts.Comments) > 0 {
+		if registerFormat == "" && format != parser.FormatENV {
+			if format != parser.FormatUnknown {
+				return fmt.Errorf("--delimiter and --comment only apply to env files, not %s", format)
+			}
+			format = parser.FormatENV
+		}
+		if err := config.SetFileLineOptions(s, vault, relPath, lineOpts); err != nil {
+			return err
+		}
+	}
+
+	if format != parser.DetectFormat(relPath) {
+		if err := config.SetFileFormat(s, vault, relPath, string(format)); err != nil {
+			return err
+		}
+	}
+
 	if err := gitignore.EnsureIgnored(s.Root(), relPath); err != nil {
 		fmt.Printf("Warning: failed to add to .gitignore: %v\n", err)
 	}
 
+	if err := gitignore.EnsureAttributes(s.Root(), cfg.GitMergeDriver); err != nil {
+		fmt.Printf("Warning: failed to update .gitattributes: %v\n", err)
+	}
+
+	v, err := config.LoadVault(s, vault)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	fileReg := v.GetFile(relPath)
+	if fileReg == nil {
+		return fmt.Errorf("file %s not registered in vault %s", relPath, vault)
+	}
+
 	fmt.Printf("Registered %s in vault %s\n", relPath, vault)
-	fmt.Printf("  Mode: %s\n", registerMode)
-	if len(registerRecipients) > 0 {
-		fmt.Printf("  Recipients: %v\n", registerRecipients)
+	fmt.Printf("  Mode: %s\n", fileReg.Mode)
+	if fileReg.Format != "" {
+		format = parser.FileFormat(fileReg.Format)
+	}
+	if format != parser.FormatUnknown {
+		fmt.Printf("  Format: %s\n", format)
+	}
+	if fileReg.FullFallback {
+		fmt.Fprintf(os.Stderr, "Warning: %s has no supported format; values mode will encrypt the whole file (use --format, or --mode full to make it explicit)\n", relPath)
+	}
+	if t := v.TemplateFor(relPath); t != nil {
+		fmt.Printf("  Recipients: %v (recipient template %s)\n", fileReg.Recipients, t.Pattern)
+	} else if len(fileReg.Recipients) > 0 {
+		fmt.Printf("  Recipients: %v\n", fileReg.Recipients)
 	} else {
 		fmt.Println("  Recipients: all vault users")
 	}
 
 	// Auto-encrypt unless --no-encrypt is specified
 	if !registerNoEncrypt {
-		v, err := config.LoadVault(s, vault)
-		if err == nil {
-			if fileReg := v.GetFile(relPath); fileReg != nil {
-				if err := encryptFile(s, vault, fileReg); err != nil {
-					fmt.Printf("Warning: encryption failed: %v\n", err)
-					fmt.Println("Run 'shhh encrypt' manually after resolving the issue")
-				}
-			}
+		if err := encryptFile(s, vault, fileReg); err != nil {
+			fmt.Printf("Warning: encryption failed: %v\n", err)
+			fmt.Println("Run 'shhh encrypt' manually after resolving the issue")
 		}
 	}
 
@@ -150,5 +334,63 @@ func runUnregister(cmd *cobra.Command, args []string) error {
 	}
 
 	fmt.Printf("Unregistered %s from vault %s\n", relPath, vault)
+
+	cleanupUnregistered(s, relPath, unregisterDeleteEnc, unregisterDeletePlaintext)
 	return nil
 }
+
+// cleanupUnregistered deletes the requested files of an unregistered path
+// and removes it from .gitignore.
+func cleanupUnregistered(s *store.Store, relPath string, deleteEnc, deletePlaintext bool) {
+	absPath := filepath.Join(s.Root(), filepath.FromSlash(relPath))
+
+	var toDelete []string
+	if deleteEnc {
+		cfg, err := config.Load(s)
+		if err != nil {
+			cfg = config.NewConfig()
+		}
+		backupPath := backup.Path(s, cfg, config.NormalizePath(relPath))
+		toDelete = append(toDelete, absPath+".enc", crypto.SidecarPath(absPath+".enc"), backupPath, backupPath+backup.MetaSuffix)
+	}
+	if deletePlaintext {
+		toDelete = append(toDelete, absPath)
+	}
+
+	for _, path := range toDelete {
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "Warning: failed to delete %s: %v\n", path, err)
+			}
+			continue
+		}
+		rel, _ := filepath.Rel(s.Root(), path)
+		fmt.Printf("  Deleted %s\n", rel)
+	}
+
+	if err := gitignore.RemoveIgnored(s.Root(), relPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update .gitignore: %v\n", err)
+	} else if _, err := os.Stat(absPath); err == nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s is no longer in .gitignore; do not commit the plaintext\n", relPath)
+	}
+}
+
+// collectRecipients merges recipients given on the command line with those
+// from a recipients file, resolving fingerprints to vault user emails.
+func collectRecipients(s *store.Store, vaultName string, recipients []string, recipientsFile string) ([]string, error) {
+	if recipientsFile == "" {
+		return recipients, nil
+	}
+
+	fromFile, err := config.ReadRecipientsFile(recipientsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := config.LoadVault(s, vaultName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	return vault.ResolveRecipients(vaultName, append(append([]string{}, recipients...), fromFile...))
+}
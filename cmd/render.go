@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	renderIn        string
+	renderOut       string
+	renderOverrides []string
+)
+
+func init() {
+	rootCmd.AddCommand(renderTreeCmd)
+
+	renderTreeCmd.Flags().StringVar(&renderIn, "in", "", "Directory to render, inside the project (required)")
+	renderTreeCmd.Flags().StringVar(&renderOut, "out", "", "Directory to write the rendered tree to (required)")
+	addOverrideFlag(renderTreeCmd, &renderOverrides)
+	renderTreeCmd.MarkFlagRequired("in")
+	renderTreeCmd.MarkFlagRequired("out")
+}
+
+var renderTreeCmd = &cobra.Command{
+	Use:   "render-tree --in <dir> --out <dir>",
+	Short: "Write a copy of a directory with registered files decrypted",
+	Long: `Walk a directory and write a copy of it to --out in which the .enc of
+every registered file is replaced by its decrypted content. Other files are
+copied as they are; plaintexts and sidecar metadata of registered files are
+left out, so the output only depends on what is committed.
+
+Meant for GitOps agents such as Argo CD config management plugins or Flux
+post-build steps that hold the private key:
+
+  shhh render-tree --in ./manifests --out ./rendered
+
+Files that cannot be decrypted are reported and the command fails after
+rendering the rest. Treat --out as secret: an --out inside the project is
+added to .gitignore, one git tracks files in is refused, and readonly mode
+refuses any --out inside the project.
+
+Use --override key.path=value, or SHHH_OVERRIDE_KEY_PATH=value in the
+environment, to render a different value for a key path in every file that
+has it, without changing the encrypted files. Variable names are derived
+from key paths as in 'shhh ci export'.`,
+	Args: cobra.NoArgs,
+	RunE: runRenderTree,
+}
+
+// ignoreRenderOutput makes git ignore an --out directory inside the project
+// before plaintext is rendered into it, and refuses one git already tracks
+// files in.
+func ignoreRenderOutput(s *store.Store, outDir string) error {
+	rel, err := filepath.Rel(s.Root(), outDir)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	if rel == "." || git.IsTracked(s.Root(), rel) {
+		return fmt.Errorf("refusing to render plaintext to %s, which git tracks; use an --out outside the project", renderOut)
+	}
+	if err := gitignore.EnsureIgnored(s.Root(), rel); err != nil {
+		return fmt.Errorf("refusing to render plaintext to %s inside the project: %w", rel, err)
+	}
+	fmt.Fprintf(os.Stderr, "Ignored %s in .gitignore, so the rendered plaintext is not committed\n", filepath.ToSlash(rel))
+	return nil
+}
+
+func runRenderTree(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	inDir, err := filepath.Abs(renderIn)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	outDir, err := filepath.Abs(renderOut)
+	if err != nil {
+		return fmt.Errorf("failed to resolve path: %w", err)
+	}
+	if rel, err := filepath.Rel(s.Root(), inDir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("--in must be within the project directory")
+	}
+	if rel, err := filepath.Rel(inDir, outDir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("--out must not be inside --in")
+	}
+	if err := checkPlaintextWrite(outDir); err != nil {
+		return err
+	}
+	if err := ignoreRenderOutput(s, outDir); err != nil {
+		return err
+	}
+
+	registered, err := registeredFilesByPath(s)
+	if err != nil {
+		return err
+	}
+	overrides, err := loadOverrides(renderOverrides)
+	if err != nil {
+		return err
+	}
+
+	rendered, copied := 0, 0
+	var errs []error
+	err = filepath.WalkDir(inDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(s.Root(), path)
+		if err != nil {
+			return err
+		}
+		outRel, err := filepath.Rel(inDir, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if d.Name() == ".git" || path == s.ShhhPath() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s (not a regular file)\n", relPath)
+			return nil
+		}
+
+		if fileReg, ok := registered[strings.TrimSuffix(relPath, ".enc")]; ok {
+			if !strings.HasSuffix(relPath, ".enc") {
+				if _, err := os.Stat(path + ".enc"); os.IsNotExist(err) {
+					errs = append(errs, fmt.Errorf("%s: encrypted file does not exist", relPath))
+				}
+				return nil
+			}
+			if err := renderRegisteredFile(s, fileReg, overrides, filepath.Join(outDir, strings.TrimSuffix(outRel, ".enc"))); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", fileReg.Path, err))
+				return nil
+			}
+			rendered++
+			return nil
+		}
+		if base, ok := strings.CutSuffix(relPath, ".enc"+crypto.SidecarSuffix); ok && registered[base] != nil {
+			return nil
+		}
+
+		if err := copyFile(path, filepath.Join(outDir, outRel), d); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", relPath, err))
+			return nil
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to walk %s: %w", renderIn, err)
+	}
+
+	fmt.Printf("Rendered %d registered file(s) and copied %d other file(s) to %s\n", rendered, copied, renderOut)
+
+	if len(errs) > 0 {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", e)
+		}
+		return fmt.Errorf("%d file(s) failed to render", len(errs))
+	}
+	return overrides.checkUsed()
+}
+
+// registeredFilesByPath returns every registered file keyed by its path.
+func registeredFilesByPath(s *store.Store) (map[string]*config.RegisteredFile, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*config.RegisteredFile)
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		for i := range vault.Files {
+			files[vault.Files[i].Path] = &vault.Files[i]
+		}
+	}
+	return files, nil
+}
+
+// renderRegisteredFile decrypts a registered file to outPath, with the mode
+// recorded in its metadata when preserve_permissions is on.
+func renderRegisteredFile(s *store.Store, fileReg *config.RegisteredFile, overrides *valueOverrides, outPath string) error {
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+	defer secmem.Wipe(decrypted)
+	if decrypted, err = overrides.apply(fileReg, decrypted); err != nil {
+		return err
+	}
+	defer secmem.Wipe(decrypted)
+
+	mode := os.FileMode(0600)
+	if config.PreservePermissions(s) {
+		if meta := currentMetadata(s, fileReg); meta != nil && meta.PlaintextMode() != 0 {
+			mode = meta.PlaintextMode()
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(outPath, decrypted, mode); err != nil {
+		return fmt.Errorf("failed to write rendered file: %w", err)
+	}
+	return os.Chmod(outPath, mode)
+}
+
+// copyFile copies a regular file, keeping its permission bits.
+func copyFile(src, dst string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(dst, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chmod(dst, info.Mode().Perm())
+}
@@ -0,0 +1,364 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/scan"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportFormat string
+	reportOutput string
+	reportMaxAge int
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.Flags().StringVarP(&reportFormat, "format", "f", "md", "Report format: md, html, or json")
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "", "Write the report to a file instead of stdout")
+	reportCmd.Flags().IntVar(&reportMaxAge, "max-age", -1, "Days before a file is due for rotation (default: rotation_days config)")
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate an access and rotation report",
+	Long: `Generate a report of who can read which secrets, when each file was last
+encrypted (rotated), and policy violations, suitable as audit evidence
+(e.g. SOC 2 or ISO 27001).
+
+Violations include expired or expiring keys, files encrypted for outdated
+recipients, files not rotated within rotation_days (or --max-age), plaintext
+missing from .gitignore or tracked by git, and unregistered files that look
+like secrets.
+
+The report reads metadata only and needs no private key.`,
+	RunE: runReport,
+}
+
+type reportUser struct {
+	Email       string     `json:"email"`
+	Fingerprint string     `json:"fingerprint"`
+	AddedAt     time.Time  `json:"added_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+type reportVault struct {
+	Name        string       `json:"name"`
+	Description string       `json:"description,omitempty"`
+	Owner       string       `json:"owner,omitempty"`
+	Users       []reportUser `json:"users"`
+}
+
+type reportFile struct {
+	Vault       string     `json:"vault"`
+	Path        string     `json:"path"`
+	Mode        string     `json:"mode"`
+	State       string     `json:"state"`
+	Readers     []string   `json:"readers"`
+	LastRotated *time.Time `json:"last_rotated,omitempty"`
+	AgeDays     int        `json:"age_days,omitempty"`
+}
+
+type reportViolation struct {
+	Severity string `json:"severity"`
+	Subject  string `json:"subject"`
+	Message  string `json:"message"`
+}
+
+type accessReport struct {
+	GeneratedAt  time.Time         `json:"generated_at"`
+	Root         string            `json:"root"`
+	RotationDays int               `json:"rotation_days"`
+	Vaults       []reportVault     `json:"vaults"`
+	Files        []reportFile      `json:"files"`
+	Violations   []reportViolation `json:"violations"`
+}
+
+func runReport(cmd *cobra.Command, args []string) error {
+	if reportFormat != "md" && reportFormat != "html" && reportFormat != "json" {
+		return fmt.Errorf("invalid format %q (use md, html, or json)", reportFormat)
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	maxAge := cfg.RotationDays
+	if reportMaxAge >= 0 {
+		maxAge = reportMaxAge
+	}
+
+	report, err := collectReport(s, maxAge)
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+	if reportOutput != "" {
+		f, err := os.Create(reportOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	switch reportFormat {
+	case "json":
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	case "html":
+		return reportHTML.Execute(out, report)
+	default:
+		return writeReportMarkdown(out, report)
+	}
+}
+
+func collectReport(s *store.Store, maxAge int) (*accessReport, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	report := &accessReport{
+		GeneratedAt:  now,
+		Root:         s.Root(),
+		RotationDays: maxAge,
+		Vaults:       []reportVault{},
+		Files:        []reportFile{},
+		Violations:   []reportViolation{},
+	}
+	violate := func(severity, subject, format string, a ...interface{}) {
+		report.Violations = append(report.Violations, reportViolation{severity, subject, fmt.Sprintf(format, a...)})
+	}
+
+	registered := make(map[string]bool)
+	tracked := gitTrackedFiles(s)
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			violate("high", vaultName, "vault cannot be loaded: %v", err)
+			continue
+		}
+
+		rv := reportVault{Name: vaultName, Description: vault.Description, Owner: vault.Owner, Users: []reportUser{}}
+		days := config.ExpiryWarningDays(s, vault)
+		for _, u := range vault.Users {
+			rv.Users = append(rv.Users, reportUser{u.Email, u.Fingerprint, u.AddedAt, u.ExpiresAt})
+			if crypto.IsExpired(u.ExpiresAt) {
+				violate("high", u.Email, "key in vault %s expired on %s", vaultName, u.ExpiresAt.Format("2006-01-02"))
+			} else if crypto.IsExpiringSoon(u.ExpiresAt, days) {
+				violate("low", u.Email, "key in vault %s expires on %s", vaultName, u.ExpiresAt.Format("2006-01-02"))
+			}
+		}
+		report.Vaults = append(report.Vaults, rv)
+
+		for i := range vault.Files {
+			f := &vault.Files[i]
+			registered[f.Path] = true
+
+			status := statusForFile(s, vaultName, f, tracked)
+			rf := reportFile{Vault: vaultName, Path: f.Path, Mode: f.Mode, State: status.State, Readers: []string{}}
+			if readers, err := config.GetEffectiveRecipients(s, vaultName, f); err == nil {
+				rf.Readers = readers
+			}
+
+			if at := lastEncrypted(s, f); at != nil {
+				rf.LastRotated = at
+				rf.AgeDays = int(now.Sub(*at).Hours() / 24)
+			}
+			report.Files = append(report.Files, rf)
+
+			switch {
+			case status.State == "pending":
+				violate("high", f.Path, "registered but never encrypted")
+			case status.State == "missing":
+				violate("medium", f.Path, "neither plaintext nor .enc exists")
+			case status.Stale:
+				violate("high", f.Path, "encrypted for outdated recipients")
+			}
+			if maxAge > 0 && rf.LastRotated != nil && rf.AgeDays > maxAge {
+				violate("medium", f.Path, "last rotated %d days ago (policy: %d)", rf.AgeDays, maxAge)
+			}
+			if status.Tracked {
+				violate("high", f.Path, "plaintext is tracked by git")
+			}
+			if !status.Ignored {
+				violate("high", f.Path, "plaintext is not in .gitignore")
+			}
+		}
+	}
+
+	err = scan.Walk(s.Root(), ".", scan.LoadIgnore(s.Root()), func(relPath string) error {
+		if registered[config.NormalizePath(relPath)] {
+			return nil
+		}
+		if reason, ok := scan.Candidate(s.Root(), relPath); ok {
+			violate("medium", relPath, "unregistered file looks like a secret (%s)", reason)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan project: %w", err)
+	}
+
+	return report, nil
+}
+
+// lastEncrypted returns when a file's .enc was written, from its metadata
+// or, when that is less precise, the local audit log, or nil when unknown.
+func lastEncrypted(s *store.Store, f *config.RegisteredFile) *time.Time {
+	encPath := filepath.Join(s.Root(), f.Path) + ".enc"
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		return nil
+	}
+
+	var at time.Time
+	if meta, err := crypto.ReadFileMetadata(content, encPath, f.Path, f.Spec()); err == nil && meta != nil {
+		at = meta.EncryptedAt.UTC()
+	}
+	if logged, ok := config.LastEncryption(s, f.Path); ok && !logged.Before(at) {
+		at = logged.UTC()
+	}
+	if at.IsZero() {
+		return nil
+	}
+	return &at
+}
+
+func writeReportMarkdown(w io.Writer, r *accessReport) error {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# shhh access and rotation report\n\n")
+	fmt.Fprintf(&b, "- Project: `%s`\n", r.Root)
+	fmt.Fprintf(&b, "- Generated: %s\n", r.GeneratedAt.Format(time.RFC3339))
+	if r.RotationDays > 0 {
+		fmt.Fprintf(&b, "- Rotation policy: %d days\n", r.RotationDays)
+	} else {
+		fmt.Fprintf(&b, "- Rotation policy: none\n")
+	}
+
+	fmt.Fprintf(&b, "\n## Vaults\n\n| Vault | Description | Owner | Users |\n|---|---|---|---|\n")
+	for _, v := range r.Vaults {
+		fmt.Fprintf(&b, "| %s | %s | %s | %d |\n", v.Name, orDash(v.Description), orDash(v.Owner), len(v.Users))
+	}
+
+	fmt.Fprintf(&b, "\n## Vault members\n\n| Vault | User | Fingerprint | Added | Expires |\n|---|---|---|---|---|\n")
+	for _, v := range r.Vaults {
+		for _, u := range v.Users {
+			fmt.Fprintf(&b, "| %s | %s | `%s` | %s | %s |\n", v.Name, u.Email, u.Fingerprint, u.AddedAt.Format("2006-01-02"), formatReportTime(u.ExpiresAt))
+		}
+	}
+
+	fmt.Fprintf(&b, "\n## Files\n\n| File | Vault | Mode | State | Readers | Last rotated |\n|---|---|---|---|---|---|\n")
+	for _, f := range r.Files {
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s |\n", f.Path, f.Vault, f.Mode, f.State, strings.Join(f.Readers, ", "), formatReportTime(f.LastRotated))
+	}
+
+	fmt.Fprintf(&b, "\n## Policy violations\n\n")
+	if len(r.Violations) == 0 {
+		fmt.Fprintf(&b, "None.\n")
+	} else {
+		fmt.Fprintf(&b, "| Severity | Subject | Finding |\n|---|---|---|\n")
+		for _, v := range r.Violations {
+			fmt.Fprintf(&b, "| %s | %s | %s |\n", v.Severity, v.Subject, v.Message)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func formatReportTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format("2006-01-02")
+}
+
+var reportHTML = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": formatReportTime,
+	"dash": orDash,
+	"join": strings.Join,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>shhh access and rotation report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+.high { color: #b00; } .medium { color: #b60; } .low { color: #666; }
+</style>
+</head>
+<body>
+<h1>shhh access and rotation report</h1>
+<p>Project: <code>{{.Root}}</code><br>
+Generated: {{.GeneratedAt.Format "2006-01-02T15:04:05Z07:00"}}<br>
+Rotation policy: {{if gt .RotationDays 0}}{{.RotationDays}} days{{else}}none{{end}}</p>
+
+<h2>Vaults</h2>
+<table>
+<tr><th>Vault</th><th>Description</th><th>Owner</th><th>Users</th></tr>
+{{- range .Vaults}}
+<tr><td>{{.Name}}</td><td>{{dash .Description}}</td><td>{{dash .Owner}}</td><td>{{len .Users}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Vault members</h2>
+<table>
+<tr><th>Vault</th><th>User</th><th>Fingerprint</th><th>Added</th><th>Expires</th></tr>
+{{- range $v := .Vaults}}{{range .Users}}
+<tr><td>{{$v.Name}}</td><td>{{.Email}}</td><td><code>{{.Fingerprint}}</code></td><td>{{.AddedAt.Format "2006-01-02"}}</td><td>{{date .ExpiresAt}}</td></tr>
+{{- end}}{{end}}
+</table>
+
+<h2>Files</h2>
+<table>
+<tr><th>File</th><th>Vault</th><th>Mode</th><th>State</th><th>Readers</th><th>Last rotated</th></tr>
+{{- range .Files}}
+<tr><td>{{.Path}}</td><td>{{.Vault}}</td><td>{{.Mode}}</td><td>{{.State}}</td><td>{{join .Readers ", "}}</td><td>{{date .LastRotated}}</td></tr>
+{{- end}}
+</table>
+
+<h2>Policy violations</h2>
+{{if .Violations}}<table>
+<tr><th>Severity</th><th>Subject</th><th>Finding</th></tr>
+{{- range .Violations}}
+<tr class="{{.Severity}}"><td>{{.Severity}}</td><td>{{.Subject}}</td><td>{{.Message}}</td></tr>
+{{- end}}
+</table>{{else}}<p>None.</p>{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+// bulkReport is the --report path of the bulk command being run.
+var bulkReport string
+
+// addReportFlag registers --report on a command that can process many files.
+func addReportFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&bulkReport, "report", "", "Write the outcome of each file as JSON to this path")
+}
+
+// Outcomes of a file in a bulk command.
+const (
+	resultOK      = "ok"
+	resultSkipped = "skipped"
+	resultFailed  = "failed"
+)
+
+type fileResult struct {
+	Path   string `json:"path"`
+	Vault  string `json:"vault"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// bulkResults collects the outcome of each file of a bulk command, so they
+// can be summarised together at the end rather than interleaved with
+// progress output.
+type bulkResults struct {
+	Command string       `json:"command"`
+	OK      int          `json:"ok"`
+	Skipped int          `json:"skipped"`
+	Failed  int          `json:"failed"`
+	Files   []fileResult `json:"files"`
+
+	// verb describes the operation in the final error, e.g. "re-encrypt".
+	verb string
+}
+
+func newBulkResults(command, verb string) *bulkResults {
+	return &bulkResults{Command: command, Files: []fileResult{}, verb: verb}
+}
+
+// record adds the outcome of processing a file: ok when err is nil, failed
+// otherwise.
+func (r *bulkResults) record(vault, path string, err error) {
+	if err != nil {
+		r.Failed++
+		r.Files = append(r.Files, fileResult{Path: path, Vault: vault, Status: resultFailed, Reason: err.Error()})
+		return
+	}
+	r.OK++
+	r.Files = append(r.Files, fileResult{Path: path, Vault: vault, Status: resultOK})
+}
+
+func (r *bulkResults) skip(vault, path, reason string) {
+	r.Skipped++
+	r.Files = append(r.Files, fileResult{Path: path, Vault: vault, Status: resultSkipped, Reason: reason})
+}
+
+// done returns the paths of the files processed successfully.
+func (r *bulkResults) done() []string {
+	var paths []string
+	for _, f := range r.Files {
+		if f.Status == resultOK {
+			paths = append(paths, f.Path)
+		}
+	}
+	return paths
+}
+
+// finish prints the summary table, writes the --report file if requested,
+// and returns an error if any file failed.
+func (r *bulkResults) finish() error {
+	if len(r.Files) > 0 {
+		r.print()
+	}
+
+	if bulkReport != "" {
+		data, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := store.WriteFile(bulkReport, append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+		fmt.Printf("Wrote results to %s\n", bulkReport)
+	}
+
+	if r.Failed > 0 {
+		return fmt.Errorf("%d file(s) failed to %s", r.Failed, r.verb)
+	}
+	return nil
+}
+
+func (r *bulkResults) print() {
+	pathWidth, vaultWidth := len("FILE"), len("VAULT")
+	for _, f := range r.Files {
+		pathWidth = max(pathWidth, len(f.Path))
+		vaultWidth = max(vaultWidth, len(f.Vault))
+	}
+
+	fmt.Println()
+	row := func(status, path, vault, reason string) {
+		line := fmt.Sprintf("  %-7s  %-*s  %-*s  %s", status, pathWidth, path, vaultWidth, vault, reason)
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+	row("STATUS", "FILE", "VAULT", "REASON")
+	for _, f := range r.Files {
+		// Reasons can span lines, such as decryption key hints.
+		row(f.Status, f.Path, f.Vault, strings.Join(strings.Fields(f.Reason), " "))
+	}
+
+	fmt.Printf("\n%d file(s): %d ok, %d skipped, %d failed\n", len(r.Files), r.OK, r.Skipped, r.Failed)
+}
@@ -3,13 +3,23 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
 	Version   = "development"
 	BuildTime = "unknown"
+
+	rootDir   string
+	gnupgHome string
 )
 
 var rootCmd = &cobra.Command{
@@ -22,14 +32,53 @@ manages users by GPG email, and supports multiple vaults with
 per-file recipient controls.`,
 	SilenceUsage:  true,
 	SilenceErrors: true,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		store.SetRoot(rootDir)
+		auditCommand = cmd.CommandPath()
+		applyGPGConfig()
+		applyMemoryHardening()
+		// A shell prompt has no room for warnings.
+		if cmd != upgradeCmd && cmd != promptCmd {
+			warnOutdatedStore()
+		}
+	},
 }
 
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+
+	// SHHH_DEBUG reports which backend handled each operation, and why
+	// auto mode fell back to the gpg CLI.
+	if os.Getenv("SHHH_DEBUG") != "" {
+		for _, line := range crypto.ProviderLog() {
+			fmt.Fprintln(os.Stderr, "debug:", line)
+		}
+	}
+
+	return err
 }
 
 func init() {
 	rootCmd.AddCommand(versionCmd)
+
+	rootCmd.PersistentFlags().StringVar(&rootDir, "root", "", "Project root to operate on (default: nearest .shhh above the working directory)")
+	rootCmd.PersistentFlags().StringVar(&gnupgHome, "gnupg-home", "", "GnuPG home directory to use (default: gnupg_home config, then $GNUPGHOME)")
+
+	crypto.SetPassphrasePrompt(promptPassphrase)
+	crypto.SetDecryptionRecorder(recordDecryption)
+}
+
+// promptPassphrase asks for the passphrase of a locked private key on the
+// terminal. Piped stdin is left alone, as it may carry the command's input.
+func promptPassphrase(key string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, fmt.Errorf("no terminal to ask on; set %s", crypto.PassphraseEnv)
+	}
+	fmt.Fprintf(os.Stderr, "Passphrase for %s: ", key)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	return passphrase, err
 }
 
 var versionCmd = &cobra.Command{
@@ -40,6 +89,76 @@ var versionCmd = &cobra.Command{
 	},
 }
 
+// applyGPGConfig points both GPG providers at the keyring chosen with
+// --gnupg-home or the gnupg_home config by setting GNUPGHOME, which the gpg
+// CLI also honours, and applies the project's provider and gpg CLI options.
+func applyGPGConfig() {
+	home := gnupgHome
+	if s, err := store.GetStore(); err == nil {
+		crypto.SetPubkeysDir(s.PubkeysPath())
+		if cfg, err := config.Load(s); err == nil {
+			opts := crypto.CLIOptions{TrustModel: cfg.GPGTrustModel}
+			if trustedSetting(s, "gpg_binary", cfg.GPGBinary) {
+				opts.Binary = cfg.GPGBinary
+			}
+			if trustedSetting(s, "gpg_args", cfg.GPGArgs) {
+				opts.Args = strings.Fields(cfg.GPGArgs)
+			}
+			crypto.SetCLIOptions(opts)
+			if err := crypto.SetProviderMode(cfg.Provider); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
+			crypto.SetFallback(cfg.ProviderFallback)
+			if home == "" && trustedSetting(s, "gnupg_home", cfg.GnuPGHome) {
+				home = cfg.GnuPGHomePath(s)
+			}
+		}
+	}
+	if home == "" {
+		return
+	}
+
+	if abs, err := filepath.Abs(home); err == nil {
+		home = abs
+	}
+	if info, err := os.Stat(home); err != nil || !info.IsDir() {
+		fmt.Fprintf(os.Stderr, "Warning: GnuPG home %s does not exist\n", home)
+	}
+	os.Setenv("GNUPGHOME", home)
+}
+
+// trustedSetting reports whether a command setting from config.yaml may be
+// used, warning when it is set but not trusted on this machine.
+func trustedSetting(s *store.Store, key, value string) bool {
+	if config.SettingTrusted(s, key, value) {
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s is not trusted on this machine and is ignored; review it, then run 'shhh hooks trust'\n", config.CommandSetting{Key: key, Value: value})
+	return false
+}
+
+// applyMemoryHardening locks decrypted buffers into RAM and disables core
+// dumps when memory_hardening is set.
+func applyMemoryHardening() {
+	s, err := store.GetStore()
+	if err != nil {
+		return
+	}
+	if cfg, err := config.Load(s); err == nil && cfg.MemoryHardening {
+		if err := secmem.Harden(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: memory hardening is incomplete: %v\n", err)
+		}
+	}
+}
+
+// warnOutdatedStore suggests 'shhh upgrade' when the project was written by
+// an older release. Older stores keep working until they are upgraded.
+func warnOutdatedStore() {
+	if s, err := store.GetStore(); err == nil && config.IsOutdated(s) {
+		fmt.Fprintln(os.Stderr, "Warning: .shhh uses an older format version; run 'shhh upgrade'")
+	}
+}
+
 func exitWithError(msg string) {
 	fmt.Fprintln(os.Stderr, "Error:", msg)
 	os.Exit(1)
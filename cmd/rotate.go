@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/spf13/cobra"
+)
+
+var (
+	rotateFile      string
+	rotateKey       string
+	rotateGenerator string
+	rotateHook      string
+)
+
+func init() {
+	rootCmd.AddCommand(rotateCmd)
+
+	rotateCmd.Flags().StringVar(&rotateFile, "file", "", "Registered file holding the secret (required)")
+	rotateCmd.Flags().StringVar(&rotateKey, "key", "", "Key path of the secret, e.g. database.password (required)")
+	rotateCmd.Flags().StringVar(&rotateGenerator, "generator", "", "Command printing the new value, e.g. 'openssl rand -hex 32' (required)")
+	rotateCmd.Flags().StringVar(&rotateHook, "hook", "", "Command run with the new value on stdin once the file is updated")
+	rotateCmd.MarkFlagRequired("file")
+	rotateCmd.MarkFlagRequired("key")
+	rotateCmd.MarkFlagRequired("generator")
+}
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate --file <file> --key <key> --generator <command>",
+	Short: "Replace a secret with a newly generated value",
+	Long: `Rotate one secret of a registered file:
+
+  1. run --generator through the shell and take its output, without
+     trailing newlines, as the new value
+  2. set the key to it in the .enc file, as 'shhh apply' would, and record
+     the rotation time in the file's metadata (shown by 'shhh file show')
+  3. run --hook, if given, with the new value on stdin and SHHH_FILE,
+     SHHH_KEY and SHHH_VAULT set, e.g. to update the database password
+
+The key must already exist. If the hook fails, the .enc file already holds
+the new value; restore it from git if the hook made no change.
+
+Example:
+  shhh rotate --file db.yaml --key database.password \
+    --generator 'openssl rand -hex 32' --hook ./update-db.sh`,
+	Args: cobra.NoArgs,
+	RunE: runRotate,
+}
+
+func runRotate(cmd *cobra.Command, args []string) error {
+	s, fileReg, err := findRegisteredFile(rotateFile)
+	if err != nil {
+		return err
+	}
+	vault, _, err := config.FindFileVault(s, fileReg.Path)
+	if err != nil {
+		return err
+	}
+	if err := config.CheckVaultWritable(s, vault); err != nil {
+		return err
+	}
+
+	value, err := hooks.Generate(s.Root(), rotateGenerator)
+	if err != nil {
+		return err
+	}
+	defer secmem.Wipe(value)
+
+	patch := &parser.Patch{Set: []parser.KeyValue{{Key: rotateKey, Value: string(value)}}}
+	done := fmt.Sprintf("Rotated %s in %s.enc", rotateKey, fileReg.Path)
+	if err := patchEncryptedFile(s, vault, fileReg, patch, []string{rotateKey}, done); err != nil {
+		return err
+	}
+
+	if rotateHook == "" {
+		return nil
+	}
+	if err := hooks.RunRotationHook(s.Root(), vault, fileReg.Path, rotateKey, rotateHook, value); err != nil {
+		return fmt.Errorf("rotation %w (%s.enc already holds the new value; restore it with 'git checkout -- %s.enc' if the hook made no change)",
+			err, fileReg.Path, fileReg.Path)
+	}
+	fmt.Println("  Rotation hook succeeded")
+	return nil
+}
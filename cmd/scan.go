@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/scan"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+}
+
+var scanCmd = &cobra.Command{
+	Use:   "scan [dir]",
+	Short: "Find unregistered files that look like secrets",
+	Long: `Scan the project (or a directory) for files that look like they hold
+secrets but are not registered with shhh.
+
+Files are flagged by name (.env, *.pem, secrets.*, ...), by containing a
+private key, or, for configuration files, by secret-like keys such as
+password or api_key.
+
+Paths matching .shhhignore (gitignore syntax) are skipped, along with .git/,
+.shhh/, and encrypted files. Use it for vendored or generated trees.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runScan,
+}
+
+func runScan(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	dir := "."
+	if len(args) > 0 {
+		dir = args[0]
+	}
+
+	relDir, err := projectRelPath(s, dir)
+	if err != nil {
+		return err
+	}
+
+	registered, err := registeredPaths(s)
+	if err != nil {
+		return err
+	}
+
+	found := 0
+	err = scan.Walk(s.Root(), relDir, scan.LoadIgnore(s.Root()), func(relPath string) error {
+		if registered[config.NormalizePath(relPath)] {
+			return nil
+		}
+		if reason, ok := scan.Candidate(s.Root(), relPath); ok {
+			fmt.Printf("  %s (%s)\n", relPath, reason)
+			found++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan %s: %w", dir, err)
+	}
+
+	if found == 0 {
+		fmt.Println("No unregistered secret-like files found")
+		return nil
+	}
+
+	fmt.Printf("\nFound %d unregistered file(s) that may contain secrets\n", found)
+	fmt.Println("Register them with 'shhh register <file>' or add them to .shhhignore")
+	return nil
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/spf13/cobra"
+)
+
+var (
+	scrubFile string
+	scrubRun  bool
+)
+
+func init() {
+	rootCmd.AddCommand(scrubCmd)
+
+	scrubCmd.Flags().StringVar(&scrubFile, "file", "", "Registered file whose plaintext was committed (required)")
+	scrubCmd.Flags().BoolVar(&scrubRun, "run", false, "Run git filter-repo and the re-encryption after confirmation")
+	scrubCmd.MarkFlagRequired("file")
+}
+
+var scrubCmd = &cobra.Command{
+	Use:   "scrub --file <file>",
+	Short: "Guide removing committed plaintext from git history",
+	Long: `List the commits, on any branch or tag, that contain the plaintext of a
+registered file, and print the steps to clean up:
+
+  1. rewrite history without the plaintext using git filter-repo
+  2. force-push and have collaborators re-clone
+  3. rotate the secrets, which anyone with the old history still has
+  4. re-encrypt the file so the new .enc shares nothing with the old one
+
+With --run, steps 1 and 4 are carried out after confirmation. git
+filter-repo must be installed, and refuses to rewrite anything but a fresh
+clone unless told otherwise; scrubbing a fresh mirror clone is safest.`,
+	Args: cobra.NoArgs,
+	RunE: runScrub,
+}
+
+func runScrub(cmd *cobra.Command, args []string) error {
+	s, fileReg, err := findRegisteredFile(scrubFile)
+	if err != nil {
+		return err
+	}
+	vault, _, err := config.FindFileVault(s, fileReg.Path)
+	if err != nil {
+		return err
+	}
+
+	top, err := git.TopLevel(s.Root())
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	// filter-repo takes paths relative to the repository top level.
+	repoPath, err := filepath.Rel(top, filepath.Join(s.Root(), fileReg.Path))
+	if err != nil {
+		return err
+	}
+	repoPath = filepath.ToSlash(repoPath)
+
+	commits, err := git.FileLogAll(s.Root(), fileReg.Path)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		fmt.Printf("No commit contains %s; there is nothing to scrub\n", fileReg.Path)
+		return nil
+	}
+
+	fmt.Printf("Plaintext of %s appears in %d commit(s):\n", fileReg.Path, len(commits))
+	for _, c := range commits {
+		fmt.Printf("  %.8s %s %s  %s\n", c.Hash, c.Date.Format("2006-01-02"), c.Email, c.Subject)
+	}
+	fmt.Println()
+
+	filterArgs := []string{"filter-repo", "--invert-paths", "--path", repoPath}
+	steps := []struct{ what, command string }{
+		{"Rewrite history without the plaintext (in " + top + "):", "git filter-repo --invert-paths --path " + shellQuote(repoPath)},
+		{"Force-push every branch and tag, then have collaborators re-clone:", "git push --force --all origin && git push --force --tags origin"},
+		{"Rotate every secret in the file; the old history still holds them:", "shhh rotate --file " + shellQuote(fileReg.Path) + " --key <key> --generator <command>"},
+		{"Re-encrypt the file so the new .enc shares nothing with the old one:", "shhh reencrypt --force " + shellQuote(fileReg.Path)},
+	}
+
+	if !scrubRun {
+		fmt.Println("To clean up:")
+		for i, step := range steps {
+			fmt.Printf("  %d. %s\n       %s\n", i+1, step.what, step.command)
+		}
+		fmt.Println("\nRun with --run to rewrite history and re-encrypt after confirmation.")
+		return nil
+	}
+
+	if _, err := exec.LookPath("git-filter-repo"); err != nil {
+		return fmt.Errorf("git filter-repo is not installed (see https://github.com/newren/git-filter-repo)")
+	}
+	if !confirm(bufio.NewReader(os.Stdin), fmt.Sprintf("Rewrite the history of %s to remove %s?", top, repoPath)) {
+		fmt.Println("Aborted")
+		return nil
+	}
+
+	filter := exec.Command("git", filterArgs...)
+	filter.Dir = top
+	filter.Stdout = os.Stdout
+	filter.Stderr = os.Stderr
+	if err := filter.Run(); err != nil {
+		return fmt.Errorf("git filter-repo failed: %w", err)
+	}
+
+	reencryptForce = true
+	if err := reencryptFile(s, vault, fileReg); err != nil {
+		return fmt.Errorf("history was rewritten but re-encryption failed: %w", err)
+	}
+
+	fmt.Println("\nStill to do:")
+	for i, step := range steps[1:3] {
+		fmt.Printf("  %d. %s\n       %s\n", i+1, step.what, step.command)
+	}
+	return nil
+}
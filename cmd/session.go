@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/session"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(sessionCmd)
+	sessionCmd.AddCommand(sessionApproveCmd)
+	sessionCmd.AddCommand(sessionRevokeCmd)
+
+	crypto.SetDecryptGate(confirmDecrypt)
+}
+
+var sessionCmd = &cobra.Command{
+	Use:   "session",
+	Short: "Manage decryption approvals of this terminal session",
+	Long: `With confirm_decrypt set, the first command in a terminal session that
+decrypts a file of a vault asks on the terminal whether to go ahead. The
+approval is cached for the session, so later commands run from the same
+terminal, including scripts, are not asked again; other terminals are.
+Approvals expire after 12 hours. CI is never asked.
+
+The cache lives in $XDG_RUNTIME_DIR/shhh, or a private directory under the
+temp directory, keyed by the terminal's session ID.`,
+}
+
+var sessionApproveCmd = &cobra.Command{
+	Use:   "approve [vault]...",
+	Short: "Approve decrypting files of vaults in this session",
+	Long: `Approve decrypting files of the given vaults, or of every vault and
+values decrypted on their own with decrypt-value, for the rest of this
+terminal session, e.g. before running a script that has no terminal to ask
+on.`,
+	RunE: runSessionApprove,
+}
+
+var sessionRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Forget the approvals given in this session",
+	Args:  cobra.NoArgs,
+	RunE:  runSessionRevoke,
+}
+
+// unknownVault is the approval scope of values decrypted on their own and
+// of files whose vault cannot be told.
+const unknownVault = "(unknown)"
+
+// decryptApprovals caches this process's answers, so decrypting several
+// files of a vault asks at most once.
+var decryptApprovals = map[string]bool{}
+
+// confirmDecrypt is the crypto.DecryptGate for confirm_decrypt: it asks
+// before the first file of a vault is decrypted in a terminal session.
+// Decryptions outside a project are not asked about.
+func confirmDecrypt(filename string, content []byte) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return nil
+	}
+	cfg, err := config.Load(s)
+	if err != nil || !cfg.ConfirmDecrypt || config.InCI() {
+		return nil
+	}
+
+	vault := decryptedVault(s, filename, content)
+	scope := approvalScope(s, vault)
+	if approved, asked := decryptApprovals[scope]; asked {
+		if !approved {
+			return fmt.Errorf("decrypting files of vault %s was not approved", vault)
+		}
+		return nil
+	}
+	if session.Approved(scope) {
+		decryptApprovals[scope] = true
+		return nil
+	}
+
+	approved, err := askDecryptApproval(vault, filename)
+	decryptApprovals[scope] = approved
+	if err != nil {
+		hint := "shhh session approve"
+		if vault != unknownVault {
+			hint += " " + vault
+		}
+		return fmt.Errorf("confirm_decrypt: %w (approve it first with '%s')", err, hint)
+	}
+	if !approved {
+		return fmt.Errorf("decrypting files of vault %s was not approved", vault)
+	}
+	if err := session.Approve(scope); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: approval is not remembered for this session: %v\n", err)
+	}
+	return nil
+}
+
+// decryptedVault names the vault a file belongs to: the one it is
+// registered in or, for other files, the one recorded in its metadata.
+// Values decrypted on their own carry no vault.
+func decryptedVault(s *store.Store, filename string, content []byte) string {
+	if filename == "" {
+		return unknownVault
+	}
+	if vaultName, _, err := config.FindFileVault(s, config.NormalizePath(filename)); err == nil {
+		return vaultName
+	}
+	if meta, err := crypto.GetFileMetadata(content, filename, parser.Spec{}); err == nil && meta != nil && meta.Privacy == "" && meta.Vault != "" {
+		return meta.Vault
+	}
+	return unknownVault
+}
+
+// approvalScope identifies a vault of a project in the session cache.
+func approvalScope(s *store.Store, vault string) string {
+	return s.Root() + "\t" + vault
+}
+
+// askDecryptApproval asks on the controlling terminal, so a command whose
+// input and output are redirected still asks the person who started it.
+func askDecryptApproval(vault, filename string) (bool, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return false, fmt.Errorf("no terminal to ask for approval to decrypt vault %s", vault)
+	}
+	defer tty.Close()
+
+	if filename == "" {
+		fmt.Fprintf(tty, "Decrypt ENC values of unknown vaults in this terminal session? [y/N] ")
+	} else {
+		fmt.Fprintf(tty, "Decrypt %s and other files of vault %s in this terminal session? [y/N] ", filename, vault)
+	}
+	answer := readAnswer(bufio.NewReader(tty))
+	return answer == "y" || answer == "yes", nil
+}
+
+func runSessionApprove(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	vaults := args
+	if len(vaults) == 0 {
+		if vaults, err = s.ListVaults(); err != nil {
+			return err
+		}
+		// Also values decrypted on their own, e.g. by decrypt-value.
+		if err := session.Approve(approvalScope(s, unknownVault)); err != nil {
+			return err
+		}
+	}
+	for _, name := range vaults {
+		if !s.VaultExists(name) {
+			return fmt.Errorf("vault %q does not exist", name)
+		}
+		if err := session.Approve(approvalScope(s, name)); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Approved decrypting files of %s in this session\n", strings.Join(vaults, ", "))
+	return nil
+}
+
+func runSessionRevoke(cmd *cobra.Command, args []string) error {
+	if err := session.Revoke(); err != nil {
+		return err
+	}
+	fmt.Println("Revoked this session's decryption approvals")
+	return nil
+}
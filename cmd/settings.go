@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	applySettingsPrune  bool
+	applySettingsDryRun bool
+)
+
+func init() {
+	fileCmd.AddCommand(fileExportSettingsCmd)
+	fileCmd.AddCommand(fileApplySettingsCmd)
+
+	fileApplySettingsCmd.Flags().BoolVar(&applySettingsPrune, "prune", false, "Unregister files the document leaves out")
+	fileApplySettingsCmd.Flags().BoolVar(&applySettingsDryRun, "dry-run", false, "Show what would change without changing anything")
+}
+
+var fileExportSettingsCmd = &cobra.Command{
+	Use:   "export-settings",
+	Short: "Print the settings of every registered file as YAML",
+	Long: `Print the settings of every registered file (vault, mode, recipients,
+gpg_copy, format, schema, hooks and the other per-file settings) as one YAML
+document, ordered by vault and path:
+
+  shhh file export-settings > settings.yaml
+
+Edit it, have the change reviewed like any other, and apply it with
+'shhh file apply-settings settings.yaml'.`,
+	Args: cobra.NoArgs,
+	RunE: runFileExportSettings,
+}
+
+var fileApplySettingsCmd = &cobra.Command{
+	Use:   "apply-settings <settings.yaml>",
+	Short: "Make file registrations match a settings document",
+	Long: `Register, update or move files so their registrations match a document
+written by 'shhh file export-settings'. A file listed under another vault
+than the one it is registered in is moved there. Settings left out of an
+entry are reset to their defaults.
+
+Registered files the document leaves out are reported and kept, unless
+--prune is given; their plaintext stays in .gitignore. The whole document is checked before anything is written;
+use --dry-run to only see the changes. Files whose vault or recipients
+change must be re-encrypted with 'shhh reencrypt'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runFileApplySettings,
+}
+
+func runFileExportSettings(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	doc, err := config.ExportSettings(s)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintln(&out, "# Settings of every registered file. Apply changes with")
+	fmt.Fprintln(&out, "# 'shhh file apply-settings <this file>'.")
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode settings: %w", err)
+	}
+	encoder.Close()
+
+	_, err = os.Stdout.Write(out.Bytes())
+	return err
+}
+
+func runFileApplySettings(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to read settings: %w", err)
+	}
+	doc, err := config.ParseSettings(data)
+	if err != nil {
+		return err
+	}
+
+	changes, err := config.ApplySettings(s, doc, applySettingsPrune, applySettingsDryRun)
+	if err != nil {
+		return err
+	}
+
+	applied, reencrypt := 0, false
+	for _, c := range changes {
+		switch c.Action {
+		case "register":
+			fmt.Printf("Register %s in vault %s\n", c.Path, c.Vault)
+			if !applySettingsDryRun {
+				if err := gitignore.EnsureIgnored(s.Root(), c.Path); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to add %s to .gitignore: %v\n", c.Path, err)
+				}
+			}
+		case "move":
+			fmt.Printf("Move %s from vault %s to vault %s\n", c.Path, c.From, c.Vault)
+			reencrypt = true
+		case "update":
+			fmt.Printf("Update %s in vault %s\n", c.Path, c.Vault)
+		case "unregister":
+			fmt.Printf("Unregister %s from vault %s\n", c.Path, c.Vault)
+		case "unlisted":
+			fmt.Fprintf(os.Stderr, "Warning: %s is registered in vault %s but not listed; keeping it (use --prune to unregister it)\n", c.Path, c.Vault)
+			continue
+		}
+		for _, detail := range c.Details {
+			fmt.Printf("  %s\n", detail)
+			if c.Action != "register" && strings.HasPrefix(detail, "recipients:") {
+				reencrypt = true
+			}
+		}
+		applied++
+	}
+
+	switch {
+	case applied == 0:
+		fmt.Println("File settings already match")
+	case applySettingsDryRun:
+		fmt.Printf("Dry run: %d file(s) would change\n", applied)
+	default:
+		fmt.Printf("Applied settings to %d file(s)\n", applied)
+		if reencrypt {
+			fmt.Println("Note: Run 'shhh reencrypt' to apply the new recipients")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// shareFormat identifies the layout of a share bundle's plaintext.
+const shareFormat = "shhh-share/v1"
+
+var (
+	shareTo      string
+	shareKeyFile string
+	shareExpires string
+	shareKeys    []string
+	shareOutput  string
+)
+
+func init() {
+	rootCmd.AddCommand(shareCmd)
+
+	shareCmd.Flags().StringVar(&shareTo, "to", "", "Email of the person to share with (required)")
+	shareCmd.Flags().StringVar(&shareKeyFile, "key-file", "", "Their armored public key, used for this bundle only (default: look --to up in the keyring)")
+	shareCmd.Flags().StringVar(&shareExpires, "expires", "7d", "How long the values may be used, e.g. 12h, 7d or 2w")
+	shareCmd.Flags().StringSliceVarP(&shareKeys, "key", "k", nil, "Only share the value at this key path, or the values under it (repeatable)")
+	shareCmd.Flags().StringVarP(&shareOutput, "output", "o", "", "Bundle to write, or - for stdout (default: <file>.share.asc)")
+	shareCmd.MarkFlagRequired("to")
+}
+
+var shareCmd = &cobra.Command{
+	Use:   "share <file> --to <email>",
+	Short: "Share values with someone outside the vault",
+	Long: `Write a standalone encrypted bundle of a registered file's values for
+someone who is not a vault user, such as a vendor. The recipient needs no
+shhh and no access to the repository:
+
+  gpg --decrypt prod.yaml.share.asc
+
+The bundle holds a manifest describing what was shared, by whom, with whom
+and until when, followed by the values as a flat mapping of key paths.
+Use --key to share only some of them.
+
+The bundle is encrypted to --to alone. Its key is taken from the keyring,
+or from --key-file, which is used for this bundle only and never imported
+or added to a vault.
+
+--expires is recorded in the manifest and in the local audit log; it cannot
+be enforced once the bundle is sent. Rotate the values if access must end
+early.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runShare,
+}
+
+type shareManifest struct {
+	Format    string   `yaml:"format"`
+	File      string   `yaml:"file"`
+	Vault     string   `yaml:"vault"`
+	SharedBy  string   `yaml:"shared_by,omitempty"`
+	To        string   `yaml:"to"`
+	CreatedAt string   `yaml:"created_at"`
+	ExpiresAt string   `yaml:"expires_at"`
+	Keys      []string `yaml:"keys"`
+}
+
+type shareBundle struct {
+	Manifest shareManifest `yaml:"manifest"`
+	Values   yaml.Node     `yaml:"values"`
+}
+
+func runShare(cmd *cobra.Command, args []string) error {
+	if !strings.Contains(shareTo, "@") {
+		return fmt.Errorf("--to must be an email address")
+	}
+	validFor, err := parseShareExpiry(shareExpires)
+	if err != nil {
+		return err
+	}
+
+	var keyData []byte
+	if shareKeyFile != "" {
+		if keyData, err = os.ReadFile(shareKeyFile); err != nil {
+			return fmt.Errorf("failed to read key file: %w", err)
+		}
+	} else {
+		keyInfo, err := crypto.GetProvider().LookupKey(shareTo)
+		if err != nil {
+			return fmt.Errorf("recipient %s: %w (import their key or use --key-file)", shareTo, err)
+		}
+		if crypto.IsExpired(keyInfo.ExpiresAt) {
+			return fmt.Errorf("recipient %s: key has expired", shareTo)
+		}
+	}
+
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+	vaultName, fileReg, err := resolveRegisteredFile(s, args[0])
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+	defer secmem.Protect(decrypted)()
+
+	values, err := secretValues(fileReg, decrypted)
+	if err != nil {
+		return err
+	}
+	values, err = selectShareValues(values, shareKeys, fileReg.Path)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	bundle := shareBundle{
+		Manifest: shareManifest{
+			Format:    shareFormat,
+			File:      fileReg.Path,
+			Vault:     vaultName,
+			SharedBy:  git.UserEmail(s.Root()),
+			To:        shareTo,
+			CreatedAt: now.Format(time.RFC3339),
+			ExpiresAt: now.Add(validFor).Format(time.RFC3339),
+		},
+		Values: yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"},
+	}
+	for _, kv := range values {
+		bundle.Manifest.Keys = append(bundle.Manifest.Keys, kv.Key)
+		bundle.Values.Content = append(bundle.Values.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: kv.Key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: kv.Value})
+	}
+
+	var plaintext bytes.Buffer
+	fmt.Fprintf(&plaintext, "# Shared read-only from %s with %s.\n", fileReg.Path, shareTo)
+	fmt.Fprintf(&plaintext, "# Do not use these values after %s.\n", bundle.Manifest.ExpiresAt)
+	encoder := yaml.NewEncoder(&plaintext)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&bundle); err != nil {
+		return fmt.Errorf("failed to encode bundle: %w", err)
+	}
+	encoder.Close()
+	defer secmem.Wipe(plaintext.Bytes())
+
+	var encrypted []byte
+	if keyData != nil {
+		encrypted, err = crypto.EncryptToKey(plaintext.Bytes(), keyData, shareTo)
+	} else {
+		encrypted, err = crypto.GetProvider().Encrypt(plaintext.Bytes(), []string{shareTo})
+	}
+	if err != nil {
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	outPath := shareOutput
+	if outPath == "" {
+		outPath = filepath.Base(fileReg.Path) + ".share.asc"
+	}
+	if outPath == "-" {
+		if _, err := os.Stdout.Write(encrypted); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(outPath, encrypted, 0600); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	if err := config.RecordShare(s, fileReg.Path, shareTo, now, now.Add(validFor)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record share in audit log: %v\n", err)
+	}
+
+	if outPath != "-" {
+		fmt.Printf("Shared %d value(s) of %s with %s -> %s\n", len(values), fileReg.Path, shareTo, outPath)
+		fmt.Printf("  Expires: %s\n", bundle.Manifest.ExpiresAt)
+	}
+	return nil
+}
+
+// selectShareValues keeps the values at or under the given key paths, or
+// all of them when there are none. Each key path must match something.
+func selectShareValues(values []parser.KeyValue, keys []string, relPath string) ([]parser.KeyValue, error) {
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	var selected []parser.KeyValue
+	for _, kv := range values {
+		for _, key := range keys {
+			if kv.Key == key || strings.HasPrefix(kv.Key, key+".") {
+				selected = append(selected, kv)
+				break
+			}
+		}
+	}
+	for _, key := range keys {
+		found := false
+		for _, kv := range selected {
+			if kv.Key == key || strings.HasPrefix(kv.Key, key+".") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("key %s not found in %s", key, relPath)
+		}
+	}
+	return selected, nil
+}
+
+// parseShareExpiry reads a duration, also accepting days (7d) and weeks
+// (2w).
+func parseShareExpiry(value string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if n, ok := strings.CutSuffix(value, "d"); ok {
+		var days int
+		days, err = strconv.Atoi(n)
+		d = time.Duration(days) * 24 * time.Hour
+	} else if n, ok := strings.CutSuffix(value, "w"); ok {
+		var weeks int
+		weeks, err = strconv.Atoi(n)
+		d = time.Duration(weeks) * 7 * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid expiry %q (use e.g. 12h, 7d or 2w)", value)
+	}
+	return d, nil
+}
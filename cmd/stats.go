@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/scan"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var statsJSON bool
+
+func init() {
+	rootCmd.AddCommand(statsCmd)
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Output as JSON")
+}
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show statistics about encrypted files",
+	Long: `Summarize what shhh protects in this project:
+
+- files and encrypted values per vault, and total ciphertext size
+- the oldest encryption timestamp per vault
+- how many recipients each file is encrypted for
+- coverage: registered files versus unregistered files that 'shhh scan'
+  flags as likely secrets`,
+	RunE: runStats,
+}
+
+type vaultStats struct {
+	Vault          string     `json:"vault"`
+	Files          int        `json:"files"`
+	EncryptedFiles int        `json:"encrypted_files"`
+	Values         int        `json:"values"`
+	Bytes          int64      `json:"ciphertext_bytes"`
+	OldestAt       *time.Time `json:"oldest_encrypted_at,omitempty"`
+	OldestFile     string     `json:"oldest_file,omitempty"`
+}
+
+type statsReport struct {
+	Vaults       []vaultStats `json:"vaults"`
+	Files        int          `json:"files"`
+	Values       int          `json:"values"`
+	Bytes        int64        `json:"ciphertext_bytes"`
+	Recipients   map[int]int  `json:"recipients_per_file"`
+	Unregistered []string     `json:"unregistered_candidates"`
+	Coverage     float64      `json:"coverage"`
+}
+
+func runStats(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	report, err := collectStats(s)
+	if err != nil {
+		return err
+	}
+
+	if statsJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("%-20s %6s %9s %7s %10s  %s\n", "VAULT", "FILES", "ENCRYPTED", "VALUES", "SIZE", "OLDEST")
+	for _, v := range report.Vaults {
+		oldest := "-"
+		if v.OldestAt != nil {
+			oldest = fmt.Sprintf("%s (%s)", v.OldestAt.Format("2006-01-02"), v.OldestFile)
+		}
+		fmt.Printf("%-20s %6d %9d %7d %10s  %s\n", v.Vault, v.Files, v.EncryptedFiles, v.Values, formatBytes(v.Bytes), oldest)
+	}
+	fmt.Printf("\nTotal: %d file(s), %d value(s), %s of ciphertext\n", report.Files, report.Values, formatBytes(report.Bytes))
+
+	if len(report.Recipients) > 0 {
+		counts := make([]int, 0, len(report.Recipients))
+		for n := range report.Recipients {
+			counts = append(counts, n)
+		}
+		sort.Ints(counts)
+
+		fmt.Println("\nRecipients per file:")
+		for _, n := range counts {
+			fmt.Printf("  %d recipient(s): %d file(s)\n", n, report.Recipients[n])
+		}
+	}
+
+	fmt.Printf("\nCoverage: %.0f%% (%d registered, %d unregistered candidate(s))\n",
+		report.Coverage*100, report.Files, len(report.Unregistered))
+	for _, p := range report.Unregistered {
+		fmt.Printf("  %s\n", p)
+	}
+
+	return nil
+}
+
+// collectStats reads every registered .enc file's metadata and values, and
+// runs the scan heuristics to estimate how many secrets are left unprotected.
+func collectStats(s *store.Store) (*statsReport, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &statsReport{
+		Vaults:       []vaultStats{},
+		Recipients:   map[int]int{},
+		Unregistered: []string{},
+	}
+	registered := make(map[string]bool)
+
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+
+		vs := vaultStats{Vault: vaultName, Files: len(vault.Files)}
+		for _, f := range vault.Files {
+			registered[f.Path] = true
+
+			encPath := filepath.Join(s.Root(), f.Path) + ".enc"
+			content, err := os.ReadFile(encPath)
+			if err != nil {
+				continue
+			}
+			vs.EncryptedFiles++
+			vs.Bytes += int64(len(content))
+			if info, err := os.Stat(crypto.SidecarPath(encPath)); err == nil {
+				vs.Bytes += info.Size()
+			}
+
+			vs.Values += countEncryptedValues(content, f.Path, f.Spec())
+
+			if at := lastEncrypted(s, &f); at != nil && (vs.OldestAt == nil || at.Before(*vs.OldestAt)) {
+				vs.OldestAt = at
+				vs.OldestFile = f.Path
+			}
+
+			meta, err := crypto.ReadFileMetadata(content, encPath, f.Path, f.Spec())
+			if err != nil || meta == nil {
+				continue
+			}
+			if len(meta.Recipients) > 0 {
+				report.Recipients[len(meta.Recipients)]++
+			}
+		}
+
+		report.Files += vs.Files
+		report.Values += vs.Values
+		report.Bytes += vs.Bytes
+		report.Vaults = append(report.Vaults, vs)
+	}
+
+	err = scan.Walk(s.Root(), ".", scan.LoadIgnore(s.Root()), func(relPath string) error {
+		if registered[config.NormalizePath(relPath)] {
+			return nil
+		}
+		if _, ok := scan.Candidate(s.Root(), relPath); ok {
+			report.Unregistered = append(report.Unregistered, relPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan project: %w", err)
+	}
+
+	if total := report.Files + len(report.Unregistered); total > 0 {
+		report.Coverage = float64(report.Files) / float64(total)
+	} else {
+		report.Coverage = 1
+	}
+
+	return report, nil
+}
+
+// countEncryptedValues counts ENC[...] values in a values-mode file. A
+// full-mode file counts as a single value.
+func countEncryptedValues(content []byte, filename string, spec parser.Spec) int {
+	if crypto.IsFullyEncrypted(content) {
+		return 1
+	}
+
+	values, err := parser.FlattenFile(content, filename, spec)
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, kv := range values {
+		if parser.IsEncrypted(kv.Value) {
+			count++
+		}
+	}
+	return count
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -1,22 +1,38 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/git"
 	"github.com/cychiuae/shhh/internal/gitignore"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
-var statusVault string
+var (
+	statusVault  string
+	statusJSON   bool
+	statusShort  bool
+	statusState  string
+	statusStrict bool
+	statusFix    bool
+)
 
 func init() {
 	rootCmd.AddCommand(statusCmd)
 	statusCmd.Flags().StringVarP(&statusVault, "vault", "v", "", "Show status for specific vault")
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Output status as JSON")
+	statusCmd.Flags().BoolVarP(&statusShort, "short", "s", false, "One line per file: <state> <path>")
+	statusCmd.Flags().BoolVar(&statusStrict, "exit-nonzero-on-warning", false, "Exit non-zero if any warning is shown, e.g. to enforce key renewals in CI")
+	statusCmd.Flags().BoolVar(&statusFix, "fix-perms", false, "Make .shhh and its files private to you (0700 directories, 0600 files)")
+	statusCmd.Flags().StringVar(&statusState, "state", "", "Only show files in this state: encrypted, decrypted, pending, missing, modified, or stale")
 }
 
 var statusCmd = &cobra.Command{
@@ -26,12 +42,69 @@ var statusCmd = &cobra.Command{
 
 Shows:
 - File encryption state (encrypted, decrypted, pending, missing)
+- Plaintext modified after encryption, and .enc files encrypted for
+  outdated recipients (stale)
 - Warnings about expiring keys
-- Gitignore status`,
+- Gitignore status, and plaintext that git tracks in the index or HEAD
+- .shhh directories and files that other users can read or write, and
+  whether git tracks .shhh as store_git requires (tracked or ignored)
+
+Use --short or --json for output that scripts and shell prompts can consume,
+and --state to filter, e.g. 'shhh status --short --state pending'.
+
+Keys are reported as expiring within expiry_warning_days (30 by default),
+which a vault can override with 'shhh vault describe --expiry-warning-days'.
+Use --exit-nonzero-on-warning in CI to fail until expiring keys are renewed
+and other warnings are addressed.
+
+A git checkout creates .shhh with the umask's permissions rather than the
+private ones shhh uses; --fix-perms restores them.`,
 	RunE: runStatus,
 }
 
+var statusStates = []string{"encrypted", "decrypted", "pending", "missing", "modified", "stale"}
+
+type statusEntry struct {
+	Vault    string   `json:"vault"`
+	Path     string   `json:"path"`
+	State    string   `json:"state"`
+	Modified bool     `json:"modified"`
+	Stale    bool     `json:"stale"`
+	Ignored  bool     `json:"gitignored"`
+	Tracked  bool     `json:"tracked"`
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+type statusKeyWarning struct {
+	Vault   string `json:"vault"`
+	Email   string `json:"email"`
+	Warning string `json:"warning"`
+}
+
+type statusReport struct {
+	Files         []statusEntry      `json:"files"`
+	KeyWarnings   []statusKeyWarning `json:"key_warnings"`
+	StoreWarnings []string           `json:"store_warnings"`
+}
+
+func (e statusEntry) matches(state string) bool {
+	switch state {
+	case "":
+		return true
+	case "modified":
+		return e.Modified
+	case "stale":
+		return e.Stale
+	default:
+		return e.State == state
+	}
+}
+
 func runStatus(cmd *cobra.Command, args []string) error {
+	if statusState != "" && !containsString(statusStates, statusState) {
+		return fmt.Errorf("invalid state: %s (must be one of %s)", statusState, strings.Join(statusStates, ", "))
+	}
+
 	s, err := store.GetStore()
 	if err != nil {
 		return err
@@ -50,8 +123,46 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	hasWarnings := false
+	storeIssues := storeWarnings(s)
+
+	if statusJSON || statusShort {
+		report := collectStatus(s, vaults)
+		report.StoreWarnings = storeIssues
+		if statusJSON {
+			data, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return report.warningsError()
+		}
+		for _, e := range report.Files {
+			line := fmt.Sprintf("%-9s %s", e.State, e.Path)
+			if e.Modified {
+				line += " (modified)"
+			}
+			if e.Stale {
+				line += " (stale)"
+			}
+			if e.Tracked {
+				line += " (tracked)"
+			}
+			fmt.Println(line)
+		}
+		return report.warningsError()
+	}
+
+	hasWarnings := len(storeIssues) > 0
 	totalFiles := 0
+	tracked := gitTrackedFiles(s)
+
+	if len(storeIssues) > 0 && statusState == "" {
+		fmt.Println("Store: .shhh")
+		for _, w := range storeIssues {
+			fmt.Printf("  ⚠ %s\n", w)
+		}
+		fmt.Println()
+	}
 
 	for _, vaultName := range vaults {
 		vault, err := config.LoadVault(s, vaultName)
@@ -63,32 +174,39 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			continue
 		}
 
-		fmt.Printf("Vault: %s\n", vaultName)
+		if statusState == "" {
+			fmt.Printf("Vault: %s\n", vaultName)
+
+			days := config.ExpiryWarningDays(s, vault)
+			for _, u := range vault.Users {
+				if crypto.IsExpired(u.ExpiresAt) {
+					fmt.Printf("  ⚠ User %s: key has EXPIRED\n", u.Email)
+					hasWarnings = true
+				} else if crypto.IsExpiringSoon(u.ExpiresAt, days) {
+					fmt.Printf("  ⚠ User %s: key expires %s\n", u.Email, u.ExpiresAt.Format("2006-01-02"))
+					hasWarnings = true
+				}
+			}
 
-		for _, u := range vault.Users {
-			if crypto.IsExpired(u.ExpiresAt) {
-				fmt.Printf("  ⚠ User %s: key has EXPIRED\n", u.Email)
-				hasWarnings = true
-			} else if crypto.IsExpiringSoon(u.ExpiresAt, 30) {
-				fmt.Printf("  ⚠ User %s: key expires %s\n", u.Email, u.ExpiresAt.Format("2006-01-02"))
-				hasWarnings = true
+			if len(vault.Files) == 0 {
+				fmt.Println("  No files registered")
+				fmt.Println()
+				continue
 			}
-		}
 
-		if len(vault.Files) == 0 {
-			fmt.Println("  No files registered")
 			fmt.Println()
-			continue
 		}
 
-		fmt.Println()
-
-		for _, f := range vault.Files {
+		for i := range vault.Files {
+			f := &vault.Files[i]
+			entry := statusForFile(s, vaultName, f, tracked)
+			if !entry.matches(statusState) {
+				continue
+			}
 			totalFiles++
-			status := getFileStatusDetailed(s.Root(), f.Path)
 
 			icon := "✓"
-			switch status.State {
+			switch entry.State {
 			case "encrypted":
 				icon = "🔒"
 			case "decrypted":
@@ -97,42 +215,245 @@ func runStatus(cmd *cobra.Command, args []string) error {
 				icon = "⏳"
 			case "missing":
 				icon = "❌"
-				hasWarnings = true
 			}
 
-			fmt.Printf("  %s %s [%s]\n", icon, f.Path, status.State)
+			fmt.Printf("  %s %s [%s]\n", icon, f.Path, entry.State)
 
-			if status.Warning != "" {
-				fmt.Printf("      ⚠ %s\n", status.Warning)
+			for _, w := range entry.Warnings {
+				fmt.Printf("      ⚠ %s\n", w)
 				hasWarnings = true
 			}
+		}
 
-			if !gitignore.IsIgnored(s.Root(), f.Path) {
-				fmt.Printf("      ⚠ Not in .gitignore!\n")
-				hasWarnings = true
+		if statusState == "" {
+			fmt.Println()
+		}
+	}
+
+	if totalFiles == 0 {
+		if statusState != "" {
+			fmt.Printf("No %s files\n", statusState)
+		} else {
+			fmt.Println("No files registered")
+		}
+	} else {
+		fmt.Printf("Total: %d file(s)\n", totalFiles)
+		if hasWarnings {
+			fmt.Println("\n⚠ Some issues need attention")
+		}
+	}
+
+	if hasWarnings && statusStrict {
+		return fmt.Errorf("status reported warnings")
+	}
+	return nil
+}
+
+// warningsError fails the command under --exit-nonzero-on-warning when the
+// report has any warning.
+func (r statusReport) warningsError() error {
+	if !statusStrict {
+		return nil
+	}
+	warnings := len(r.KeyWarnings) + len(r.StoreWarnings)
+	for _, e := range r.Files {
+		warnings += len(e.Warnings)
+	}
+	if warnings > 0 {
+		return fmt.Errorf("status reported %d warning(s)", warnings)
+	}
+	return nil
+}
+
+// collectStatus gathers the status of every file in the given vaults,
+// applying the --state filter.
+func collectStatus(s *store.Store, vaults []string) statusReport {
+	report := statusReport{Files: []statusEntry{}, KeyWarnings: []statusKeyWarning{}, StoreWarnings: []string{}}
+	tracked := gitTrackedFiles(s)
+
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+
+		days := config.ExpiryWarningDays(s, vault)
+		for _, u := range vault.Users {
+			if crypto.IsExpired(u.ExpiresAt) {
+				report.KeyWarnings = append(report.KeyWarnings, statusKeyWarning{vaultName, u.Email, "expired"})
+			} else if crypto.IsExpiringSoon(u.ExpiresAt, days) {
+				report.KeyWarnings = append(report.KeyWarnings, statusKeyWarning{vaultName, u.Email, "expires " + u.ExpiresAt.Format("2006-01-02")})
 			}
 		}
 
-		fmt.Println()
+		for i := range vault.Files {
+			entry := statusForFile(s, vaultName, &vault.Files[i], tracked)
+			if entry.matches(statusState) {
+				report.Files = append(report.Files, entry)
+			}
+		}
 	}
 
-	if totalFiles == 0 {
-		fmt.Println("No files registered")
+	return report
+}
+
+// statusForFile reports the state of one registered file. tracked is the
+// result of gitTrackedFiles.
+func statusForFile(s *store.Store, vault string, f *config.RegisteredFile, tracked map[string]bool) statusEntry {
+	status := getFileStatusDetailed(s.Root(), f.Path)
+
+	entry := statusEntry{
+		Vault:    vault,
+		Path:     f.Path,
+		State:    status.State,
+		Modified: status.Modified,
+		Ignored:  gitignore.IsIgnored(s.Root(), f.Path),
+		Tracked:  tracked[filepath.ToSlash(f.Path)],
+	}
+
+	if status.Warning != "" {
+		entry.Warnings = append(entry.Warnings, status.Warning)
+	}
+
+	if status.State == "encrypted" || status.State == "decrypted" {
+		if stale, err := hasStaleRecipients(s, vault, f); err == nil && stale {
+			entry.Stale = true
+			entry.Warnings = append(entry.Warnings, "Encrypted for outdated recipients (run 'shhh reencrypt')")
+		}
+	}
+
+	if entry.Tracked {
+		entry.Warnings = append(entry.Warnings, fmt.Sprintf("Plaintext is tracked by git! (run 'git rm --cached %s', commit, and rotate its secrets)", f.Path))
+	}
+	if !entry.Ignored {
+		entry.Warnings = append(entry.Warnings, "Not in .gitignore!")
+	}
+
+	return entry
+}
+
+// storeWarnings checks the .shhh directory itself: permissions that let
+// other users read or change it, which --fix-perms repairs, and whether git
+// tracks it as store_git requires.
+func storeWarnings(s *store.Store) []string {
+	var warnings []string
+
+	issues, err := s.CheckPermissions()
+	if err != nil {
+		warnings = append(warnings, fmt.Sprintf("Could not check .shhh permissions: %v", err))
+	}
+	if statusFix && len(issues) > 0 {
+		if err := s.FixPermissions(issues); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Failed to fix .shhh permissions: %v", err))
+		} else {
+			fmt.Fprintf(os.Stderr, "Fixed permissions of %d path(s) in .shhh\n", len(issues))
+			issues = nil
+		}
+	}
+
+	byProblem := map[string][]string{}
+	var problems []string
+	for _, issue := range issues {
+		if _, ok := byProblem[issue.Problem]; !ok {
+			problems = append(problems, issue.Problem)
+		}
+		byProblem[issue.Problem] = append(byProblem[issue.Problem], fmt.Sprintf("%s (%04o)", filepath.ToSlash(issue.Path), issue.Mode))
+	}
+	for _, problem := range problems {
+		warnings = append(warnings, fmt.Sprintf("%s: %s (run 'shhh status --fix-perms')", problem, summarizePaths(byProblem[problem])))
+	}
+
+	return append(warnings, storeGitWarnings(s)...)
+}
+
+// storeGitWarnings checks the store_git policy: with tracked, the config,
+// vault files and cached public keys must be committed; with ignored, git
+// must neither track nor be about to track anything in .shhh.
+func storeGitWarnings(s *store.Store) []string {
+	cfg, err := config.Load(s)
+	if err != nil || cfg.StoreGitValue() == config.StoreGitAny || !git.IsRepo(s.Root()) {
+		return nil
+	}
+	tracked, err := git.TrackedFiles(s.Root())
+	if err != nil {
 		return nil
 	}
 
-	fmt.Printf("Total: %d file(s)\n", totalFiles)
+	var warnings []string
+	switch cfg.StoreGitValue() {
+	case config.StoreGitTracked:
+		expected := []string{s.ConfigPath()}
+		vaults, _ := s.ListVaults()
+		for _, name := range vaults {
+			expected = append(expected, s.VaultConfigPath(name))
+		}
+		pubkeys, _ := filepath.Glob(filepath.Join(s.ShhhPath(), store.PubkeysDir, "*.asc"))
+		expected = append(expected, pubkeys...)
+
+		var untracked []string
+		for _, path := range expected {
+			rel, _ := filepath.Rel(s.Root(), path)
+			if !tracked[filepath.ToSlash(rel)] {
+				untracked = append(untracked, filepath.ToSlash(rel))
+			}
+		}
+		if len(untracked) > 0 {
+			warnings = append(warnings, fmt.Sprintf("not tracked by git: %s (store_git is tracked; commit them)", summarizePaths(untracked)))
+		}
 
-	if hasWarnings {
-		fmt.Println("\n⚠ Some issues need attention")
+	case config.StoreGitIgnored:
+		var inGit []string
+		for path := range tracked {
+			if strings.HasPrefix(path, store.ShhhDir+"/") {
+				inGit = append(inGit, path)
+			}
+		}
+		sort.Strings(inGit)
+		if len(inGit) > 0 {
+			warnings = append(warnings, fmt.Sprintf("tracked by git: %s (store_git is ignored; run 'git rm -r --cached %s')", summarizePaths(inGit), store.ShhhDir))
+		}
+		if !gitignore.IsIgnored(s.Root(), s.ConfigPath()) {
+			warnings = append(warnings, fmt.Sprintf("%s is not in .gitignore (store_git is ignored)", store.ShhhDir))
+		}
 	}
+	return warnings
+}
 
-	return nil
+// summarizePaths lists a few paths and counts the rest.
+func summarizePaths(paths []string) string {
+	const shown = 3
+	if len(paths) <= shown {
+		return strings.Join(paths, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:shown], ", "), len(paths)-shown)
+}
+
+// gitTrackedFiles returns the files git tracks in the index or HEAD under
+// the project root, or nil outside a git repository.
+func gitTrackedFiles(s *store.Store) map[string]bool {
+	if !git.IsRepo(s.Root()) {
+		return nil
+	}
+	tracked, err := git.TrackedFiles(s.Root())
+	if err != nil {
+		return nil
+	}
+	return tracked
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 type FileStatusDetailed struct {
-	State   string
-	Warning string
+	State    string
+	Warning  string
+	Modified bool
 }
 
 func getFileStatusDetailed(root, path string) FileStatusDetailed {
@@ -154,6 +475,7 @@ func getFileStatusDetailed(root, path string) FileStatusDetailed {
 		if plainInfo != nil && encInfo != nil {
 			if plainInfo.ModTime().After(encInfo.ModTime()) {
 				result.Warning = "Plaintext modified after encryption"
+				result.Modified = true
 			}
 		}
 
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	syncVault  string
+	syncDryRun bool
+)
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+
+	syncCmd.Flags().StringVarP(&syncVault, "vault", "v", "", "Only sync files in this vault")
+	syncCmd.Flags().BoolVarP(&syncDryRun, "dry-run", "n", false, "Report what would change without writing files")
+}
+
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Bring plaintext and encrypted files in line",
+	Long: `Converge the working tree with the registry; run it after pulling.
+
+For every registered file:
+- plaintext without a .enc, or plaintext edited since it was last decrypted,
+  is encrypted
+- a .enc without plaintext is decrypted
+- a .enc that changed while the plaintext differs is reported as a conflict
+  and left alone (resolve with 'decrypt --force' or 'encrypt')
+- a .enc encrypted for a different recipient set is flagged as stale
+- plaintext tracked by git, in the index or HEAD, is flagged as committed
+
+A summary is printed at the end.`,
+	RunE: runSync,
+}
+
+type syncSummary struct {
+	encrypted []string
+	decrypted []string
+	upToDate  int
+	conflicts []string
+	stale     []string
+	committed []string
+	failed    []string
+}
+
+func runSync(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var vaults []string
+	if syncVault != "" {
+		if !s.VaultExists(syncVault) {
+			return fmt.Errorf("vault %q does not exist", syncVault)
+		}
+		vaults = []string{syncVault}
+	} else {
+		vaults, err = s.ListVaults()
+		if err != nil {
+			return err
+		}
+	}
+
+	var sum syncSummary
+	tracked := gitTrackedFiles(s)
+	for _, vaultName := range vaults {
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			sum.failed = append(sum.failed, fmt.Sprintf("vault %s: %v", vaultName, err))
+			continue
+		}
+		for i := range vault.Files {
+			syncFile(s, vaultName, &vault.Files[i], &sum)
+			if tracked[filepath.ToSlash(vault.Files[i].Path)] {
+				sum.committed = append(sum.committed, vault.Files[i].Path)
+			}
+		}
+	}
+
+	printSyncSummary(&sum)
+
+	if len(sum.stale) > 0 {
+		fireHook(s, hooks.Event{Name: hooks.StaleDetected, Vault: syncVault, Files: sum.stale})
+	}
+	if len(sum.committed) > 0 {
+		fireHook(s, hooks.Event{Name: hooks.PlaintextCommitted, Vault: syncVault, Files: sum.committed})
+	}
+
+	if len(sum.failed) > 0 {
+		return fmt.Errorf("%d file(s) failed to sync", len(sum.failed))
+	}
+	return nil
+}
+
+func syncFile(s *store.Store, vault string, fileReg *config.RegisteredFile, sum *syncSummary) {
+	plainPath := filepath.Join(s.Root(), fileReg.Path)
+	encPath := plainPath + ".enc"
+
+	plainInfo, plainErr := os.Stat(plainPath)
+	encInfo, encErr := os.Stat(encPath)
+
+	switch {
+	case plainErr != nil && encErr != nil:
+		sum.failed = append(sum.failed, fmt.Sprintf("%s: neither plaintext nor .enc exists", fileReg.Path))
+		return
+
+	case encErr != nil:
+		syncEncrypt(s, vault, fileReg, sum)
+		return
+
+	case plainErr != nil:
+		if syncDryRun {
+			sum.decrypted = append(sum.decrypted, fileReg.Path)
+		} else if err := decryptFileNoPrompt(s, vault, fileReg); err != nil {
+			sum.failed = append(sum.failed, fmt.Sprintf("%s: %v", fileReg.Path, err))
+		} else {
+			sum.decrypted = append(sum.decrypted, fileReg.Path)
+		}
+		checkStaleRecipients(s, vault, fileReg, sum)
+		return
+	}
+
+	plaintext, err := os.ReadFile(plainPath)
+	if err != nil {
+		sum.failed = append(sum.failed, fmt.Sprintf("%s: %v", fileReg.Path, err))
+		return
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		// Without a private key we cannot compare contents; fall back to
+		// timestamps so local edits still get encrypted.
+		if plainInfo.ModTime().After(encInfo.ModTime()) {
+			syncEncrypt(s, vault, fileReg, sum)
+			return
+		}
+		sum.failed = append(sum.failed, fmt.Sprintf("%s: %v", fileReg.Path, err))
+		return
+	}
+
+	if crypto.SamePlaintext(plaintext, decrypted, fileReg.Path, fileReg.Spec()) {
+		sum.upToDate++
+		checkStaleRecipients(s, vault, fileReg, sum)
+		return
+	}
+
+	if encInfo.ModTime().After(plainInfo.ModTime()) {
+		sum.conflicts = append(sum.conflicts, fileReg.Path)
+		return
+	}
+
+	syncEncrypt(s, vault, fileReg, sum)
+}
+
+func syncEncrypt(s *store.Store, vault string, fileReg *config.RegisteredFile, sum *syncSummary) {
+	if !syncDryRun {
+		if err := encryptFile(s, vault, fileReg); err != nil {
+			sum.failed = append(sum.failed, fmt.Sprintf("%s: %v", fileReg.Path, err))
+			return
+		}
+	}
+	sum.encrypted = append(sum.encrypted, fileReg.Path)
+}
+
+func checkStaleRecipients(s *store.Store, vault string, fileReg *config.RegisteredFile, sum *syncSummary) {
+	stale, err := hasStaleRecipients(s, vault, fileReg)
+	if err == nil && stale {
+		sum.stale = append(sum.stale, fileReg.Path)
+	}
+}
+
+// hasStaleRecipients reports whether a file's .enc was encrypted for a
+// different recipient set than the one currently in effect.
+func hasStaleRecipients(s *store.Store, vault string, fileReg *config.RegisteredFile) (bool, error) {
+	encPath := filepath.Join(s.Root(), fileReg.Path) + ".enc"
+	content, err := os.ReadFile(encPath)
+	if err != nil {
+		return false, err
+	}
+
+	meta, err := crypto.ReadFileMetadata(content, encPath, fileReg.Path, fileReg.Spec())
+	if err != nil || meta == nil {
+		return false, err
+	}
+
+	current, err := config.GetEffectiveRecipients(s, vault, fileReg)
+	if err != nil {
+		return false, err
+	}
+
+	// Recipients omitted by metadata_privacy cannot be compared.
+	match, known := meta.MatchesRecipients(current, fileReg.Path)
+	return known && !match, nil
+}
+
+func printSyncSummary(sum *syncSummary) {
+	verb := ""
+	if syncDryRun {
+		verb = "would be "
+	}
+
+	fmt.Println()
+	fmt.Println("Sync summary:")
+	fmt.Printf("  %d %sencrypted, %d %sdecrypted, %d up to date\n",
+		len(sum.encrypted), verb, len(sum.decrypted), verb, sum.upToDate)
+
+	if syncDryRun {
+		for _, f := range sum.encrypted {
+			fmt.Printf("  → encrypt %s\n", f)
+		}
+		for _, f := range sum.decrypted {
+			fmt.Printf("  → decrypt %s\n", f)
+		}
+	}
+
+	for _, f := range sum.conflicts {
+		fmt.Printf("  ⚠ %s: .enc changed and differs from plaintext (run 'shhh decrypt --force' or 'shhh encrypt')\n", f)
+	}
+	for _, f := range sum.stale {
+		fmt.Printf("  ⚠ %s: encrypted for outdated recipients (run 'shhh reencrypt')\n", f)
+	}
+	for _, f := range sum.committed {
+		fmt.Printf("  ⚠ %s: plaintext is tracked by git (run 'git rm --cached %s', commit, and rotate its secrets)\n", f, f)
+	}
+	for _, f := range sum.failed {
+		fmt.Printf("  ✗ %s\n", f)
+	}
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var (
+	systemdUnit      string
+	systemdDir       string
+	systemdEncrypt   bool
+	systemdWholeFile bool
+)
+
+func init() {
+	rootCmd.AddCommand(systemdCredsCmd)
+
+	systemdCredsCmd.Flags().StringVarP(&systemdUnit, "unit", "u", "", "systemd unit the credentials are for (e.g. app.service)")
+	systemdCredsCmd.Flags().StringVarP(&systemdDir, "dir", "d", "", "Directory to write credentials to (default: /etc/credstore/<unit>)")
+	systemdCredsCmd.Flags().BoolVar(&systemdEncrypt, "encrypt", false, "Hand each credential to 'systemd-creds encrypt' instead of writing plaintext")
+	systemdCredsCmd.Flags().BoolVar(&systemdWholeFile, "whole-file", false, "Write the decrypted file as a single credential")
+	systemdCredsCmd.MarkFlagRequired("unit")
+}
+
+var systemdCredsCmd = &cobra.Command{
+	Use:   "systemd-creds <file>",
+	Short: "Write a registered file as systemd credentials",
+	Long: `Decrypt a registered file and write each value as a systemd credential.
+
+Structured files (YAML, JSON, INI, ENV) produce one credential per value,
+named after its key path (e.g. database.password). Other files, or any file
+with --whole-file, produce a single credential named after the file.
+
+Credentials are written with 0600 permissions into a 0700 directory, and a
+drop-in snippet with the matching LoadCredential= lines is printed for the
+unit. Use --encrypt to pipe each value through 'systemd-creds encrypt' so
+only host-bound ciphertext is written (LoadCredentialEncrypted=).`,
+	Args: cobra.ExactArgs(1),
+	RunE: runSystemdCreds,
+}
+
+func runSystemdCreds(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	_, fileReg, err := resolveRegisteredFile(s, args[0])
+	if err != nil {
+		return err
+	}
+
+	decrypted, err := decryptRegisteredFile(s, fileReg)
+	if err != nil {
+		return err
+	}
+
+	var creds []parser.KeyValue
+	if systemdWholeFile {
+		creds = []parser.KeyValue{{Key: filepath.Base(fileReg.Path), Value: string(decrypted)}}
+	} else {
+		creds, err = secretValues(fileReg, decrypted)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(creds) == 0 {
+		return fmt.Errorf("no values found in %s", fileReg.Path)
+	}
+
+	dir := systemdDir
+	if dir == "" {
+		dir = filepath.Join("/etc/credstore", systemdUnit)
+	}
+	if !systemdEncrypt {
+		if err := checkPlaintextWrite(dir); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(dir, store.DirPerms); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+
+	directive := "LoadCredential"
+	if systemdEncrypt {
+		directive = "LoadCredentialEncrypted"
+	}
+
+	var dropin bytes.Buffer
+	dropin.WriteString("[Service]\n")
+
+	seen := make(map[string]bool)
+	for _, c := range creds {
+		name := systemdCredentialName(c.Key)
+		if seen[name] {
+			return fmt.Errorf("credential name collision for %q", name)
+		}
+		seen[name] = true
+
+		credPath := filepath.Join(dir, name)
+		if systemdEncrypt {
+			credPath += ".cred"
+			if err := systemdEncryptCredential(name, c.Value, credPath); err != nil {
+				return err
+			}
+		} else if err := os.WriteFile(credPath, []byte(c.Value), store.FilePerms); err != nil {
+			return fmt.Errorf("failed to write credential %s: %w", name, err)
+		}
+
+		fmt.Fprintf(&dropin, "%s=%s:%s\n", directive, name, credPath)
+	}
+
+	fmt.Printf("Wrote %d credential(s) for %s to %s\n\n", len(creds), systemdUnit, dir)
+	fmt.Printf("# /etc/systemd/system/%s.d/shhh-credentials.conf\n", systemdUnit)
+	fmt.Print(dropin.String())
+	fmt.Println()
+	fmt.Println("Services read credentials from $CREDENTIALS_DIRECTORY/<name>")
+
+	return nil
+}
+
+// systemdCredentialName turns a key path into a valid credential name.
+func systemdCredentialName(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+func systemdEncryptCredential(name, value, outPath string) error {
+	cmd := exec.Command("systemd-creds", "encrypt", "--name="+name, "-", outPath)
+	cmd.Stdin = strings.NewReader(value)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("systemd-creds encrypt failed for %s: %s", name, strings.TrimSpace(stderr.String()))
+	}
+
+	return os.Chmod(outPath, store.FilePerms)
+}
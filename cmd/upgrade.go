@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var upgradeDryRun bool
+
+func init() {
+	rootCmd.AddCommand(upgradeCmd)
+	upgradeCmd.Flags().BoolVarP(&upgradeDryRun, "dry-run", "n", false, "Only list the migrations that would run")
+}
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Migrate the .shhh store to the current format version",
+	Long: `Bring a .shhh directory written by an older shhh release up to the
+format version of this build. The version is recorded in config.yaml and
+each vault.yaml; migrations run in order and the version is saved after
+each one, so an interrupted upgrade can be resumed by running it again.
+
+Commit the changes under .shhh afterwards. Everyone working on the repo
+needs a shhh release that understands the new version.`,
+	RunE: runUpgrade,
+}
+
+func runUpgrade(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	pending, err := config.PendingMigrations(s)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Printf("Store is already at format version %s\n", config.CurrentVersion)
+		return nil
+	}
+
+	if upgradeDryRun {
+		for _, m := range pending {
+			fmt.Printf("Would migrate %s -> %s: %s\n", m.From, m.To, m.Description)
+		}
+		return nil
+	}
+
+	applied, err := config.Upgrade(s)
+	for _, m := range applied {
+		fmt.Printf("Migrated %s -> %s: %s\n", m.From, m.To, m.Description)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Store upgraded to format version %s\n", config.CurrentVersion)
+	return nil
+}
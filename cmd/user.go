@@ -2,14 +2,24 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
 	"github.com/cychiuae/shhh/internal/store"
 	"github.com/spf13/cobra"
 )
 
-var userVault string
+var (
+	userVault      string
+	userCheckAll   bool
+	userListSort   string
+	userListFilter string
+	userAddUID     string
+)
 
 func init() {
 	rootCmd.AddCommand(userCmd)
@@ -19,6 +29,10 @@ func init() {
 	userCmd.AddCommand(userCheckCmd)
 
 	userCmd.PersistentFlags().StringVarP(&userVault, "vault", "v", "", "Vault to operate on (default: default vault)")
+	userAddCmd.Flags().StringVar(&userAddUID, "uid", "", "Full user ID to pick when the email matches several keys or identities, e.g. 'Alice (work) <alice@example.com>'")
+	userListCmd.Flags().StringVar(&userListSort, "sort", "added", "Sort users by added, email, or expiry")
+	userListCmd.Flags().StringVar(&userListFilter, "filter", "", "Only users whose key is expired, expiring, or missing from the keyring")
+	userCheckCmd.Flags().BoolVar(&userCheckAll, "all", false, "Check every vault and show a user × vault matrix")
 }
 
 var userCmd = &cobra.Command{
@@ -33,7 +47,11 @@ var userAddCmd = &cobra.Command{
 	Long: `Add a user by their GPG email address.
 
 The user's GPG public key must be available in the local keyring.
-The key will be cached in .shhh/pubkeys/ for other team members.`,
+The key will be cached in .shhh/pubkeys/ for other team members.
+
+A key with a user ID equal to --uid is preferred over any key with the
+email in one of its user IDs. The matched user ID is recorded, and the key
+is looked up by it from then on.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runUserAdd,
 }
@@ -48,7 +66,13 @@ var userRemoveCmd = &cobra.Command{
 var userListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List users in a vault",
-	RunE:  runUserList,
+	Long: `List the users of a vault with their key details.
+
+Users are listed in the order they were added; use --sort email or
+--sort expiry (soonest first, keys that never expire last) to reorder them.
+--filter shows only users whose key is expired, expiring within
+expiry_warning_days, or missing from the local keyring.`,
+	RunE: runUserList,
 }
 
 var userCheckCmd = &cobra.Command{
@@ -60,7 +84,11 @@ Reports on:
 - Missing keys (not in local keyring)
 - Changed keys (fingerprint mismatch)
 - Expired keys
-- Keys expiring within 30 days`,
+- Keys expiring within expiry_warning_days
+
+With --all, every vault is checked in one pass and the result is shown as a
+matrix of users by vaults. Users recorded with different fingerprints in
+different vaults, or missing from some vaults, are listed below it.`,
 	RunE: runUserCheck,
 }
 
@@ -91,12 +119,15 @@ func runUserAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	email := args[0]
-	user, err := config.AddUser(s, vault, email)
+	user, err := config.AddUser(s, vault, email, userAddUID)
 	if err != nil {
 		return err
 	}
 
 	fmt.Printf("Added user %s to vault %s\n", email, vault)
+	if user.UID != "" {
+		fmt.Printf("  UID: %s\n", user.UID)
+	}
 	fmt.Printf("  Key ID: %s\n", user.KeyID)
 	fmt.Printf("  Fingerprint: %s\n", user.Fingerprint)
 	if user.ExpiresAt != nil {
@@ -106,6 +137,12 @@ func runUserAdd(cmd *cobra.Command, args []string) error {
 	}
 	fmt.Println("Note: Run 'shhh reencrypt' to grant access to existing secrets")
 
+	if err := config.RefreshCanary(s, vault); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update vault canary: %v\n", err)
+	}
+
+	fireHook(s, hooks.Event{Name: hooks.UserAdded, Vault: vault, User: email})
+
 	return nil
 }
 
@@ -127,6 +164,12 @@ func runUserRemove(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("Removed user %s from vault %s\n", email, vault)
 	fmt.Println("Note: Run 'shhh reencrypt' to remove their access to existing secrets")
+
+	if err := config.RefreshCanary(s, vault); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to update vault canary: %v\n", err)
+	}
+
+	fireHook(s, hooks.Event{Name: hooks.UserRemoved, Vault: vault, User: email})
 	return nil
 }
 
@@ -141,6 +184,13 @@ func runUserList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	if userListSort != "added" && userListSort != "email" && userListSort != "expiry" {
+		return fmt.Errorf("invalid sort %q (use added, email, or expiry)", userListSort)
+	}
+	if userListFilter != "" && userListFilter != "expired" && userListFilter != "expiring" && userListFilter != "missing" {
+		return fmt.Errorf("invalid filter %q (use expired, expiring, or missing)", userListFilter)
+	}
+
 	v, err := config.LoadVault(s, vault)
 	if err != nil {
 		return fmt.Errorf("failed to load vault: %w", err)
@@ -151,19 +201,30 @@ func runUserList(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	days := config.ExpiryWarningDays(s, v)
+	users := filterUsers(v.Users, days)
+	if len(users) == 0 {
+		fmt.Printf("No %s users in vault %s\n", userListFilter, vault)
+		return nil
+	}
+	sortUsers(users)
+
 	fmt.Printf("Users in vault %s:\n\n", vault)
 
-	for _, u := range v.Users {
+	for _, u := range users {
 		status := "valid"
 		if u.ExpiresAt != nil {
 			if crypto.IsExpired(u.ExpiresAt) {
 				status = "EXPIRED"
-			} else if crypto.IsExpiringSoon(u.ExpiresAt, 30) {
+			} else if crypto.IsExpiringSoon(u.ExpiresAt, days) {
 				status = "expiring soon"
 			}
 		}
 
 		fmt.Printf("  %s\n", u.Email)
+		if u.UID != "" {
+			fmt.Printf("    UID: %s\n", u.UID)
+		}
 		fmt.Printf("    Key ID: %s\n", u.KeyID)
 		fmt.Printf("    Fingerprint: %s\n", u.Fingerprint)
 		if u.ExpiresAt != nil {
@@ -178,12 +239,63 @@ func runUserList(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// filterUsers returns the users matching --filter, counting keys that
+// expire within days as expiring.
+func filterUsers(users []config.User, days int) []config.User {
+	if userListFilter == "" {
+		return append([]config.User{}, users...)
+	}
+
+	gpg := crypto.GetProvider()
+	var filtered []config.User
+	for _, u := range users {
+		var match bool
+		switch userListFilter {
+		case "expired":
+			match = u.ExpiresAt != nil && crypto.IsExpired(u.ExpiresAt)
+		case "expiring":
+			match = u.ExpiresAt != nil && !crypto.IsExpired(u.ExpiresAt) && crypto.IsExpiringSoon(u.ExpiresAt, days)
+		case "missing":
+			_, err := gpg.LookupKey(u.KeyQuery())
+			match = err != nil
+		}
+		if match {
+			filtered = append(filtered, u)
+		}
+	}
+	return filtered
+}
+
+func sortUsers(users []config.User) {
+	sort.SliceStable(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		switch userListSort {
+		case "email":
+			return a.Email < b.Email
+		case "expiry":
+			if a.ExpiresAt == nil || b.ExpiresAt == nil {
+				return a.ExpiresAt != nil && b.ExpiresAt == nil
+			}
+			return a.ExpiresAt.Before(*b.ExpiresAt)
+		default:
+			return a.AddedAt.Before(b.AddedAt)
+		}
+	})
+}
+
 func runUserCheck(cmd *cobra.Command, args []string) error {
 	s, err := store.GetStore()
 	if err != nil {
 		return err
 	}
 
+	if userCheckAll {
+		if userVault != "" {
+			return fmt.Errorf("--all and --vault cannot be used together")
+		}
+		return runUserCheckAll(s)
+	}
+
 	vault, err := getVault(s)
 	if err != nil {
 		return err
@@ -203,16 +315,76 @@ func runUserCheck(cmd *cobra.Command, args []string) error {
 
 	hasIssues := false
 	for _, status := range statuses {
-		icon := "✓"
 		if status.Status != "valid" {
-			icon = "✗"
 			hasIssues = true
 		}
-		if status.Status == "expiring" {
-			icon = "!"
+		fmt.Printf("  %s %s: %s\n", keyStatusIcon(status.Status), status.Email, status.Message)
+	}
+
+	if hasIssues {
+		fmt.Println("\nSome keys have issues. Please address them before encrypting.")
+		return fmt.Errorf("key validation failed")
+	}
+
+	fmt.Println("\nAll keys are valid.")
+	return nil
+}
+
+func runUserCheckAll(s *store.Store) error {
+	vaults, users, err := config.CheckAllUserKeys(s)
+	if err != nil {
+		return err
+	}
+
+	if len(users) == 0 {
+		fmt.Println("No users in any vault")
+		return nil
+	}
+
+	fmt.Printf("Key status across %d vault(s):\n\n", len(vaults))
+
+	emailWidth := len("USER")
+	for _, u := range users {
+		emailWidth = max(emailWidth, len(u.Email))
+	}
+	widths := make([]int, len(vaults))
+	for i, vaultName := range vaults {
+		widths[i] = max(len(vaultName), len("! expiring"))
+	}
+
+	header := fmt.Sprintf("  %-*s", emailWidth, "USER")
+	for i, vaultName := range vaults {
+		header += fmt.Sprintf("  %-*s", widths[i], vaultName)
+	}
+	fmt.Println(strings.TrimRight(header, " "))
+
+	hasIssues := false
+	for _, u := range users {
+		row := fmt.Sprintf("  %-*s", emailWidth, u.Email)
+		for i, vaultName := range vaults {
+			cell := "-"
+			if status, ok := u.Vaults[vaultName]; ok {
+				cell = keyStatusIcon(status.Status) + " " + status.Status
+				if status.Status != "valid" && status.Status != "expiring" {
+					hasIssues = true
+				}
+			}
+			row += fmt.Sprintf("  %-*s", widths[i], cell)
 		}
+		fmt.Println(strings.TrimRight(row, " "))
+	}
 
-		fmt.Printf("  %s %s: %s\n", icon, status.Email, status.Message)
+	var notes []string
+	for _, u := range users {
+		for _, p := range u.Problems {
+			notes = append(notes, fmt.Sprintf("  %s: %s", u.Email, p))
+		}
+	}
+	if len(notes) > 0 {
+		fmt.Println("\nInconsistent across vaults:")
+		for _, n := range notes {
+			fmt.Println(n)
+		}
 	}
 
 	if hasIssues {
@@ -223,3 +395,14 @@ func runUserCheck(cmd *cobra.Command, args []string) error {
 	fmt.Println("\nAll keys are valid.")
 	return nil
 }
+
+func keyStatusIcon(status string) string {
+	switch status {
+	case "valid":
+		return "✓"
+	case "expiring":
+		return "!"
+	default:
+		return "✗"
+	}
+}
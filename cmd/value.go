@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	valueVault      string
+	valueRecipients []string
+)
+
+func init() {
+	rootCmd.AddCommand(encryptValueCmd)
+	rootCmd.AddCommand(decryptValueCmd)
+
+	encryptValueCmd.Flags().StringVarP(&valueVault, "vault", "v", "", "Encrypt for all users of this vault (default: default vault)")
+	encryptValueCmd.Flags().StringSliceVarP(&valueRecipients, "recipients", "r", nil, "Specific recipients (default: all vault users)")
+}
+
+var encryptValueCmd = &cobra.Command{
+	Use:   "encrypt-value [value]",
+	Short: "Encrypt a single value into an ENC[v1:...] token",
+	Long: `Encrypt a single value and print the ENC[v1:...] token, ready to paste
+into any values-mode file.
+
+The value is read from the argument, from stdin when piped, or from a
+hidden prompt. Prefer stdin or the prompt so the secret does not end up
+in shell history.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runEncryptValue,
+}
+
+var decryptValueCmd = &cobra.Command{
+	Use:   "decrypt-value [token]",
+	Short: "Decrypt a single ENC[v1:...] token",
+	Long:  `Decrypt an ENC[v1:...] token read from the argument or stdin and print the plaintext.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runDecryptValue,
+}
+
+func runEncryptValue(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	recipients := valueRecipients
+	if len(recipients) == 0 {
+		vaultName := valueVault
+		if vaultName == "" {
+			cfg, err := config.Load(s)
+			if err != nil {
+				return err
+			}
+			vaultName = cfg.DefaultVault
+		}
+
+		if !s.VaultExists(vaultName) {
+			return fmt.Errorf("vault %q does not exist", vaultName)
+		}
+
+		vault, err := config.LoadVault(s, vaultName)
+		if err != nil {
+			return fmt.Errorf("failed to load vault: %w", err)
+		}
+		if vault.Archived {
+			return config.CheckVaultWritable(s, vaultName)
+		}
+		recipients = vault.Emails()
+	}
+
+	if len(recipients) == 0 {
+		return fmt.Errorf("no recipients available (add users to vault)")
+	}
+
+	if err := crypto.CheckRecipients(recipients); err != nil {
+		return err
+	}
+
+	value, err := readValueInput(args, "Value: ")
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := crypto.EncryptValue(value, recipients)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(encrypted)
+	return nil
+}
+
+func runDecryptValue(cmd *cobra.Command, args []string) error {
+	token, err := readValueInput(args, "Token: ")
+	if err != nil {
+		return err
+	}
+
+	token = strings.TrimSpace(token)
+	if !parser.IsEncrypted(token) {
+		return fmt.Errorf("not an ENC[v1:...] token")
+	}
+
+	plaintext, err := crypto.DecryptValue(token)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(plaintext)
+	return nil
+}
+
+// readValueInput returns the positional argument if given, otherwise reads
+// stdin: a hidden prompt on a terminal, or the full piped input (minus one
+// trailing newline).
+func readValueInput(args []string, prompt string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		value, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read value: %w", err)
+		}
+		return string(value), nil
+	}
+
+	data, err := io.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
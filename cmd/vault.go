@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -11,15 +12,43 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var vaultForce bool
+var (
+	vaultForce       bool
+	vaultMoveTo      string
+	vaultUnregister  bool
+	vaultDeleteEnc   bool
+	vaultListJSON    bool
+	vaultDescription string
+	vaultOwner       string
+
+	vaultExpiryWarningDays int
+)
 
 func init() {
 	rootCmd.AddCommand(vaultCmd)
 	vaultCmd.AddCommand(vaultCreateCmd)
 	vaultCmd.AddCommand(vaultRemoveCmd)
 	vaultCmd.AddCommand(vaultListCmd)
+	vaultCmd.AddCommand(vaultSetDefaultCmd)
+	vaultCmd.AddCommand(vaultDescribeCmd)
+	vaultCmd.AddCommand(vaultArchiveCmd)
+	vaultCmd.AddCommand(vaultUnarchiveCmd)
+	vaultCmd.AddCommand(vaultTemplateCmd)
+	vaultTemplateCmd.AddCommand(vaultTemplateSetCmd)
+	vaultTemplateCmd.AddCommand(vaultTemplateRemoveCmd)
+	vaultTemplateCmd.AddCommand(vaultTemplateListCmd)
+
+	for _, c := range []*cobra.Command{vaultCreateCmd, vaultDescribeCmd} {
+		c.Flags().StringVar(&vaultDescription, "description", "", "What the vault is for")
+		c.Flags().StringVar(&vaultOwner, "owner", "", "Who is responsible for the vault")
+	}
+	vaultDescribeCmd.Flags().IntVar(&vaultExpiryWarningDays, "expiry-warning-days", 0, "Warn about users' keys expiring within this many days (-1 to use the project's expiry_warning_days)")
 
 	vaultRemoveCmd.Flags().BoolVarP(&vaultForce, "force", "f", false, "Skip confirmation")
+	vaultRemoveCmd.Flags().StringVar(&vaultMoveTo, "move-to", "", "Move registered files to this vault first")
+	vaultRemoveCmd.Flags().BoolVar(&vaultUnregister, "unregister", false, "Unregister the vault's files")
+	vaultRemoveCmd.Flags().BoolVar(&vaultDeleteEnc, "delete-enc", false, "With --unregister, also delete the .enc files and their backups")
+	vaultListCmd.Flags().BoolVar(&vaultListJSON, "json", false, "Output as JSON")
 }
 
 var vaultCmd = &cobra.Command{
@@ -31,23 +60,128 @@ var vaultCmd = &cobra.Command{
 var vaultCreateCmd = &cobra.Command{
 	Use:   "create <name>",
 	Short: "Create a new vault",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runVaultCreate,
+	Long: `Create a new vault. --description and --owner document what the vault is
+for and who is responsible for it; they are shown in 'shhh vault list' and
+'shhh report'.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVaultCreate,
+}
+
+var vaultDescribeCmd = &cobra.Command{
+	Use:   "describe <name>",
+	Short: "Set a vault's description, owner, and expiry warning",
+	Long: `Set the description or owner of an existing vault. Only the given flags
+are changed; pass an empty value to clear one.
+
+--expiry-warning-days overrides the project's expiry_warning_days for the
+vault's users, e.g. for a vault whose keys take longer to renew; pass -1 to
+use the project setting again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVaultDescribe,
 }
 
 var vaultRemoveCmd = &cobra.Command{
 	Use:   "remove <name>",
 	Short: "Remove a vault",
-	Args:  cobra.ExactArgs(1),
-	RunE:  runVaultRemove,
+	Long: `Remove a vault. A vault with registered files is only removed once you
+choose what happens to them:
+
+  --move-to <vault>   move the registrations to another vault (then run
+                      'shhh reencrypt' for the new vault's users)
+  --unregister        unregister the files, keeping their .enc files unless
+                      --delete-enc is given
+
+Without either flag you are asked interactively. Plaintext files are never
+deleted. Consider 'shhh vault archive' to keep a vault's history instead.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVaultRemove,
 }
 
 var vaultListCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List all vaults",
+	Long:  `List all vaults with their user and file counts. The default vault is marked with *.`,
 	RunE:  runVaultList,
 }
 
+var vaultSetDefaultCmd = &cobra.Command{
+	Use:   "set-default <name>",
+	Short: "Set the default vault",
+	Long: `Set the vault used by commands when --vault is not given. This is the
+same as 'shhh config set default_vault <name>', but checks that the vault
+exists.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVaultSetDefault,
+}
+
+var vaultArchiveCmd = &cobra.Command{
+	Use:   "archive <name>",
+	Short: "Make a vault read-only",
+	Long: `Archive a vault instead of removing it. Files in an archived vault can
+still be decrypted, but they cannot be encrypted, edited or re-encrypted, and
+no files can be registered in or moved to the vault. 'shhh encrypt' and
+'shhh reencrypt' without arguments skip archived vaults.
+
+Use 'shhh vault unarchive' to make the vault writable again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVaultArchive,
+}
+
+var vaultUnarchiveCmd = &cobra.Command{
+	Use:   "unarchive <name>",
+	Short: "Make an archived vault writable again",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runVaultUnarchive,
+}
+
+var vaultTemplateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "Restrict files at matching paths to fixed recipients",
+	Long: `Recipient templates restrict the files of a vault whose paths match a
+gitignore-style pattern, such as payments/, to a fixed set of recipients.
+
+A file registered at a matching path gets the template's recipients unless
+others are given, which must then be among them. Encrypting, editing,
+applying or re-encrypting a matching file fails if it would be encrypted for
+anyone outside the template, e.g. because it was registered before the
+template existed. The first matching template applies.`,
+}
+
+var vaultTemplateSetCmd = &cobra.Command{
+	Use:   "set <vault> <pattern> <recipient>...",
+	Short: "Add or replace a recipient template",
+	Long: `Restrict files of the vault matching pattern to the given recipients,
+emails or fingerprints of vault users. Setting a pattern again replaces its
+recipients. Existing registrations are not changed; 'shhh encrypt' reports
+those that violate the template.`,
+	Args: cobra.MinimumNArgs(3),
+	RunE: runVaultTemplateSet,
+}
+
+var vaultTemplateRemoveCmd = &cobra.Command{
+	Use:   "remove <vault> <pattern>",
+	Short: "Remove a recipient template",
+	Args:  cobra.ExactArgs(2),
+	RunE:  runVaultTemplateRemove,
+}
+
+var vaultTemplateListCmd = &cobra.Command{
+	Use:   "list [vault]",
+	Short: "List recipient templates and the files they match",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  runVaultTemplateList,
+}
+
+type vaultListEntry struct {
+	Name        string `json:"name"`
+	Default     bool   `json:"default"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Archived    bool   `json:"archived,omitempty"`
+	Users       int    `json:"users"`
+	Files       int    `json:"files"`
+}
+
 func runVaultCreate(cmd *cobra.Command, args []string) error {
 	s, err := store.GetStore()
 	if err != nil {
@@ -60,6 +194,8 @@ func runVaultCreate(cmd *cobra.Command, args []string) error {
 	}
 
 	vault := config.NewVault()
+	vault.Description = vaultDescription
+	vault.Owner = vaultOwner
 	if err := vault.Save(s, name); err != nil {
 		return fmt.Errorf("failed to initialize vault: %w", err)
 	}
@@ -84,36 +220,113 @@ func runVaultRemove(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("vault %q does not exist", name)
 	}
 
+	if cfg, err := config.Load(s); err == nil && cfg.DefaultVault == name {
+		return fmt.Errorf("vault %q is the default vault (run 'shhh vault set-default' first)", name)
+	}
+
+	if vaultMoveTo != "" && vaultUnregister {
+		return fmt.Errorf("--move-to and --unregister cannot be used together")
+	}
+	if vaultDeleteEnc && !vaultUnregister {
+		return fmt.Errorf("--delete-enc requires --unregister")
+	}
+	if vaultMoveTo == name {
+		return fmt.Errorf("cannot move files to the vault being removed")
+	}
+	if vaultMoveTo != "" && !s.VaultExists(vaultMoveTo) {
+		return fmt.Errorf("vault %q does not exist", vaultMoveTo)
+	}
+
 	vault, err := config.LoadVault(s, name)
 	if err != nil {
 		return fmt.Errorf("failed to load vault: %w", err)
 	}
 
-	if !vaultForce {
-		fileCount := len(vault.Files)
-		if fileCount > 0 {
-			fmt.Printf("Vault %q contains %d registered file(s).\n", name, fileCount)
+	reader := bufio.NewReader(os.Stdin)
+	if len(vault.Files) > 0 && vaultMoveTo == "" && !vaultUnregister {
+		if vaultForce {
+			return fmt.Errorf("vault %q contains %d registered file(s) (use --move-to or --unregister)", name, len(vault.Files))
 		}
-		fmt.Printf("Are you sure you want to remove vault %q? [y/N] ", name)
-
-		reader := bufio.NewReader(os.Stdin)
-		response, _ := reader.ReadString('\n')
-		response = strings.TrimSpace(strings.ToLower(response))
-
-		if response != "y" && response != "yes" {
+		if !promptFileMigration(s, reader, name, vault) {
+			fmt.Println("Aborted")
+			return nil
+		}
+	} else if !vaultForce {
+		if len(vault.Files) > 0 {
+			fmt.Printf("Vault %q contains %d registered file(s).\n", name, len(vault.Files))
+		}
+		if !confirm(reader, fmt.Sprintf("Are you sure you want to remove vault %q?", name)) {
 			fmt.Println("Aborted")
 			return nil
 		}
 	}
 
+	for _, f := range vault.Files {
+		if vaultMoveTo != "" {
+			if err := config.MoveFile(s, f.Path, name, vaultMoveTo); err != nil {
+				return fmt.Errorf("failed to move %s: %w (vault not removed)", f.Path, err)
+			}
+			fmt.Printf("Moved %s to vault %s\n", f.Path, vaultMoveTo)
+			continue
+		}
+		if err := config.UnregisterFile(s, name, f.Path); err != nil {
+			return fmt.Errorf("failed to unregister %s: %w (vault not removed)", f.Path, err)
+		}
+		fmt.Printf("Unregistered %s\n", f.Path)
+		cleanupUnregistered(s, f.Path, vaultDeleteEnc, false)
+	}
+
 	if err := s.RemoveVault(name); err != nil {
 		return err
 	}
 
 	fmt.Printf("Removed vault %q\n", name)
+	if vaultMoveTo != "" && len(vault.Files) > 0 {
+		fmt.Println("Note: Run 'shhh reencrypt' to apply the new vault's recipients")
+	}
 	return nil
 }
 
+// promptFileMigration asks what to do with the files of a vault being
+// removed and sets --move-to, --unregister and --delete-enc accordingly. It
+// returns false when the user aborts.
+func promptFileMigration(s *store.Store, reader *bufio.Reader, name string, vault *config.Vault) bool {
+	fmt.Printf("Vault %q contains %d registered file(s):\n", name, len(vault.Files))
+	for _, f := range vault.Files {
+		fmt.Printf("  %s\n", f.Path)
+	}
+	fmt.Print("Move them to another vault (m), unregister them (u), or abort? [m/u/N] ")
+
+	switch readAnswer(reader) {
+	case "m", "move":
+		fmt.Print("Target vault: ")
+		target, _ := reader.ReadString('\n')
+		target = strings.TrimSpace(target)
+		if target == "" || target == name || !s.VaultExists(target) {
+			fmt.Printf("Invalid target vault %q\n", target)
+			return false
+		}
+		vaultMoveTo = target
+	case "u", "unregister":
+		vaultUnregister = true
+		vaultDeleteEnc = confirm(reader, "Also delete their .enc files and backups?")
+	default:
+		return false
+	}
+	return true
+}
+
+func confirm(reader *bufio.Reader, question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	response := readAnswer(reader)
+	return response == "y" || response == "yes"
+}
+
+func readAnswer(reader *bufio.Reader) string {
+	response, _ := reader.ReadString('\n')
+	return strings.TrimSpace(strings.ToLower(response))
+}
+
 func runVaultList(cmd *cobra.Command, args []string) error {
 	s, err := store.GetStore()
 	if err != nil {
@@ -130,28 +343,273 @@ func runVaultList(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	if len(vaults) == 0 {
+	entries := make([]vaultListEntry, 0, len(vaults))
+	for _, vaultName := range vaults {
+		entry := vaultListEntry{Name: vaultName, Default: vaultName == cfg.DefaultVault}
+		if vault, _ := config.LoadVault(s, vaultName); vault != nil {
+			entry.Description = vault.Description
+			entry.Owner = vault.Owner
+			entry.Archived = vault.Archived
+			entry.Users = len(vault.Users)
+			entry.Files = len(vault.Files)
+		}
+		entries = append(entries, entry)
+	}
+
+	if vaultListJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(entries) == 0 {
 		fmt.Println("No vaults found")
 		return nil
 	}
 
-	for _, vaultName := range vaults {
-		vault, _ := config.LoadVault(s, vaultName)
+	for _, entry := range entries {
+		marker := " "
+		if entry.Default {
+			marker = "*"
+		}
 
-		userCount := 0
-		fileCount := 0
-		if vault != nil {
-			userCount = len(vault.Users)
-			fileCount = len(vault.Files)
+		archived := ""
+		if entry.Archived {
+			archived = " [archived]"
 		}
 
-		marker := " "
-		if vaultName == cfg.DefaultVault {
-			marker = "*"
+		fmt.Printf("%s %s (%d users, %d files)%s\n", marker, entry.Name, entry.Users, entry.Files, archived)
+		if entry.Description != "" {
+			fmt.Printf("    %s\n", entry.Description)
+		}
+		if entry.Owner != "" {
+			fmt.Printf("    Owner: %s\n", entry.Owner)
 		}
+	}
+
+	return nil
+}
+
+func runVaultSetDefault(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
 
-		fmt.Printf("%s %s (%d users, %d files)\n", marker, vaultName, userCount, fileCount)
+	name := args[0]
+	if !s.VaultExists(name) {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+	if err := config.CheckVaultWritable(s, name); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if cfg.DefaultVault == name {
+		fmt.Printf("Vault %q is already the default\n", name)
+		return nil
 	}
 
+	cfg.DefaultVault = name
+	if err := cfg.Save(s); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("Default vault is now %q\n", name)
+	return nil
+}
+
+func runVaultDescribe(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	name := args[0]
+	if !s.VaultExists(name) {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+
+	descChanged := cmd.Flags().Changed("description")
+	ownerChanged := cmd.Flags().Changed("owner")
+	expiryChanged := cmd.Flags().Changed("expiry-warning-days")
+	if !descChanged && !ownerChanged && !expiryChanged {
+		return fmt.Errorf("specify --description, --owner, or --expiry-warning-days")
+	}
+	if vaultExpiryWarningDays < -1 {
+		return fmt.Errorf("invalid expiry warning %d (use a number of days, 0 to disable, or -1 for the project setting)", vaultExpiryWarningDays)
+	}
+
+	vault, err := config.LoadVault(s, name)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	if descChanged {
+		vault.Description = vaultDescription
+	}
+	if ownerChanged {
+		vault.Owner = vaultOwner
+	}
+	if expiryChanged {
+		vault.ExpiryWarningDays = nil
+		if vaultExpiryWarningDays >= 0 {
+			days := vaultExpiryWarningDays
+			vault.ExpiryWarningDays = &days
+		}
+	}
+	if err := vault.Save(s, name); err != nil {
+		return fmt.Errorf("failed to save vault: %w", err)
+	}
+
+	fmt.Printf("Updated vault %q\n", name)
+	return nil
+}
+
+func runVaultArchive(cmd *cobra.Command, args []string) error {
+	return setVaultArchived(args[0], true)
+}
+
+func runVaultUnarchive(cmd *cobra.Command, args []string) error {
+	return setVaultArchived(args[0], false)
+}
+
+func setVaultArchived(name string, archived bool) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	if !s.VaultExists(name) {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+
+	if archived {
+		if cfg, err := config.Load(s); err == nil && cfg.DefaultVault == name {
+			return fmt.Errorf("vault %q is the default vault (run 'shhh vault set-default' first)", name)
+		}
+	}
+
+	vault, err := config.LoadVault(s, name)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	if vault.Archived == archived {
+		if archived {
+			fmt.Printf("Vault %q is already archived\n", name)
+		} else {
+			fmt.Printf("Vault %q is not archived\n", name)
+		}
+		return nil
+	}
+
+	vault.Archived = archived
+	if err := vault.Save(s, name); err != nil {
+		return fmt.Errorf("failed to save vault: %w", err)
+	}
+
+	if archived {
+		fmt.Printf("Archived vault %q (its files can still be decrypted)\n", name)
+	} else {
+		fmt.Printf("Unarchived vault %q\n", name)
+	}
+	return nil
+}
+
+func runVaultTemplateSet(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	name, pattern := args[0], args[1]
+	if !s.VaultExists(name) {
+		return fmt.Errorf("vault %q does not exist", name)
+	}
+	if err := config.SetRecipientTemplate(s, name, pattern, args[2:]); err != nil {
+		return err
+	}
+
+	vault, err := config.LoadVault(s, name)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	t := vault.RecipientTemplate(pattern)
+	fmt.Printf("Files matching %s in vault %s are restricted to: %s\n", pattern, name, strings.Join(t.Recipients, ", "))
+
+	for _, f := range vault.Files {
+		if vault.TemplateFor(f.Path) != t {
+			continue
+		}
+		recipients, err := config.GetEffectiveRecipients(s, name, &f)
+		if err != nil {
+			continue
+		}
+		if err := t.Check(f.Path, recipients); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+	return nil
+}
+
+func runVaultTemplateRemove(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	if !s.VaultExists(args[0]) {
+		return fmt.Errorf("vault %q does not exist", args[0])
+	}
+	if err := config.RemoveRecipientTemplate(s, args[0], args[1]); err != nil {
+		return err
+	}
+	fmt.Printf("Removed recipient template %s from vault %s\n", args[1], args[0])
+	return nil
+}
+
+func runVaultTemplateList(cmd *cobra.Command, args []string) error {
+	s, err := store.GetStore()
+	if err != nil {
+		return err
+	}
+
+	var vaults []string
+	if len(args) > 0 {
+		if !s.VaultExists(args[0]) {
+			return fmt.Errorf("vault %q does not exist", args[0])
+		}
+		vaults = args
+	} else if vaults, err = s.ListVaults(); err != nil {
+		return err
+	}
+
+	found := false
+	for _, name := range vaults {
+		vault, err := config.LoadVault(s, name)
+		if err != nil {
+			return fmt.Errorf("failed to load vault %s: %w", name, err)
+		}
+		for i := range vault.RecipientTemplates {
+			t := &vault.RecipientTemplates[i]
+			found = true
+			fmt.Printf("%s: %s -> %s\n", name, t.Pattern, strings.Join(t.Recipients, ", "))
+			for _, f := range vault.Files {
+				if vault.TemplateFor(f.Path) == t {
+					fmt.Printf("  %s\n", f.Path)
+				}
+			}
+		}
+	}
+	if !found {
+		fmt.Println("No recipient templates")
+	}
 	return nil
 }
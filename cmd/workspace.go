@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/store"
+	"github.com/spf13/cobra"
+)
+
+var workspaceJSON bool
+
+func init() {
+	rootCmd.AddCommand(workspaceCmd)
+	workspaceCmd.AddCommand(workspaceStatusCmd)
+
+	workspaceStatusCmd.Flags().BoolVar(&workspaceJSON, "json", false, "Output as JSON")
+}
+
+var workspaceCmd = &cobra.Command{
+	Use:   "workspace",
+	Short: "Work with several shhh projects in one repository",
+	Long: `A repository can hold several independent shhh projects, e.g.
+services/a/.shhh and services/b/.shhh. Commands act on the nearest project
+above the working directory; use --root to target another one.`,
+}
+
+var workspaceStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Summarize status across all shhh projects in the repository",
+	Long: `Find every shhh project in the repository (or below the working
+directory outside git) and summarize the state of their registered files.`,
+	RunE: runWorkspaceStatus,
+}
+
+type workspaceSummary struct {
+	Root      string `json:"root"`
+	Vaults    int    `json:"vaults"`
+	Files     int    `json:"files"`
+	Encrypted int    `json:"encrypted"`
+	Decrypted int    `json:"decrypted"`
+	Pending   int    `json:"pending"`
+	Missing   int    `json:"missing"`
+	Modified  int    `json:"modified"`
+	Stale     int    `json:"stale"`
+	Error     string `json:"error,omitempty"`
+}
+
+func runWorkspaceStatus(cmd *cobra.Command, args []string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	base := cwd
+	if top, err := git.TopLevel(cwd); err == nil {
+		base = top
+	}
+
+	roots, err := store.FindRoots(base)
+	if err != nil {
+		return err
+	}
+
+	if len(roots) == 0 {
+		fmt.Printf("No shhh projects found under %s\n", base)
+		return nil
+	}
+
+	var summaries []workspaceSummary
+	for _, root := range roots {
+		rel, err := filepath.Rel(base, root)
+		if err != nil {
+			rel = root
+		}
+		summaries = append(summaries, summarizeRoot(store.New(root), rel))
+	}
+
+	if workspaceJSON {
+		data, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("%-30s %6s %6s %9s %9s %7s %7s %8s %5s\n",
+		"ROOT", "VAULTS", "FILES", "ENCRYPTED", "DECRYPTED", "PENDING", "MISSING", "MODIFIED", "STALE")
+	for _, sum := range summaries {
+		if sum.Error != "" {
+			fmt.Printf("%-30s error: %s\n", sum.Root, sum.Error)
+			continue
+		}
+		fmt.Printf("%-30s %6d %6d %9d %9d %7d %7d %8d %5d\n",
+			sum.Root, sum.Vaults, sum.Files, sum.Encrypted, sum.Decrypted, sum.Pending, sum.Missing, sum.Modified, sum.Stale)
+	}
+
+	return nil
+}
+
+func summarizeRoot(s *store.Store, name string) workspaceSummary {
+	sum := workspaceSummary{Root: name}
+
+	vaults, err := s.ListVaults()
+	if err != nil {
+		sum.Error = err.Error()
+		return sum
+	}
+	sum.Vaults = len(vaults)
+
+	for _, e := range collectStatus(s, vaults).Files {
+		sum.Files++
+		switch e.State {
+		case "encrypted":
+			sum.Encrypted++
+		case "decrypted":
+			sum.Decrypted++
+		case "pending":
+			sum.Pending++
+		case "missing":
+			sum.Missing++
+		}
+		if e.Modified {
+			sum.Modified++
+		}
+		if e.Stale {
+			sum.Stale++
+		}
+	}
+
+	return sum
+}
@@ -4,7 +4,10 @@ go 1.25
 
 require (
 	github.com/ProtonMail/go-crypto v1.0.0
+	github.com/klauspost/compress v1.18.0
 	github.com/spf13/cobra v1.8.0
+	golang.org/x/sys v0.18.0
+	golang.org/x/term v0.18.0
 	gopkg.in/ini.v1 v1.67.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -15,5 +18,4 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.11.1 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
-	golang.org/x/sys v0.18.0 // indirect
 )
@@ -0,0 +1,201 @@
+// Package backup writes and verifies the native .gpg copies of registered
+// files, which can be decrypted with plain gpg when shhh is not available.
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/config"
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Suffix is appended to a registered path to name its backup.
+const Suffix = ".gpg"
+
+// MetaSuffix is appended to a backup path to name its metadata file.
+const MetaSuffix = ".meta"
+
+// Metadata describes a backup. It is written next to the backup when
+// backup_metadata is enabled.
+type Metadata struct {
+	Path       string    `yaml:"path"`
+	Vault      string    `yaml:"vault"`
+	Recipients []string  `yaml:"recipients"`
+	Armored    bool      `yaml:"armored"`
+	CreatedAt  time.Time `yaml:"created_at"`
+}
+
+// Path returns where the backup of a registered file is written: next to
+// the file, or under backup_dir mirroring the registered path.
+func Path(s *store.Store, cfg *config.Config, relPath string) string {
+	rel := filepath.FromSlash(relPath) + Suffix
+	if cfg.BackupDir == "" {
+		return filepath.Join(s.Root(), rel)
+	}
+	dir := cfg.BackupDir
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(s.Root(), dir)
+	}
+	return filepath.Join(dir, rel)
+}
+
+// Write encrypts plaintext for recipients with the active GPG provider and
+// writes the backup of relPath, plus its metadata when enabled. It returns
+// the backup path.
+func Write(s *store.Store, cfg *config.Config, vault, relPath string, plaintext []byte, recipients []string) (string, error) {
+	encrypted, err := crypto.GetProvider().Encrypt(plaintext, recipients)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt backup: %w", err)
+	}
+	if !cfg.BackupArmor && crypto.IsArmored(encrypted) {
+		if encrypted, err = crypto.DearmorMessage(encrypted); err != nil {
+			return "", err
+		}
+	}
+
+	path := Path(s, cfg, relPath)
+	if err := store.WriteFile(path, encrypted); err != nil {
+		return "", fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	metaPath := path + MetaSuffix
+	if !cfg.BackupMetadata {
+		os.Remove(metaPath)
+		return path, nil
+	}
+
+	sorted := append([]string{}, recipients...)
+	sort.Strings(sorted)
+	meta, err := yaml.Marshal(Metadata{
+		Path:       relPath,
+		Vault:      vault,
+		Recipients: sorted,
+		Armored:    cfg.BackupArmor,
+		CreatedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := store.WriteFile(metaPath, meta); err != nil {
+		return "", fmt.Errorf("failed to write backup metadata: %w", err)
+	}
+	return path, nil
+}
+
+// Result is the outcome of verifying one backup.
+type Result struct {
+	Path     string
+	Backup   string
+	Problems []string
+	// Warnings are checks that could not be completed, such as comparing
+	// the content without a private key.
+	Warnings []string
+}
+
+// OK reports whether the backup passed every check.
+func (r Result) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Verify checks the backup of a registered file against its .enc file: it
+// must exist, be in the configured format, decrypt to the same plaintext,
+// and, when metadata is present, list the file's current vault and
+// recipients.
+func Verify(s *store.Store, cfg *config.Config, vault string, fileReg *config.RegisteredFile) Result {
+	path := Path(s, cfg, fileReg.Path)
+	r := Result{Path: fileReg.Path, Backup: path}
+	if rel, err := filepath.Rel(s.Root(), path); err == nil {
+		r.Backup = rel
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			r.Problems = append(r.Problems, "backup is missing (run 'shhh backup')")
+		} else {
+			r.Problems = append(r.Problems, err.Error())
+		}
+		return r
+	}
+
+	armored := crypto.IsArmored(data)
+	if armored != cfg.BackupArmor {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("backup is %s but backup_armor is %v", describeFormat(armored), cfg.BackupArmor))
+	}
+
+	if recipients, err := config.GetEffectiveRecipients(s, vault, fileReg); err == nil {
+		checkMetadata(&r, path+MetaSuffix, vault, recipients)
+	}
+
+	encPath := filepath.Join(s.Root(), filepath.FromSlash(fileReg.Path)) + ".enc"
+	encContent, err := os.ReadFile(encPath)
+	if err != nil {
+		r.Warnings = append(r.Warnings, "no .enc file to compare with")
+		return r
+	}
+
+	if !armored {
+		if data, err = crypto.ArmorMessage(data); err != nil {
+			r.Problems = append(r.Problems, err.Error())
+			return r
+		}
+	}
+	backupPlain, err := crypto.GetProvider().Decrypt(data)
+	if err != nil {
+		if errors.Is(err, crypto.ErrNoPrivateKey) {
+			r.Warnings = append(r.Warnings, "content not compared (no private key)")
+		} else {
+			r.Problems = append(r.Problems, fmt.Sprintf("backup does not decrypt: %v", err))
+		}
+		return r
+	}
+
+	encPlain, err := crypto.DecryptFileContent(encContent, fileReg.Path, fileReg.Spec())
+	if err != nil {
+		r.Warnings = append(r.Warnings, fmt.Sprintf("content not compared (.enc does not decrypt: %v)", err))
+		return r
+	}
+	if !bytes.Equal(backupPlain, encPlain) {
+		r.Problems = append(r.Problems, "content differs from the .enc file (run 'shhh backup')")
+	}
+	return r
+}
+
+func checkMetadata(r *Result, metaPath, vault string, recipients []string) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return
+	}
+
+	var meta Metadata
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		r.Problems = append(r.Problems, fmt.Sprintf("invalid metadata: %v", err))
+		return
+	}
+
+	if meta.Vault != vault {
+		r.Problems = append(r.Problems, fmt.Sprintf("backup was made for vault %s, file is in %s", meta.Vault, vault))
+	}
+	want := append([]string{}, recipients...)
+	sort.Strings(want)
+	if strings.Join(meta.Recipients, ",") != strings.Join(want, ",") {
+		r.Problems = append(r.Problems, fmt.Sprintf("backup recipients %s differ from %s (run 'shhh backup')",
+			strings.Join(meta.Recipients, ", "), strings.Join(want, ", ")))
+	}
+}
+
+func describeFormat(armored bool) string {
+	if armored {
+		return "armored"
+	}
+	return "binary"
+}
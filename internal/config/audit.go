@@ -0,0 +1,74 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// RecordEncryption appends the exact time a file was encrypted to the
+// local audit log, which is kept out of git. It is used when encrypted_at
+// records less than the precise time in the file's metadata.
+func RecordEncryption(s *store.Store, relPath string, at time.Time) error {
+	// Projects from before .shhh/.gitignore get it the first time they
+	// encrypt; read paths such as decryption leave the tree alone.
+	if err := s.EnsureLocalIgnores(); err != nil {
+		return err
+	}
+	return appendAuditLog(s, at, "encrypt", relPath)
+}
+
+// RecordShare appends a share bundle made with 'shhh share' to the local
+// audit log: who it was for, and until when.
+func RecordShare(s *store.Store, relPath, to string, at, expiresAt time.Time) error {
+	return appendAuditLog(s, at, "share", relPath, to, expiresAt.UTC().Format(time.RFC3339))
+}
+
+// RecordDecryption appends to the local audit log which private keys or
+// subkeys decrypted a file, or "value" for ENC tokens decrypted on their
+// own, and the shhh command that did it.
+func RecordDecryption(s *store.Store, what string, keys []string, command string, at time.Time) error {
+	return appendAuditLog(s, at, "decrypt", what, strings.Join(keys, ", "), command)
+}
+
+func appendAuditLog(s *store.Store, at time.Time, action string, fields ...string) error {
+	f, err := os.OpenFile(s.AuditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, store.FilePerms)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer f.Close()
+
+	line := strings.Join(append([]string{at.UTC().Format(time.RFC3339Nano), action}, fields...), "\t")
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return nil
+}
+
+// LastEncryption returns when a file was last encrypted according to the
+// local audit log, or false when it has no entry.
+func LastEncryption(s *store.Store, relPath string) (time.Time, bool) {
+	f, err := os.Open(s.AuditLogPath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+
+	var last time.Time
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 || fields[1] != "encrypt" || fields[2] != relPath {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339Nano, fields[0]); err == nil {
+			last, found = at, true
+		}
+	}
+	return last, found
+}
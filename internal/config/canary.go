@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// Results of CheckVaultAccess.
+const (
+	// AccessGranted means the canary decrypts with a local private key.
+	AccessGranted = "granted"
+	// AccessDenied means no local private key can decrypt the canary: you
+	// are not, or no longer, a user of the vault.
+	AccessDenied = "denied"
+	// AccessUnknown means the vault has no canary yet.
+	AccessUnknown = "unknown"
+)
+
+// canaryPlaintext is what the canary of a vault decrypts to.
+func canaryPlaintext(vaultName string) string {
+	return "shhh-canary:" + vaultName
+}
+
+// RefreshCanary encrypts a new canary for the vault's current users: a
+// small known value that only vault users can decrypt, used to tell "you
+// have no access to this vault" apart from other decryption failures. A
+// vault without users gets no canary.
+func RefreshCanary(s *store.Store, vaultName string) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	vault.Canary = ""
+	if len(vault.Users) > 0 {
+		recipients := make([]string, len(vault.Users))
+		for i, u := range vault.Users {
+			recipients[i] = u.Email
+		}
+		canary, err := crypto.EncryptValue(canaryPlaintext(vaultName), recipients)
+		if err != nil {
+			// Save without a canary rather than keep one for old users.
+			vault.Save(s, vaultName)
+			return fmt.Errorf("failed to encrypt canary: %w", err)
+		}
+		vault.Canary = canary
+	}
+
+	return vault.Save(s, vaultName)
+}
+
+// CheckVaultAccess decrypts the vault's canary and reports whether the
+// local keyring has access to the vault. The error explains AccessDenied
+// and any failure that is not a missing key.
+func CheckVaultAccess(s *store.Store, vaultName string) (string, error) {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return "", fmt.Errorf("failed to load vault: %w", err)
+	}
+	if vault.Canary == "" {
+		return AccessUnknown, nil
+	}
+
+	plaintext, err := crypto.DecryptCanary(vault.Canary)
+	if errors.Is(err, crypto.ErrNoPrivateKey) {
+		return AccessDenied, err
+	}
+	if err != nil {
+		return "", fmt.Errorf("canary does not decrypt: %w", err)
+	}
+	if plaintext != canaryPlaintext(vaultName) {
+		return "", fmt.Errorf("canary belongs to another vault")
+	}
+	return AccessGranted, nil
+}
@@ -3,24 +3,131 @@ package config
 import (
 	"bytes"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/hooks"
 	"github.com/cychiuae/shhh/internal/store"
 	"gopkg.in/yaml.v3"
 )
 
-const CurrentVersion = "1"
+// CurrentVersion is the .shhh store format version written by this build.
+// See migrations for how older stores are upgraded.
+const CurrentVersion = "2"
+
+// Where shhh metadata is kept for values-mode files.
+const (
+	MetadataEmbedded = "embedded"
+	MetadataSidecar  = "sidecar"
+)
 
 type Config struct {
-	Version      string `yaml:"version"`
-	GPGCopy      bool   `yaml:"gpg_copy"`
-	DefaultVault string `yaml:"default_vault"`
+	Version        string `yaml:"version"`
+	GPGCopy        bool   `yaml:"gpg_copy"`
+	DefaultVault   string `yaml:"default_vault"`
+	GitMergeDriver bool   `yaml:"git_merge_driver"`
+	// PreservePermissions records the plaintext file mode on encrypt and
+	// restores it on decrypt instead of always writing 0600.
+	PreservePermissions bool `yaml:"preserve_permissions"`
+	// DedupeValues encrypts repeated values within a file only once. Equal
+	// values then share a ciphertext, revealing that they are equal.
+	DedupeValues bool `yaml:"dedupe_values"`
+	// VerifyEncrypt checks each freshly encrypted file round-trips to the
+	// original plaintext before it is written.
+	VerifyEncrypt bool `yaml:"verify_encrypt"`
+	// MetadataPrivacy controls how vault names and recipients appear in
+	// encrypted files: "none", "hash" or "omit".
+	MetadataPrivacy string `yaml:"metadata_privacy"`
+	// Metadata is MetadataEmbedded to inject the _shhh block into values-mode
+	// files, or MetadataSidecar to write it to <file>.enc.meta instead.
+	Metadata string `yaml:"metadata"`
+	// RotationDays is how old an encryption may get before reports flag the
+	// file as due for rotation. Zero disables the check.
+	RotationDays int `yaml:"rotation_days"`
+	// ExpiryWarningDays is how close to expiry a user's key must be to be
+	// reported as expiring soon. Vaults can override it. Zero disables the
+	// warning.
+	ExpiryWarningDays int `yaml:"expiry_warning_days"`
+	// GnuPGHome points shhh at a dedicated keyring. Relative paths are
+	// resolved against the project root. The keyring's gpg.conf can name
+	// programs to run, so it is a command setting, only used once trusted.
+	GnuPGHome string `yaml:"gnupg_home,omitempty"`
+	// GPGBinary, GPGArgs and GPGTrustModel configure the gpg CLI provider.
+	// GPGArgs is split on whitespace; an empty GPGTrustModel leaves the
+	// trust model to gpg's own configuration. GPGBinary and GPGArgs are
+	// command settings, only used once trusted.
+	GPGBinary     string `yaml:"gpg_binary,omitempty"`
+	GPGArgs       string `yaml:"gpg_args,omitempty"`
+	GPGTrustModel string `yaml:"gpg_trust_model,omitempty"`
+	// Provider is "auto", "native" or "cli".
+	Provider string `yaml:"provider"`
+	// ProviderFallback lets auto mode retry with the gpg CLI each operation
+	// the native provider fails. Without it, auto mode uses one of them for
+	// the whole run, so behaviour does not depend on which operations fail.
+	ProviderFallback bool `yaml:"provider_fallback"`
+	// BackupDir holds the .gpg backups written for files with gpg_copy,
+	// mirroring their registered paths. Empty writes each backup next to
+	// its file; relative paths are resolved against the project root.
+	BackupDir string `yaml:"backup_dir,omitempty"`
+	// BackupArmor writes ASCII-armored backups instead of binary OpenPGP.
+	BackupArmor bool `yaml:"backup_armor"`
+	// BackupMetadata writes <backup>.meta with the vault, recipients and
+	// time of each backup, which 'shhh backup verify' checks.
+	BackupMetadata bool `yaml:"backup_metadata"`
+	// Readonly is "true" to refuse writing plaintext into the working tree,
+	// "ci" to do so only when running in CI, or "false".
+	Readonly string `yaml:"readonly,omitempty"`
+	// ConfirmDecrypt asks for approval in each terminal session before the
+	// first file of a vault is decrypted. CI is not asked.
+	ConfirmDecrypt bool `yaml:"confirm_decrypt,omitempty"`
+	// StoreGit is whether git must track .shhh (StoreGitTracked) or
+	// ignore it (StoreGitIgnored), which 'shhh status' checks. Empty
+	// checks neither.
+	StoreGit string `yaml:"store_git,omitempty"`
+	// MemoryHardening locks decrypted buffers into RAM and disables core
+	// dumps, where the platform supports it.
+	MemoryHardening bool `yaml:"memory_hardening,omitempty"`
+	// EditTmpfile is where 'shhh edit' puts the plaintext: EditTmpfileAuto
+	// (default), EditTmpfileDir or EditTmpfileMemfd.
+	EditTmpfile string `yaml:"edit_tmpfile,omitempty"`
+	// ValueKeyIDs records the recipients' key IDs in each ENC value, so
+	// 'shhh file show --values' can list them without decrypting. Older
+	// shhh releases cannot read annotated values.
+	ValueKeyIDs bool `yaml:"value_key_ids,omitempty"`
+	// EncryptedAt is how the time of encryption is recorded in metadata:
+	// crypto.TimestampPrecise (default), TimestampDay, TimestampOmit or
+	// TimestampCounter. Other than precise, exact times are kept in the
+	// local audit log instead.
+	EncryptedAt string `yaml:"encrypted_at,omitempty"`
+	// Compression compresses full-mode plaintext before encryption:
+	// crypto.CompressionNone (default) or CompressionZstd. Older shhh
+	// releases cannot decrypt compressed files.
+	Compression string `yaml:"compression,omitempty"`
+	// Editor is the command 'shhh edit' opens files with, instead of
+	// $VISUAL or $EDITOR. See EditorFor for the command syntax. Editor and
+	// Editors are command settings, only used once trusted.
+	Editor string `yaml:"editor,omitempty"`
+	// Editors overrides Editor by file type: a format name such as "yaml"
+	// or "env", or the extension of other files, e.g. "sql".
+	Editors map[string]string `yaml:"editors,omitempty"`
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Version:      CurrentVersion,
-		GPGCopy:      false,
-		DefaultVault: store.DefaultVault,
+		Version:           CurrentVersion,
+		GPGCopy:           false,
+		DefaultVault:      store.DefaultVault,
+		VerifyEncrypt:     true,
+		MetadataPrivacy:   "none",
+		Metadata:          MetadataEmbedded,
+		RotationDays:      90,
+		ExpiryWarningDays: 30,
+		Provider:          "auto",
+		ProviderFallback:  true,
+		BackupArmor:       true,
 	}
 }
 
@@ -33,12 +140,16 @@ func Load(s *store.Store) (*Config, error) {
 		return nil, err
 	}
 
-	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	// Start from the defaults so keys missing from older configs keep them.
+	cfg := NewConfig()
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(cfg.Version); err != nil {
 		return nil, err
 	}
 
-	return &cfg, nil
+	return cfg, nil
 }
 
 func (c *Config) Save(s *store.Store) error {
@@ -63,7 +174,62 @@ func (c *Config) Get(key string) (string, bool) {
 		return "false", true
 	case "default_vault":
 		return c.DefaultVault, true
+	case "git_merge_driver":
+		return formatBool(c.GitMergeDriver), true
+	case "preserve_permissions":
+		return formatBool(c.PreservePermissions), true
+	case "dedupe_values":
+		return formatBool(c.DedupeValues), true
+	case "verify_encrypt":
+		return formatBool(c.VerifyEncrypt), true
+	case "metadata_privacy":
+		return c.MetadataPrivacy, true
+	case "metadata":
+		return c.Metadata, true
+	case "rotation_days":
+		return strconv.Itoa(c.RotationDays), true
+	case "expiry_warning_days":
+		return strconv.Itoa(c.ExpiryWarningDays), true
+	case "gnupg_home":
+		return c.GnuPGHome, true
+	case "gpg_binary":
+		return c.GPGBinary, true
+	case "gpg_args":
+		return c.GPGArgs, true
+	case "gpg_trust_model":
+		return c.GPGTrustModel, true
+	case "provider":
+		return c.Provider, true
+	case "provider_fallback":
+		return formatBool(c.ProviderFallback), true
+	case "backup_dir":
+		return c.BackupDir, true
+	case "backup_armor":
+		return formatBool(c.BackupArmor), true
+	case "backup_metadata":
+		return formatBool(c.BackupMetadata), true
+	case "readonly":
+		return c.readonlyValue(), true
+	case "confirm_decrypt":
+		return formatBool(c.ConfirmDecrypt), true
+	case "store_git":
+		return c.StoreGitValue(), true
+	case "memory_hardening":
+		return formatBool(c.MemoryHardening), true
+	case "value_key_ids":
+		return formatBool(c.ValueKeyIDs), true
+	case "edit_tmpfile":
+		return c.editTmpfileValue(), true
+	case "encrypted_at":
+		return c.EncryptedAtValue(), true
+	case "compression":
+		return c.CompressionValue(), true
+	case "editor":
+		return c.Editor, true
 	default:
+		if fileType, ok := strings.CutPrefix(key, EditorKeyPrefix); ok && fileType != "" {
+			return c.Editors[fileType], true
+		}
 		return "", false
 	}
 }
@@ -76,7 +242,125 @@ func (c *Config) Set(key, value string) bool {
 	case "default_vault":
 		c.DefaultVault = value
 		return true
+	case "git_merge_driver":
+		c.GitMergeDriver = parseBool(value)
+		return true
+	case "preserve_permissions":
+		c.PreservePermissions = parseBool(value)
+		return true
+	case "dedupe_values":
+		c.DedupeValues = parseBool(value)
+		return true
+	case "verify_encrypt":
+		c.VerifyEncrypt = parseBool(value)
+		return true
+	case "metadata_privacy":
+		c.MetadataPrivacy = value
+		return true
+	case "metadata":
+		c.Metadata = value
+		return true
+	case "rotation_days":
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return false
+		}
+		c.RotationDays = days
+		return true
+	case "expiry_warning_days":
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return false
+		}
+		c.ExpiryWarningDays = days
+		return true
+	case "gnupg_home":
+		c.GnuPGHome = value
+		return true
+	case "gpg_binary":
+		c.GPGBinary = value
+		return true
+	case "gpg_args":
+		c.GPGArgs = value
+		return true
+	case "gpg_trust_model":
+		c.GPGTrustModel = value
+		return true
+	case "provider":
+		c.Provider = value
+		return true
+	case "provider_fallback":
+		c.ProviderFallback = parseBool(value)
+		return true
+	case "backup_dir":
+		c.BackupDir = value
+		return true
+	case "backup_armor":
+		c.BackupArmor = parseBool(value)
+		return true
+	case "backup_metadata":
+		c.BackupMetadata = parseBool(value)
+		return true
+	case "readonly":
+		switch {
+		case value == ReadonlyCI:
+			c.Readonly = ReadonlyCI
+		case parseBool(value):
+			c.Readonly = "true"
+		case value == "false" || value == "0" || value == "no":
+			c.Readonly = ""
+		default:
+			return false
+		}
+		return true
+	case "confirm_decrypt":
+		c.ConfirmDecrypt = parseBool(value)
+		return true
+	case "store_git":
+		if value == StoreGitAny {
+			value = ""
+		}
+		c.StoreGit = value
+		return true
+	case "memory_hardening":
+		c.MemoryHardening = parseBool(value)
+		return true
+	case "value_key_ids":
+		c.ValueKeyIDs = parseBool(value)
+		return true
+	case "edit_tmpfile":
+		if value == EditTmpfileAuto {
+			value = ""
+		}
+		c.EditTmpfile = value
+		return true
+	case "encrypted_at":
+		if value == crypto.TimestampPrecise {
+			value = ""
+		}
+		c.EncryptedAt = value
+		return true
+	case "compression":
+		if value == crypto.CompressionNone {
+			value = ""
+		}
+		c.Compression = value
+		return true
+	case "editor":
+		c.Editor = value
+		return true
 	default:
+		if fileType, ok := strings.CutPrefix(key, EditorKeyPrefix); ok && fileType != "" {
+			if value == "" {
+				delete(c.Editors, fileType)
+				return true
+			}
+			if c.Editors == nil {
+				c.Editors = make(map[string]string)
+			}
+			c.Editors[fileType] = value
+			return true
+		}
 		return false
 	}
 }
@@ -86,9 +370,244 @@ func (c *Config) List() map[string]string {
 	if c.GPGCopy {
 		gpgCopy = "true"
 	}
-	return map[string]string{
-		"version":       c.Version,
-		"gpg_copy":      gpgCopy,
-		"default_vault": c.DefaultVault,
+	values := map[string]string{
+		"version":              c.Version,
+		"gpg_copy":             gpgCopy,
+		"default_vault":        c.DefaultVault,
+		"git_merge_driver":     formatBool(c.GitMergeDriver),
+		"preserve_permissions": formatBool(c.PreservePermissions),
+		"dedupe_values":        formatBool(c.DedupeValues),
+		"verify_encrypt":       formatBool(c.VerifyEncrypt),
+		"metadata_privacy":     c.MetadataPrivacy,
+		"metadata":             c.Metadata,
+		"rotation_days":        strconv.Itoa(c.RotationDays),
+		"expiry_warning_days":  strconv.Itoa(c.ExpiryWarningDays),
+		"gnupg_home":           c.GnuPGHome,
+		"gpg_binary":           c.GPGBinary,
+		"gpg_args":             c.GPGArgs,
+		"gpg_trust_model":      c.GPGTrustModel,
+		"provider":             c.Provider,
+		"provider_fallback":    formatBool(c.ProviderFallback),
+		"backup_dir":           c.BackupDir,
+		"backup_armor":         formatBool(c.BackupArmor),
+		"backup_metadata":      formatBool(c.BackupMetadata),
+		"readonly":             c.readonlyValue(),
+		"confirm_decrypt":      formatBool(c.ConfirmDecrypt),
+		"store_git":            c.StoreGitValue(),
+		"memory_hardening":     formatBool(c.MemoryHardening),
+		"edit_tmpfile":         c.editTmpfileValue(),
+		"value_key_ids":        formatBool(c.ValueKeyIDs),
+		"encrypted_at":         c.EncryptedAtValue(),
+		"compression":          c.CompressionValue(),
+		"editor":               c.Editor,
+	}
+	for fileType, editor := range c.Editors {
+		values[EditorKeyPrefix+fileType] = editor
+	}
+	return values
+}
+
+func (c *Config) readonlyValue() string {
+	if c.Readonly == "" {
+		return "false"
+	}
+	return c.Readonly
+}
+
+// PreservePermissions reports whether the project records and restores
+// plaintext file modes.
+func PreservePermissions(s *store.Store) bool {
+	cfg, err := Load(s)
+	if err != nil {
+		return false
+	}
+	return cfg.PreservePermissions
+}
+
+func (c *Config) editTmpfileValue() string {
+	if c.EditTmpfile == "" {
+		return EditTmpfileAuto
+	}
+	return c.EditTmpfile
+}
+
+// EncryptedAtValue returns the encrypted_at setting, defaulting to
+// crypto.TimestampPrecise.
+func (c *Config) EncryptedAtValue() string {
+	if c.EncryptedAt == "" {
+		return crypto.TimestampPrecise
+	}
+	return c.EncryptedAt
+}
+
+// CompressionValue returns the compression setting, defaulting to
+// crypto.CompressionNone.
+func (c *Config) CompressionValue() string {
+	if c.Compression == "" {
+		return crypto.CompressionNone
+	}
+	return c.Compression
+}
+
+// EditorKeyPrefix starts the config keys of per file type editors, e.g.
+// editor.yaml.
+const EditorKeyPrefix = "editor."
+
+// EditorFor returns the configured editor command for a file type, or ""
+// to fall back to $VISUAL and $EDITOR. The command is split on whitespace;
+// "{file}" in it is replaced by the path to edit, which is appended when
+// there is none. A command starting with "|" is a filter, such as vipe,
+// that reads the file on stdin and writes the edited file to stdout.
+func (c *Config) EditorFor(fileType string) string {
+	return c.EditorSetting(fileType).Value
+}
+
+// EditorSetting is EditorFor with the config key the editor is set by.
+func (c *Config) EditorSetting(fileType string) CommandSetting {
+	if editor := c.Editors[fileType]; editor != "" {
+		return CommandSetting{EditorKeyPrefix + fileType, editor}
+	}
+	return CommandSetting{"editor", c.Editor}
+}
+
+// Values of edit_tmpfile.
+const (
+	// EditTmpfileAuto uses EditTmpfileMemfd where memfd_create works and
+	// the editor is not one known to save by renaming, and EditTmpfileDir
+	// otherwise.
+	EditTmpfileAuto = "auto"
+	// EditTmpfileDir writes the plaintext to a file in a private temp
+	// directory, overwritten and removed after editing.
+	EditTmpfileDir = "dir"
+	// EditTmpfileMemfd keeps the plaintext in an anonymous in-memory file
+	// (Linux memfd_create) that never has a directory entry.
+	EditTmpfileMemfd = "memfd"
+)
+
+// EditTmpfile returns the edit_tmpfile setting.
+func EditTmpfile(s *store.Store) string {
+	cfg, err := Load(s)
+	if err != nil {
+		return EditTmpfileAuto
+	}
+	return cfg.editTmpfileValue()
+}
+
+// store_git settings: whether git must track the .shhh directory, ignore
+// it, or either.
+const (
+	StoreGitTracked = "tracked"
+	StoreGitIgnored = "ignored"
+	StoreGitAny     = "any"
+)
+
+// StoreGitValue returns the store_git setting, defaulting to StoreGitAny.
+func (c *Config) StoreGitValue() string {
+	if c.StoreGit == "" {
+		return StoreGitAny
+	}
+	return c.StoreGit
+}
+
+// ReadonlyCI is the readonly setting that applies only when running in CI.
+const ReadonlyCI = "ci"
+
+// ReadonlyEnv forces readonly mode when set to a non-empty value, e.g. for
+// a shared checkout without changing the project config.
+const ReadonlyEnv = "SHHH_READONLY"
+
+// IsReadonly reports whether commands must not write plaintext into the
+// working tree: readonly is "true", or "ci" and the CI environment variable
+// is set, or SHHH_READONLY is set.
+func IsReadonly(s *store.Store) bool {
+	if os.Getenv(ReadonlyEnv) != "" {
+		return true
+	}
+	cfg, err := Load(s)
+	if err != nil {
+		return false
+	}
+	switch cfg.Readonly {
+	case "true":
+		return true
+	case ReadonlyCI:
+		return InCI()
+	}
+	return false
+}
+
+// InCI reports whether shhh runs in CI, according to the CI environment
+// variable most CI systems set.
+func InCI() bool {
+	ci := os.Getenv("CI")
+	return ci != "" && ci != "false" && ci != "0"
+}
+
+// Settings that make shhh run a program come from the committed
+// config.yaml, which anyone who can push to the repository can change.
+// Like hook commands, they are only used on a machine once its user has
+// trusted them with 'shhh hooks trust'; see hooks.Trusted.
+
+// CommandSetting is a config setting that is only used once trusted.
+type CommandSetting struct {
+	Key   string
+	Value string
+}
+
+// String is how the setting is shown and trusted, e.g. "gpg_binary: gpg2".
+func (cs CommandSetting) String() string {
+	return cs.Key + ": " + cs.Value
+}
+
+// CommandSettings lists the command settings the config sets.
+func (c *Config) CommandSettings() []CommandSetting {
+	var settings []CommandSetting
+	for _, cs := range []CommandSetting{
+		{"gnupg_home", c.GnuPGHome},
+		{"gpg_binary", c.GPGBinary},
+		{"gpg_args", c.GPGArgs},
+		{"editor", c.Editor},
+	} {
+		if cs.Value != "" {
+			settings = append(settings, cs)
+		}
+	}
+
+	fileTypes := make([]string, 0, len(c.Editors))
+	for fileType, editor := range c.Editors {
+		if editor != "" {
+			fileTypes = append(fileTypes, fileType)
+		}
+	}
+	sort.Strings(fileTypes)
+	for _, fileType := range fileTypes {
+		settings = append(settings, CommandSetting{EditorKeyPrefix + fileType, c.Editors[fileType]})
+	}
+	return settings
+}
+
+// SettingTrusted reports whether a command setting may be used in the
+// project at s: it is unset, or was trusted on this machine.
+func SettingTrusted(s *store.Store, key, value string) bool {
+	return value == "" || hooks.Trusted(s.Root(), CommandSetting{key, value}.String())
+}
+
+// GnuPGHomePath returns the absolute keyring directory configured with
+// gnupg_home, or "" when the project uses the default keyring.
+func (c *Config) GnuPGHomePath(s *store.Store) string {
+	if c.GnuPGHome == "" || filepath.IsAbs(c.GnuPGHome) {
+		return c.GnuPGHome
+	}
+	return filepath.Join(s.Root(), c.GnuPGHome)
+}
+
+func parseBool(value string) bool {
+	return value == "true" || value == "1" || value == "yes"
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "true"
 	}
+	return "false"
 }
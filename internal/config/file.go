@@ -2,10 +2,13 @@ package config
 
 import (
 	"fmt"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
 	"github.com/cychiuae/shhh/internal/store"
 )
 
@@ -14,13 +17,22 @@ const (
 	ModeFull   = "full"
 )
 
+// NormalizePath converts a registered path to the stored form: forward
+// slashes regardless of the OS it was registered on. Paths are translated
+// back to OS separators by filepath.Join/FromSlash when accessing files.
+func NormalizePath(p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	return path.Clean(p)
+}
+
 func ValidateFilePath(path string) error {
 	if path == "" {
 		return fmt.Errorf("path cannot be empty")
 	}
 
-	cleaned := filepath.Clean(path)
-	if filepath.IsAbs(cleaned) {
+	cleaned := NormalizePath(path)
+	if filepath.IsAbs(path) || strings.HasPrefix(cleaned, "/") || filepath.VolumeName(path) != "" ||
+		(len(cleaned) >= 2 && cleaned[1] == ':') {
 		return fmt.Errorf("path must be relative")
 	}
 
@@ -35,12 +47,25 @@ func ValidateFilePath(path string) error {
 	return nil
 }
 
+// RegisterFile registers a file in a vault. Re-registering a file keeps its
+// existing settings: only a non-empty mode and non-empty recipients replace
+// the registered ones. An empty mode registers new files in values mode.
 func RegisterFile(s *store.Store, vaultName, path string, mode string, recipients []string) error {
+	return registerFile(s, vaultName, path, mode, recipients, false)
+}
+
+// ReplaceFileRegistration registers a file like RegisterFile, but resets
+// every setting of an existing registration.
+func ReplaceFileRegistration(s *store.Store, vaultName, path string, mode string, recipients []string) error {
+	return registerFile(s, vaultName, path, mode, recipients, true)
+}
+
+func registerFile(s *store.Store, vaultName, path string, mode string, recipients []string, replace bool) error {
 	if err := ValidateFilePath(path); err != nil {
 		return err
 	}
 
-	if mode != ModeValues && mode != ModeFull {
+	if mode != "" && mode != ModeValues && mode != ModeFull {
 		return fmt.Errorf("invalid mode: %s (must be 'values' or 'full')", mode)
 	}
 
@@ -48,6 +73,20 @@ func RegisterFile(s *store.Store, vaultName, path string, mode string, recipient
 	if err != nil {
 		return fmt.Errorf("failed to load vault: %w", err)
 	}
+	if err := vault.checkWritable(vaultName); err != nil {
+		return err
+	}
+
+	// A path registered in two vaults would make FindFileVault ambiguous.
+	others, err := FileVaults(s, path)
+	if err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other != vaultName {
+			return fmt.Errorf("file %s is already registered in vault %s (use 'shhh file move' to change its vault)", NormalizePath(path), other)
+		}
+	}
 
 	for _, r := range recipients {
 		if !vault.HasUser(r) {
@@ -56,12 +95,27 @@ func RegisterFile(s *store.Store, vaultName, path string, mode string, recipient
 	}
 
 	file := RegisteredFile{
-		Path:         path,
-		Mode:         mode,
+		Path:         NormalizePath(path),
+		Mode:         ModeValues,
 		GPGCopy:      nil, // nil means inherit from global config
-		Recipients:   recipients,
 		RegisteredAt: time.Now(),
 	}
+	if existing := vault.GetFile(path); existing != nil && !replace {
+		file = *existing
+	}
+	if mode != "" {
+		file.Mode = mode
+	}
+	if len(recipients) > 0 || replace {
+		file.Recipients = recipients
+	}
+	if t := vault.TemplateFor(file.Path); t != nil {
+		if len(file.Recipients) == 0 {
+			file.Recipients = append([]string{}, t.Recipients...)
+		} else if err := t.Check(file.Path, file.Recipients); err != nil {
+			return err
+		}
+	}
 
 	vault.RegisterFile(file)
 
@@ -89,12 +143,35 @@ func UnregisterFile(s *store.Store, vaultName, path string) error {
 	return nil
 }
 
+// FileVaults returns every vault the path is registered in. A path should
+// be registered in at most one; see MoveFile to resolve duplicates.
+func FileVaults(s *store.Store, path string) ([]string, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	for _, vaultName := range vaults {
+		vault, err := LoadVault(s, vaultName)
+		if err != nil {
+			continue
+		}
+		if vault.HasFile(path) {
+			found = append(found, vaultName)
+		}
+	}
+	return found, nil
+}
+
 func FindFileVault(s *store.Store, path string) (string, *RegisteredFile, error) {
 	vaults, err := s.ListVaults()
 	if err != nil {
 		return "", nil, err
 	}
 
+	var foundVaults []string
+	var found *RegisteredFile
 	for _, vaultName := range vaults {
 		vault, err := LoadVault(s, vaultName)
 		if err != nil {
@@ -102,11 +179,95 @@ func FindFileVault(s *store.Store, path string) (string, *RegisteredFile, error)
 		}
 
 		if f := vault.GetFile(path); f != nil {
-			return vaultName, f, nil
+			foundVaults = append(foundVaults, vaultName)
+			found = f
 		}
 	}
 
-	return "", nil, fmt.Errorf("file %s not registered in any vault", path)
+	switch len(foundVaults) {
+	case 0:
+		return "", nil, fmt.Errorf("file %s not registered in any vault", path)
+	case 1:
+		return foundVaults[0], found, nil
+	default:
+		return "", nil, fmt.Errorf("file %s is registered in several vaults (%s); keep one with 'shhh file move %s <vault>'",
+			path, strings.Join(foundVaults, ", "), NormalizePath(path))
+	}
+}
+
+// MoveFile moves a file's registration, with its settings, to toVault and
+// removes it from every other vault. When the file is registered in several
+// vaults, fromVault selects the entry to keep; it may be empty if the file
+// is registered only once or toVault already has an entry.
+func MoveFile(s *store.Store, path, fromVault, toVault string) error {
+	if !s.VaultExists(toVault) {
+		return fmt.Errorf("vault %q does not exist", toVault)
+	}
+
+	vaults, err := FileVaults(s, path)
+	if err != nil {
+		return err
+	}
+	if len(vaults) == 0 {
+		return fmt.Errorf("file %s not registered in any vault", path)
+	}
+
+	source := fromVault
+	if source == "" {
+		switch {
+		case len(vaults) == 1:
+			source = vaults[0]
+		case containsVault(vaults, toVault):
+			source = toVault
+		default:
+			return fmt.Errorf("file %s is registered in %s; choose the registration to keep with --from", path, strings.Join(vaults, ", "))
+		}
+	} else if !containsVault(vaults, source) {
+		return fmt.Errorf("file %s not registered in vault %s", path, source)
+	}
+
+	src, err := LoadVault(s, source)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	file := *src.GetFile(path)
+
+	dst, err := LoadVault(s, toVault)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	if err := dst.checkWritable(toVault); err != nil {
+		return err
+	}
+	for _, r := range file.Recipients {
+		if !dst.HasUser(r) {
+			return fmt.Errorf("recipient %s is not a user in vault %s", r, toVault)
+		}
+	}
+
+	dst.RegisterFile(file)
+	if err := dst.Save(s, toVault); err != nil {
+		return fmt.Errorf("failed to save vault: %w", err)
+	}
+
+	for _, vaultName := range vaults {
+		if vaultName == toVault {
+			continue
+		}
+		if err := UnregisterFile(s, vaultName, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func containsVault(vaults []string, name string) bool {
+	for _, v := range vaults {
+		if v == name {
+			return true
+		}
+	}
+	return false
 }
 
 func GetEffectiveRecipients(s *store.Store, vaultName string, file *RegisteredFile) ([]string, error) {
@@ -192,6 +353,66 @@ func SetFileGPGCopy(s *store.Store, vaultName, path string, gpgCopy bool) error
 	return vault.Save(s, vaultName)
 }
 
+func SetFileExample(s *store.Store, vaultName, path string, example bool) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	if !vault.UpdateFile(path, func(f *RegisteredFile) {
+		f.Example = example
+	}) {
+		return fmt.Errorf("file %s not registered in vault %s", path, vaultName)
+	}
+
+	return vault.Save(s, vaultName)
+}
+
+// SetFileHook sets the command run at a hook stage for a file; an empty
+// command removes the hook.
+func SetFileHook(s *store.Store, vaultName, path, stage, command string) error {
+	return updateFileHooks(s, vaultName, path, func(h *hooks.FileHooks) error {
+		return h.Set(stage, command)
+	})
+}
+
+// SetValidateHook sets a file's validate hook, which only warns on failure
+// with warn. An empty command removes it.
+func SetValidateHook(s *store.Store, vaultName, path, command string, warn bool) error {
+	return updateFileHooks(s, vaultName, path, func(h *hooks.FileHooks) error {
+		if err := h.Set(hooks.Validate, command); err != nil {
+			return err
+		}
+		h.ValidateWarn = warn && command != ""
+		return nil
+	})
+}
+
+func updateFileHooks(s *store.Store, vaultName, path string, update func(*hooks.FileHooks) error) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	var setErr error
+	if !vault.UpdateFile(path, func(f *RegisteredFile) {
+		if f.Hooks == nil {
+			f.Hooks = &hooks.FileHooks{}
+		}
+		setErr = update(f.Hooks)
+		if f.Hooks.IsZero() {
+			f.Hooks = nil
+		}
+	}) {
+		return fmt.Errorf("file %s not registered in vault %s", path, vaultName)
+	}
+	if setErr != nil {
+		return setErr
+	}
+
+	return vault.Save(s, vaultName)
+}
+
 func ClearFileGPGCopy(s *store.Store, vaultName, path string) error {
 	vault, err := LoadVault(s, vaultName)
 	if err != nil {
@@ -207,6 +428,81 @@ func ClearFileGPGCopy(s *store.Store, vaultName, path string) error {
 	return vault.Save(s, vaultName)
 }
 
+func SetFileObfuscateKeys(s *store.Store, vaultName, path string, obfuscate bool) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	if !vault.UpdateFile(path, func(f *RegisteredFile) {
+		f.ObfuscateKeys = obfuscate
+	}) {
+		return fmt.Errorf("file %s not registered in vault %s", path, vaultName)
+	}
+
+	return vault.Save(s, vaultName)
+}
+
+// SetFileSchema sets the JSON Schema (a path relative to the project root)
+// that decrypted content must satisfy. An empty schema clears it.
+func SetFileSchema(s *store.Store, vaultName, path, schema string) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	if schema != "" {
+		schema = NormalizePath(schema)
+	}
+
+	if !vault.UpdateFile(path, func(f *RegisteredFile) {
+		f.Schema = schema
+	}) {
+		return fmt.Errorf("file %s not registered in vault %s", path, vaultName)
+	}
+
+	return vault.Save(s, vaultName)
+}
+
+// SetFileFormat records the parser format for a file, overriding detection
+// by extension. An empty format restores detection.
+func SetFileFormat(s *store.Store, vaultName, path, format string) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	if !vault.UpdateFile(path, func(f *RegisteredFile) {
+		f.Format = format
+	}) {
+		return fmt.Errorf("file %s not registered in vault %s", path, vaultName)
+	}
+
+	return vault.Save(s, vaultName)
+}
+
+// SetFileLineOptions records ENV parser options (delimiter and comment
+// prefixes) for a file. Zero options restore standard .env syntax.
+func SetFileLineOptions(s *store.Store, vaultName, path string, opts parser.LineOptions) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	var stored *parser.LineOptions
+	if opts.Delimiter != "" || len(opts.Comments) > 0 {
+		stored = &opts
+	}
+
+	if !vault.UpdateFile(path, func(f *RegisteredFile) {
+		f.LineOptions = stored
+	}) {
+		return fmt.Errorf("file %s not registered in vault %s", path, vaultName)
+	}
+
+	return vault.Save(s, vaultName)
+}
+
 // GetEffectiveGPGCopy returns whether GPG copy should be created for a file.
 // Per-file setting overrides global; if not set, uses global config.
 func GetEffectiveGPGCopy(s *store.Store, file *RegisteredFile) bool {
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Issue is a problem found in the .shhh store by Fsck.
+type Issue struct {
+	// File is the store file concerned, relative to the project root.
+	File    string
+	Message string
+	// Warning marks issues that do not break shhh.
+	Warning bool
+	// Fixed is set when Fsck repaired the issue.
+	Fixed bool
+}
+
+// Fsck validates the project configuration and every vault file, and
+// repairs what it safely can when fix is set: missing vault files, path
+// spelling, duplicate entries within a vault, outdated full_fallback flags,
+// and missing public key caches.
+func Fsck(s *store.Store, fix bool) ([]Issue, error) {
+	var issues []Issue
+	rel := func(path string) string {
+		if r, err := filepath.Rel(s.Root(), path); err == nil {
+			return filepath.ToSlash(r)
+		}
+		return path
+	}
+	report := func(file string, warning bool, format string, a ...interface{}) *Issue {
+		issues = append(issues, Issue{File: rel(file), Message: fmt.Sprintf(format, a...), Warning: warning})
+		return &issues[len(issues)-1]
+	}
+
+	vaultNames, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := NewConfig()
+	if err := decodeStrict(s.ConfigPath(), cfg); err != nil {
+		report(s.ConfigPath(), false, "%v", err)
+	} else {
+		if err := checkVersion(cfg.Version); err != nil {
+			report(s.ConfigPath(), false, "%v", err)
+		} else if compareVersions(cfg.Version, CurrentVersion) < 0 {
+			report(s.ConfigPath(), true, "store format version %s is outdated (run 'shhh upgrade')", cfg.Version)
+		}
+		checkConfig(cfg, vaultNames, func(format string, a ...interface{}) {
+			report(s.ConfigPath(), false, format, a...)
+		})
+	}
+
+	// Paths registered per vault, to find registrations in several vaults.
+	owners := make(map[string][]string)
+	emails := make(map[string]bool)
+
+	for _, name := range vaultNames {
+		path := s.VaultConfigPath(name)
+
+		var v Vault
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			issue := report(path, false, "vault %s has no %s", name, store.VaultFile)
+			if fix {
+				issue.Fixed = NewVault().Save(s, name) == nil
+			}
+			continue
+		}
+		if err := decodeStrict(path, &v); err != nil {
+			report(path, false, "%v", err)
+			continue
+		}
+		if v.Version != "" {
+			if err := checkVersion(v.Version); err != nil {
+				report(path, false, "%v", err)
+				continue
+			}
+		}
+
+		changed := false
+		seenUsers := make(map[string]bool)
+		users := v.Users[:0]
+		for _, u := range v.Users {
+			key := strings.ToLower(u.Email)
+			if seenUsers[key] {
+				issue := report(path, false, "user %s is listed twice", u.Email)
+				if fix {
+					issue.Fixed, changed = true, true
+					continue
+				}
+			}
+			seenUsers[key] = true
+			users = append(users, u)
+			emails[key] = true
+
+			if err := ValidateEmail(u.Email); err != nil {
+				report(path, false, "user %q: %v", u.Email, err)
+			}
+			if u.Fingerprint == "" {
+				report(path, false, "user %s has no fingerprint", u.Email)
+			}
+			if crypto.IsExpired(u.ExpiresAt) {
+				report(path, true, "user %s has an expired key", u.Email)
+			}
+			if _, err := os.Stat(s.PubkeyPath(u.Email)); os.IsNotExist(err) {
+				issue := report(s.PubkeyPath(u.Email), false, "public key cache for %s is missing", u.Email)
+				if fix {
+					issue.Fixed = restorePubkey(s, u.Email) == nil
+				}
+			}
+		}
+		v.Users = users
+
+		seenFiles := make(map[string]bool)
+		files := v.Files[:0]
+		for _, f := range v.Files {
+			if err := ValidateFilePath(f.Path); err != nil {
+				report(path, false, "file %q: %v", f.Path, err)
+				files = append(files, f)
+				continue
+			}
+
+			if normalized := NormalizePath(f.Path); normalized != f.Path {
+				issue := report(path, true, "file %q should be stored as %q", f.Path, normalized)
+				if fix {
+					f.Path = normalized
+					issue.Fixed, changed = true, true
+				}
+			}
+
+			if seenFiles[NormalizePath(f.Path)] {
+				issue := report(path, false, "file %s is registered twice", f.Path)
+				if fix {
+					issue.Fixed, changed = true, true
+					continue
+				}
+			}
+			seenFiles[NormalizePath(f.Path)] = true
+			files = append(files, f)
+			owners[NormalizePath(f.Path)] = append(owners[NormalizePath(f.Path)], name)
+
+			if f.Mode != ModeValues && f.Mode != ModeFull {
+				report(path, false, "file %s has invalid mode %q", f.Path, f.Mode)
+			}
+			if f.Format != "" {
+				if _, err := parser.ParseFormat(f.Format); err != nil {
+					report(path, false, "file %s: %v", f.Path, err)
+				}
+			}
+			if f.FullFallback != f.FallsBackToFull() {
+				issue := report(path, true, "file %s has an outdated full_fallback (values mode encrypts unsupported formats whole)", f.Path)
+				if fix {
+					issue.Fixed, changed = true, true
+				}
+			}
+			plain := filepath.Join(s.Root(), filepath.FromSlash(f.Path))
+			if !exists(plain) && !exists(plain+".enc") {
+				report(path, true, "file %s has neither plaintext nor .enc (see 'shhh prune')", f.Path)
+			}
+			for _, r := range f.Recipients {
+				if !seenUsers[strings.ToLower(r)] {
+					report(path, true, "file %s lists recipient %s who is not a vault user", f.Path, r)
+				}
+			}
+		}
+		v.Files = files
+
+		if changed {
+			if err := v.Save(s, name); err != nil {
+				return issues, fmt.Errorf("failed to save vault %s: %w", name, err)
+			}
+		}
+	}
+
+	for path, vaults := range owners {
+		if len(vaults) > 1 {
+			report(s.VaultsPath(), false, "file %s is registered in several vaults: %s (keep one with 'shhh file move %s <vault>')", path, strings.Join(vaults, ", "), path)
+		}
+	}
+
+	if entries, err := os.ReadDir(s.PubkeysPath()); err == nil {
+		for _, e := range entries {
+			email := strings.TrimSuffix(e.Name(), ".asc")
+			if !e.IsDir() && email != e.Name() && !emails[strings.ToLower(email)] {
+				report(filepath.Join(s.PubkeysPath(), e.Name()), true, "public key for %s belongs to no vault user", email)
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+func checkConfig(cfg *Config, vaults []string, report func(string, ...interface{})) {
+	found := false
+	for _, v := range vaults {
+		found = found || v == cfg.DefaultVault
+	}
+	if !found {
+		report("default_vault %q does not exist", cfg.DefaultVault)
+	}
+	if cfg.Metadata != MetadataEmbedded && cfg.Metadata != MetadataSidecar {
+		report("invalid metadata %q", cfg.Metadata)
+	}
+	if p := cfg.MetadataPrivacy; p != crypto.PrivacyNone && p != crypto.PrivacyHash && p != crypto.PrivacyOmit {
+		report("invalid metadata_privacy %q", p)
+	}
+	if p := cfg.Provider; p != crypto.ProviderAuto && p != crypto.ProviderNative && p != crypto.ProviderCLI {
+		report("invalid provider %q", p)
+	}
+	if c := cfg.CompressionValue(); c != crypto.CompressionNone && c != crypto.CompressionZstd {
+		report("invalid compression %q", c)
+	}
+	if cfg.RotationDays < 0 {
+		report("rotation_days must not be negative")
+	}
+	if g := cfg.StoreGitValue(); g != StoreGitTracked && g != StoreGitIgnored && g != StoreGitAny {
+		report("invalid store_git %q", g)
+	}
+}
+
+// decodeStrict decodes a YAML store file, rejecting unknown keys and values
+// of the wrong type.
+func decodeStrict(path string, out interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(out); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid YAML: %w", err)
+	}
+	return nil
+}
+
+func restorePubkey(s *store.Store, email string) error {
+	key, err := crypto.GetProvider().GetPublicKey(email)
+	if err != nil {
+		return err
+	}
+	return cachePubkey(s, email, key)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// ReencryptJournal holds the files a bulk re-encryption finished, keyed by
+// vault and path, with a digest of the recipients they were encrypted to.
+type ReencryptJournal map[string]string
+
+// LoadReencryptJournal reads the re-encryption journal. It returns false
+// when there is none, i.e. no bulk re-encryption was interrupted.
+func LoadReencryptJournal(s *store.Store) (ReencryptJournal, bool, error) {
+	f, err := os.Open(s.ReencryptJournalPath())
+	if os.IsNotExist(err) {
+		return ReencryptJournal{}, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to open re-encryption journal: %w", err)
+	}
+	defer f.Close()
+
+	journal := ReencryptJournal{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		journal[journalKey(fields[0], fields[1])] = fields[2]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read re-encryption journal: %w", err)
+	}
+	return journal, true, nil
+}
+
+// Done reports whether a file was re-encrypted to the same recipients it
+// has now. A file whose recipients changed since must be re-encrypted again.
+func (j ReencryptJournal) Done(vault, path string, recipients []string) bool {
+	digest, ok := j[journalKey(vault, path)]
+	return ok && digest == recipientsDigest(recipients)
+}
+
+// RecordReencrypted appends a re-encrypted file to the journal, which is
+// kept out of git.
+func RecordReencrypted(s *store.Store, vault, path string, recipients []string) error {
+	journalPath := s.ReencryptJournalPath()
+	if err := s.EnsureLocalIgnores(); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, store.FilePerms)
+	if err != nil {
+		return fmt.Errorf("failed to open re-encryption journal: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%s\t%s\n", journalKey(vault, path), recipientsDigest(recipients)); err != nil {
+		return fmt.Errorf("failed to write re-encryption journal: %w", err)
+	}
+	return nil
+}
+
+// ClearReencryptJournal removes the journal once a bulk re-encryption has
+// no files left to retry.
+func ClearReencryptJournal(s *store.Store) error {
+	if err := os.Remove(s.ReencryptJournalPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove re-encryption journal: %w", err)
+	}
+	return nil
+}
+
+func journalKey(vault, path string) string {
+	return vault + "\t" + path
+}
+
+func recipientsDigest(recipients []string) string {
+	sorted := slices.Clone(recipients)
+	slices.Sort(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
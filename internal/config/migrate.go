@@ -0,0 +1,152 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/cychiuae/shhh/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Migration moves the .shhh store from one format version to the next.
+type Migration struct {
+	From        string
+	To          string
+	Description string
+	Apply       func(s *store.Store) error
+}
+
+// migrations are applied in order by Upgrade. A format change bumps
+// CurrentVersion and appends a step here, so repos created by older
+// releases can always be brought forward.
+var migrations = []Migration{
+	{
+		From:        "1",
+		To:          "2",
+		Description: "record the format version in each vault.yaml, store registered paths normalized, and write all config defaults explicitly",
+		Apply:       migrateV1ToV2,
+	},
+}
+
+// StoreVersion returns the format version recorded in config.yaml. Stores
+// without a config file or version key predate versioning and count as 1.
+func StoreVersion(s *store.Store) (string, error) {
+	data, err := os.ReadFile(s.ConfigPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "1", nil
+		}
+		return "", err
+	}
+
+	var raw struct {
+		Version string `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return "", err
+	}
+	if raw.Version == "" {
+		return "1", nil
+	}
+	return raw.Version, nil
+}
+
+// PendingMigrations returns the migrations needed to bring s to
+// CurrentVersion, or an error if the store was written by a newer shhh.
+func PendingMigrations(s *store.Store) ([]Migration, error) {
+	version, err := StoreVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(version); err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if compareVersions(m.From, version) >= 0 {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Upgrade applies the pending migrations in order, recording the new
+// version after each step so an interrupted upgrade can be resumed.
+func Upgrade(s *store.Store) ([]Migration, error) {
+	pending, err := PendingMigrations(s)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range pending {
+		if err := m.Apply(s); err != nil {
+			return pending[:i], fmt.Errorf("migration %s -> %s failed: %w", m.From, m.To, err)
+		}
+		if err := setStoreVersion(s, m.To); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// IsOutdated reports whether the store uses an older format than
+// CurrentVersion and needs 'shhh upgrade'.
+func IsOutdated(s *store.Store) bool {
+	version, err := StoreVersion(s)
+	return err == nil && compareVersions(version, CurrentVersion) < 0
+}
+
+func migrateV1ToV2(s *store.Store) error {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return err
+	}
+	for _, name := range vaults {
+		// LoadVault normalizes registered paths; saving persists them along
+		// with the vault's format version.
+		v, err := LoadVault(s, name)
+		if err != nil {
+			return fmt.Errorf("failed to load vault %s: %w", name, err)
+		}
+		v.Version = "2"
+		if err := v.Save(s, name); err != nil {
+			return fmt.Errorf("failed to save vault %s: %w", name, err)
+		}
+	}
+
+	// Loading fills keys missing from version 1 configs with their defaults.
+	cfg, err := Load(s)
+	if err != nil {
+		return err
+	}
+	return cfg.Save(s)
+}
+
+func setStoreVersion(s *store.Store, version string) error {
+	cfg, err := Load(s)
+	if err != nil {
+		return err
+	}
+	cfg.Version = version
+	return cfg.Save(s)
+}
+
+// checkVersion rejects format versions newer than this build understands,
+// rather than silently dropping data it does not know about.
+func checkVersion(version string) error {
+	if _, err := strconv.Atoi(version); err != nil {
+		return fmt.Errorf("invalid store format version %q", version)
+	}
+	if compareVersions(version, CurrentVersion) > 0 {
+		return fmt.Errorf("store format version %s is newer than this shhh supports (%s); upgrade shhh", version, CurrentVersion)
+	}
+	return nil
+}
+
+func compareVersions(a, b string) int {
+	x, _ := strconv.Atoi(a)
+	y, _ := strconv.Atoi(b)
+	return x - y
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// ReadRecipientsFile reads one email or fingerprint per line. Blank lines and
+// anything after a '#' are ignored; duplicates are dropped.
+func ReadRecipientsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recipients file: %w", err)
+	}
+	defer f.Close()
+
+	var recipients []string
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || seen[line] {
+			continue
+		}
+		seen[line] = true
+		recipients = append(recipients, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recipients file: %w", err)
+	}
+
+	return recipients, nil
+}
+
+// NormalizeFingerprint strips spaces and a "0x" prefix and upper-cases a
+// fingerprint or key ID for comparison.
+func NormalizeFingerprint(fingerprint string) string {
+	fp := strings.ReplaceAll(strings.TrimSpace(fingerprint), " ", "")
+	if strings.HasPrefix(fp, "0x") || strings.HasPrefix(fp, "0X") {
+		fp = fp[2:]
+	}
+	return strings.ToUpper(fp)
+}
+
+// ResolveRecipients maps emails and fingerprints to vault user emails.
+func (v *Vault) ResolveRecipients(vaultName string, ids []string) ([]string, error) {
+	var emails []string
+	seen := make(map[string]bool)
+
+	for _, id := range ids {
+		email := id
+		if !v.HasUser(id) {
+			user := v.GetUserByFingerprint(id)
+			if user == nil {
+				return nil, fmt.Errorf("recipient %s is not a user in vault %s", id, vaultName)
+			}
+			email = user.Email
+		}
+		if !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+
+	return emails, nil
+}
+
+// RecipientTemplate restricts the files at paths matching Pattern, a
+// gitignore-style pattern such as payments/, to Recipients. Files are given
+// the template's recipients when they are registered, and encrypting them
+// for anyone else fails.
+type RecipientTemplate struct {
+	Pattern    string   `yaml:"pattern"`
+	Recipients []string `yaml:"recipients"`
+}
+
+// Matches reports whether the template applies to path.
+func (t *RecipientTemplate) Matches(path string) bool {
+	matcher := gitignore.NewMatcher(gitignore.ParsePatterns([]string{t.Pattern}, ""))
+	return matcher.Ignored(NormalizePath(path))
+}
+
+// Check fails when recipients include anyone the template does not list.
+func (t *RecipientTemplate) Check(path string, recipients []string) error {
+	var outside []string
+	for _, r := range recipients {
+		if !slices.ContainsFunc(t.Recipients, func(allowed string) bool { return strings.EqualFold(allowed, r) }) {
+			outside = append(outside, r)
+		}
+	}
+	if len(outside) > 0 {
+		return fmt.Errorf("%s matches recipient template %s, which does not include %s (restrict it with 'shhh file set-recipients %s %s')",
+			NormalizePath(path), t.Pattern, strings.Join(outside, ", "), NormalizePath(path), strings.Join(t.Recipients, " "))
+	}
+	return nil
+}
+
+// RecipientTemplate returns the vault's recipient template for pattern, or
+// nil.
+func (v *Vault) RecipientTemplate(pattern string) *RecipientTemplate {
+	for i := range v.RecipientTemplates {
+		if v.RecipientTemplates[i].Pattern == pattern {
+			return &v.RecipientTemplates[i]
+		}
+	}
+	return nil
+}
+
+// TemplateFor returns the first of the vault's recipient templates that
+// matches path, or nil.
+func (v *Vault) TemplateFor(path string) *RecipientTemplate {
+	for i := range v.RecipientTemplates {
+		if v.RecipientTemplates[i].Matches(path) {
+			return &v.RecipientTemplates[i]
+		}
+	}
+	return nil
+}
+
+// SetRecipientTemplate adds a recipient template for pattern, or replaces
+// the recipients of an existing one. Recipients may be emails or
+// fingerprints of vault users.
+func SetRecipientTemplate(s *store.Store, vaultName, pattern string, recipients []string) error {
+	if strings.TrimSpace(pattern) == "" || strings.HasPrefix(pattern, "!") || strings.HasPrefix(pattern, "#") {
+		return fmt.Errorf("invalid pattern %q", pattern)
+	}
+
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	if err := vault.checkWritable(vaultName); err != nil {
+		return err
+	}
+	emails, err := vault.ResolveRecipients(vaultName, recipients)
+	if err != nil {
+		return err
+	}
+
+	if t := vault.RecipientTemplate(pattern); t != nil {
+		t.Recipients = emails
+	} else {
+		vault.RecipientTemplates = append(vault.RecipientTemplates, RecipientTemplate{Pattern: pattern, Recipients: emails})
+	}
+	return vault.Save(s, vaultName)
+}
+
+// RemoveRecipientTemplate removes the recipient template for pattern.
+func RemoveRecipientTemplate(s *store.Store, vaultName, pattern string) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	if err := vault.checkWritable(vaultName); err != nil {
+		return err
+	}
+
+	n := len(vault.RecipientTemplates)
+	vault.RecipientTemplates = slices.DeleteFunc(vault.RecipientTemplates, func(t RecipientTemplate) bool {
+		return t.Pattern == pattern
+	})
+	if len(vault.RecipientTemplates) == n {
+		return fmt.Errorf("vault %s has no recipient template %s", vaultName, pattern)
+	}
+	return vault.Save(s, vaultName)
+}
+
+// EncryptionRecipients returns the effective recipients to encrypt a file
+// for, checked against the vault's recipient templates.
+func EncryptionRecipients(s *store.Store, vaultName string, file *RegisteredFile) ([]string, error) {
+	recipients, err := GetEffectiveRecipients(s, vaultName, file)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return nil, err
+	}
+	if t := vault.TemplateFor(file.Path); t != nil {
+		if err := t.Check(file.Path, recipients); err != nil {
+			return nil, err
+		}
+	}
+	return recipients, nil
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// SettingsFormat identifies the layout of a file settings document.
+const SettingsFormat = "shhh-file-settings/v1"
+
+// FileSettings are the settings of one registered file, as they appear in a
+// settings document. Derived state such as registered_at and full_fallback
+// is left out.
+type FileSettings struct {
+	Path          string              `yaml:"path"`
+	Mode          string              `yaml:"mode"`
+	Recipients    []string            `yaml:"recipients,omitempty"`
+	GPGCopy       *bool               `yaml:"gpg_copy,omitempty"`
+	ObfuscateKeys bool                `yaml:"obfuscate_keys,omitempty"`
+	Format        string              `yaml:"format,omitempty"`
+	Schema        string              `yaml:"schema,omitempty"`
+	Example       bool                `yaml:"example,omitempty"`
+	Hooks         *hooks.FileHooks    `yaml:"hooks,omitempty"`
+	LineOptions   *parser.LineOptions `yaml:"line_options,omitempty"`
+}
+
+// SettingsDocument holds the settings of every registered file by vault, so
+// they can be reviewed and changed in one place.
+type SettingsDocument struct {
+	Format string                    `yaml:"format"`
+	Vaults map[string][]FileSettings `yaml:"vaults"`
+}
+
+// SettingsChange describes what applying a settings document does to one
+// file.
+type SettingsChange struct {
+	Vault string
+	Path  string
+	// Action is "register", "update", "move" (from the vault in From),
+	// "unregister", or "unlisted" for a registered file the document
+	// leaves out and that is kept.
+	Action  string
+	From    string
+	Details []string
+}
+
+func fileSettings(f *RegisteredFile) FileSettings {
+	fs := FileSettings{
+		Path:          f.Path,
+		Mode:          f.Mode,
+		Recipients:    f.Recipients,
+		GPGCopy:       f.GPGCopy,
+		ObfuscateKeys: f.ObfuscateKeys,
+		Format:        f.Format,
+		Schema:        f.Schema,
+		Example:       f.Example,
+		LineOptions:   f.LineOptions,
+	}
+	if !f.Hooks.IsZero() {
+		fs.Hooks = f.Hooks
+	}
+	return fs
+}
+
+// apply copies the settings onto a registration.
+func (fs *FileSettings) apply(f *RegisteredFile) {
+	f.Mode = fs.Mode
+	f.Recipients = fs.Recipients
+	f.GPGCopy = fs.GPGCopy
+	f.ObfuscateKeys = fs.ObfuscateKeys
+	f.Format = fs.Format
+	f.Schema = fs.Schema
+	f.Example = fs.Example
+	f.Hooks = fs.Hooks
+	f.LineOptions = fs.LineOptions
+}
+
+// fields lists the settings as names and display values, for describing
+// changes.
+func (fs *FileSettings) fields() [][2]string {
+	gpgCopy := "inherit"
+	if fs.GPGCopy != nil {
+		gpgCopy = strconv.FormatBool(*fs.GPGCopy)
+	}
+	recipients := "all users"
+	if len(fs.Recipients) > 0 {
+		recipients = strings.Join(fs.Recipients, ", ")
+	}
+	var hookList, lineOptions string
+	if fs.Hooks != nil {
+		data, _ := yaml.Marshal(fs.Hooks)
+		hookList = strings.TrimSpace(strings.ReplaceAll(string(data), "\n", "; "))
+	}
+	if fs.LineOptions != nil {
+		data, _ := yaml.Marshal(fs.LineOptions)
+		lineOptions = strings.TrimSpace(strings.ReplaceAll(string(data), "\n", "; "))
+	}
+	return [][2]string{
+		{"mode", fs.Mode},
+		{"recipients", recipients},
+		{"gpg_copy", gpgCopy},
+		{"obfuscate_keys", strconv.FormatBool(fs.ObfuscateKeys)},
+		{"format", fs.Format},
+		{"schema", fs.Schema},
+		{"example", strconv.FormatBool(fs.Example)},
+		{"hooks", hookList},
+		{"line_options", lineOptions},
+	}
+}
+
+// diff describes the settings that differ from old.
+func (fs *FileSettings) diff(old *FileSettings) []string {
+	var details []string
+	before := old.fields()
+	for i, field := range fs.fields() {
+		if field[1] != before[i][1] {
+			details = append(details, fmt.Sprintf("%s: %s -> %s", field[0], displaySetting(before[i][1]), displaySetting(field[1])))
+		}
+	}
+	return details
+}
+
+func displaySetting(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
+
+// ExportSettings returns the settings of every registered file, ordered by
+// path within each vault.
+func ExportSettings(s *store.Store) (*SettingsDocument, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &SettingsDocument{Format: SettingsFormat, Vaults: map[string][]FileSettings{}}
+	for _, vaultName := range vaults {
+		vault, err := LoadVault(s, vaultName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", vaultName, err)
+		}
+		files := []FileSettings{}
+		for i := range vault.Files {
+			files = append(files, fileSettings(&vault.Files[i]))
+		}
+		sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+		doc.Vaults[vaultName] = files
+	}
+	return doc, nil
+}
+
+// ParseSettings reads a settings document. Unknown keys are rejected, so a
+// typo is not silently ignored.
+func ParseSettings(data []byte) (*SettingsDocument, error) {
+	doc := &SettingsDocument{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(doc); err != nil {
+		return nil, fmt.Errorf("invalid settings document: %w", err)
+	}
+	if doc.Format != SettingsFormat {
+		return nil, fmt.Errorf("unsupported settings format %q (expected %s)", doc.Format, SettingsFormat)
+	}
+	return doc, nil
+}
+
+// ApplySettings makes the file registrations match a settings document:
+// files it lists are registered, moved or updated, and registered files it
+// leaves out are unregistered with prune, or kept and reported as
+// "unlisted". Everything is validated before any vault is written, and with
+// dryRun nothing is written.
+func ApplySettings(s *store.Store, doc *SettingsDocument, prune, dryRun bool) ([]SettingsChange, error) {
+	vaultNames, err := s.ListVaults()
+	if err != nil {
+		return nil, err
+	}
+	vaults := make(map[string]*Vault)
+	current := make(map[string]string) // path -> vault
+	for _, name := range vaultNames {
+		vault, err := LoadVault(s, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load vault %s: %w", name, err)
+		}
+		vaults[name] = vault
+		for _, f := range vault.Files {
+			current[f.Path] = name
+		}
+	}
+
+	if err := validateSettings(doc, vaults); err != nil {
+		return nil, err
+	}
+
+	var changes []SettingsChange
+	listed := make(map[string]string) // path -> vault in the document
+	docVaults := make([]string, 0, len(doc.Vaults))
+	for name := range doc.Vaults {
+		docVaults = append(docVaults, name)
+	}
+	sort.Strings(docVaults)
+
+	for _, vaultName := range docVaults {
+		vault := vaults[vaultName]
+		for _, fs := range doc.Vaults[vaultName] {
+			fs.Path = NormalizePath(fs.Path)
+			listed[fs.Path] = vaultName
+
+			change := SettingsChange{Vault: vaultName, Path: fs.Path}
+			file := RegisteredFile{Path: fs.Path, RegisteredAt: time.Now()}
+			old := FileSettings{}
+			if from, ok := current[fs.Path]; ok {
+				file = *vaults[from].GetFile(fs.Path)
+				old = fileSettings(&file)
+				if from != vaultName {
+					change.Action, change.From = "move", from
+					vaults[from].UnregisterFile(fs.Path)
+				} else {
+					change.Action = "update"
+				}
+			} else {
+				change.Action = "register"
+			}
+
+			fs.apply(&file)
+			change.Details = fs.diff(&old)
+			if change.Action == "update" && len(change.Details) == 0 {
+				continue
+			}
+			vault.RegisterFile(file)
+			changes = append(changes, change)
+		}
+	}
+
+	for _, name := range vaultNames {
+		for _, f := range slices.Clone(vaults[name].Files) {
+			if in, ok := listed[f.Path]; ok && in == name {
+				continue
+			}
+			// A duplicate registration in another vault than the
+			// document's is always removed.
+			if _, ok := listed[f.Path]; ok || prune {
+				vaults[name].UnregisterFile(f.Path)
+				changes = append(changes, SettingsChange{Vault: name, Path: f.Path, Action: "unregister"})
+			} else {
+				changes = append(changes, SettingsChange{Vault: name, Path: f.Path, Action: "unlisted"})
+			}
+		}
+	}
+
+	changed := make(map[string]bool)
+	for _, c := range changes {
+		if c.Action == "unlisted" {
+			continue
+		}
+		changed[c.Vault] = true
+		if c.From != "" {
+			changed[c.From] = true
+		}
+	}
+	for _, name := range vaultNames {
+		if !changed[name] {
+			continue
+		}
+		if err := vaults[name].checkWritable(name); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		return changes, nil
+	}
+
+	for _, name := range vaultNames {
+		if !changed[name] {
+			continue
+		}
+		if err := vaults[name].Save(s, name); err != nil {
+			return nil, fmt.Errorf("failed to save vault %s: %w", name, err)
+		}
+	}
+	return changes, nil
+}
+
+// validateSettings checks every entry of a settings document against the
+// vaults, filling in defaults, so a bad document changes nothing.
+func validateSettings(doc *SettingsDocument, vaults map[string]*Vault) error {
+	seen := make(map[string]string)
+	for vaultName, files := range doc.Vaults {
+		vault, ok := vaults[vaultName]
+		if !ok {
+			return fmt.Errorf("vault %q does not exist", vaultName)
+		}
+		for i := range files {
+			fs := &files[i]
+			if err := ValidateFilePath(fs.Path); err != nil {
+				return err
+			}
+			path := NormalizePath(fs.Path)
+			if other, ok := seen[path]; ok {
+				return fmt.Errorf("%s is listed in vault %s and vault %s", path, other, vaultName)
+			}
+			seen[path] = vaultName
+
+			if fs.Mode == "" {
+				fs.Mode = ModeValues
+			}
+			if fs.Mode != ModeValues && fs.Mode != ModeFull {
+				return fmt.Errorf("%s: invalid mode: %s (must be 'values' or 'full')", path, fs.Mode)
+			}
+			if fs.Format != "" {
+				format, err := parser.ParseFormat(fs.Format)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				fs.Format = string(format)
+			}
+			if fs.Schema != "" {
+				fs.Schema = NormalizePath(fs.Schema)
+			}
+			if fs.Hooks.IsZero() {
+				fs.Hooks = nil
+			}
+			if fs.LineOptions != nil && fs.LineOptions.Delimiter == "" && len(fs.LineOptions.Comments) == 0 {
+				fs.LineOptions = nil
+			}
+			for _, r := range fs.Recipients {
+				if !vault.HasUser(r) {
+					return fmt.Errorf("%s: recipient %s is not a user in vault %s", path, r, vaultName)
+				}
+			}
+			// As when registering, a recipient template fills in
+			// recipients that are left out.
+			if t := vault.TemplateFor(path); t != nil {
+				if len(fs.Recipients) == 0 {
+					fs.Recipients = slices.Clone(t.Recipients)
+				} else if err := t.Check(path, fs.Recipients); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
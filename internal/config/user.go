@@ -2,8 +2,12 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"net/mail"
+	"os"
 	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/cychiuae/shhh/internal/crypto"
@@ -28,29 +32,39 @@ func ValidateEmail(email string) error {
 	return nil
 }
 
-func AddUser(s *store.Store, vaultName, email string) (*User, error) {
+// AddUser adds the owner of email's key to a vault. uid, when set, is the
+// full user ID to pick, for emails that match several keys or identities;
+// it must hold email.
+func AddUser(s *store.Store, vaultName, email, uid string) (*User, error) {
 	if err := ValidateEmail(email); err != nil {
 		return nil, err
 	}
 
+	query := email
+	if uid != "" {
+		if !strings.Contains(strings.ToLower(uid), "<"+strings.ToLower(email)+">") {
+			return nil, fmt.Errorf("user ID %q is not for %s", uid, email)
+		}
+		query = uid
+	}
+
 	gpg := crypto.GetProvider()
-	keyInfo, err := gpg.LookupKey(email)
+	keyInfo, err := gpg.LookupKey(query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find GPG key for %s: %w", email, err)
+		return nil, fmt.Errorf("failed to find GPG key for %s: %w", query, err)
 	}
 
 	if keyInfo.IsExpired {
 		return nil, fmt.Errorf("GPG key for %s has expired", email)
 	}
 
-	pubKey, err := gpg.GetPublicKey(email)
+	pubKey, err := gpg.GetPublicKey(query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to export public key: %w", err)
 	}
 
-	pubKeyPath := s.PubkeyPath(email)
-	if err := store.WriteFile(pubKeyPath, pubKey); err != nil {
-		return nil, fmt.Errorf("failed to cache public key: %w", err)
+	if err := cachePubkey(s, email, pubKey); err != nil {
+		return nil, err
 	}
 
 	vault, err := LoadVault(s, vaultName)
@@ -60,6 +74,7 @@ func AddUser(s *store.Store, vaultName, email string) (*User, error) {
 
 	user := User{
 		Email:       email,
+		UID:         keyInfo.UID,
 		KeyID:       keyInfo.KeyID,
 		Fingerprint: keyInfo.Fingerprint,
 		ExpiresAt:   keyInfo.ExpiresAt,
@@ -99,6 +114,7 @@ func CheckUserKeys(s *store.Store, vaultName string) ([]UserKeyStatus, error) {
 	}
 
 	gpg := crypto.GetProvider()
+	days := ExpiryWarningDays(s, vault)
 	var statuses []UserKeyStatus
 
 	for _, user := range vault.Users {
@@ -107,7 +123,7 @@ func CheckUserKeys(s *store.Store, vaultName string) ([]UserKeyStatus, error) {
 			Fingerprint: user.Fingerprint,
 		}
 
-		keyInfo, err := gpg.LookupKey(user.Email)
+		keyInfo, err := gpg.LookupKey(user.KeyQuery())
 		if err != nil {
 			status.Status = "missing"
 			status.Message = "Key not found in keyring"
@@ -117,7 +133,7 @@ func CheckUserKeys(s *store.Store, vaultName string) ([]UserKeyStatus, error) {
 		} else if keyInfo.IsExpired {
 			status.Status = "expired"
 			status.Message = "Key has expired"
-		} else if crypto.IsExpiringSoon(keyInfo.ExpiresAt, 30) {
+		} else if crypto.IsExpiringSoon(keyInfo.ExpiresAt, days) {
 			status.Status = "expiring"
 			if keyInfo.ExpiresAt != nil {
 				status.Message = fmt.Sprintf("Key expires on %s", keyInfo.ExpiresAt.Format("2006-01-02"))
@@ -133,9 +149,231 @@ func CheckUserKeys(s *store.Store, vaultName string) ([]UserKeyStatus, error) {
 	return statuses, nil
 }
 
+// UserKeyRefresh is the outcome of refreshing one user's key.
+type UserKeyRefresh struct {
+	UserKeyStatus
+	// Changes describes what the refresh updated, if anything.
+	Changes []string
+}
+
+// RefreshUserKeys updates the recorded expiry date and key ID and the cached
+// public key of each user of a vault from the keyring, so extended
+// expirations and new subkeys are picked up. Users whose keyring key has a
+// different fingerprint are left alone: adopting another key must be a
+// deliberate 'shhh user add'.
+func RefreshUserKeys(s *store.Store, vaultName string) ([]UserKeyRefresh, error) {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load vault: %w", err)
+	}
+
+	gpg := crypto.GetProvider()
+	days := ExpiryWarningDays(s, vault)
+	var results []UserKeyRefresh
+	changed := false
+
+	for i := range vault.Users {
+		user := &vault.Users[i]
+		result := UserKeyRefresh{UserKeyStatus: UserKeyStatus{
+			Email:       user.Email,
+			Fingerprint: user.Fingerprint,
+		}}
+
+		keyInfo, err := gpg.LookupKey(user.KeyQuery())
+		if err != nil {
+			result.Status = "missing"
+			result.Message = "Key not found in keyring"
+			results = append(results, result)
+			continue
+		}
+		if keyInfo.Fingerprint != user.Fingerprint {
+			result.Status = "changed"
+			result.Message = fmt.Sprintf("Keyring holds a different key (%s); verify it and run 'shhh user add'", keyInfo.Fingerprint)
+			results = append(results, result)
+			continue
+		}
+
+		if !sameExpiry(user.ExpiresAt, keyInfo.ExpiresAt) {
+			result.Changes = append(result.Changes, fmt.Sprintf("expiry %s -> %s", formatExpiry(user.ExpiresAt), formatExpiry(keyInfo.ExpiresAt)))
+			user.ExpiresAt = keyInfo.ExpiresAt
+			changed = true
+		}
+		if keyInfo.KeyID != "" && keyInfo.KeyID != user.KeyID {
+			user.KeyID = keyInfo.KeyID
+			changed = true
+		}
+
+		pubKey, err := gpg.GetPublicKey(user.KeyQuery())
+		if err != nil {
+			return nil, fmt.Errorf("failed to export public key of %s: %w", user.Email, err)
+		}
+		pubKeyPath := s.PubkeyPath(user.Email)
+		cached, _ := os.ReadFile(pubKeyPath)
+		if change := pubkeyChange(cached, pubKey); change != "" {
+			if err := cachePubkey(s, user.Email, pubKey); err != nil {
+				return nil, err
+			}
+			result.Changes = append(result.Changes, change)
+		}
+
+		switch {
+		case keyInfo.IsRevoked:
+			result.Status = "revoked"
+			result.Message = "Key has been revoked"
+		case keyInfo.IsExpired:
+			result.Status = "expired"
+			result.Message = "Key has expired"
+		case crypto.IsExpiringSoon(keyInfo.ExpiresAt, days):
+			result.Status = "expiring"
+			result.Message = fmt.Sprintf("Key expires on %s", keyInfo.ExpiresAt.Format("2006-01-02"))
+		default:
+			result.Status = "valid"
+			result.Message = "Key is valid"
+		}
+		results = append(results, result)
+	}
+
+	if changed {
+		if err := vault.Save(s, vaultName); err != nil {
+			return nil, fmt.Errorf("failed to save vault: %w", err)
+		}
+	}
+
+	return results, nil
+}
+
+// cachePubkey checks that an exported public key can be read before
+// writing it to the public key cache.
+func cachePubkey(s *store.Store, email string, pubKey []byte) error {
+	if _, err := crypto.ParsePublicKey(pubKey); err != nil {
+		return fmt.Errorf("exported public key of %s is unusable: %w", email, err)
+	}
+	if err := s.WritePubkey(email, pubKey); err != nil {
+		return fmt.Errorf("failed to cache public key: %w", err)
+	}
+	return nil
+}
+
+// pubkeyChange describes how a freshly exported public key differs from the
+// cached one, or returns "" when the cache is up to date.
+func pubkeyChange(cached, current []byte) string {
+	if len(cached) == 0 {
+		return "public key cached"
+	}
+	old, err := crypto.ParsePublicKey(cached)
+	if err != nil {
+		return "unreadable public key cache replaced"
+	}
+	cur, err := crypto.ParsePublicKey(current)
+	if err != nil {
+		// Keep a cache that can be read over an export that cannot.
+		return ""
+	}
+
+	added := 0
+	for fpr := range cur.Subkeys {
+		if _, ok := old.Subkeys[fpr]; !ok {
+			added++
+		}
+	}
+	switch {
+	case added > 0:
+		return fmt.Sprintf("%d new subkey(s)", added)
+	case !maps.Equal(old.Subkeys, cur.Subkeys) || !sameExpiry(old.ExpiresAt, cur.ExpiresAt) || old.IsRevoked != cur.IsRevoked:
+		return "public key cache updated"
+	}
+	return ""
+}
+
+func sameExpiry(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+func formatExpiry(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Format("2006-01-02")
+}
+
 type UserKeyStatus struct {
 	Email       string
 	Fingerprint string
 	Status      string
 	Message     string
 }
+
+// UserVaultStatus is the key status of one user across every vault.
+type UserVaultStatus struct {
+	Email string
+	// Vaults maps each vault the user is a member of to their key status.
+	Vaults map[string]UserKeyStatus
+	// Problems lists inconsistencies between vaults, such as a user
+	// recorded with different fingerprints or missing from some vaults.
+	Problems []string
+}
+
+// CheckAllUserKeys checks the keys of every user in every vault. It returns
+// the vault names and one entry per user, sorted by email.
+func CheckAllUserKeys(s *store.Store) ([]string, []UserVaultStatus, error) {
+	vaults, err := s.ListVaults()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	byEmail := make(map[string]*UserVaultStatus)
+	var populated []string
+	for _, vaultName := range vaults {
+		statuses, err := CheckUserKeys(s, vaultName)
+		if err != nil {
+			return nil, nil, fmt.Errorf("vault %s: %w", vaultName, err)
+		}
+		if len(statuses) > 0 {
+			populated = append(populated, vaultName)
+		}
+		for _, status := range statuses {
+			u, ok := byEmail[status.Email]
+			if !ok {
+				u = &UserVaultStatus{Email: status.Email, Vaults: make(map[string]UserKeyStatus)}
+				byEmail[status.Email] = u
+			}
+			u.Vaults[vaultName] = status
+		}
+	}
+
+	users := make([]UserVaultStatus, 0, len(byEmail))
+	for _, u := range byEmail {
+		// Empty vaults are not counted as missing the user.
+		u.Problems = vaultInconsistencies(populated, u.Vaults)
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Email < users[j].Email })
+
+	return vaults, users, nil
+}
+
+func vaultInconsistencies(vaults []string, statuses map[string]UserKeyStatus) []string {
+	var problems []string
+
+	var absent []string
+	fingerprints := make(map[string]bool)
+	for _, vaultName := range vaults {
+		status, ok := statuses[vaultName]
+		if !ok {
+			absent = append(absent, vaultName)
+			continue
+		}
+		fingerprints[status.Fingerprint] = true
+	}
+
+	if len(fingerprints) > 1 {
+		problems = append(problems, "recorded with different fingerprints (re-add the user in the stale vaults)")
+	}
+	if len(absent) > 0 {
+		problems = append(problems, fmt.Sprintf("not in %s", strings.Join(absent, ", ")))
+	}
+	return problems
+}
@@ -2,15 +2,22 @@ package config
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"time"
 
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/parser"
 	"github.com/cychiuae/shhh/internal/store"
 	"gopkg.in/yaml.v3"
 )
 
 type User struct {
-	Email       string     `yaml:"email"`
+	Email string `yaml:"email"`
+	// UID is the user ID of the key that was matched when the user was
+	// added, e.g. "Alice <alice@example.com>". Keys are looked up by it
+	// rather than by email when it is set.
+	UID         string     `yaml:"uid,omitempty"`
 	KeyID       string     `yaml:"key_id"`
 	Fingerprint string     `yaml:"fingerprint"`
 	ExpiresAt   *time.Time `yaml:"expires_at,omitempty"`
@@ -18,22 +25,111 @@ type User struct {
 }
 
 type RegisteredFile struct {
-	Path         string    `yaml:"path"`
-	Mode         string    `yaml:"mode"`
-	GPGCopy      *bool     `yaml:"gpg_copy,omitempty"`
+	Path          string `yaml:"path"`
+	Mode          string `yaml:"mode"`
+	GPGCopy       *bool  `yaml:"gpg_copy,omitempty"`
+	ObfuscateKeys bool   `yaml:"obfuscate_keys,omitempty"`
+	Schema        string `yaml:"schema,omitempty"`
+	Format        string `yaml:"format,omitempty"`
+	// Example keeps <path>.example, a copy with placeholder values, in sync
+	// whenever the file is encrypted or edited.
+	Example bool `yaml:"example,omitempty"`
+	// Hooks are commands run before and after the file is encrypted or
+	// decrypted.
+	Hooks *hooks.FileHooks `yaml:"hooks,omitempty"`
+	// LineOptions customise the ENV parser (delimiter, comment prefixes).
+	LineOptions *parser.LineOptions `yaml:"line_options,omitempty"`
+	// FullFallback records that the file is in values mode but its format
+	// is not supported, so the whole file is encrypted instead. It is
+	// updated whenever the vault is saved.
+	FullFallback bool      `yaml:"full_fallback,omitempty"`
 	Recipients   []string  `yaml:"recipients,omitempty"`
 	RegisteredAt time.Time `yaml:"registered_at"`
 }
 
+// FallsBackToFull reports whether values mode encrypts the whole file
+// because its format is not supported.
+func (f *RegisteredFile) FallsBackToFull() bool {
+	return f.Mode == ModeValues && f.Spec().FormatOf(f.Path) == parser.FormatUnknown
+}
+
+// Spec returns how the file is parsed: its recorded format, or detection by
+// extension when it has none, and its line options.
+func (f *RegisteredFile) Spec() parser.Spec {
+	spec := parser.Spec{Format: parser.FileFormat(f.Format)}
+	if f.LineOptions != nil {
+		spec.Lines = *f.LineOptions
+	}
+	return spec
+}
+
 type Vault struct {
-	Users []User           `yaml:"users"`
-	Files []RegisteredFile `yaml:"files"`
+	// Version is the store format version; vaults written before format
+	// version 2 have none.
+	Version string `yaml:"version,omitempty"`
+	// Description and Owner document what the vault is for and who is
+	// responsible for it. They are shown in vault list and reports.
+	Description string `yaml:"description,omitempty"`
+	Owner       string `yaml:"owner,omitempty"`
+	// Archived vaults are read-only: their files can be decrypted but not
+	// encrypted, and no files can be registered in them.
+	Archived bool             `yaml:"archived,omitempty"`
+	Users    []User           `yaml:"users"`
+	Files    []RegisteredFile `yaml:"files"`
+	// Canary is a known value encrypted for the vault's users; see
+	// RefreshCanary.
+	Canary string `yaml:"canary,omitempty"`
+	// ExpiryWarningDays overrides the project's expiry_warning_days for
+	// the vault's users.
+	ExpiryWarningDays *int `yaml:"expiry_warning_days,omitempty"`
+	// RecipientTemplates restrict the files registered at matching paths
+	// to fixed recipients.
+	RecipientTemplates []RecipientTemplate `yaml:"recipient_templates,omitempty"`
+}
+
+// KeyQuery returns what to look the user's key up by: the recorded user ID,
+// or the email for users added before user IDs were recorded.
+func (u *User) KeyQuery() string {
+	if u.UID != "" {
+		return u.UID
+	}
+	return u.Email
+}
+
+// ExpiryWarningDays returns how many days before expiry a key of one of the
+// vault's users is reported as expiring soon.
+func ExpiryWarningDays(s *store.Store, v *Vault) int {
+	if v != nil && v.ExpiryWarningDays != nil {
+		return *v.ExpiryWarningDays
+	}
+	cfg, err := Load(s)
+	if err != nil {
+		cfg = NewConfig()
+	}
+	return cfg.ExpiryWarningDays
+}
+
+// CheckVaultWritable returns an error when the vault is archived.
+func CheckVaultWritable(s *store.Store, vaultName string) error {
+	vault, err := LoadVault(s, vaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load vault: %w", err)
+	}
+	return vault.checkWritable(vaultName)
+}
+
+func (v *Vault) checkWritable(vaultName string) error {
+	if v.Archived {
+		return fmt.Errorf("vault %q is archived (run 'shhh vault unarchive %s' to use it)", vaultName, vaultName)
+	}
+	return nil
 }
 
 func NewVault() *Vault {
 	return &Vault{
-		Users: []User{},
-		Files: []RegisteredFile{},
+		Version: CurrentVersion,
+		Users:   []User{},
+		Files:   []RegisteredFile{},
 	}
 }
 
@@ -45,11 +141,21 @@ func LoadVault(s *store.Store, vaultName string) (*Vault, error) {
 		}
 		return nil, err
 	}
+	return ParseVault(vaultName, data)
+}
 
+// ParseVault decodes a vault.yaml, e.g. read from a git tree instead of the
+// working copy, and applies the format settings of its files.
+func ParseVault(vaultName string, data []byte) (*Vault, error) {
 	var v Vault
 	if err := yaml.Unmarshal(data, &v); err != nil {
 		return nil, err
 	}
+	if v.Version != "" {
+		if err := checkVersion(v.Version); err != nil {
+			return nil, fmt.Errorf("vault %s: %w", vaultName, err)
+		}
+	}
 
 	if v.Users == nil {
 		v.Users = []User{}
@@ -58,10 +164,18 @@ func LoadVault(s *store.Store, vaultName string) (*Vault, error) {
 		v.Files = []RegisteredFile{}
 	}
 
+	for i := range v.Files {
+		v.Files[i].Path = NormalizePath(v.Files[i].Path)
+	}
+
 	return &v, nil
 }
 
 func (v *Vault) Save(s *store.Store, vaultName string) error {
+	for i := range v.Files {
+		v.Files[i].FullFallback = v.Files[i].FallsBackToFull()
+	}
+
 	var buf bytes.Buffer
 	encoder := yaml.NewEncoder(&buf)
 	encoder.SetIndent(2)
@@ -103,6 +217,21 @@ func (v *Vault) GetUser(email string) *User {
 	return nil
 }
 
+// GetUserByFingerprint finds a user by full fingerprint or long key ID,
+// ignoring case, spaces, and a leading "0x".
+func (v *Vault) GetUserByFingerprint(fingerprint string) *User {
+	fp := NormalizeFingerprint(fingerprint)
+	if fp == "" {
+		return nil
+	}
+	for i := range v.Users {
+		if NormalizeFingerprint(v.Users[i].Fingerprint) == fp || NormalizeFingerprint(v.Users[i].KeyID) == fp {
+			return &v.Users[i]
+		}
+	}
+	return nil
+}
+
 func (v *Vault) HasUser(email string) bool {
 	return v.GetUser(email) != nil
 }
@@ -118,6 +247,7 @@ func (v *Vault) Emails() []string {
 // File methods
 
 func (v *Vault) RegisterFile(file RegisteredFile) {
+	file.Path = NormalizePath(file.Path)
 	for i, f := range v.Files {
 		if f.Path == file.Path {
 			v.Files[i] = file
@@ -128,6 +258,7 @@ func (v *Vault) RegisterFile(file RegisteredFile) {
 }
 
 func (v *Vault) UnregisterFile(path string) bool {
+	path = NormalizePath(path)
 	for i, f := range v.Files {
 		if f.Path == path {
 			v.Files = append(v.Files[:i], v.Files[i+1:]...)
@@ -138,6 +269,7 @@ func (v *Vault) UnregisterFile(path string) bool {
 }
 
 func (v *Vault) GetFile(path string) *RegisteredFile {
+	path = NormalizePath(path)
 	for i := range v.Files {
 		if v.Files[i].Path == path {
 			return &v.Files[i]
@@ -151,6 +283,7 @@ func (v *Vault) HasFile(path string) bool {
 }
 
 func (v *Vault) UpdateFile(path string, fn func(*RegisteredFile)) bool {
+	path = NormalizePath(path)
 	for i := range v.Files {
 		if v.Files[i].Path == path {
 			fn(&v.Files[i])
@@ -0,0 +1,42 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+const messageType = "PGP MESSAGE"
+
+// IsArmored reports whether data is an ASCII-armored OpenPGP block.
+func IsArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
+
+// DearmorMessage converts an armored OpenPGP message to binary.
+func DearmorMessage(data []byte) ([]byte, error) {
+	block, err := armor.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode armor: %w", err)
+	}
+	return io.ReadAll(block.Body)
+}
+
+// ArmorMessage converts a binary OpenPGP message to ASCII armor, which the
+// native provider's Decrypt expects.
+func ArmorMessage(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, messageType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/cychiuae/shhh/internal/parser"
+)
+
+// digestCache remembers, for the lifetime of the process, a digest of the
+// plaintext of every ENC token decrypted through ValueDigest, keyed by the
+// SHA-256 of the token. Plaintext is never cached. Digests are HMACs under
+// a random per-process key, so they cannot be compared across processes or
+// brute-forced from a memory dump.
+//
+// The cache deliberately ends with the command. It serves commands that
+// compare values across many versions of a file, such as blame, where the
+// same ciphertext recurs. Commands that need the plaintext itself (cat,
+// get, edit) cannot be served from digests, and status does not decrypt;
+// keeping digests across commands would need a key stored outside the
+// process, which would let anyone who reads it test guesses at low-entropy
+// secrets.
+var digestCache struct {
+	sync.Mutex
+	key     []byte
+	digests map[[sha256.Size]byte]string
+}
+
+// PlaintextDigest returns the digest of a plaintext value, comparable with
+// the digests returned by ValueDigest within the same process.
+func PlaintextDigest(value string) string {
+	digestCache.Lock()
+	defer digestCache.Unlock()
+	return plaintextDigestLocked(value)
+}
+
+func plaintextDigestLocked(value string) string {
+	if digestCache.key == nil {
+		digestCache.key = make([]byte, 32)
+		rand.Read(digestCache.key)
+	}
+	mac := hmac.New(sha256.New, digestCache.key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValueDigest returns the digest of the plaintext of an ENC token of
+// filename. The token is only decrypted the first time it is seen, once
+// the DecryptGate allows it; values that are not encrypted are digested as
+// they are.
+func ValueDigest(token, filename string) (string, error) {
+	if !parser.IsEncrypted(token) {
+		return PlaintextDigest(token), nil
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	digestCache.Lock()
+	digest, ok := digestCache.digests[sum]
+	digestCache.Unlock()
+	if ok {
+		return digest, nil
+	}
+
+	if err := checkDecryptGate(filename, []byte(token)); err != nil {
+		return "", err
+	}
+	plaintext, err := decryptValue(token)
+	if err != nil {
+		return "", err
+	}
+
+	digestCache.Lock()
+	defer digestCache.Unlock()
+	digest = plaintextDigestLocked(plaintext)
+	if digestCache.digests == nil {
+		digestCache.digests = make(map[[sha256.Size]byte]string)
+	}
+	digestCache.digests[sum] = digest
+	return digest, nil
+}
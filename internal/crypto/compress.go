@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Values of the compression setting, which controls how full-mode
+// plaintext is compressed before it is encrypted.
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
+// maxDecompressedSize bounds the plaintext a compressed full-mode file may
+// expand to, so a crafted file cannot exhaust memory on decrypt.
+const maxDecompressedSize = 1 << 30
+
+// compress compresses full-mode plaintext with algorithm. With
+// CompressionNone, or no algorithm, content is returned as it is.
+func compress(content []byte, algorithm string) ([]byte, error) {
+	switch algorithm {
+	case "", CompressionNone:
+		return content, nil
+	case CompressionZstd:
+		encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress: %w", err)
+		}
+		defer encoder.Close()
+		return encoder.EncodeAll(content, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q (use none or zstd)", algorithm)
+	}
+}
+
+// decompress reverses compress for the algorithm recorded in a full-mode
+// file's header.
+func decompress(data []byte, algorithm string) ([]byte, error) {
+	switch algorithm {
+	case "", CompressionNone:
+		return data, nil
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecompressedSize))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		defer decoder.Close()
+		plaintext, err := decoder.DecodeAll(data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress: %w", err)
+		}
+		return plaintext, nil
+	default:
+		return nil, fmt.Errorf("file is compressed with %q, which this version of shhh does not support", algorithm)
+	}
+}
@@ -3,11 +3,16 @@ package crypto
 import (
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/secmem"
 )
 
 const (
@@ -19,25 +24,121 @@ type EncryptOptions struct {
 	Vault      string
 	Mode       string
 	Recipients []string
+	// FileMode is the plaintext's permission bits, recorded so decrypt can
+	// restore them. Zero means the mode is not recorded.
+	FileMode os.FileMode
+	// DedupeValues encrypts each distinct plaintext once per file and reuses
+	// the ciphertext, so repeated values stay identical in the output.
+	DedupeValues bool
+	// ObfuscateKeys encrypts mapping keys as well as values. Only supported
+	// for formats whose parser implements parser.KeyEncrypter.
+	ObfuscateKeys bool
+	// MetadataPrivacy controls how the vault and recipients are recorded in
+	// the file's metadata: PrivacyNone (default), PrivacyHash or PrivacyOmit.
+	MetadataPrivacy string
+	// Verify decodes the freshly built output again and compares it with
+	// the plaintext, catching round-trip bugs before the .enc is written.
+	Verify bool
+	// AnnotateKeyIDs records the recipients' key IDs in every ENC token of
+	// a values-mode file, so they can be listed without decrypting.
+	AnnotateKeyIDs bool
+	// Timestamp is how the time of encryption is recorded: TimestampPrecise
+	// (default), TimestampDay, TimestampOmit or TimestampCounter.
+	Timestamp string
+	// Revision is recorded instead of a time with TimestampCounter. It
+	// should be one more than the revision of the previous encryption.
+	Revision int
+	// Rotations records when keys were last rotated, by key path. Times
+	// are recorded with the precision of Timestamp, and not at all with
+	// TimestampOmit or TimestampCounter.
+	Rotations map[string]time.Time
+	// Compression compresses full-mode plaintext before it is encrypted:
+	// CompressionNone (default) or CompressionZstd. The algorithm is
+	// recorded in the header for decrypt.
+	Compression string
+	// Spec is the format and line options the file was registered with.
+	// The zero Spec detects the format from the file name.
+	Spec parser.Spec
 }
 
 func EncryptValue(plaintext string, recipients []string) (string, error) {
+	return encryptValue(plaintext, recipients, nil)
+}
+
+func encryptValue(plaintext string, recipients, keyIDs []string) (string, error) {
 	if len(recipients) == 0 {
 		return "", fmt.Errorf("no recipients specified")
 	}
 
+	data := []byte(plaintext)
+	defer secmem.Wipe(data)
+
 	gpg := GetProvider()
-	encrypted, err := gpg.Encrypt([]byte(plaintext), recipients)
+	encrypted, err := gpg.Encrypt(data, recipients)
 	if err != nil {
 		return "", fmt.Errorf("encryption failed: %w", err)
 	}
 
 	encoded := base64.StdEncoding.EncodeToString(encrypted)
 
-	return parser.EncPrefix + encoded + parser.EncSuffix, nil
+	return parser.EncodeAnnotatedValue([]byte(encoded), keyIDs), nil
+}
+
+// RecipientKeyIDs returns the long key IDs of recipients' keys, sorted.
+func RecipientKeyIDs(recipients []string) ([]string, error) {
+	gpg := GetProvider()
+	keyIDs := make([]string, 0, len(recipients))
+	for _, r := range recipients {
+		info, err := gpg.LookupKey(r)
+		if err != nil {
+			return nil, fmt.Errorf("key not found for %s: %w", r, err)
+		}
+		keyIDs = append(keyIDs, LongKeyID(info.Fingerprint))
+	}
+	sort.Strings(keyIDs)
+	return keyIDs, nil
+}
+
+// LongKeyID returns the 16 hex digit key ID of a fingerprint.
+func LongKeyID(fingerprint string) string {
+	fp := strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	if len(fp) > 16 {
+		return fp[len(fp)-16:]
+	}
+	return fp
+}
+
+// EncryptedValues returns the ENC tokens of a values-mode file, keyed by
+// their flattened key path.
+func EncryptedValues(content []byte, filename string, spec parser.Spec) ([]parser.KeyValue, error) {
+	stripped, err := removeMetadata(content, filename, spec)
+	if err != nil {
+		return nil, err
+	}
+	return parser.FlattenFile(stripped, filename, spec)
 }
 
+// DecryptValue decrypts an ENC token; other values are returned as they
+// are. The DecryptGate is asked first, with no file name.
 func DecryptValue(encoded string) (string, error) {
+	if err := checkDecryptGate("", []byte(encoded)); err != nil {
+		return "", err
+	}
+	return decryptValue(encoded)
+}
+
+// DecryptCanary is DecryptValue without asking the DecryptGate, for values
+// that are not secret, such as vault canaries.
+func DecryptCanary(encoded string) (string, error) {
+	return decryptValue(encoded)
+}
+
+// decryptValue decrypts an ENC token for callers that have already asked
+// the DecryptGate.
+func decryptValue(encoded string) (plaintext string, err error) {
+	done := trackKeyUsage()
+	defer func() { done("value", err) }()
+
 	if !parser.IsEncrypted(encoded) {
 		return encoded, nil
 	}
@@ -53,48 +154,125 @@ func DecryptValue(encoded string) (string, error) {
 	}
 
 	gpg := GetProvider()
-	plaintext, err := gpg.Decrypt(decoded)
+	decrypted, err := gpg.Decrypt(decoded)
 	if err != nil {
-		return "", fmt.Errorf("decryption failed: %w", err)
+		return "", fmt.Errorf("decryption failed: %w", withKeyHint(err, decoded))
 	}
+	defer secmem.Wipe(decrypted)
 
-	return string(plaintext), nil
+	return string(decrypted), nil
 }
 
 func EncryptFileContent(content []byte, filename string, opts EncryptOptions) ([]byte, error) {
+	if err := CheckRecipients(opts.Recipients); err != nil {
+		return nil, err
+	}
+
 	if opts.Mode == "full" {
-		return encryptFullFile(content, opts)
+		return encryptFullFile(content, filename, opts)
 	}
 
 	return encryptValuesFile(content, filename, opts)
 }
 
 func encryptValuesFile(content []byte, filename string, opts EncryptOptions) ([]byte, error) {
-	p := parser.GetParserForFile(filename)
+	p := parser.GetParserForFile(filename, opts.Spec)
 	if p == nil {
 		// For unsupported file formats, encrypt the entire content
-		return encryptFullFile(content, opts)
+		return encryptFullFile(content, filename, opts)
+	}
+
+	var keyIDs []string
+	if opts.AnnotateKeyIDs {
+		var err error
+		if keyIDs, err = RecipientKeyIDs(opts.Recipients); err != nil {
+			return nil, err
+		}
 	}
 
-	encryptFunc := func(plaintext string) (string, error) {
-		return EncryptValue(plaintext, opts.Recipients)
+	var encryptFunc parser.EncryptFunc = func(plaintext string) (string, error) {
+		return encryptValue(plaintext, opts.Recipients, keyIDs)
+	}
+	encryptFunc = wrapLongTokens(encryptFunc, filename, opts.Spec)
+	if opts.DedupeValues {
+		encryptFunc = memoize(encryptFunc)
+	}
+	var tokens map[string]string
+	if opts.Verify {
+		tokens = make(map[string]string)
+		encryptFunc = recordTokens(encryptFunc, tokens)
 	}
 
-	encrypted, err := p.EncryptValues(content, encryptFunc)
+	// The metadata is added before encrypting, which the parsers leave it
+	// alone for: re-parsing the output instead would cost far more, as every
+	// value grows into a long ENC token.
+	withMetadata, err := addMetadata(content, filename, opts)
 	if err != nil {
 		return nil, err
 	}
 
+	var output []byte
+	if opts.ObfuscateKeys {
+		ke, ok := p.(parser.KeyEncrypter)
+		if !ok {
+			return nil, fmt.Errorf("obfuscate_keys is not supported for %s files", p.FileType())
+		}
+		output, err = ke.EncryptKeysAndValues(withMetadata, encryptFunc)
+	} else {
+		output, err = p.EncryptValues(withMetadata, encryptFunc)
+	}
+	if errors.Is(err, errTokenTooLong) {
+		fmt.Fprintf(os.Stderr, "Warning: a value of %s is too large for one line of a %s file; encrypting the whole file instead\n", filename, p.FileType())
+		return encryptFullFile(content, filename, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Every value grows into a token of several hundred bytes; refuse output
+	// that decrypt would then reject as too large.
+	if err := parser.ValidateContentSize(output); err != nil {
+		return nil, fmt.Errorf("encrypted output is too large for values mode, use full mode: %w", err)
+	}
+
+	if opts.Verify {
+		if err := verifyValuesFile(content, output, filename, opts.Spec, tokens); err != nil {
+			return nil, err
+		}
+	}
+
+	return output, nil
+}
+
+func addMetadata(encrypted []byte, filename string, opts EncryptOptions) ([]byte, error) {
+	vault, recipients := metadataIdentifiers(opts, filename)
 	metadata := map[string]interface{}{
-		"version":      "1",
-		"vault":        opts.Vault,
-		"mode":         opts.Mode,
-		"encrypted_at": time.Now().Format(time.RFC3339),
-		"recipients":   strings.Join(opts.Recipients, ", "),
+		"version": "1",
+		"mode":    opts.Mode,
+	}
+	if at := metadataTimestamp(opts); at != "" {
+		metadata["encrypted_at"] = at
+	}
+	if opts.Timestamp == TimestampCounter {
+		metadata["revision"] = opts.Revision
+	}
+	if rotated := formatRotations(opts); rotated != "" {
+		metadata["rotated"] = rotated
+	}
+	if vault != "" {
+		metadata["vault"] = vault
+	}
+	if len(recipients) > 0 {
+		metadata["recipients"] = strings.Join(recipients, ", ")
+	}
+	if opts.MetadataPrivacy == PrivacyHash || opts.MetadataPrivacy == PrivacyOmit {
+		metadata["privacy"] = opts.MetadataPrivacy
+	}
+	if opts.FileMode != 0 {
+		metadata["file_mode"] = formatFileMode(opts.FileMode)
 	}
 
-	format := parser.DetectFormat(filename)
-	switch format {
+	switch opts.Spec.FormatOf(filename) {
 	case parser.FormatYAML:
 		return parser.AddShhhMetadata(encrypted, metadata)
 	case parser.FormatJSON:
@@ -108,22 +286,201 @@ func encryptValuesFile(content []byte, filename string, opts EncryptOptions) ([]
 	}
 }
 
-func encryptFullFile(content []byte, opts EncryptOptions) ([]byte, error) {
+// ReencryptStaleValues re-encrypts, in place, only the values of a
+// values-mode file whose recorded key IDs differ from the current keys of
+// opts.Recipients; values already encrypted to those keys are kept as they
+// are, so no private key operation is spent on them. It returns the number
+// of values re-encrypted, and nil content when there were none. New values
+// are annotated with their key IDs.
+func ReencryptStaleValues(content []byte, filename string, opts EncryptOptions) ([]byte, int, error) {
+	if err := CheckRecipients(opts.Recipients); err != nil {
+		return nil, 0, err
+	}
+	p := parser.GetParserForFile(filename, opts.Spec)
+	if p == nil || IsFullyEncrypted(content) || opts.ObfuscateKeys {
+		return nil, 0, fmt.Errorf("%s is not a values-mode file", filename)
+	}
+	if err := checkDecryptGate(filename, content); err != nil {
+		return nil, 0, err
+	}
+
+	keyIDs, err := RecipientKeyIDs(opts.Recipients)
+	if err != nil {
+		return nil, 0, err
+	}
+	want := strings.Join(keyIDs, ",")
+
+	stripped, err := removeMetadata(content, filename, opts.Spec)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	changed := 0
+	reencrypt := wrapLongTokens(func(plaintext string) (string, error) {
+		return encryptValue(plaintext, opts.Recipients, keyIDs)
+	}, filename, opts.Spec)
+	if opts.DedupeValues {
+		// Equal values must keep sharing one token, including with values
+		// that are already up to date and keep theirs.
+		current, err := currentTokens(p, stripped, want)
+		if err != nil {
+			return nil, 0, err
+		}
+		reencrypt = memoizeFrom(current, reencrypt)
+	}
+	output, err := p.DecryptValues(stripped, func(token string) (string, error) {
+		if strings.Join(parser.ValueKeyIDs(token), ",") == want {
+			return token, nil
+		}
+		changed++
+		plaintext, err := decryptValue(token)
+		if err != nil {
+			return "", err
+		}
+		return reencrypt(plaintext)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if changed == 0 {
+		return nil, 0, nil
+	}
+
+	opts.Mode = "values"
+	output, err = addMetadata(output, filename, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	return output, changed, nil
+}
+
+// errTokenTooLong is returned for a token longer than MaxUnwrappedToken in
+// a format whose values cannot span lines. Values are not chunked or split
+// into separate files; encryptValuesFile encrypts the whole file instead.
+var errTokenTooLong = errors.New("encrypted value is too long for a single line")
+
+// wrapLongTokens wraps the long tokens encrypt returns for formats whose
+// values can span lines, which only YAML's can. In other formats a token
+// longer than WrapThreshold is warned about once per file, and one longer
+// than MaxUnwrappedToken fails with errTokenTooLong.
+func wrapLongTokens(encrypt parser.EncryptFunc, filename string, spec parser.Spec) parser.EncryptFunc {
+	format := spec.FormatOf(filename)
+	if format == parser.FormatYAML {
+		return func(plaintext string) (string, error) {
+			token, err := encrypt(plaintext)
+			if err != nil {
+				return "", err
+			}
+			return parser.WrapToken(token), nil
+		}
+	}
+
+	warned := false
+	return func(plaintext string) (string, error) {
+		token, err := encrypt(plaintext)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case len(token) > parser.MaxUnwrappedToken:
+			return "", fmt.Errorf("%w: %d bytes (max %d in %s files)", errTokenTooLong, len(token), parser.MaxUnwrappedToken, format)
+		case len(token) > parser.WrapThreshold && !warned:
+			warned = true
+			fmt.Fprintf(os.Stderr, "Warning: a value of %s encrypts to a single line of %d bytes; %s values cannot span lines, so consider moving it to a full-mode file\n", filename, len(token), format)
+		}
+		return token, nil
+	}
+}
+
+// currentTokens maps the plaintext of each value already encrypted to the
+// key IDs in want to its token.
+func currentTokens(p parser.Parser, content []byte, want string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	_, err := p.DecryptValues(content, func(token string) (string, error) {
+		if strings.Join(parser.ValueKeyIDs(token), ",") != want {
+			return token, nil
+		}
+		plaintext, err := decryptValue(token)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := tokens[plaintext]; !ok {
+			tokens[plaintext] = token
+		}
+		return token, nil
+	})
+	return tokens, err
+}
+
+// memoize caches a value transform for the duration of one file, so each
+// distinct input is only passed to GPG once.
+func memoize(fn func(string) (string, error)) func(string) (string, error) {
+	return memoizeFrom(make(map[string]string), fn)
+}
+
+// memoizeFrom is memoize with a cache that starts out with known results.
+func memoizeFrom(cache map[string]string, fn func(string) (string, error)) func(string) (string, error) {
+	return func(value string) (string, error) {
+		if result, ok := cache[value]; ok {
+			return result, nil
+		}
+		result, err := fn(value)
+		if err != nil {
+			return "", err
+		}
+		cache[value] = result
+		return result, nil
+	}
+}
+
+func encryptFullFile(content []byte, filename string, opts EncryptOptions) ([]byte, error) {
+	compressed, err := compress(content, opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+	compressing := opts.Compression != "" && opts.Compression != CompressionNone
+	if compressing {
+		defer secmem.Wipe(compressed)
+	}
+
 	gpg := GetProvider()
-	encrypted, err := gpg.Encrypt(content, opts.Recipients)
+	encrypted, err := gpg.Encrypt(compressed, opts.Recipients)
 	if err != nil {
 		return nil, fmt.Errorf("encryption failed: %w", err)
 	}
 
 	encoded := base64.StdEncoding.EncodeToString(encrypted)
 
+	vault, recipients := metadataIdentifiers(opts, filename)
+
 	var buf bytes.Buffer
 	buf.WriteString(FullFileHeader + "\n")
 	buf.WriteString(fmt.Sprintf("Version: 1\n"))
-	buf.WriteString(fmt.Sprintf("Vault: %s\n", opts.Vault))
+	if vault != "" {
+		buf.WriteString(fmt.Sprintf("Vault: %s\n", vault))
+	}
 	buf.WriteString(fmt.Sprintf("Mode: full\n"))
-	buf.WriteString(fmt.Sprintf("Recipients: %s\n", strings.Join(opts.Recipients, ", ")))
-	buf.WriteString(fmt.Sprintf("Encrypted-At: %s\n", time.Now().Format(time.RFC3339)))
+	if len(recipients) > 0 {
+		buf.WriteString(fmt.Sprintf("Recipients: %s\n", strings.Join(recipients, ", ")))
+	}
+	if compressing {
+		buf.WriteString(fmt.Sprintf("Compression: %s\n", opts.Compression))
+	}
+	if opts.MetadataPrivacy == PrivacyHash || opts.MetadataPrivacy == PrivacyOmit {
+		buf.WriteString(fmt.Sprintf("Privacy: %s\n", opts.MetadataPrivacy))
+	}
+	if at := metadataTimestamp(opts); at != "" {
+		buf.WriteString(fmt.Sprintf("Encrypted-At: %s\n", at))
+	}
+	if opts.Timestamp == TimestampCounter {
+		buf.WriteString(fmt.Sprintf("Revision: %d\n", opts.Revision))
+	}
+	if rotated := formatRotations(opts); rotated != "" {
+		buf.WriteString(fmt.Sprintf("Rotated: %s\n", rotated))
+	}
+	if opts.FileMode != 0 {
+		buf.WriteString(fmt.Sprintf("File-Mode: %s\n", formatFileMode(opts.FileMode)))
+	}
 	buf.WriteString("\n")
 
 	for i := 0; i < len(encoded); i += 64 {
@@ -136,44 +493,124 @@ func encryptFullFile(content []byte, opts EncryptOptions) ([]byte, error) {
 
 	buf.WriteString(FullFileFooter + "\n")
 
+	if opts.Verify {
+		decoded, err := decodeFullFile(buf.Bytes())
+		if err != nil || !bytes.Equal(decoded, encrypted) {
+			return nil, fmt.Errorf("round-trip verification failed: encrypted envelope does not decode to the ciphertext")
+		}
+	}
+
 	return buf.Bytes(), nil
 }
 
-func DecryptFileContent(content []byte, filename string) ([]byte, error) {
+// DecryptGate may refuse to decrypt a file before anything is decrypted,
+// e.g. until the user approves it. It is given the arguments of
+// DecryptFileContent, or an empty file name and the token for values
+// decrypted on their own.
+type DecryptGate func(filename string, content []byte) error
+
+var decryptGate DecryptGate
+
+// SetDecryptGate sets what is asked before each file is decrypted. nil
+// allows every decryption.
+func SetDecryptGate(g DecryptGate) {
+	decryptGate = g
+}
+
+func checkDecryptGate(filename string, content []byte) error {
+	if decryptGate == nil {
+		return nil
+	}
+	return decryptGate(filename, content)
+}
+
+// DecryptFileContent decrypts a values-mode or full-file .enc. The keys
+// that decrypted it are reported to the DecryptionRecorder, if any.
+func DecryptFileContent(content []byte, filename string, spec parser.Spec) (decrypted []byte, err error) {
+	if err := checkDecryptGate(filename, content); err != nil {
+		return nil, err
+	}
+
+	done := trackKeyUsage()
+	defer func() { done(filename, err) }()
+
 	if bytes.HasPrefix(content, []byte(FullFileHeader)) {
 		return decryptFullFile(content)
 	}
 
-	return decryptValuesFile(content, filename)
+	return decryptValuesFile(content, filename, spec)
 }
 
-func decryptValuesFile(content []byte, filename string) ([]byte, error) {
-	p := parser.GetParserForFile(filename)
+func decryptValuesFile(content []byte, filename string, spec parser.Spec) ([]byte, error) {
+	decrypted, err := decryptValuesWith(content, filename, spec, memoize(decryptValue))
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse output that no longer parses rather than hand a corrupted
+	// config to whatever consumes it.
+	if _, err := parser.FlattenFile(decrypted, filename, spec); err != nil {
+		return nil, fmt.Errorf("decrypted output is not valid %s: %w", spec.FormatOf(filename), err)
+	}
+
+	return decrypted, nil
+}
+
+func decryptValuesWith(content []byte, filename string, spec parser.Spec, decrypt parser.DecryptFunc) ([]byte, error) {
+	p := parser.GetParserForFile(filename, spec)
 	if p == nil {
 		return nil, fmt.Errorf("unsupported file format: %s", filename)
 	}
 
-	decrypted, err := p.DecryptValues(content, DecryptValue)
+	decrypted, err := p.DecryptValues(content, decrypt)
 	if err != nil {
 		return nil, err
 	}
 
-	format := parser.DetectFormat(filename)
-	switch format {
+	return removeMetadata(decrypted, filename, spec)
+}
+
+func removeMetadata(content []byte, filename string, spec parser.Spec) ([]byte, error) {
+	switch spec.FormatOf(filename) {
 	case parser.FormatYAML:
-		return parser.RemoveShhhMetadata(decrypted)
+		return parser.RemoveShhhMetadata(content)
 	case parser.FormatJSON:
-		return parser.RemoveJSONMetadata(decrypted)
+		return parser.RemoveJSONMetadata(content)
 	case parser.FormatINI:
-		return parser.RemoveINIMetadata(decrypted)
+		return parser.RemoveINIMetadata(content)
 	case parser.FormatENV:
-		return parser.RemoveENVMetadata(decrypted)
+		return parser.RemoveENVMetadata(content)
 	default:
-		return decrypted, nil
+		return content, nil
 	}
 }
 
 func decryptFullFile(content []byte) ([]byte, error) {
+	decoded, err := decodeFullFile(content)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := parseFullFileMetadata(content)
+	if err != nil {
+		return nil, err
+	}
+
+	gpg := GetProvider()
+	plaintext, err := gpg.Decrypt(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", withKeyHint(err, decoded))
+	}
+	if meta.Compression == "" || meta.Compression == CompressionNone {
+		return plaintext, nil
+	}
+
+	defer secmem.Wipe(plaintext)
+	return decompress(plaintext, meta.Compression)
+}
+
+// decodeFullFile extracts the GPG ciphertext from a full-file envelope.
+func decodeFullFile(content []byte) ([]byte, error) {
 	lines := strings.Split(string(content), "\n")
 
 	var encodedData strings.Builder
@@ -201,13 +638,7 @@ func decryptFullFile(content []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to decode base64: %w", err)
 	}
 
-	gpg := GetProvider()
-	plaintext, err := gpg.Decrypt(decoded)
-	if err != nil {
-		return nil, fmt.Errorf("decryption failed: %w", err)
-	}
-
-	return plaintext, nil
+	return decoded, nil
 }
 
 func IsFullyEncrypted(content []byte) bool {
@@ -220,52 +651,67 @@ type FileMetadata struct {
 	Mode        string
 	Recipients  []string
 	EncryptedAt time.Time
+	// Revision counts encryptions when encrypted_at is "counter", and is
+	// zero otherwise.
+	Revision int
+	// Rotations are the times keys were last rotated with 'shhh rotate'.
+	Rotations map[string]time.Time
+	FileMode  os.FileMode
+	// Privacy is PrivacyHash or PrivacyOmit when identifiers were hashed or
+	// left out, and empty otherwise.
+	Privacy string
+	// Compression is the algorithm full-mode plaintext was compressed with
+	// before encryption, and empty when it was not.
+	Compression string
 }
 
-func GetFileMetadata(content []byte, filename string) (*FileMetadata, error) {
+func GetFileMetadata(content []byte, filename string, spec parser.Spec) (*FileMetadata, error) {
 	if bytes.HasPrefix(content, []byte(FullFileHeader)) {
 		return parseFullFileMetadata(content)
 	}
 
-	format := parser.DetectFormat(filename)
+	meta, err := rawMetadata(content, filename, spec)
+	if err != nil {
+		return nil, err
+	}
 
-	var meta map[string]string
-	var err error
+	if len(meta) == 0 {
+		return nil, nil
+	}
 
-	switch format {
+	return metadataFromMap(meta), nil
+}
+
+// rawMetadata returns the key/value pairs embedded in a values-mode file.
+func rawMetadata(content []byte, filename string, spec parser.Spec) (map[string]string, error) {
+	switch spec.FormatOf(filename) {
 	case parser.FormatYAML:
-		meta, err = parser.GetShhhMetadata(content)
+		return parser.GetShhhMetadata(content)
 	case parser.FormatINI:
-		meta, err = parser.GetINIMetadata(content)
+		return parser.GetINIMetadata(content)
 	case parser.FormatENV:
-		meta, err = parser.GetENVMetadata(content)
+		return parser.GetENVMetadata(content)
 	case parser.FormatJSON:
-		jsonMeta, jsonErr := parser.GetJSONMetadata(content)
-		if jsonErr != nil {
-			return nil, jsonErr
+		jsonMeta, err := parser.GetJSONMetadata(content)
+		if err != nil || jsonMeta == nil {
+			return nil, err
 		}
-		if jsonMeta != nil {
-			meta = make(map[string]string)
-			for k, v := range jsonMeta {
-				meta[k] = fmt.Sprintf("%v", v)
-			}
+		meta := make(map[string]string)
+		for k, v := range jsonMeta {
+			meta[k] = fmt.Sprintf("%v", v)
 		}
+		return meta, nil
 	default:
 		return nil, nil
 	}
+}
 
-	if err != nil {
-		return nil, err
-	}
-
-	if meta == nil {
-		return nil, nil
-	}
-
+func metadataFromMap(meta map[string]string) *FileMetadata {
 	result := &FileMetadata{
 		Version: meta["version"],
 		Vault:   meta["vault"],
 		Mode:    meta["mode"],
+		Privacy: meta["privacy"],
 	}
 
 	if recipients, ok := meta["recipients"]; ok && recipients != "" {
@@ -284,7 +730,19 @@ func GetFileMetadata(content []byte, filename string) (*FileMetadata, error) {
 		}
 	}
 
-	return result, nil
+	if rev, ok := meta["revision"]; ok {
+		result.Revision, _ = strconv.Atoi(rev)
+	}
+
+	if rotated, ok := meta["rotated"]; ok {
+		result.Rotations = parseRotations(rotated)
+	}
+
+	if mode, ok := meta["file_mode"]; ok {
+		result.FileMode = parseFileMode(mode)
+	}
+
+	return result
 }
 
 func parseFullFileMetadata(content []byte) (*FileMetadata, error) {
@@ -318,8 +776,40 @@ func parseFullFileMetadata(content []byte) (*FileMetadata, error) {
 			if t, err := time.Parse(time.RFC3339, encAtStr); err == nil {
 				result.EncryptedAt = t
 			}
+		} else if strings.HasPrefix(line, "Rotated:") {
+			result.Rotations = parseRotations(strings.TrimSpace(strings.TrimPrefix(line, "Rotated:")))
+		} else if strings.HasPrefix(line, "Revision:") {
+			result.Revision, _ = strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Revision:")))
+		} else if strings.HasPrefix(line, "Privacy:") {
+			result.Privacy = strings.TrimSpace(strings.TrimPrefix(line, "Privacy:"))
+		} else if strings.HasPrefix(line, "Compression:") {
+			result.Compression = strings.TrimSpace(strings.TrimPrefix(line, "Compression:"))
+		} else if strings.HasPrefix(line, "File-Mode:") {
+			result.FileMode = parseFileMode(strings.TrimSpace(strings.TrimPrefix(line, "File-Mode:")))
 		}
 	}
 
 	return result, nil
 }
+
+// PlaintextMode is the recorded FileMode to give decrypted plaintext, less
+// its group and other bits: metadata is committed, so it must not be able
+// to make a secret readable by other users. It is zero when no mode is
+// recorded.
+func (m *FileMetadata) PlaintextMode() os.FileMode {
+	return m.FileMode &^ 0077
+}
+
+func formatFileMode(mode os.FileMode) string {
+	return fmt.Sprintf("%#o", mode.Perm())
+}
+
+// parseFileMode accepts the octal form written by formatFileMode. YAML
+// metadata decodes "0755" as an integer, so plain decimal is accepted too.
+func parseFileMode(value string) os.FileMode {
+	mode, err := strconv.ParseUint(strings.TrimSpace(value), 0, 32)
+	if err != nil {
+		return 0
+	}
+	return os.FileMode(mode).Perm()
+}
@@ -2,6 +2,8 @@ package crypto
 
 import (
 	"errors"
+	"fmt"
+	"os"
 	"time"
 )
 
@@ -14,12 +16,16 @@ var (
 )
 
 type KeyInfo struct {
-	Email       string
+	Email string
+	// UID is the full user ID the lookup matched, e.g.
+	// "Alice <alice@example.com>".
+	UID         string
 	KeyID       string
 	Fingerprint string
 	ExpiresAt   *time.Time
 	CreatedAt   time.Time
 	IsExpired   bool
+	IsRevoked   bool
 	PublicKey   []byte
 }
 
@@ -32,17 +38,140 @@ type GPGProvider interface {
 	LoadCachedPublicKeys(dirPath string) error
 }
 
-var defaultProvider GPGProvider
+// Provider modes: auto tries the native implementation first and falls back
+// to the gpg CLI; native and cli use only one of them.
+const (
+	ProviderAuto   = "auto"
+	ProviderNative = "native"
+	ProviderCLI    = "cli"
+)
+
+var (
+	defaultProvider GPGProvider
+	providerMode    = ProviderAuto
+)
 
 func GetProvider() GPGProvider {
 	if defaultProvider == nil {
-		native := NewNativeGPG()
-		cli := NewCLIGPG()
-		defaultProvider = &fallbackProvider{primary: native, fallback: cli}
+		switch providerMode {
+		case ProviderNative:
+			defaultProvider = NewNativeGPG()
+		case ProviderCLI:
+			defaultProvider = NewCLIGPG()
+		default:
+			native := NewNativeGPG()
+			if fallbackEnabled {
+				defaultProvider = &fallbackProvider{primary: native, fallback: NewCLIGPG()}
+			} else if !native.hasPrivateKeys() && len(cliSecretKeyIDs()) > 0 {
+				defaultProvider = NewCLIGPG()
+			} else {
+				defaultProvider = native
+			}
+		}
+		if pubkeysDir != "" {
+			if err := defaultProvider.LoadCachedPublicKeys(pubkeysDir); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to load cached keys: %v\n", err)
+			}
+		}
 	}
 	return defaultProvider
 }
 
+// pubkeysDir is the project's public key cache, loaded into each provider
+// GetProvider creates.
+var pubkeysDir string
+
+// SetPubkeysDir makes GetProvider load the public keys cached in dir, so
+// every command can encrypt to vault users whose keys are not in the local
+// keyring. An empty dir loads none.
+func SetPubkeysDir(dir string) {
+	pubkeysDir = dir
+	defaultProvider = nil
+}
+
+// fallbackEnabled lets auto mode hand each operation the native provider
+// fails to the gpg CLI. Without it, auto mode picks one of them for the
+// whole run: the gpg CLI when only it has private keys, native otherwise.
+var fallbackEnabled = true
+
+// SetFallback enables or disables the per-operation fallback of auto mode.
+func SetFallback(enabled bool) {
+	if enabled != fallbackEnabled {
+		fallbackEnabled = enabled
+		defaultProvider = nil
+	}
+}
+
+// SetProviderMode selects the provider GetProvider returns from now on.
+func SetProviderMode(mode string) error {
+	switch mode {
+	case "", ProviderAuto:
+		mode = ProviderAuto
+	case ProviderNative, ProviderCLI:
+	default:
+		return fmt.Errorf("invalid provider %q (use auto, native, or cli)", mode)
+	}
+	if mode != providerMode {
+		providerMode = mode
+		defaultProvider = nil
+	}
+	return nil
+}
+
+func ProviderMode() string {
+	return providerMode
+}
+
+// NativeKeyring returns the native provider in use, or nil in cli mode.
+func NativeKeyring() *NativeGPG {
+	switch p := GetProvider().(type) {
+	case *NativeGPG:
+		return p
+	case *fallbackProvider:
+		if native, ok := p.primary.(*NativeGPG); ok {
+			return native
+		}
+	}
+	return nil
+}
+
+// BackendName names the implementation behind a provider: "native",
+// "gpg CLI", or "auto" for the fallback of auto mode.
+func BackendName(p GPGProvider) string {
+	switch p.(type) {
+	case *NativeGPG:
+		return "native"
+	case *CLIGPG:
+		return "gpg CLI"
+	case *fallbackProvider:
+		return "auto"
+	default:
+		return fmt.Sprintf("%T", p)
+	}
+}
+
+// ProviderLog describes which backend handled the operations of this run.
+// In auto mode with fallback, each kind of operation is listed with the
+// backend that handled it, how often, and why native failed if it did.
+func ProviderLog() []string {
+	switch p := defaultProvider.(type) {
+	case nil:
+		return nil
+	case *fallbackProvider:
+		lines := make([]string, len(p.log))
+		for i, e := range p.log {
+			lines[i] = e.String()
+		}
+		return lines
+	default:
+		why := "provider " + providerMode
+		if providerMode == ProviderAuto {
+			why += ", fallback disabled"
+		}
+		return []string{fmt.Sprintf("every operation: %s (%s)", BackendName(p), why)}
+	}
+}
+
 func SetProvider(p GPGProvider) {
 	defaultProvider = p
 }
@@ -50,51 +179,93 @@ func SetProvider(p GPGProvider) {
 type fallbackProvider struct {
 	primary  GPGProvider
 	fallback GPGProvider
+	log      []logEntry
+}
+
+type logEntry struct {
+	op      string
+	backend string
+	// reason is why native failed when the gpg CLI handled the operation.
+	reason string
+	count  int
+}
+
+func (e logEntry) String() string {
+	line := e.op + ": " + e.backend
+	if e.reason != "" {
+		line += " (native failed: " + e.reason + ")"
+	}
+	if e.count > 1 {
+		line += fmt.Sprintf(" x%d", e.count)
+	}
+	return line
+}
+
+// handled records that backend handled op; nativeErr is why native failed
+// when it was the gpg CLI.
+func (f *fallbackProvider) handled(op string, backend GPGProvider, nativeErr error) {
+	entry := logEntry{op: op, backend: BackendName(backend)}
+	if nativeErr != nil {
+		entry.reason = nativeErr.Error()
+	}
+	for i, e := range f.log {
+		if e.op == entry.op && e.backend == entry.backend && e.reason == entry.reason {
+			f.log[i].count++
+			return
+		}
+	}
+	entry.count = 1
+	f.log = append(f.log, entry)
 }
 
 func (f *fallbackProvider) LookupKey(email string) (*KeyInfo, error) {
 	key, err := f.primary.LookupKey(email)
-	if err == nil {
-		return key, nil
-	}
-	if !errors.Is(err, ErrKeyNotFound) {
-		return nil, err
+	if err == nil || !errors.Is(err, ErrKeyNotFound) {
+		f.handled("lookup "+email, f.primary, nil)
+		return key, err
 	}
+	f.handled("lookup "+email, f.fallback, err)
 	return f.fallback.LookupKey(email)
 }
 
 func (f *fallbackProvider) GetPublicKey(email string) ([]byte, error) {
 	key, err := f.primary.GetPublicKey(email)
 	if err == nil {
+		f.handled("export "+email, f.primary, nil)
 		return key, nil
 	}
+	f.handled("export "+email, f.fallback, err)
 	return f.fallback.GetPublicKey(email)
 }
 
 func (f *fallbackProvider) Encrypt(data []byte, recipients []string) ([]byte, error) {
 	result, err := f.primary.Encrypt(data, recipients)
 	if err == nil {
+		f.handled("encrypt", f.primary, nil)
 		return result, nil
 	}
+	f.handled("encrypt", f.fallback, err)
 	return f.fallback.Encrypt(data, recipients)
 }
 
 func (f *fallbackProvider) Decrypt(data []byte) ([]byte, error) {
 	result, err := f.primary.Decrypt(data)
-	if err == nil {
-		return result, nil
-	}
-	if errors.Is(err, ErrNoPrivateKey) {
-		return f.fallback.Decrypt(data)
+	// gpg can use its agent and pinentry for keys locked natively.
+	if err == nil || !(errors.Is(err, ErrNoPrivateKey) || errors.Is(err, ErrKeyLocked)) {
+		f.handled("decrypt", f.primary, nil)
+		return result, err
 	}
-	return nil, err
+	f.handled("decrypt", f.fallback, err)
+	return f.fallback.Decrypt(data)
 }
 
 func (f *fallbackProvider) ImportPublicKey(armoredKey []byte) (*KeyInfo, error) {
 	key, err := f.primary.ImportPublicKey(armoredKey)
 	if err == nil {
+		f.handled("import", f.primary, nil)
 		return key, nil
 	}
+	f.handled("import", f.fallback, err)
 	return f.fallback.ImportPublicKey(armoredKey)
 }
 
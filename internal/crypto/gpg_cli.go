@@ -3,8 +3,11 @@ package crypto
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,8 +18,51 @@ func NewCLIGPG() *CLIGPG {
 	return &CLIGPG{}
 }
 
+// CLIOptions configure how the gpg binary is invoked.
+type CLIOptions struct {
+	// Binary is the gpg executable; empty means "gpg" from PATH.
+	Binary string
+	// Args are passed before every gpg command, e.g. --homedir or
+	// --keyserver-options.
+	Args []string
+	// TrustModel is passed as --trust-model when encrypting. Empty leaves
+	// gpg's own configuration in charge.
+	TrustModel string
+}
+
+var cliOptions CLIOptions
+
+// SetCLIOptions configures every subsequent gpg invocation.
+func SetCLIOptions(opts CLIOptions) {
+	cliOptions = opts
+}
+
+// GPGBinary returns the configured gpg executable.
+func GPGBinary() string {
+	if cliOptions.Binary == "" {
+		return "gpg"
+	}
+	return cliOptions.Binary
+}
+
+// GPGVersion returns the first line of 'gpg --version'.
+func GPGVersion() (string, error) {
+	output, err := gpgCommand("--version").Output()
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(output), "\n")
+	return line, nil
+}
+
+func gpgCommand(args ...string) *exec.Cmd {
+	return exec.Command(GPGBinary(), append(append([]string{}, cliOptions.Args...), args...)...)
+}
+
+// LookupKey finds a key by a full user ID, such as "Alice <alice@example.com>",
+// which must match exactly, or by email.
 func (g *CLIGPG) LookupKey(email string) (*KeyInfo, error) {
-	cmd := exec.Command("gpg", "--list-keys", "--with-colons", "--with-fingerprint", email)
+	cmd := gpgCommand("--list-keys", "--with-colons", "--with-fingerprint", lookupQuery(email))
 	output, err := cmd.Output()
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
@@ -34,10 +80,11 @@ func (g *CLIGPG) LookupKey(email string) (*KeyInfo, error) {
 func (g *CLIGPG) parseKeyOutput(output, email string) (*KeyInfo, error) {
 	lines := strings.Split(output, "\n")
 
-	var keyID, fingerprint string
+	var keyID, fingerprint, uid string
 	var expiresAt *time.Time
 	var createdAt time.Time
 	isExpired := false
+	isRevoked := false
 
 	for _, line := range lines {
 		fields := strings.Split(line, ":")
@@ -67,10 +114,17 @@ func (g *CLIGPG) parseKeyOutput(output, email string) (*KeyInfo, error) {
 			if len(fields) >= 2 && fields[1] == "e" {
 				isExpired = true
 			}
+			if len(fields) >= 2 && fields[1] == "r" {
+				isRevoked = true
+			}
 		case "fpr":
 			if len(fields) >= 10 && fingerprint == "" {
 				fingerprint = fields[9]
 			}
+		case "uid":
+			if len(fields) >= 10 && uid == "" && uidMatches(unescapeColons(fields[9]), email) {
+				uid = unescapeColons(fields[9])
+			}
 		}
 	}
 
@@ -80,14 +134,54 @@ func (g *CLIGPG) parseKeyOutput(output, email string) (*KeyInfo, error) {
 
 	return &KeyInfo{
 		Email:       email,
+		UID:         uid,
 		KeyID:       keyID,
 		Fingerprint: fingerprint,
 		ExpiresAt:   expiresAt,
 		CreatedAt:   createdAt,
 		IsExpired:   isExpired,
+		IsRevoked:   isRevoked,
 	}, nil
 }
 
+// lookupQuery turns a full user ID into an exact-match gpg user ID
+// specification; emails are passed through.
+func lookupQuery(email string) string {
+	if strings.Contains(email, "<") {
+		return "=" + email
+	}
+	return email
+}
+
+// uidMatches reports whether a user ID is the one looked up: equal to a
+// full user ID query, or holding the queried email.
+func uidMatches(uid, query string) bool {
+	if strings.Contains(query, "<") {
+		return uid == query
+	}
+	uid, query = strings.ToLower(uid), strings.ToLower(query)
+	return uid == query || strings.Contains(uid, "<"+query+">")
+}
+
+// unescapeColons decodes the \xNN escapes gpg uses in --with-colons output.
+func unescapeColons(s string) string {
+	if !strings.Contains(s, `\x`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 func parseTimestamp(s string) (time.Time, error) {
 	if matched, _ := regexp.MatchString(`^\d+$`, s); matched {
 		var ts int64
@@ -98,7 +192,7 @@ func parseTimestamp(s string) (time.Time, error) {
 }
 
 func (g *CLIGPG) GetPublicKey(email string) ([]byte, error) {
-	cmd := exec.Command("gpg", "--export", "--armor", email)
+	cmd := gpgCommand("--export", "--armor", lookupQuery(email))
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to export public key: %w", err)
@@ -112,12 +206,15 @@ func (g *CLIGPG) GetPublicKey(email string) ([]byte, error) {
 }
 
 func (g *CLIGPG) Encrypt(data []byte, recipients []string) ([]byte, error) {
-	args := []string{"--encrypt", "--armor", "--trust-model", "always"}
+	args := []string{"--encrypt", "--armor"}
+	if cliOptions.TrustModel != "" {
+		args = append(args, "--trust-model", cliOptions.TrustModel)
+	}
 	for _, r := range recipients {
 		args = append(args, "--recipient", r)
 	}
 
-	cmd := exec.Command("gpg", args...)
+	cmd := gpgCommand(args...)
 	cmd.Stdin = bytes.NewReader(data)
 
 	var stdout, stderr bytes.Buffer
@@ -125,6 +222,9 @@ func (g *CLIGPG) Encrypt(data []byte, recipients []string) ([]byte, error) {
 	cmd.Stderr = &stderr
 
 	if err := cmd.Run(); err != nil {
+		if strings.Contains(stderr.String(), "no assurance") || strings.Contains(stderr.String(), "unusable public key") {
+			return nil, fmt.Errorf("gpg encrypt failed: %s(gpg does not consider a recipient key valid; certify it with 'gpg --lsign-key', or set gpg_trust_model to opt out of the check)", stderr.String())
+		}
 		return nil, fmt.Errorf("gpg encrypt failed: %s", stderr.String())
 	}
 
@@ -132,14 +232,36 @@ func (g *CLIGPG) Encrypt(data []byte, recipients []string) ([]byte, error) {
 }
 
 func (g *CLIGPG) Decrypt(data []byte) ([]byte, error) {
-	cmd := exec.Command("gpg", "--decrypt", "--quiet", "--batch")
+	// Status lines go to a pipe of their own, so the key that decrypted can
+	// be recorded without mixing them into error messages.
+	statusR, statusW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create status pipe: %w", err)
+	}
+	defer statusR.Close()
+
+	cmd := gpgCommand("--decrypt", "--quiet", "--batch", "--status-fd", "3")
 	cmd.Stdin = bytes.NewReader(data)
+	cmd.ExtraFiles = []*os.File{statusW}
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	if err := cmd.Run(); err != nil {
+	status := make(chan []byte, 1)
+	go func() {
+		out, _ := io.ReadAll(statusR)
+		status <- out
+	}()
+
+	err = cmd.Start()
+	statusW.Close()
+	if err == nil {
+		err = cmd.Wait()
+	}
+	statusOut := <-status
+
+	if err != nil {
 		errStr := stderr.String()
 		if strings.Contains(errStr, "No secret key") {
 			return nil, ErrNoPrivateKey
@@ -147,11 +269,70 @@ func (g *CLIGPG) Decrypt(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("gpg decrypt failed: %s", errStr)
 	}
 
+	if key, ok := parseDecryptionKeyStatus(string(statusOut)); ok {
+		noteDecryptionKey(key)
+	}
 	return stdout.Bytes(), nil
 }
 
+// parseDecryptionKeyStatus reads the key that decrypted from gpg's
+// "DECRYPTION_KEY <fingerprint> <primary fingerprint> <trust>" status line.
+func parseDecryptionKeyStatus(status string) (DecryptionKey, bool) {
+	for _, line := range strings.Split(status, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != "[GNUPG:]" || fields[1] != "DECRYPTION_KEY" {
+			continue
+		}
+		key := DecryptionKey{KeyID: LongKeyID(fields[2]), PrimaryKeyID: LongKeyID(fields[3])}
+		key.Email = cliKeyEmail(fields[3])
+		return key, true
+	}
+	return DecryptionKey{}, false
+}
+
+// cliKeyEmail returns the email of the first user ID of a key in the gpg
+// keyring, or "" when it cannot be listed.
+func cliKeyEmail(fingerprint string) string {
+	output, err := gpgCommand("--list-keys", "--with-colons", fingerprint).Output()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) <= 9 || fields[0] != "uid" {
+			continue
+		}
+		uid := fields[9]
+		if start, end := strings.Index(uid, "<"), strings.LastIndex(uid, ">"); start >= 0 && end > start {
+			return uid[start+1 : end]
+		}
+		if strings.Contains(uid, "@") {
+			return uid
+		}
+	}
+	return ""
+}
+
+// cliSecretKeyIDs returns the long key IDs of the keys and subkeys in the
+// gpg secret keyring, or nothing when gpg cannot be run.
+func cliSecretKeyIDs() []string {
+	output, err := gpgCommand("--list-secret-keys", "--with-colons").Output()
+	if err != nil {
+		return nil
+	}
+
+	var ids []string
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) >= 5 && (fields[0] == "sec" || fields[0] == "ssb") {
+			ids = append(ids, fields[4])
+		}
+	}
+	return ids
+}
+
 func (g *CLIGPG) ImportPublicKey(armoredKey []byte) (*KeyInfo, error) {
-	cmd := exec.Command("gpg", "--import")
+	cmd := gpgCommand("--import")
 	cmd.Stdin = bytes.NewReader(armoredKey)
 
 	var stderr bytes.Buffer
@@ -175,3 +356,21 @@ func (g *CLIGPG) LoadCachedPublicKeys(dirPath string) error {
 	// CLIGPG uses system keyring; avoid modifying it with cached keys
 	return nil
 }
+
+// ReceiveKeys fetches the keys with the given fingerprints from a keyserver
+// into the gpg keyring, picking up extended expiry dates, new subkeys and
+// revocations. The provider is reset so later lookups see the fetched keys.
+func ReceiveKeys(keyserver string, fingerprints []string) error {
+	args := append([]string{"--batch", "--keyserver", keyserver, "--recv-keys"}, fingerprints...)
+	cmd := gpgCommand(args...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	defaultProvider = nil
+	if err != nil {
+		return fmt.Errorf("gpg --recv-keys failed: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
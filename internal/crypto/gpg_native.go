@@ -13,11 +13,21 @@ import (
 
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
 	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
 type NativeGPG struct {
 	keyring openpgp.EntityList
+	sources []KeyringSource
+}
+
+// KeyringSource records a keyring file the native provider tried to read.
+type KeyringSource struct {
+	Path        string
+	Keys        int
+	PrivateKeys int
+	Error       string
 }
 
 func NewNativeGPG() *NativeGPG {
@@ -26,15 +36,31 @@ func NewNativeGPG() *NativeGPG {
 	return gpg
 }
 
-func (g *NativeGPG) loadKeyring() {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return
+// Sources returns the keyring files read at startup, for diagnostics.
+func (g *NativeGPG) Sources() []KeyringSource {
+	return g.sources
+}
+
+// hasPrivateKeys reports whether any key or subkey in the keyring has its
+// private part.
+func (g *NativeGPG) hasPrivateKeys() bool {
+	for _, entity := range g.keyring {
+		if entity.PrivateKey != nil {
+			return true
+		}
+		for _, sk := range entity.Subkeys {
+			if sk.PrivateKey != nil {
+				return true
+			}
+		}
 	}
+	return false
+}
 
-	gnupgHome := os.Getenv("GNUPGHOME")
+func (g *NativeGPG) loadKeyring() {
+	gnupgHome := GnuPGHome()
 	if gnupgHome == "" {
-		gnupgHome = filepath.Join(home, ".gnupg")
+		return
 	}
 
 	pubringPath := filepath.Join(gnupgHome, "pubring.kbx")
@@ -44,44 +70,116 @@ func (g *NativeGPG) loadKeyring() {
 
 	pubFile, err := os.Open(pubringPath)
 	if err != nil {
+		g.sources = append(g.sources, KeyringSource{Path: pubringPath, Error: err.Error()})
 		return
 	}
 	defer pubFile.Close()
 
-	keyring, _ := openpgp.ReadKeyRing(pubFile)
+	keyring, err := openpgp.ReadKeyRing(pubFile)
 	if keyring != nil {
 		g.keyring = keyring
 	}
+	g.sources = append(g.sources, newKeyringSource(pubringPath, keyring, err))
 
 	secringPath := filepath.Join(gnupgHome, "secring.gpg")
 	secFile, err := os.Open(secringPath)
 	if err == nil {
 		defer secFile.Close()
-		secring, _ := openpgp.ReadKeyRing(secFile)
+		secring, err := openpgp.ReadKeyRing(secFile)
 		if secring != nil {
 			g.keyring = append(g.keyring, secring...)
 		}
+		g.sources = append(g.sources, newKeyringSource(secringPath, secring, err))
 	}
 
 	privateKeysDir := filepath.Join(gnupgHome, "private-keys-v1.d")
 	if info, err := os.Stat(privateKeysDir); err == nil && info.IsDir() {
 		// Modern GnuPG uses keybox format; we may not be able to read all keys
 		// Fall back to CLI for these cases
+		g.sources = append(g.sources, KeyringSource{Path: privateKeysDir, Error: "GnuPG 2.1+ private keys are only usable through the gpg CLI"})
 	}
 }
 
+func newKeyringSource(path string, keyring openpgp.EntityList, err error) KeyringSource {
+	src := KeyringSource{Path: path, Keys: len(keyring)}
+	for _, e := range keyring {
+		if e.PrivateKey != nil {
+			src.PrivateKeys++
+		}
+	}
+	if err != nil {
+		src.Error = err.Error()
+		if strings.HasSuffix(path, ".kbx") {
+			src.Error += " (keybox format is only usable through the gpg CLI)"
+		}
+	}
+	return src
+}
+
+// LookupKey finds a key by a full user ID, such as "Alice <alice@example.com>",
+// or by email. Exact user ID matches are preferred over email matches.
 func (g *NativeGPG) LookupKey(email string) (*KeyInfo, error) {
-	email = strings.ToLower(email)
+	matches := g.matchKeys(email)
+	if len(matches) == 0 {
+		return nil, ErrKeyNotFound
+	}
 
-	for _, entity := range g.keyring {
-		for _, ident := range entity.Identities {
-			if ident.UserId != nil && strings.ToLower(ident.UserId.Email) == email {
-				return g.entityToKeyInfo(entity, email)
+	m := matches[0]
+	info, err := g.entityToKeyInfo(m.entity, strings.ToLower(m.email))
+	if err != nil {
+		return nil, err
+	}
+	info.UID = m.uid
+	return info, nil
+}
+
+// keyMatch is a keyring key matched by a lookup, with the user ID that
+// matched.
+type keyMatch struct {
+	entity *openpgp.Entity
+	uid    string
+	email  string
+}
+
+// matchKeys returns the keyring keys with a user ID equal to query or, if
+// there are none, the keys with a user ID whose email is query.
+func (g *NativeGPG) matchKeys(query string) []keyMatch {
+	for _, exact := range []bool{true, false} {
+		var matches []keyMatch
+		for _, entity := range g.keyring {
+			if ident := matchIdentity(entity, query, exact); ident != nil {
+				matches = append(matches, keyMatch{entity: entity, uid: ident.Name, email: ident.UserId.Email})
 			}
 		}
+		if len(matches) > 0 {
+			return matches
+		}
 	}
+	return nil
+}
 
-	return nil, ErrKeyNotFound
+// matchIdentity returns the identity of entity whose user ID is query, or
+// with exact unset, whose email is query. Of several identities with the
+// email, the primary one is preferred, then the first by user ID.
+func matchIdentity(entity *openpgp.Entity, query string, exact bool) *openpgp.Identity {
+	if exact {
+		if ident, ok := entity.Identities[query]; ok && ident.UserId != nil {
+			return ident
+		}
+		return nil
+	}
+
+	email := strings.ToLower(query)
+	if primary := entity.PrimaryIdentity(); primary != nil && primary.UserId != nil && strings.ToLower(primary.UserId.Email) == email {
+		return primary
+	}
+	var match *openpgp.Identity
+	for name, ident := range entity.Identities {
+		if ident.UserId != nil && strings.ToLower(ident.UserId.Email) == email && (match == nil || name < match.Name) {
+			match = ident
+		}
+	}
+	return match
 }
 
 func (g *NativeGPG) entityToKeyInfo(entity *openpgp.Entity, email string) (*KeyInfo, error) {
@@ -93,7 +191,8 @@ func (g *NativeGPG) entityToKeyInfo(entity *openpgp.Entity, email string) (*KeyI
 	isExpired := false
 
 	for _, ident := range entity.Identities {
-		if ident.SelfSignature != nil && ident.SelfSignature.KeyLifetimeSecs != nil {
+		// A lifetime of zero means the key does not expire.
+		if ident.SelfSignature != nil && ident.SelfSignature.KeyLifetimeSecs != nil && *ident.SelfSignature.KeyLifetimeSecs > 0 {
 			expiry := pk.CreationTime.Add(time.Duration(*ident.SelfSignature.KeyLifetimeSecs) * time.Second)
 			expiresAt = &expiry
 			if expiry.Before(time.Now()) {
@@ -121,6 +220,7 @@ func (g *NativeGPG) entityToKeyInfo(entity *openpgp.Entity, email string) (*KeyI
 		ExpiresAt:   expiresAt,
 		CreatedAt:   pk.CreationTime,
 		IsExpired:   isExpired,
+		IsRevoked:   entity.Revoked(time.Now()),
 		PublicKey:   pubKeyBuf.Bytes(),
 	}, nil
 }
@@ -137,25 +237,11 @@ func (g *NativeGPG) Encrypt(data []byte, recipients []string) ([]byte, error) {
 	var entities []*openpgp.Entity
 
 	for _, email := range recipients {
-		email = strings.ToLower(email)
-		found := false
-
-		for _, entity := range g.keyring {
-			for _, ident := range entity.Identities {
-				if ident.UserId != nil && strings.ToLower(ident.UserId.Email) == email {
-					entities = append(entities, entity)
-					found = true
-					break
-				}
-			}
-			if found {
-				break
-			}
-		}
-
-		if !found {
-			return nil, fmt.Errorf("key not found for recipient: %s", email)
+		key, err := g.encryptionKey(email)
+		if err != nil {
+			return nil, err
 		}
+		entities = append(entities, key.Entity)
 	}
 
 	if len(entities) == 0 {
@@ -174,7 +260,10 @@ func (g *NativeGPG) Encrypt(data []byte, recipients []string) ([]byte, error) {
 		DefaultCompressionAlgo: packet.CompressionZLIB,
 	}
 
-	plainWriter, err := openpgp.Encrypt(armorWriter, entities, nil, nil, config)
+	// Marked binary, so the gpg CLI does not convert line endings of the
+	// plaintext, or of compressed full-mode data, when decrypting it.
+	hints := &openpgp.FileHints{IsBinary: true}
+	plainWriter, err := openpgp.Encrypt(armorWriter, entities, nil, hints, config)
 	if err != nil {
 		armorWriter.Close()
 		return nil, fmt.Errorf("failed to create encrypt writer: %w", err)
@@ -198,6 +287,57 @@ func (g *NativeGPG) Encrypt(data []byte, recipients []string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// encryptionKey picks the key to encrypt to for a recipient. Of the keyring
+// keys matching the recipient, as in LookupKey, it takes the one whose encryption key is
+// newest, where each key's encryption key is its newest valid encryption
+// subkey, or its primary key if that may encrypt. Revoked and expired keys,
+// sign-only keys and keys whose encryption subkeys have all expired or been
+// revoked are skipped.
+func (g *NativeGPG) encryptionKey(email string) (openpgp.Key, error) {
+	now := time.Now()
+	var best openpgp.Key
+	found := false
+	reason := ""
+
+	for _, m := range g.matchKeys(email) {
+		found = true
+		key, ok := m.entity.EncryptionKey(now)
+		if !ok {
+			reason = unusableReason(m.entity, now)
+			continue
+		}
+		if best.PublicKey == nil || key.PublicKey.CreationTime.After(best.PublicKey.CreationTime) {
+			best = key
+		}
+	}
+
+	switch {
+	case best.PublicKey != nil:
+		return best, nil
+	case found:
+		return openpgp.Key{}, fmt.Errorf("no usable encryption key for recipient %s: %s", email, reason)
+	default:
+		return openpgp.Key{}, fmt.Errorf("key not found for recipient: %s", email)
+	}
+}
+
+// unusableReason explains why a key has no encryption key.
+func unusableReason(entity *openpgp.Entity, now time.Time) string {
+	ident := entity.PrimaryIdentity()
+	switch {
+	case entity.Revoked(now):
+		return "key is revoked"
+	case ident == nil || ident.SelfSignature == nil:
+		return "key has no self-signed user ID"
+	case entity.PrimaryKey.KeyExpired(ident.SelfSignature, now) || ident.SelfSignature.SigExpired(now):
+		return "key has expired"
+	case ident.Revoked(now):
+		return "user ID is revoked"
+	default:
+		return "key has no valid encryption subkey (it is sign-only, or its encryption subkeys expired or were revoked)"
+	}
+}
+
 func (g *NativeGPG) Decrypt(data []byte) ([]byte, error) {
 	block, err := armor.Decode(bytes.NewReader(data))
 	if err != nil {
@@ -215,7 +355,14 @@ func (g *NativeGPG) Decrypt(data []byte) ([]byte, error) {
 		return nil, ErrNoPrivateKey
 	}
 
-	md, err := openpgp.ReadMessage(block.Body, privateKeys, nil, nil)
+	md, err := openpgp.ReadMessage(block.Body, privateKeys, unlockPrompt(), nil)
+	if errors.Is(err, ErrKeyLocked) {
+		return nil, err
+	}
+	if errors.Is(err, pgperrors.ErrKeyIncorrect) {
+		// None of our keys is a recipient, as the CLI's "No secret key".
+		return nil, ErrNoPrivateKey
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to read encrypted message: %w", err)
 	}
@@ -224,6 +371,9 @@ func (g *NativeGPG) Decrypt(data []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read plaintext: %w", err)
 	}
+	if md.DecryptedWith.PublicKey != nil {
+		noteDecryptionKey(nativeDecryptionKey(md.DecryptedWith))
+	}
 
 	return plaintext, nil
 }
@@ -295,3 +445,60 @@ func (g *NativeGPG) LoadCachedPublicKeys(dirPath string) error {
 
 	return nil
 }
+
+// PublicKeyDetails describes the parts of a public key that change when it
+// is renewed or extended: expiry, revocation and subkeys.
+type PublicKeyDetails struct {
+	Fingerprint string
+	ExpiresAt   *time.Time
+	IsRevoked   bool
+	// Subkeys maps each subkey fingerprint to whether it is usable, i.e.
+	// neither expired nor revoked.
+	Subkeys map[string]bool
+}
+
+// ParsePublicKey reads the details of the first key in an armored public
+// key. Unlike the armored bytes, which depend on the tool that exported
+// them, the details can be compared.
+func ParsePublicKey(armoredKey []byte) (*PublicKeyDetails, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, ErrInvalidKey
+	}
+
+	entity := entities[0]
+	info, err := (&NativeGPG{}).entityToKeyInfo(entity, "")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	details := &PublicKeyDetails{
+		Fingerprint: info.Fingerprint,
+		ExpiresAt:   info.ExpiresAt,
+		IsRevoked:   info.IsRevoked,
+		Subkeys:     make(map[string]bool),
+	}
+	for _, sk := range entity.Subkeys {
+		usable := !sk.Revoked(now) && !sk.PublicKey.KeyExpired(sk.Sig, now)
+		details.Subkeys[fmt.Sprintf("%X", sk.PublicKey.Fingerprint)] = usable
+	}
+	return details, nil
+}
+
+// EncryptToKey encrypts data to recipient using only the given armored
+// public key, which is never added to a keyring. It is for one-off
+// recipients outside every vault.
+func EncryptToKey(data, armoredKey []byte, recipient string) ([]byte, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, ErrInvalidKey
+	}
+	return (&NativeGPG{keyring: entities}).Encrypt(data, []string{recipient})
+}
@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// NoKeyError is returned, wrapping ErrNoPrivateKey, when none of the local
+// private keys can decrypt a message. It tells which keys could.
+type NoKeyError struct {
+	// EncryptedFor lists the long key IDs the message was encrypted to.
+	EncryptedFor []string
+	// Available lists the long key IDs of the local private keys.
+	Available []string
+}
+
+func (e *NoKeyError) Error() string {
+	return fmt.Sprintf("%v (%s)", ErrNoPrivateKey, e.Hint())
+}
+
+func (e *NoKeyError) Unwrap() error {
+	return ErrNoPrivateKey
+}
+
+// Hint describes the keys the message was encrypted for, with their owners
+// when the native keyring knows them, and the private keys available
+// locally.
+func (e *NoKeyError) Hint() string {
+	encryptedFor := "unknown keys"
+	if len(e.EncryptedFor) > 0 {
+		described := make([]string, len(e.EncryptedFor))
+		for i, id := range e.EncryptedFor {
+			described[i] = id
+			if owner := keyOwner(id); owner != "" {
+				described[i] += " (" + owner + ")"
+			}
+		}
+		encryptedFor = strings.Join(described, ", ")
+	}
+	available := "none"
+	if len(e.Available) > 0 {
+		available = strings.Join(e.Available, ", ")
+	}
+	return fmt.Sprintf("encrypted for %s; local private keys: %s", encryptedFor, available)
+}
+
+// withKeyHint turns an ErrNoPrivateKey from decrypting message into a
+// NoKeyError. Other errors are returned unchanged.
+func withKeyHint(err error, message []byte) error {
+	if !errors.Is(err, ErrNoPrivateKey) {
+		return err
+	}
+	var hint *NoKeyError
+	if errors.As(err, &hint) {
+		return err
+	}
+
+	return &NoKeyError{EncryptedFor: MessageKeyIDs(message), Available: LocalPrivateKeyIDs()}
+}
+
+// MessageKeyIDs returns the long key IDs an OpenPGP message, armored or
+// not, was encrypted to. Recipients hidden with a zero key ID are reported
+// as "anonymous".
+func MessageKeyIDs(message []byte) []string {
+	var r io.Reader = bytes.NewReader(message)
+	if IsArmored(message) {
+		block, err := armor.Decode(r)
+		if err != nil {
+			return nil
+		}
+		r = block.Body
+	}
+
+	var ids []string
+	packets := packet.NewReader(r)
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return ids
+		}
+		key, ok := p.(*packet.EncryptedKey)
+		if !ok {
+			// Encrypted session keys all precede the encrypted data.
+			return ids
+		}
+		if key.KeyId == 0 {
+			ids = append(ids, "anonymous")
+		} else {
+			ids = append(ids, fmt.Sprintf("%016X", key.KeyId))
+		}
+	}
+}
+
+// LocalPrivateKeyIDs returns the long key IDs, subkeys included, of the
+// private keys the provider in use can decrypt with.
+func LocalPrivateKeyIDs() []string {
+	seen := make(map[string]bool)
+	if native := NativeKeyring(); native != nil {
+		for _, entity := range native.keyring {
+			if entity.PrivateKey != nil {
+				seen[fmt.Sprintf("%016X", entity.PrimaryKey.KeyId)] = true
+			}
+			for _, sk := range entity.Subkeys {
+				if sk.PrivateKey != nil {
+					seen[fmt.Sprintf("%016X", sk.PublicKey.KeyId)] = true
+				}
+			}
+		}
+	}
+	if _, native := GetProvider().(*NativeGPG); !native {
+		for _, id := range cliSecretKeyIDs() {
+			seen[id] = true
+		}
+	}
+
+	ids := make([]string, 0, len(seen))
+	for id := range seen {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// keyOwner returns the user ID of the key or subkey with a long key ID, if
+// the native keyring, which includes cached public keys, knows it.
+func keyOwner(id string) string {
+	native := NativeKeyring()
+	if native == nil {
+		return ""
+	}
+	for _, entity := range native.keyring {
+		match := fmt.Sprintf("%016X", entity.PrimaryKey.KeyId) == id
+		for _, sk := range entity.Subkeys {
+			match = match || fmt.Sprintf("%016X", sk.PublicKey.KeyId) == id
+		}
+		if match {
+			if ident := entity.PrimaryIdentity(); ident != nil {
+				return ident.Name
+			}
+			return ""
+		}
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Key algorithms accepted by GenerateKey.
+var KeyAlgorithms = []string{"ed25519", "rsa3072", "rsa4096"}
+
+// GenerateKey creates an OpenPGP key pair for name and email. lifetime of
+// zero means the key never expires.
+func GenerateKey(name, email, algo string, lifetime time.Duration) (*openpgp.Entity, error) {
+	cfg := &packet.Config{
+		DefaultHash:     crypto.SHA256,
+		KeyLifetimeSecs: uint32(lifetime.Seconds()),
+	}
+
+	switch algo {
+	case "ed25519":
+		cfg.Algorithm = packet.PubKeyAlgoEdDSA
+		cfg.Curve = packet.Curve25519
+	case "rsa3072":
+		cfg.Algorithm = packet.PubKeyAlgoRSA
+		cfg.RSABits = 3072
+	case "rsa4096":
+		cfg.Algorithm = packet.PubKeyAlgoRSA
+		cfg.RSABits = 4096
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm %q", algo)
+	}
+
+	entity, err := openpgp.NewEntity(name, "", email, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return entity, nil
+}
+
+// ArmorPublicKey returns the ASCII-armored public key of an entity.
+func ArmorPublicKey(entity *openpgp.Entity) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := entity.Serialize(w); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ArmorPrivateKey returns the ASCII-armored, unprotected private key of an
+// entity.
+func ArmorPrivateKey(entity *openpgp.Entity) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := entity.SerializePrivate(w, nil); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// StorePrivateKey adds a generated key pair to the local keyring and
+// returns a description of where it went. It is imported with the gpg CLI
+// when available, and otherwise appended to secring.gpg and pubring.gpg in
+// the GnuPG home, which the native provider reads.
+func StorePrivateKey(entity *openpgp.Entity) (string, error) {
+	armored, err := ArmorPrivateKey(entity)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := exec.LookPath(GPGBinary()); err == nil {
+		cmd := gpgCommand("--batch", "--import")
+		cmd.Stdin = bytes.NewReader(armored)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("gpg import failed: %s", stderr.String())
+		}
+		return "GnuPG keyring", nil
+	}
+
+	home := GnuPGHome()
+	if home == "" {
+		return "", fmt.Errorf("cannot determine GnuPG home directory")
+	}
+	if err := os.MkdirAll(home, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", home, err)
+	}
+
+	var secret, public bytes.Buffer
+	if err := entity.SerializePrivate(&secret, nil); err != nil {
+		return "", err
+	}
+	if err := entity.Serialize(&public); err != nil {
+		return "", err
+	}
+
+	secring := filepath.Join(home, "secring.gpg")
+	if err := appendFile(secring, secret.Bytes()); err != nil {
+		return "", err
+	}
+	if err := appendFile(filepath.Join(home, "pubring.gpg"), public.Bytes()); err != nil {
+		return "", err
+	}
+	return secring, nil
+}
+
+// GnuPGHome returns $GNUPGHOME, or ~/.gnupg when it is unset.
+func GnuPGHome() string {
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		return home
+	}
+	userHome, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(userHome, ".gnupg")
+}
+
+func appendFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
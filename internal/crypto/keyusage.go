@@ -0,0 +1,103 @@
+package crypto
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// DecryptionKey identifies the private key or subkey that decrypted a
+// message.
+type DecryptionKey struct {
+	// KeyID is the long ID of the key or subkey that decrypted.
+	KeyID string
+	// PrimaryKeyID is the long ID of its primary key; equal to KeyID when
+	// the primary key decrypted.
+	PrimaryKeyID string
+	// Email is the key's primary user ID email, when known.
+	Email string
+}
+
+func (k DecryptionKey) String() string {
+	s := k.KeyID
+	if k.PrimaryKeyID != "" && k.PrimaryKeyID != k.KeyID {
+		s += " (subkey of " + k.PrimaryKeyID + ")"
+	}
+	if k.Email != "" {
+		s = k.Email + " " + s
+	}
+	return s
+}
+
+// DecryptionRecorder is told which keys decrypted something: the file name
+// passed to DecryptFileContent, or "value" for ENC tokens decrypted on
+// their own.
+type DecryptionRecorder func(what string, keys []DecryptionKey)
+
+var keyUsage struct {
+	sync.Mutex
+	recorder DecryptionRecorder
+	depth    int
+	keys     []DecryptionKey
+}
+
+// SetDecryptionRecorder sets what is told about each successful decryption,
+// for auditing key use. nil disables recording.
+func SetDecryptionRecorder(r DecryptionRecorder) {
+	keyUsage.Lock()
+	defer keyUsage.Unlock()
+	keyUsage.recorder = r
+}
+
+// trackKeyUsage starts collecting the keys that providers decrypt with. The
+// returned function ends the collection and, for the outermost one only,
+// reports the keys for what unless the decryption failed or used none.
+func trackKeyUsage() func(what string, err error) {
+	keyUsage.Lock()
+	if keyUsage.depth == 0 {
+		keyUsage.keys = nil
+	}
+	keyUsage.depth++
+	keyUsage.Unlock()
+
+	return func(what string, err error) {
+		keyUsage.Lock()
+		keyUsage.depth--
+		if keyUsage.depth > 0 {
+			keyUsage.Unlock()
+			return
+		}
+		recorder, keys := keyUsage.recorder, keyUsage.keys
+		keyUsage.keys = nil
+		keyUsage.Unlock()
+
+		if err == nil && recorder != nil && len(keys) > 0 {
+			recorder(what, keys)
+		}
+	}
+}
+
+// noteDecryptionKey records that a provider decrypted with key.
+func noteDecryptionKey(key DecryptionKey) {
+	keyUsage.Lock()
+	defer keyUsage.Unlock()
+	for _, k := range keyUsage.keys {
+		if k == key {
+			return
+		}
+	}
+	keyUsage.keys = append(keyUsage.keys, key)
+}
+
+// nativeDecryptionKey describes the key the native provider decrypted with.
+func nativeDecryptionKey(key openpgp.Key) DecryptionKey {
+	k := DecryptionKey{KeyID: fmt.Sprintf("%016X", key.PublicKey.KeyId)}
+	if key.Entity != nil {
+		k.PrimaryKeyID = fmt.Sprintf("%016X", key.Entity.PrimaryKey.KeyId)
+		if ident := key.Entity.PrimaryIdentity(); ident != nil && ident.UserId != nil {
+			k.Email = ident.UserId.Email
+		}
+	}
+	return k
+}
@@ -0,0 +1,104 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// PassphraseEnv supplies the passphrase of locked private keys to the native
+// provider without a prompt, e.g. in CI.
+const PassphraseEnv = "SHHH_PASSPHRASE"
+
+// ErrKeyLocked is returned when the private key that could decrypt a
+// message is passphrase-protected and no passphrase unlocked it.
+var ErrKeyLocked = errors.New("private key is passphrase-protected")
+
+// maxPassphraseAttempts is how often a wrong passphrase may be entered for
+// one message before giving up.
+const maxPassphraseAttempts = 3
+
+// PassphrasePrompt asks for the passphrase of the private key described by
+// key, its user ID and key ID.
+type PassphrasePrompt func(key string) ([]byte, error)
+
+var (
+	passphrasePrompt PassphrasePrompt
+
+	// passphrases holds the passphrases that unlocked a key in this
+	// process, so keys sharing one are unlocked without asking again.
+	// Unlocked keys stay unlocked in the keyring.
+	passphrasesMu sync.Mutex
+	passphrases   [][]byte
+)
+
+// SetPassphrasePrompt sets how the native provider asks for passphrases. A
+// nil prompt leaves SHHH_PASSPHRASE as the only source.
+func SetPassphrasePrompt(p PassphrasePrompt) {
+	passphrasePrompt = p
+}
+
+// unlockPrompt returns the prompt function used to decrypt a message. It
+// tries SHHH_PASSPHRASE and the passphrases that worked before on every
+// locked candidate key, then asks for the passphrase of the first one.
+func unlockPrompt() openpgp.PromptFunction {
+	attempts := 0
+	return func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		if len(keys) == 0 {
+			return nil, ErrKeyLocked
+		}
+
+		known := knownPassphrases()
+		for _, k := range keys {
+			for _, p := range known {
+				if k.PrivateKey.Decrypt(p) == nil {
+					return nil, nil
+				}
+			}
+		}
+
+		if passphrasePrompt == nil {
+			return nil, fmt.Errorf("%w (set %s, or use the gpg CLI and its agent)", ErrKeyLocked, PassphraseEnv)
+		}
+		if attempts == maxPassphraseAttempts {
+			return nil, fmt.Errorf("%w (wrong passphrase)", ErrKeyLocked)
+		}
+		attempts++
+
+		key := keys[0]
+		passphrase, err := passphrasePrompt(describeKey(key))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrKeyLocked, err)
+		}
+		if key.PrivateKey.Decrypt(passphrase) == nil {
+			passphrasesMu.Lock()
+			passphrases = append(passphrases, passphrase)
+			passphrasesMu.Unlock()
+		}
+		// Called again: decrypts with the key if it is unlocked now, or
+		// asks again.
+		return nil, nil
+	}
+}
+
+func knownPassphrases() [][]byte {
+	passphrasesMu.Lock()
+	defer passphrasesMu.Unlock()
+
+	known := append([][]byte{}, passphrases...)
+	if env := os.Getenv(PassphraseEnv); env != "" {
+		known = append(known, []byte(env))
+	}
+	return known
+}
+
+func describeKey(key openpgp.Key) string {
+	desc := fmt.Sprintf("key %X", key.PublicKey.KeyId)
+	if ident := key.Entity.PrimaryIdentity(); ident != nil {
+		desc = fmt.Sprintf("%s, %s", ident.Name, desc)
+	}
+	return desc
+}
@@ -0,0 +1,173 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Metadata privacy levels for the vault and recipients written into
+// encrypted files.
+const (
+	PrivacyNone = "none"
+	PrivacyHash = "hash"
+	PrivacyOmit = "omit"
+)
+
+const hashPrefix = "sha256:"
+
+// Values of the encrypted_at setting, which controls how the time of
+// encryption is recorded in metadata.
+const (
+	TimestampPrecise = "precise"
+	TimestampDay     = "day"
+	TimestampOmit    = "omit"
+	TimestampCounter = "counter"
+)
+
+// metadataTimestamp returns the encryption time to record for the given
+// setting, or "" when none is recorded. Day granularity is in UTC so every
+// encryption on the same day writes the same value.
+func metadataTimestamp(opts EncryptOptions) string {
+	return formatTimestamp(opts, time.Now())
+}
+
+func formatTimestamp(opts EncryptOptions, t time.Time) string {
+	switch opts.Timestamp {
+	case TimestampDay:
+		return t.UTC().Truncate(24 * time.Hour).Format(time.RFC3339)
+	case TimestampOmit, TimestampCounter:
+		return ""
+	default:
+		return t.Format(time.RFC3339)
+	}
+}
+
+// formatRotations records key rotation times as "key=time" pairs sorted
+// by key, or "" when there are none or times are not recorded.
+func formatRotations(opts EncryptOptions) string {
+	keys := make([]string, 0, len(opts.Rotations))
+	for k := range opts.Rotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		if at := formatTimestamp(opts, opts.Rotations[k]); at != "" {
+			pairs = append(pairs, k+"="+at)
+		}
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func parseRotations(value string) map[string]time.Time {
+	rotations := make(map[string]time.Time)
+	for _, pair := range strings.Split(value, ",") {
+		i := strings.LastIndex(pair, "=")
+		if i == -1 {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, strings.TrimSpace(pair[i+1:])); err == nil {
+			rotations[strings.TrimSpace(pair[:i])] = t
+		}
+	}
+	return rotations
+}
+
+// hashIdentifier hashes a vault name or recipient, salted with the file path
+// so the same email cannot be correlated across files or looked up in a
+// precomputed table.
+func hashIdentifier(value, filename string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(filename) + "\x00" + strings.ToLower(strings.TrimSpace(value))))
+	return hashPrefix + hex.EncodeToString(sum[:8])
+}
+
+// metadataIdentifiers returns the vault and recipients as they should be
+// recorded for the given privacy level. Recipients are sorted, so the order
+// they were configured in does not show up as a change in the .enc.
+func metadataIdentifiers(opts EncryptOptions, filename string) (string, []string) {
+	switch opts.MetadataPrivacy {
+	case PrivacyHash:
+		recipients := make([]string, len(opts.Recipients))
+		for i, r := range opts.Recipients {
+			recipients[i] = hashIdentifier(r, filename)
+		}
+		sort.Strings(recipients)
+		return hashIdentifier(opts.Vault, filename), recipients
+	case PrivacyOmit:
+		return "", nil
+	default:
+		recipients := append([]string{}, opts.Recipients...)
+		sort.Strings(recipients)
+		return opts.Vault, recipients
+	}
+}
+
+// MatchesVault reports whether the metadata was written for the named vault,
+// whether it was recorded in plain text or hashed.
+func (m *FileMetadata) MatchesVault(name, filename string) bool {
+	if strings.HasPrefix(m.Vault, hashPrefix) {
+		return m.Vault == hashIdentifier(name, filename)
+	}
+	return m.Vault == name
+}
+
+// HasRecipient reports whether the metadata records recipient. known is
+// false when the recipients were omitted.
+func (m *FileMetadata) HasRecipient(recipient, filename string) (has, known bool) {
+	if m.Privacy == PrivacyOmit {
+		return false, false
+	}
+	want := strings.ToLower(recipient)
+	if m.Privacy == PrivacyHash {
+		want = hashIdentifier(recipient, filename)
+	}
+	for _, r := range m.Recipients {
+		if m.Privacy != PrivacyHash {
+			r = strings.ToLower(r)
+		}
+		if r == want {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// MatchesRecipients reports whether the metadata records exactly the given
+// recipients. known is false when the recipients were omitted, in which case
+// the answer cannot be determined.
+func (m *FileMetadata) MatchesRecipients(recipients []string, filename string) (match, known bool) {
+	if m.Privacy == PrivacyOmit {
+		return false, false
+	}
+
+	recorded := append([]string{}, m.Recipients...)
+	current := make([]string, len(recipients))
+	for i, r := range recipients {
+		if m.Privacy == PrivacyHash {
+			current[i] = hashIdentifier(r, filename)
+		} else {
+			current[i] = strings.ToLower(r)
+		}
+	}
+	if m.Privacy != PrivacyHash {
+		for i := range recorded {
+			recorded[i] = strings.ToLower(recorded[i])
+		}
+	}
+
+	if len(recorded) != len(current) {
+		return false, true
+	}
+	sort.Strings(recorded)
+	sort.Strings(current)
+	for i := range recorded {
+		if recorded[i] != current[i] {
+			return false, true
+		}
+	}
+	return true, true
+}
@@ -0,0 +1,58 @@
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RecipientProblem is a recipient whose key cannot be encrypted to.
+type RecipientProblem struct {
+	Recipient string
+	Reason    string
+}
+
+// RecipientsError lists every recipient without a usable key.
+type RecipientsError struct {
+	Problems []RecipientProblem
+}
+
+func (e *RecipientsError) Error() string {
+	parts := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		parts[i] = fmt.Sprintf("%s (%s)", p.Recipient, p.Reason)
+	}
+	return fmt.Sprintf("no usable key for %d recipient(s): %s", len(e.Problems), strings.Join(parts, ", "))
+}
+
+// CheckRecipients looks up the key of every recipient with the active
+// provider and returns a *RecipientsError naming all recipients whose key is
+// missing, expired or revoked, so encryption fails before any value is
+// encrypted instead of on the first bad key.
+func CheckRecipients(recipients []string) error {
+	gpg := GetProvider()
+
+	var problems []RecipientProblem
+	for _, r := range recipients {
+		info, err := gpg.LookupKey(r)
+		switch {
+		case errors.Is(err, ErrKeyNotFound):
+			problems = append(problems, RecipientProblem{r, "key not found"})
+		case err != nil:
+			problems = append(problems, RecipientProblem{r, err.Error()})
+		case info.IsRevoked:
+			problems = append(problems, RecipientProblem{r, "key revoked"})
+		case info.IsExpired:
+			reason := "key expired"
+			if info.ExpiresAt != nil {
+				reason += " " + info.ExpiresAt.Format("2006-01-02")
+			}
+			problems = append(problems, RecipientProblem{r, reason})
+		}
+	}
+
+	if len(problems) > 0 {
+		return &RecipientsError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package crypto
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/cychiuae/shhh/internal/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// SidecarSuffix is appended to an .enc path to name its sidecar metadata
+// file, used when metadata is kept out of the encrypted document.
+const SidecarSuffix = ".meta"
+
+func SidecarPath(encPath string) string {
+	return encPath + SidecarSuffix
+}
+
+// SplitMetadata removes the embedded metadata from a values-mode encrypted
+// file, returning the bare document and the metadata as YAML for a sidecar
+// file. Full-mode files keep their header and are returned unchanged with
+// nil metadata.
+func SplitMetadata(content []byte, filename string, spec parser.Spec) ([]byte, []byte, error) {
+	if IsFullyEncrypted(content) {
+		return content, nil, nil
+	}
+
+	meta, err := rawMetadata(content, filename, spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(meta) == 0 {
+		return content, nil, nil
+	}
+
+	doc, err := removeMetadata(content, filename, spec)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to remove metadata: %w", err)
+	}
+
+	data, err := yaml.Marshal(meta)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode metadata: %w", err)
+	}
+
+	return doc, data, nil
+}
+
+// ReadFileMetadata returns the metadata embedded in an encrypted file or,
+// when there is none, the metadata from its sidecar file next to encPath.
+func ReadFileMetadata(content []byte, encPath, filename string, spec parser.Spec) (*FileMetadata, error) {
+	meta, err := GetFileMetadata(content, filename, spec)
+	if err != nil || meta != nil {
+		return meta, err
+	}
+
+	data, err := os.ReadFile(SidecarPath(encPath))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read sidecar metadata: %w", err)
+	}
+	return ParseSidecarMetadata(data)
+}
+
+// ParseSidecarMetadata decodes the content of a sidecar metadata file. It
+// returns nil when the file holds no metadata.
+func ParseSidecarMetadata(data []byte) (*FileMetadata, error) {
+	var raw map[string]string
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid sidecar metadata: %w", err)
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	return metadataFromMap(raw), nil
+}
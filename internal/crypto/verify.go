@@ -0,0 +1,111 @@
+package crypto
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/cychiuae/shhh/internal/parser"
+)
+
+// recordTokens wraps an encrypt function so every ciphertext token it
+// produces is remembered with its plaintext.
+func recordTokens(fn parser.EncryptFunc, tokens map[string]string) parser.EncryptFunc {
+	return func(plaintext string) (string, error) {
+		token, err := fn(plaintext)
+		if err != nil {
+			return "", err
+		}
+		tokens[token] = plaintext
+		return token, nil
+	}
+}
+
+// verifyValuesFile runs encrypted output back through the decrypt path,
+// resolving tokens from those recorded during encryption instead of calling
+// GPG, and checks that every key and value matches the original plaintext.
+// This catches parser round-trip bugs without needing a secret key.
+func verifyValuesFile(plaintext, encrypted []byte, filename string, spec parser.Spec, tokens map[string]string) error {
+	lookup := func(token string) (string, error) {
+		value, ok := tokens[token]
+		if !ok {
+			return "", fmt.Errorf("unknown ciphertext in output")
+		}
+		return value, nil
+	}
+
+	decrypted, err := decryptValuesWith(encrypted, filename, spec, lookup)
+	if err != nil {
+		return fmt.Errorf("round-trip verification failed: %w", err)
+	}
+
+	want, err := parser.FlattenFile(plaintext, filename, spec)
+	if err != nil {
+		return fmt.Errorf("round-trip verification failed: %w", err)
+	}
+	got, err := parser.FlattenFile(decrypted, filename, spec)
+	if err != nil {
+		return fmt.Errorf("round-trip verification failed: decrypted output does not parse: %w", err)
+	}
+
+	if len(want) != len(got) {
+		return fmt.Errorf("round-trip verification failed: expected %d values, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if want[i].Key != got[i].Key {
+			return fmt.Errorf("round-trip verification failed: expected key %q, got %q", want[i].Key, got[i].Key)
+		}
+		if want[i].Value != got[i].Value {
+			return fmt.Errorf("round-trip verification failed: value of %q does not match", want[i].Key)
+		}
+	}
+
+	return nil
+}
+
+// SamePlaintext reports whether plaintext holds the same content as
+// decrypted, the decryption of its .enc file. Values mode rewrites a file's
+// formatting on the way through (indentation, quoting, spacing before
+// comments), so plaintext that was never edited can differ from its
+// decryption byte for byte; it is compared as it reads after that round
+// trip instead, which needs no key.
+func SamePlaintext(plaintext, decrypted []byte, filename string, spec parser.Spec) bool {
+	if bytes.Equal(plaintext, decrypted) {
+		return true
+	}
+	if IsFullyEncrypted(plaintext) || parser.GetParserForFile(filename, spec) == nil {
+		return false
+	}
+	normalized, err := normalizeValuesFile(plaintext, filename, spec)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(normalized, decrypted)
+}
+
+// normalizeValuesFile runs plaintext through the values-mode encrypt and
+// decrypt paths with placeholder tokens instead of GPG.
+func normalizeValuesFile(plaintext []byte, filename string, spec parser.Spec) ([]byte, error) {
+	p := parser.GetParserForFile(filename, spec)
+	withMetadata, err := addMetadata(plaintext, filename, EncryptOptions{Mode: "values", Spec: spec})
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	encrypted, err := p.EncryptValues(withMetadata, func(value string) (string, error) {
+		values = append(values, value)
+		return parser.EncodeValue([]byte(strconv.Itoa(len(values) - 1))), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decryptValuesWith(encrypted, filename, spec, func(token string) (string, error) {
+		data, _ := parser.DecodeValue(token)
+		i, err := strconv.Atoi(string(data))
+		if err != nil || i < 0 || i >= len(values) {
+			return "", fmt.Errorf("unknown placeholder in output")
+		}
+		return values[i], nil
+	})
+}
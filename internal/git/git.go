@@ -0,0 +1,240 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// run executes git in dir and returns stdout, folding stderr into the error.
+func run(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], msg)
+	}
+	return out, nil
+}
+
+// TopLevel returns the root of the git work tree containing dir.
+func TopLevel(dir string) (string, error) {
+	out, err := run(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// IsRepo reports whether dir is inside a git work tree.
+func IsRepo(dir string) bool {
+	_, err := TopLevel(dir)
+	return err == nil
+}
+
+// UserEmail returns git's user.email for dir, or "" when it is not set.
+func UserEmail(dir string) string {
+	out, err := run(dir, "config", "user.email")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// StatusFiles lists paths with uncommitted changes, including untracked files.
+// Paths are relative to the repository top level.
+func StatusFiles(dir string) ([]string, error) {
+	out, err := run(dir, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	entries := strings.Split(string(out), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		files = append(files, entry[3:])
+		// Renames and copies are followed by the original path.
+		if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+			if i < len(entries) && entries[i] != "" {
+				files = append(files, entries[i])
+			}
+		}
+	}
+	return files, nil
+}
+
+// DiffFiles lists paths changed between the merge base of base and HEAD, and
+// HEAD. Paths are relative to the repository top level.
+func DiffFiles(dir, base string) ([]string, error) {
+	out, err := run(dir, "diff", "--name-only", "-z", base+"...HEAD")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// ChangedFiles returns the set of files touched in the working tree and, when
+// base is set, on the current branch since base. Paths are slash-separated
+// and relative to root; files outside root are dropped.
+func ChangedFiles(root, base string) (map[string]bool, error) {
+	top, err := TopLevel(root)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := StatusFiles(root)
+	if err != nil {
+		return nil, err
+	}
+
+	if base != "" {
+		diff, err := DiffFiles(root, base)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, diff...)
+	}
+
+	absRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		absRoot = root
+	}
+
+	changed := make(map[string]bool)
+	for _, f := range files {
+		rel, err := filepath.Rel(absRoot, filepath.Join(top, filepath.FromSlash(f)))
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		changed[filepath.ToSlash(rel)] = true
+	}
+	return changed, nil
+}
+
+// IsTracked reports whether path (relative to dir) is in the git index.
+func IsTracked(dir, path string) bool {
+	_, err := run(dir, "ls-files", "--error-unmatch", "--", path)
+	return err == nil
+}
+
+// TrackedFiles returns the files under dir that are in the index or in the
+// HEAD commit, as slash-separated paths relative to dir. A file removed
+// with 'git rm --cached' still counts until the removal is committed.
+func TrackedFiles(dir string) (map[string]bool, error) {
+	out, err := run(dir, "ls-files", "-z")
+	if err != nil {
+		return nil, err
+	}
+	// A repository without commits has no HEAD to list.
+	if head, err := run(dir, "ls-tree", "-r", "-z", "--name-only", "HEAD"); err == nil {
+		out = append(out, head...)
+	}
+
+	tracked := make(map[string]bool)
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			tracked[f] = true
+		}
+	}
+	return tracked, nil
+}
+
+// Commit is one entry of a file's history.
+type Commit struct {
+	Hash    string
+	Author  string
+	Email   string
+	Date    time.Time
+	Subject string
+}
+
+// FileLog returns the commits that touched path (relative to dir), newest
+// first.
+func FileLog(dir, path string) ([]Commit, error) {
+	return fileLog(dir, path)
+}
+
+// FileLogAll returns the commits on any ref (branches, tags, remotes, the
+// stash) that touched path (relative to dir), newest first.
+func FileLogAll(dir, path string) ([]Commit, error) {
+	return fileLog(dir, path, "--all")
+}
+
+func fileLog(dir, path string, extra ...string) ([]Commit, error) {
+	args := append([]string{"log", "-z", "--format=%H%x1f%an%x1f%ae%x1f%at%x1f%s"}, extra...)
+	out, err := run(dir, append(args, "--", path)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []Commit
+	for _, entry := range strings.Split(string(out), "\x00") {
+		fields := strings.Split(strings.TrimSpace(entry), "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		unix, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("git log: invalid date %q", fields[3])
+		}
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    time.Unix(unix, 0),
+			Subject: fields[4],
+		})
+	}
+	return commits, nil
+}
+
+// Show returns the content of path (relative to dir) at rev.
+func Show(dir, rev, path string) ([]byte, error) {
+	return run(dir, "show", rev+":./"+filepath.ToSlash(path))
+}
+
+// TreeFiles lists every file in the tree of rev, relative to the repository
+// top level. Unlike the functions above it also works in bare repositories.
+func TreeFiles(dir, rev string) ([]string, error) {
+	out, err := run(dir, "ls-tree", "-r", "-z", "--name-only", rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, f := range strings.Split(string(out), "\x00") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
+// ShowBlob returns the content of path, relative to the repository top
+// level, at rev. Unlike Show it also works in bare repositories.
+func ShowBlob(dir, rev, path string) ([]byte, error) {
+	return run(dir, "cat-file", "blob", rev+":"+path)
+}
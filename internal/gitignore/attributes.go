@@ -0,0 +1,39 @@
+package gitignore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// EncAttributes marks ciphertext as binary so git never attempts textual
+// merges or EOL conversion, while routing diffs through a "shhh" driver.
+const EncAttributes = "*.enc binary diff=shhh"
+
+// EnsureAttributes writes the shhh entries into a managed block in
+// .gitattributes, adding merge=shhh when mergeDriver is set. The file is
+// only rewritten when the block changes.
+func EnsureAttributes(rootDir string, mergeDriver bool) error {
+	attributesPath := filepath.Join(rootDir, ".gitattributes")
+
+	lines, err := readGitignore(attributesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+
+	entry := EncAttributes
+	if mergeDriver {
+		entry += " merge=shhh"
+	}
+
+	before, block, after := splitBlock(lines)
+	if len(block) == 1 && block[0] == entry {
+		return nil
+	}
+
+	if err := writeGitignore(attributesPath, joinBlock(before, []string{entry}, after)); err != nil {
+		return fmt.Errorf("failed to write .gitattributes: %w", err)
+	}
+
+	return nil
+}
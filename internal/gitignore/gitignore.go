@@ -8,6 +8,15 @@ import (
 	"strings"
 )
 
+const (
+	BlockBegin = "# BEGIN shhh"
+	BlockEnd   = "# END shhh"
+)
+
+// EnsureIgnored makes sure git ignores a file. Entries are kept in a managed
+// block delimited by BlockBegin/BlockEnd so they can be grouped, deduplicated,
+// and removed again; a legacy entry for the file outside the block is moved
+// into it. Nothing is added when existing rules already ignore the file.
 func EnsureIgnored(rootDir, filePath string) error {
 	gitignorePath := filepath.Join(rootDir, ".gitignore")
 
@@ -16,50 +25,165 @@ func EnsureIgnored(rootDir, filePath string) error {
 		return fmt.Errorf("failed to read .gitignore: %w", err)
 	}
 
-	relativePath := filePath
-	if filepath.IsAbs(filePath) {
-		rel, err := filepath.Rel(rootDir, filePath)
-		if err != nil {
-			return fmt.Errorf("failed to get relative path: %w", err)
-		}
-		relativePath = rel
+	relativePath, err := relativeTo(rootDir, filePath)
+	if err != nil {
+		return err
 	}
 
 	pattern := "/" + relativePath
+	before, block, after := splitBlock(lines)
 
-	if isIgnored(lines, pattern) {
-		return nil
+	legacy := false
+	before, legacy = removeLine(before, pattern)
+	after, legacy2 := removeLine(after, pattern)
+	legacy = legacy || legacy2
+
+	if !legacy {
+		if containsLine(block, pattern) || LoadMatcher(rootDir, relativePath).Ignored(relativePath) {
+			return nil
+		}
 	}
 
-	lines = append(lines, pattern)
+	if !containsLine(block, pattern) {
+		block = append(block, pattern)
+	}
 
-	if err := writeGitignore(gitignorePath, lines); err != nil {
+	if err := writeGitignore(gitignorePath, joinBlock(before, block, after)); err != nil {
 		return fmt.Errorf("failed to write .gitignore: %w", err)
 	}
 
 	return nil
 }
 
-func IsIgnored(rootDir, filePath string) bool {
+// RemoveIgnored removes the entry EnsureIgnored added for a file, dropping
+// the managed block once it is empty. Other patterns that happen to match
+// the file are left alone.
+func RemoveIgnored(rootDir, filePath string) error {
 	gitignorePath := filepath.Join(rootDir, ".gitignore")
 
 	lines, err := readGitignore(gitignorePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read .gitignore: %w", err)
+	}
+
+	relativePath, err := relativeTo(rootDir, filePath)
+	if err != nil {
+		return err
+	}
+
+	pattern := "/" + relativePath
+	before, block, after := splitBlock(lines)
+
+	before, r1 := removeLine(before, pattern)
+	block, r2 := removeLine(block, pattern)
+	after, r3 := removeLine(after, pattern)
+
+	if !r1 && !r2 && !r3 {
+		return nil
+	}
+
+	if err := writeGitignore(gitignorePath, joinBlock(before, block, after)); err != nil {
+		return fmt.Errorf("failed to write .gitignore: %w", err)
+	}
+
+	return nil
+}
+
+// IsIgnored reports whether git would ignore a file, honouring wildcards,
+// directory patterns, negations, and nested .gitignore files.
+func IsIgnored(rootDir, filePath string) bool {
+	relativePath, err := relativeTo(rootDir, filePath)
 	if err != nil {
 		return false
 	}
 
+	return LoadMatcher(rootDir, relativePath).Ignored(relativePath)
+}
+
+func relativeTo(rootDir, filePath string) (string, error) {
 	relativePath := filePath
 	if filepath.IsAbs(filePath) {
 		rel, err := filepath.Rel(rootDir, filePath)
 		if err != nil {
-			return false
+			return "", fmt.Errorf("failed to get relative path: %w", err)
 		}
 		relativePath = rel
 	}
+	return filepath.ToSlash(relativePath), nil
+}
 
-	pattern := "/" + relativePath
+// splitBlock separates the lines before, inside, and after the managed block.
+func splitBlock(lines []string) (before, block, after []string) {
+	begin, end := -1, -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case BlockBegin:
+			if begin < 0 {
+				begin = i
+			}
+		case BlockEnd:
+			if begin >= 0 && end < 0 {
+				end = i
+			}
+		}
+	}
 
-	return isIgnored(lines, pattern)
+	if begin < 0 || end < 0 {
+		return lines, nil, nil
+	}
+
+	before = append(before, lines[:begin]...)
+	after = append(after, lines[end+1:]...)
+	for _, line := range lines[begin+1 : end] {
+		if line = strings.TrimSpace(line); line != "" && !containsLine(block, line) {
+			block = append(block, line)
+		}
+	}
+	return before, block, after
+}
+
+// joinBlock reassembles the file, appending the block at the end if it did
+// not exist yet and omitting it when empty.
+func joinBlock(before, block, after []string) []string {
+	result := append([]string{}, before...)
+	if len(block) > 0 {
+		if len(result) > 0 && strings.TrimSpace(result[len(result)-1]) != "" {
+			result = append(result, "")
+		}
+		result = append(result, BlockBegin)
+		result = append(result, block...)
+		result = append(result, BlockEnd)
+	} else {
+		for len(result) > 0 && strings.TrimSpace(result[len(result)-1]) == "" && len(after) == 0 {
+			result = result[:len(result)-1]
+		}
+	}
+	return append(result, after...)
+}
+
+func removeLine(lines []string, line string) ([]string, bool) {
+	var kept []string
+	removed := false
+	for _, l := range lines {
+		if strings.TrimSpace(l) == line {
+			removed = true
+			continue
+		}
+		kept = append(kept, l)
+	}
+	return kept, removed
+}
+
+func containsLine(lines []string, line string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == line {
+			return true
+		}
+	}
+	return false
 }
 
 func readGitignore(path string) ([]string, error) {
@@ -89,6 +213,10 @@ func writeGitignore(path string, lines []string) error {
 	}
 	defer file.Close()
 
+	if len(lines) == 0 {
+		return nil
+	}
+
 	for i, line := range lines {
 		if i > 0 {
 			file.WriteString("\n")
@@ -100,35 +228,6 @@ func writeGitignore(path string, lines []string) error {
 	return nil
 }
 
-func isIgnored(lines []string, pattern string) bool {
-	pattern = strings.TrimSpace(pattern)
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		if line == pattern {
-			return true
-		}
-
-		if strings.HasPrefix(line, "/") && strings.HasPrefix(pattern, "/") {
-			if line == pattern {
-				return true
-			}
-		} else if !strings.HasPrefix(line, "/") {
-			filename := filepath.Base(strings.TrimPrefix(pattern, "/"))
-			if line == filename {
-				return true
-			}
-		}
-	}
-
-	return false
-}
-
 func WarnIfNotIgnored(rootDir, filePath string) string {
 	if !IsIgnored(rootDir, filePath) {
 		return fmt.Sprintf("Warning: %s is not in .gitignore", filePath)
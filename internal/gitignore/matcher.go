@@ -0,0 +1,207 @@
+package gitignore
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pattern is a single compiled gitignore rule.
+type Pattern struct {
+	Raw     string
+	Negate  bool
+	DirOnly bool
+	base    string // directory the pattern is relative to, slash-separated
+	re      *regexp.Regexp
+}
+
+// Matcher evaluates paths against gitignore rules. Later patterns take
+// precedence over earlier ones, as in git.
+type Matcher struct {
+	patterns []Pattern
+}
+
+// ParsePatterns compiles the lines of an ignore file located in base
+// (slash-separated, relative to the matcher root; "" for the root).
+func ParsePatterns(lines []string, base string) []Pattern {
+	var patterns []Pattern
+	for _, line := range lines {
+		if p, ok := parsePattern(line, base); ok {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// NewMatcher builds a matcher from the given patterns.
+func NewMatcher(patterns ...[]Pattern) *Matcher {
+	m := &Matcher{}
+	for _, p := range patterns {
+		m.patterns = append(m.patterns, p...)
+	}
+	return m
+}
+
+// LoadMatcher reads the .gitignore in rootDir plus the nested .gitignore files
+// in each parent directory of relPath.
+func LoadMatcher(rootDir, relPath string) *Matcher {
+	m := &Matcher{}
+
+	dirs := []string{""}
+	dir := path.Dir(filepath.ToSlash(relPath))
+	var parents []string
+	for dir != "." && dir != "/" && dir != "" {
+		parents = append([]string{dir}, parents...)
+		dir = path.Dir(dir)
+	}
+	dirs = append(dirs, parents...)
+
+	for _, d := range dirs {
+		lines, err := readGitignore(filepath.Join(rootDir, filepath.FromSlash(d), ".gitignore"))
+		if err != nil {
+			continue
+		}
+		m.patterns = append(m.patterns, ParsePatterns(lines, d)...)
+	}
+
+	return m
+}
+
+// Match reports whether a single path (relative to the matcher root) is
+// matched by the rules, ignoring its parent directories.
+func (m *Matcher) Match(relPath string, isDir bool) bool {
+	relPath = strings.TrimPrefix(filepath.ToSlash(relPath), "/")
+
+	ignored := false
+	for _, p := range m.patterns {
+		if p.DirOnly && !isDir {
+			continue
+		}
+		if p.matches(relPath) {
+			ignored = !p.Negate
+		}
+	}
+	return ignored
+}
+
+// Ignored reports whether a file is ignored, either directly or because one
+// of its parent directories is. As in git, a file cannot be re-included
+// when a parent directory is excluded.
+func (m *Matcher) Ignored(relPath string) bool {
+	relPath = strings.TrimPrefix(filepath.ToSlash(relPath), "/")
+
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.Match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.Match(relPath, false)
+}
+
+func (p Pattern) matches(relPath string) bool {
+	if p.base != "" {
+		if !strings.HasPrefix(relPath, p.base+"/") {
+			return false
+		}
+		relPath = strings.TrimPrefix(relPath, p.base+"/")
+	}
+	return p.re.MatchString(relPath)
+}
+
+func parsePattern(line, base string) (Pattern, bool) {
+	raw := line
+
+	// Trailing spaces are ignored unless escaped.
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Pattern{}, false
+	}
+
+	p := Pattern{Raw: raw, base: base}
+
+	if strings.HasPrefix(line, "!") {
+		p.Negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.DirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+
+	if line == "" {
+		return Pattern{}, false
+	}
+
+	// A slash anywhere but the end anchors the pattern to its directory;
+	// otherwise it matches at any depth.
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if !anchored && !strings.HasPrefix(line, "**") {
+		line = "**/" + line
+	}
+
+	re, err := regexp.Compile("^" + globToRegexp(line) + "$")
+	if err != nil {
+		return Pattern{}, false
+	}
+	p.re = re
+
+	return p, true
+}
+
+// globToRegexp translates gitignore glob syntax, including "**", into a
+// regular expression over slash-separated paths.
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				atEnd := i+2 == len(glob)
+				if atStart && i+2 < len(glob) && glob[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+				if atStart && atEnd {
+					b.WriteString(".*")
+					i++
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
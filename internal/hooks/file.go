@@ -0,0 +1,181 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/secmem"
+)
+
+// Stages at which a file's own hooks run.
+const (
+	PreEncrypt  = "pre_encrypt"
+	PostEncrypt = "post_encrypt"
+	PreDecrypt  = "pre_decrypt"
+	PostDecrypt = "post_decrypt"
+	// Validate checks edited plaintext, given on stdin, before it is
+	// encrypted.
+	Validate = "validate"
+)
+
+// Stages lists every file hook stage, for validation.
+var Stages = []string{PreEncrypt, PostEncrypt, PreDecrypt, PostDecrypt, Validate}
+
+// FileHooks are commands declared in a file's registration, run when the
+// file is encrypted or decrypted. A failing pre hook stops the operation.
+type FileHooks struct {
+	PreEncrypt  string `yaml:"pre_encrypt,omitempty" json:"pre_encrypt,omitempty"`
+	PostEncrypt string `yaml:"post_encrypt,omitempty" json:"post_encrypt,omitempty"`
+	PreDecrypt  string `yaml:"pre_decrypt,omitempty" json:"pre_decrypt,omitempty"`
+	PostDecrypt string `yaml:"post_decrypt,omitempty" json:"post_decrypt,omitempty"`
+	Validate    string `yaml:"validate,omitempty" json:"validate,omitempty"`
+	// ValidateWarn only warns when the validate hook fails, instead of
+	// refusing to encrypt.
+	ValidateWarn bool `yaml:"validate_warn,omitempty" json:"validate_warn,omitempty"`
+}
+
+// ParseStage accepts a stage name with underscores or dashes
+// ("pre-encrypt").
+func ParseStage(name string) (string, error) {
+	stage := strings.ReplaceAll(name, "-", "_")
+	for _, s := range Stages {
+		if s == stage {
+			return stage, nil
+		}
+	}
+	return "", fmt.Errorf("unknown hook stage %q (use %s)", name, strings.Join(Stages, ", "))
+}
+
+func (h *FileHooks) field(stage string) *string {
+	switch stage {
+	case PreEncrypt:
+		return &h.PreEncrypt
+	case PostEncrypt:
+		return &h.PostEncrypt
+	case PreDecrypt:
+		return &h.PreDecrypt
+	case PostDecrypt:
+		return &h.PostDecrypt
+	case Validate:
+		return &h.Validate
+	}
+	return nil
+}
+
+// Get returns the command for a stage, or "" if none is set.
+func (h *FileHooks) Get(stage string) string {
+	if h == nil {
+		return ""
+	}
+	if f := h.field(stage); f != nil {
+		return *f
+	}
+	return ""
+}
+
+// Set sets the command for a stage; an empty command removes it.
+func (h *FileHooks) Set(stage, command string) error {
+	f := h.field(stage)
+	if f == nil {
+		return fmt.Errorf("unknown hook stage %q", stage)
+	}
+	*f = command
+	if stage == Validate && command == "" {
+		h.ValidateWarn = false
+	}
+	return nil
+}
+
+// IsZero reports whether no hook is set, so empty hooks are omitted from
+// the vault file.
+func (h *FileHooks) IsZero() bool {
+	return h == nil || *h == FileHooks{}
+}
+
+// RunFileHook runs a file's hook for stage, if one is set. The command runs
+// through sh in the project root with SHHH_HOOK (the stage), SHHH_FILE (the
+// registered path), SHHH_FILE_PATH and SHHH_ENC_PATH (absolute plaintext
+// and .enc paths), SHHH_VAULT, and SHHH_STATE ("encrypting", "encrypted",
+// "decrypting" or "decrypted") in the environment. A command the user has
+// not trusted is skipped with a warning.
+func RunFileHook(root, vault, relPath string, h *FileHooks, stage string) error {
+	command := h.Get(stage)
+	if command == "" || os.Getenv(DisableEnv) != "" {
+		return nil
+	}
+	if !Trusted(root, command) {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s hook of %s: %v\n", stage, relPath, untrustedError(command))
+		return nil
+	}
+
+	plainPath := filepath.Join(root, filepath.FromSlash(relPath))
+	err := shell(root, command, nil,
+		"SHHH_HOOK="+stage,
+		"SHHH_FILE="+relPath,
+		"SHHH_FILE_PATH="+plainPath,
+		"SHHH_ENC_PATH="+plainPath+".enc",
+		"SHHH_VAULT="+vault,
+		"SHHH_STATE="+stageState(stage),
+	)
+	if err != nil {
+		return fmt.Errorf("%s %w", stage, err)
+	}
+	return nil
+}
+
+// RunValidateHook runs a file's validate hook, if one is set, with the
+// plaintext about to be encrypted on stdin and SHHH_HOOK, SHHH_FILE and
+// SHHH_VAULT in the environment. The hook's output is only shown when it
+// fails, since validators such as 'jq .' echo the plaintext. A command the
+// user has not trusted never sees the plaintext: it is skipped with a
+// warning.
+func RunValidateHook(root, vault, relPath string, h *FileHooks, plaintext []byte) error {
+	command := h.Get(Validate)
+	if command == "" || os.Getenv(DisableEnv) != "" {
+		return nil
+	}
+	if !Trusted(root, command) {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %s hook of %s: %v\n", Validate, relPath, untrustedError(command))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = root
+	cmd.Stdin = bytes.NewReader(plaintext)
+	cmd.Env = append(os.Environ(),
+		"SHHH_HOOK="+Validate,
+		"SHHH_FILE="+relPath,
+		"SHHH_VAULT="+vault,
+	)
+
+	output, err := cmd.CombinedOutput()
+	defer secmem.Wipe(output)
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("validate hook %q: %w\n%s", command, err, msg)
+		}
+		return fmt.Errorf("validate hook %q: %w", command, err)
+	}
+	return nil
+}
+
+func stageState(stage string) string {
+	switch stage {
+	case PreEncrypt:
+		return "encrypting"
+	case PostEncrypt:
+		return "encrypted"
+	case PreDecrypt:
+		return "decrypting"
+	default:
+		return "decrypted"
+	}
+}
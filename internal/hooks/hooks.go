@@ -0,0 +1,276 @@
+// Package hooks runs the notification hooks configured in .shhh/hooks.yaml
+// when the state of a project changes.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cychiuae/shhh/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Events hooks can subscribe to.
+const (
+	UserAdded          = "user_added"
+	UserRemoved        = "user_removed"
+	ReencryptCompleted = "reencrypt_completed"
+	StaleDetected      = "stale_detected"
+	PlaintextCommitted = "plaintext_committed"
+)
+
+// Events lists every event name, for validation.
+var Events = []string{UserAdded, UserRemoved, ReencryptCompleted, StaleDetected, PlaintextCommitted}
+
+// DisableEnv turns all hooks off when set to a non-empty value, e.g. in CI
+// jobs that must not notify anyone.
+const DisableEnv = "SHHH_NO_HOOKS"
+
+const timeout = 30 * time.Second
+
+// Hook runs a command or posts to a URL for the listed events. An empty
+// Events list matches every event.
+type Hook struct {
+	Events []string `yaml:"events,omitempty"`
+	Exec   string   `yaml:"exec,omitempty"`
+	URL    string   `yaml:"url,omitempty"`
+	// Format "slack" posts {"text": ...} instead of the event JSON, for
+	// Slack-compatible incoming webhooks.
+	Format string `yaml:"format,omitempty"`
+}
+
+type File struct {
+	Hooks []Hook `yaml:"hooks"`
+}
+
+// Event describes a state change. It is sent as JSON to webhooks and on
+// stdin to exec hooks.
+type Event struct {
+	Name    string    `json:"event"`
+	Project string    `json:"project"`
+	Vault   string    `json:"vault,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Files   []string  `json:"files,omitempty"`
+	Time    time.Time `json:"timestamp"`
+}
+
+// Summary is a one-line human-readable description of the event.
+func (e Event) Summary() string {
+	project := filepath.Base(e.Project)
+	switch e.Name {
+	case UserAdded:
+		return fmt.Sprintf("shhh (%s): %s was added to vault %s", project, e.User, e.Vault)
+	case UserRemoved:
+		return fmt.Sprintf("shhh (%s): %s was removed from vault %s", project, e.User, e.Vault)
+	case ReencryptCompleted:
+		return fmt.Sprintf("shhh (%s): re-encrypted %d file(s)", project, len(e.Files))
+	case StaleDetected:
+		return fmt.Sprintf("shhh (%s): %d file(s) encrypted for outdated recipients: %s", project, len(e.Files), strings.Join(e.Files, ", "))
+	case PlaintextCommitted:
+		return fmt.Sprintf("shhh (%s): plaintext of %d registered file(s) is tracked by git: %s", project, len(e.Files), strings.Join(e.Files, ", "))
+	default:
+		return fmt.Sprintf("shhh (%s): %s", project, e.Name)
+	}
+}
+
+// Load reads .shhh/hooks.yaml. A missing file yields no hooks.
+func Load(s *store.Store) (*File, error) {
+	data, err := os.ReadFile(s.HooksPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &File{}, nil
+		}
+		return nil, err
+	}
+
+	var f File
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", store.HooksFile, err)
+	}
+
+	for i, h := range f.Hooks {
+		if (h.Exec == "") == (h.URL == "") {
+			return nil, fmt.Errorf("invalid %s: hook %d needs exactly one of exec or url", store.HooksFile, i+1)
+		}
+		if h.Format != "" && h.Format != "slack" {
+			return nil, fmt.Errorf("invalid %s: unknown format %q", store.HooksFile, h.Format)
+		}
+		for _, e := range h.Events {
+			if !validEvent(e) {
+				return nil, fmt.Errorf("invalid %s: unknown event %q", store.HooksFile, e)
+			}
+		}
+	}
+
+	return &f, nil
+}
+
+// Fire runs every hook subscribed to the event and returns the errors of
+// those that failed. Hooks never block the command that fired them for
+// longer than the timeout. Exec hooks the user has not trusted are skipped
+// and reported as errors.
+func Fire(s *store.Store, e Event) []error {
+	if os.Getenv(DisableEnv) != "" {
+		return nil
+	}
+
+	f, err := Load(s)
+	if err != nil {
+		return []error{err}
+	}
+
+	e.Project = s.Root()
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	var errs []error
+	for _, h := range f.Hooks {
+		if !h.matches(e.Name) {
+			continue
+		}
+		var err error
+		if h.Exec != "" && !Trusted(s.Root(), h.Exec) {
+			err = untrustedError(h.Exec)
+		} else if h.Exec != "" {
+			err = runExec(s.Root(), h.Exec, e)
+		} else {
+			err = post(h, e)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func (h Hook) matches(event string) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func validEvent(name string) bool {
+	for _, e := range Events {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// runExec runs command through the shell in the project root, with the
+// event JSON on stdin and its fields in SHHH_* environment variables.
+func runExec(root, command string, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return shell(root, command, bytes.NewReader(payload),
+		"SHHH_EVENT="+e.Name,
+		"SHHH_VAULT="+e.Vault,
+		"SHHH_USER="+e.User,
+		"SHHH_FILES="+strings.Join(e.Files, "\n"),
+		"SHHH_SUMMARY="+e.Summary(),
+	)
+}
+
+// shell runs command through sh in root with extra environment variables,
+// sending its output to stderr so it does not mix with shhh's output.
+func shell(root, command string, stdin io.Reader, env ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = root
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), env...)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook %q: %w", command, err)
+	}
+	return nil
+}
+
+// Generate runs a value generator through sh in root and returns its
+// output without trailing newlines. Its stderr goes to stderr.
+func Generate(root, command string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = root
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("generator %q: %w", command, err)
+	}
+	out = bytes.TrimRight(out, "\r\n")
+	if len(out) == 0 {
+		return nil, fmt.Errorf("generator %q printed nothing", command)
+	}
+	return out, nil
+}
+
+// RunRotationHook runs the hook given to 'shhh rotate' once a key has a new
+// value, with the value on stdin. It runs even when hooks are disabled,
+// since it was asked for explicitly.
+func RunRotationHook(root, vault, relPath, key, command string, value []byte) error {
+	return shell(root, command, bytes.NewReader(value),
+		"SHHH_HOOK=rotate",
+		"SHHH_FILE="+relPath,
+		"SHHH_KEY="+key,
+		"SHHH_VAULT="+vault,
+	)
+}
+
+func post(h Hook, e Event) error {
+	var payload []byte
+	var err error
+	if h.Format == "slack" {
+		payload, err = json.Marshal(map[string]string{"text": e.Summary()})
+	} else {
+		payload, err = json.Marshal(e)
+	}
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(h.URL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		// Webhook URLs often embed a token; keep them out of the error.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
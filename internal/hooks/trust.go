@@ -0,0 +1,126 @@
+package hooks
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Hook commands come from the project's committed files, so anyone who can
+// push to the repository could otherwise run code on every machine that
+// uses it. A command only runs once the local user has trusted it for the
+// project, which records a hash of the project root and the command in
+// TrustFile under the user's config directory, outside any repository.
+// Changing a command, or cloning the project elsewhere, needs trusting
+// again.
+
+// TrustFile is the name of the allowlist in the user's shhh config
+// directory.
+const TrustFile = "trusted-hooks"
+
+// TrustPath returns the path of the allowlist: $XDG_CONFIG_HOME/shhh on
+// Linux, and the platform's user config directory elsewhere.
+func TrustPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot locate the user config directory: %w", err)
+	}
+	return filepath.Join(dir, "shhh", TrustFile), nil
+}
+
+func trustHash(root, command string) string {
+	sum := sha256.Sum256([]byte(root + "\x00" + command))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadTrusted() (map[string]bool, error) {
+	path, err := TrustPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]bool{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	trusted := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			trusted[line] = true
+		}
+	}
+	return trusted, scanner.Err()
+}
+
+func saveTrusted(trusted map[string]bool) error {
+	path, err := TrustPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0, len(trusted))
+	for h := range trusted {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	var b strings.Builder
+	b.WriteString("# Hook commands trusted with 'shhh hooks trust' (SHA-256 of project root and command)\n")
+	for _, h := range hashes {
+		b.WriteString(h + "\n")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Trusted reports whether command was trusted for the project at root.
+func Trusted(root, command string) bool {
+	trusted, err := loadTrusted()
+	return err == nil && trusted[trustHash(root, command)]
+}
+
+// Trust records commands as trusted for the project at root.
+func Trust(root string, commands ...string) error {
+	trusted, err := loadTrusted()
+	if err != nil {
+		return err
+	}
+	for _, c := range commands {
+		trusted[trustHash(root, c)] = true
+	}
+	return saveTrusted(trusted)
+}
+
+// Untrust removes commands from the allowlist of the project at root.
+func Untrust(root string, commands ...string) error {
+	trusted, err := loadTrusted()
+	if err != nil {
+		return err
+	}
+	for _, c := range commands {
+		delete(trusted, trustHash(root, c))
+	}
+	return saveTrusted(trusted)
+}
+
+// untrustedError explains how to allow a command that was not run.
+func untrustedError(command string) error {
+	return fmt.Errorf("hook %q is not trusted on this machine; review it, then run 'shhh hooks trust'", command)
+}
@@ -0,0 +1,277 @@
+package k8s
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/parser"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ScopeStrict        = "strict"
+	ScopeNamespaceWide = "namespace-wide"
+	ScopeClusterWide   = "cluster-wide"
+
+	sessionKeyBytes = 32
+)
+
+type ObjectMeta struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+type SealedSecret struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   ObjectMeta       `yaml:"metadata"`
+	Spec       SealedSecretSpec `yaml:"spec"`
+}
+
+type SealedSecretSpec struct {
+	EncryptedData map[string]string    `yaml:"encryptedData"`
+	Template      SealedSecretTemplate `yaml:"template"`
+}
+
+type SealedSecretTemplate struct {
+	Metadata ObjectMeta `yaml:"metadata"`
+	Type     string     `yaml:"type,omitempty"`
+}
+
+type ExternalSecret struct {
+	APIVersion string             `yaml:"apiVersion"`
+	Kind       string             `yaml:"kind"`
+	Metadata   ObjectMeta         `yaml:"metadata"`
+	Spec       ExternalSecretSpec `yaml:"spec"`
+}
+
+type ExternalSecretSpec struct {
+	RefreshInterval string               `yaml:"refreshInterval"`
+	SecretStoreRef  SecretStoreRef       `yaml:"secretStoreRef"`
+	Target          ExternalSecretTarget `yaml:"target"`
+	Data            []ExternalSecretData `yaml:"data"`
+}
+
+type SecretStoreRef struct {
+	Name string `yaml:"name"`
+	Kind string `yaml:"kind"`
+}
+
+type ExternalSecretTarget struct {
+	Name           string `yaml:"name"`
+	CreationPolicy string `yaml:"creationPolicy"`
+}
+
+type ExternalSecretData struct {
+	SecretKey string    `yaml:"secretKey"`
+	RemoteRef RemoteRef `yaml:"remoteRef"`
+}
+
+type RemoteRef struct {
+	Key      string `yaml:"key"`
+	Property string `yaml:"property,omitempty"`
+}
+
+// SecretKey converts a key path into a valid Kubernetes Secret data key.
+func SecretKey(key string) string {
+	var b strings.Builder
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '-', c == '_':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	return b.String()
+}
+
+// ResourceName converts a file name into a DNS-1123 compatible object name.
+func ResourceName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			b.WriteRune(c)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	result := strings.Trim(b.String(), "-")
+	if len(result) > 253 {
+		result = strings.TrimRight(result[:253], "-")
+	}
+	return result
+}
+
+// ParsePublicKey reads the sealed-secrets controller certificate (as printed
+// by `kubeseal --fetch-cert`) or a bare PEM RSA public key.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+
+	var pub interface{}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+		pub = cert.PublicKey
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse public key: %w", err)
+		}
+		pub = key
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+
+	rsaKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("sealed-secrets requires an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// SealLabel returns the OAEP label sealed-secrets binds ciphertext to for a scope.
+func SealLabel(scope, namespace, name string) ([]byte, error) {
+	switch scope {
+	case ScopeStrict:
+		return []byte(namespace + "/" + name), nil
+	case ScopeNamespaceWide:
+		return []byte(namespace), nil
+	case ScopeClusterWide:
+		return []byte{}, nil
+	default:
+		return nil, fmt.Errorf("invalid scope: %s (must be strict, namespace-wide, or cluster-wide)", scope)
+	}
+}
+
+// HybridEncrypt implements the sealed-secrets value format: an RSA-OAEP
+// wrapped session key followed by the AES-GCM sealed plaintext.
+func HybridEncrypt(rnd io.Reader, pubKey *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aed, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rnd, pubKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, 2)
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(rsaCiphertext)))
+	ciphertext = append(ciphertext, rsaCiphertext...)
+
+	// The session key is never reused, so a zero nonce is safe here.
+	zeroNonce := make([]byte, aed.NonceSize())
+	return aed.Seal(ciphertext, zeroNonce, plaintext, nil), nil
+}
+
+// NewSealedSecret seals each value for the given controller key and scope.
+func NewSealedSecret(pubKey *rsa.PublicKey, name, namespace, scope string, values []parser.KeyValue) (*SealedSecret, error) {
+	label, err := SealLabel(scope, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations map[string]string
+	switch scope {
+	case ScopeNamespaceWide:
+		annotations = map[string]string{"sealedsecrets.bitnami.com/namespace-wide": "true"}
+	case ScopeClusterWide:
+		annotations = map[string]string{"sealedsecrets.bitnami.com/cluster-wide": "true"}
+	}
+
+	meta := ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations}
+	sealed := &SealedSecret{
+		APIVersion: "bitnami.com/v1alpha1",
+		Kind:       "SealedSecret",
+		Metadata:   meta,
+		Spec: SealedSecretSpec{
+			EncryptedData: make(map[string]string),
+			Template: SealedSecretTemplate{
+				Metadata: meta,
+				Type:     "Opaque",
+			},
+		},
+	}
+
+	for _, v := range values {
+		key := SecretKey(v.Key)
+		if _, exists := sealed.Spec.EncryptedData[key]; exists {
+			return nil, fmt.Errorf("secret key collision for %q", key)
+		}
+
+		ciphertext, err := HybridEncrypt(rand.Reader, pubKey, []byte(v.Value), label)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seal %s: %w", v.Key, err)
+		}
+		sealed.Spec.EncryptedData[key] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	return sealed, nil
+}
+
+// NewExternalSecret describes values as properties of a single remote secret,
+// for use with the External Secrets Operator.
+func NewExternalSecret(name, namespace, storeName, storeKind, remoteKey string, values []parser.KeyValue) *ExternalSecret {
+	es := &ExternalSecret{
+		APIVersion: "external-secrets.io/v1beta1",
+		Kind:       "ExternalSecret",
+		Metadata:   ObjectMeta{Name: name, Namespace: namespace},
+		Spec: ExternalSecretSpec{
+			RefreshInterval: "1h",
+			SecretStoreRef:  SecretStoreRef{Name: storeName, Kind: storeKind},
+			Target:          ExternalSecretTarget{Name: name, CreationPolicy: "Owner"},
+		},
+	}
+
+	for _, v := range values {
+		es.Spec.Data = append(es.Spec.Data, ExternalSecretData{
+			SecretKey: SecretKey(v.Key),
+			RemoteRef: RemoteRef{Key: remoteKey, Property: v.Key},
+		})
+	}
+
+	return es
+}
+
+// Marshal encodes a manifest as YAML with the repo's two-space indentation.
+func Marshal(manifest interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(manifest); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package k8s
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/cychiuae/shhh/internal/parser"
+	"gopkg.in/yaml.v3"
+)
+
+// ResourceList is the input and output of a KRM function, such as a
+// kustomize exec plugin. Items are kept as nodes so resources shhh does not
+// know are passed through unchanged.
+type ResourceList struct {
+	APIVersion     string    `yaml:"apiVersion"`
+	Kind           string    `yaml:"kind"`
+	Items          yaml.Node `yaml:"items"`
+	FunctionConfig yaml.Node `yaml:"functionConfig"`
+}
+
+// SecretGenerator is the function config of 'shhh krm': the Secret to
+// generate and the registered files whose values it holds.
+type SecretGenerator struct {
+	Metadata              ObjectMeta `yaml:"metadata"`
+	Files                 []string   `yaml:"files"`
+	Type                  string     `yaml:"type,omitempty"`
+	DisableNameSuffixHash bool       `yaml:"disableNameSuffixHash,omitempty"`
+}
+
+type Secret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   ObjectMeta        `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+// needsHashAnnotation asks kustomize to add a content hash to the Secret's
+// name, as its built-in secretGenerator does.
+const needsHashAnnotation = "kustomize.config.k8s.io/needs-hash"
+
+// ParseResourceList reads a ResourceList and its SecretGenerator config.
+func ParseResourceList(data []byte) (*ResourceList, *SecretGenerator, error) {
+	var rl ResourceList
+	if err := yaml.Unmarshal(data, &rl); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ResourceList: %w", err)
+	}
+	if rl.Kind != "ResourceList" {
+		return nil, nil, fmt.Errorf("expected a ResourceList on stdin, got kind %q", rl.Kind)
+	}
+	if rl.Items.Kind == 0 {
+		rl.Items = yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	} else if rl.Items.Kind != yaml.SequenceNode {
+		return nil, nil, fmt.Errorf("ResourceList items must be a list")
+	}
+	if rl.FunctionConfig.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("ResourceList has no functionConfig")
+	}
+
+	var gen SecretGenerator
+	if err := rl.FunctionConfig.Decode(&gen); err != nil {
+		return nil, nil, fmt.Errorf("invalid functionConfig: %w", err)
+	}
+	if gen.Metadata.Name == "" {
+		return nil, nil, fmt.Errorf("functionConfig needs metadata.name")
+	}
+	if len(gen.Files) == 0 {
+		return nil, nil, fmt.Errorf("functionConfig needs at least one entry in files")
+	}
+	if gen.Type == "" {
+		gen.Type = "Opaque"
+	}
+	return &rl, &gen, nil
+}
+
+// NewSecret builds the Secret described by a SecretGenerator, with one data
+// key per value named after its key path.
+func NewSecret(gen *SecretGenerator, values []parser.KeyValue) (*Secret, error) {
+	secret := &Secret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   ObjectMeta{Name: gen.Metadata.Name, Namespace: gen.Metadata.Namespace},
+		Type:       gen.Type,
+		Data:       make(map[string]string),
+	}
+	if !gen.DisableNameSuffixHash {
+		secret.Metadata.Annotations = map[string]string{needsHashAnnotation: "true"}
+	}
+
+	for _, v := range values {
+		key := SecretKey(v.Key)
+		if _, exists := secret.Data[key]; exists {
+			return nil, fmt.Errorf("secret key collision for %q", key)
+		}
+		secret.Data[key] = base64.StdEncoding.EncodeToString([]byte(v.Value))
+	}
+	return secret, nil
+}
+
+// Append adds a resource to the items of a ResourceList.
+func (rl *ResourceList) Append(resource interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(resource); err != nil {
+		return err
+	}
+	rl.Items.Content = append(rl.Items.Content, &node)
+	return nil
+}
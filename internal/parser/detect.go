@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"path/filepath"
 	"strings"
 )
@@ -15,6 +16,34 @@ const (
 	FormatUnknown FileFormat = "unknown"
 )
 
+// Spec is how a file is parsed when its name alone does not say: the
+// format it was registered with, for a path whose extension does not reveal
+// it (e.g. notes.txt holding KEY=value lines), and its line options. The
+// zero Spec detects the format from the file name.
+type Spec struct {
+	Format FileFormat
+	Lines  LineOptions
+}
+
+// FormatOf returns the format of filename under the spec.
+func (sp Spec) FormatOf(filename string) FileFormat {
+	if sp.Format != "" {
+		return sp.Format
+	}
+	return DetectFormat(filename)
+}
+
+// ParseFormat validates a format name given on the command line.
+func ParseFormat(name string) (FileFormat, error) {
+	switch format := FileFormat(strings.ToLower(name)); format {
+	case FormatYAML, FormatJSON, FormatINI, FormatENV:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q (use yaml, json, ini, or env)", name)
+	}
+}
+
+// DetectFormat tells a file's format from its extension.
 func DetectFormat(filename string) FileFormat {
 	ext := strings.ToLower(filepath.Ext(filename))
 
@@ -47,7 +76,10 @@ func GetParser(format FileFormat) Parser {
 	}
 }
 
-func GetParserForFile(filename string) Parser {
-	format := DetectFormat(filename)
+func GetParserForFile(filename string, spec Spec) Parser {
+	format := spec.FormatOf(filename)
+	if format == FormatENV {
+		return &ENVParser{Options: spec.Lines}
+	}
 	return GetParser(format)
 }
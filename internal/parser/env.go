@@ -7,7 +7,11 @@ import (
 	"strings"
 )
 
-type ENVParser struct{}
+// ENVParser handles KEY=value files. Options adapt it to variants with a
+// different delimiter or comment syntax.
+type ENVParser struct {
+	Options LineOptions
+}
 
 func (p *ENVParser) FileType() string {
 	return "env"
@@ -66,7 +70,7 @@ func (p *ENVParser) DecryptValues(content []byte, decrypt DecryptFunc) ([]byte,
 func (p *ENVParser) processLine(line string, transform func(string) (string, error), encrypting bool) (string, error) {
 	trimmed := strings.TrimSpace(line)
 
-	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+	if trimmed == "" || p.Options.isComment(trimmed) {
 		return line, nil
 	}
 
@@ -74,13 +78,16 @@ func (p *ENVParser) processLine(line string, transform func(string) (string, err
 		return line, nil
 	}
 
-	eqIndex := strings.Index(line, "=")
+	delim := p.Options.delimiter()
+	eqIndex := strings.Index(line, delim)
 	if eqIndex == -1 {
 		return line, nil
 	}
 
-	key := line[:eqIndex]
-	value := line[eqIndex+1:]
+	// Keep whitespace after the delimiter, as in "KEY: value".
+	value := line[eqIndex+len(delim):]
+	pad := value[:len(value)-len(strings.TrimLeft(value, " \t"))]
+	key := line[:eqIndex] + delim + pad
 
 	unquotedValue, wasQuoted, quoteChar := unquoteValue(value)
 
@@ -88,17 +95,24 @@ func (p *ENVParser) processLine(line string, transform func(string) (string, err
 		if !IsEncrypted(unquotedValue) && unquotedValue != "" {
 			encrypted, err := transform(unquotedValue)
 			if err != nil {
-				return "", fmt.Errorf("failed to encrypt value for %s: %w", strings.TrimSpace(key), err)
+				return "", fmt.Errorf("failed to encrypt value for %s: %w", strings.TrimSpace(line[:eqIndex]), err)
 			}
-			return key + "=" + quoteValue(encrypted, wasQuoted, quoteChar), nil
+			return key + quoteValue(encrypted, wasQuoted, quoteChar), nil
 		}
 	} else {
 		if IsEncrypted(unquotedValue) {
 			decrypted, err := transform(unquotedValue)
 			if err != nil {
-				return "", fmt.Errorf("failed to decrypt value for %s: %w", strings.TrimSpace(key), err)
+				return "", fmt.Errorf("failed to decrypt value for %s: %w", strings.TrimSpace(line[:eqIndex]), err)
+			}
+			if p.Options.Delimiter != "" && p.Options.Delimiter != "=" {
+				// Non-dotenv syntax has no quoting rules; restore the value as written.
+				if wasQuoted {
+					return key + quoteValue(decrypted, true, quoteChar), nil
+				}
+				return key + decrypted, nil
 			}
-			return key + "=" + quoteValue(decrypted, needsQuoting(decrypted), '"'), nil
+			return key + quoteValue(decrypted, needsQuoting(decrypted), '"'), nil
 		}
 	}
 
@@ -151,8 +165,8 @@ func AddENVMetadata(content []byte, metadata map[string]interface{}) ([]byte, er
 	buf.Write(content)
 	buf.WriteString("\n# shhh metadata\n")
 
-	for k, v := range metadata {
-		buf.WriteString(fmt.Sprintf("_SHHH_%s=%v\n", strings.ToUpper(k), v))
+	for _, k := range sortedMetadataKeys(metadata) {
+		buf.WriteString(fmt.Sprintf("_SHHH_%s=%v\n", strings.ToUpper(k), metadata[k]))
 	}
 
 	return buf.Bytes(), nil
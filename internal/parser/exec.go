@@ -0,0 +1,35 @@
+package parser
+
+import "gopkg.in/yaml.v3"
+
+// ExecTag marks a YAML value as provided by a command, resolved by
+// 'shhh encrypt --resolve':
+//
+//	password: !shhh-exec "vault kv get -field=pass db"
+const ExecTag = "!shhh-exec"
+
+// ExecValues returns the key path and command of every value tagged ExecTag
+// in a document. Only YAML has tags, so other formats have none. A value
+// referenced through aliases is returned once, under its anchor's path.
+func ExecValues(content []byte, filename string, spec Spec) ([]KeyValue, error) {
+	if spec.FormatOf(filename) != FormatYAML {
+		return nil, nil
+	}
+	if err := ValidateContentSize(content); err != nil {
+		return nil, err
+	}
+
+	var result []KeyValue
+	seen := make(map[*yaml.Node]bool)
+	err := walkYAMLScalars(content, func(key string, node *yaml.Node) {
+		if node.Tag != ExecTag || seen[node] {
+			return
+		}
+		seen[node] = true
+		result = append(result, KeyValue{Key: key, Value: node.Value})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,217 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// KeyValue is a single leaf value addressed by its dotted key path.
+// Literal marks a JSON number or boolean, which values mode never encrypts.
+type KeyValue struct {
+	Key     string
+	Value   string
+	Literal bool
+}
+
+// FlattenValues returns every scalar value in a structured document as a
+// dotted key path (e.g. "database.password", "hosts.0") in document order.
+// The _shhh metadata block is skipped.
+func FlattenValues(content []byte, format FileFormat) ([]KeyValue, error) {
+	if err := ValidateContentSize(content); err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatYAML:
+		return flattenYAML(content)
+	case FormatJSON:
+		return flattenJSON(content)
+	case FormatINI:
+		return flattenINI(content)
+	case FormatENV:
+		return flattenENV(content, LineOptions{})
+	default:
+		return nil, fmt.Errorf("cannot flatten %s files", format)
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func flattenYAML(content []byte) ([]KeyValue, error) {
+	var result []KeyValue
+	err := walkYAMLScalars(content, func(key string, node *yaml.Node) {
+		result = append(result, KeyValue{Key: key, Value: node.Value})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// walkYAMLScalars calls visit with the key path of every scalar in a YAML
+// document, in document order, following aliases and skipping _shhh.
+func walkYAMLScalars(content []byte, visit func(key string, node *yaml.Node)) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	var walk func(node *yaml.Node, prefix string, depth int) error
+	walk = func(node *yaml.Node, prefix string, depth int) error {
+		if depth > MaxNestingDepth {
+			return fmt.Errorf("maximum nesting depth exceeded")
+		}
+
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				if err := walk(child, prefix, depth+1); err != nil {
+					return err
+				}
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := node.Content[i].Value
+				if prefix == "" && key == "_shhh" {
+					continue
+				}
+				if err := walk(node.Content[i+1], joinKey(prefix, key), depth+1); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				if err := walk(child, joinKey(prefix, strconv.Itoa(i)), depth+1); err != nil {
+					return err
+				}
+			}
+		case yaml.ScalarNode:
+			visit(prefix, node)
+		case yaml.AliasNode:
+			if node.Alias != nil {
+				return walk(node.Alias, prefix, depth+1)
+			}
+		}
+		return nil
+	}
+
+	return walk(&root, "", 0)
+}
+
+func flattenJSON(content []byte) ([]KeyValue, error) {
+	var data interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	var result []KeyValue
+	var walk func(value interface{}, prefix string, depth int) error
+	walk = func(value interface{}, prefix string, depth int) error {
+		if depth > MaxNestingDepth {
+			return fmt.Errorf("maximum nesting depth exceeded")
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				if prefix == "" && k == "_shhh" {
+					continue
+				}
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if err := walk(v[k], joinKey(prefix, k), depth+1); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for i, item := range v {
+				if err := walk(item, joinKey(prefix, strconv.Itoa(i)), depth+1); err != nil {
+					return err
+				}
+			}
+		case string:
+			result = append(result, KeyValue{Key: prefix, Value: v})
+		case nil:
+			result = append(result, KeyValue{Key: prefix, Value: ""})
+		case float64:
+			result = append(result, KeyValue{Key: prefix, Value: strconv.FormatFloat(v, 'f', -1, 64), Literal: true})
+		default:
+			result = append(result, KeyValue{Key: prefix, Value: fmt.Sprintf("%v", v), Literal: true})
+		}
+		return nil
+	}
+
+	if err := walk(data, "", 0); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func flattenINI(content []byte) ([]KeyValue, error) {
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse INI: %w", err)
+	}
+
+	var result []KeyValue
+	for _, section := range cfg.Sections() {
+		if section.Name() == "_shhh" {
+			continue
+		}
+
+		prefix := section.Name()
+		if prefix == ini.DefaultSection {
+			prefix = ""
+		}
+
+		for _, key := range section.Keys() {
+			result = append(result, KeyValue{Key: joinKey(prefix, key.Name()), Value: key.String()})
+		}
+	}
+
+	return result, nil
+}
+
+func flattenENV(content []byte, opts LineOptions) ([]KeyValue, error) {
+	var result []KeyValue
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || opts.isComment(line) || strings.HasPrefix(line, "_SHHH_") {
+			continue
+		}
+
+		delim := opts.delimiter()
+		eqIndex := strings.Index(line, delim)
+		if eqIndex == -1 {
+			continue
+		}
+
+		key := strings.TrimSpace(strings.TrimPrefix(line[:eqIndex], "export "))
+		value, _, _ := unquoteValue(line[eqIndex+len(delim):])
+		result = append(result, KeyValue{Key: key, Value: value})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+
+	return result, nil
+}
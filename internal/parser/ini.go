@@ -95,8 +95,8 @@ func AddINIMetadata(content []byte, metadata map[string]interface{}) ([]byte, er
 		return nil, err
 	}
 
-	for k, v := range metadata {
-		section.Key(k).SetValue(fmt.Sprintf("%v", v))
+	for _, k := range sortedMetadataKeys(metadata) {
+		section.Key(k).SetValue(fmt.Sprintf("%v", metadata[k]))
 	}
 
 	var buf bytes.Buffer
@@ -13,6 +13,15 @@ func (p *JSONParser) FileType() string {
 }
 
 func (p *JSONParser) EncryptValues(content []byte, encrypt EncryptFunc) ([]byte, error) {
+	return p.encrypt(content, encrypt, false)
+}
+
+// EncryptKeysAndValues encrypts object keys as well as values.
+func (p *JSONParser) EncryptKeysAndValues(content []byte, encrypt EncryptFunc) ([]byte, error) {
+	return p.encrypt(content, encrypt, true)
+}
+
+func (p *JSONParser) encrypt(content []byte, encrypt EncryptFunc, keys bool) ([]byte, error) {
 	if err := ValidateContentSize(content); err != nil {
 		return nil, err
 	}
@@ -27,6 +36,13 @@ func (p *JSONParser) EncryptValues(content []byte, encrypt EncryptFunc) ([]byte,
 		return nil, err
 	}
 
+	if keys {
+		encrypted, err = p.processKeys(encrypted, encrypt, true, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var buf bytes.Buffer
 	encoder := json.NewEncoder(&buf)
 	encoder.SetIndent("", "  ")
@@ -53,6 +69,11 @@ func (p *JSONParser) DecryptValues(content []byte, decrypt DecryptFunc) ([]byte,
 		return nil, err
 	}
 
+	decrypted, err = p.processKeys(decrypted, decrypt, false, 0)
+	if err != nil {
+		return nil, err
+	}
+
 	var buf bytes.Buffer
 	encoder := json.NewEncoder(&buf)
 	encoder.SetIndent("", "  ")
@@ -64,6 +85,8 @@ func (p *JSONParser) DecryptValues(content []byte, decrypt DecryptFunc) ([]byte,
 	return buf.Bytes(), nil
 }
 
+// processValue transforms string values in place, so large documents are
+// not copied, and returns the transformed value.
 func (p *JSONParser) processValue(value interface{}, transform func(string) (string, error), encrypting bool, depth int) (interface{}, error) {
 	if depth > MaxNestingDepth {
 		return nil, fmt.Errorf("maximum nesting depth exceeded")
@@ -71,30 +94,27 @@ func (p *JSONParser) processValue(value interface{}, transform func(string) (str
 
 	switch v := value.(type) {
 	case map[string]interface{}:
-		result := make(map[string]interface{})
 		for key, val := range v {
 			if key == "_shhh" {
-				result[key] = val
 				continue
 			}
 			processed, err := p.processValue(val, transform, encrypting, depth+1)
 			if err != nil {
 				return nil, err
 			}
-			result[key] = processed
+			v[key] = processed
 		}
-		return result, nil
+		return v, nil
 
 	case []interface{}:
-		result := make([]interface{}, len(v))
 		for i, val := range v {
 			processed, err := p.processValue(val, transform, encrypting, depth+1)
 			if err != nil {
 				return nil, err
 			}
-			result[i] = processed
+			v[i] = processed
 		}
-		return result, nil
+		return v, nil
 
 	case string:
 		if encrypting {
@@ -121,6 +141,72 @@ func (p *JSONParser) processValue(value interface{}, transform func(string) (str
 	}
 }
 
+// processKeys transforms object keys, leaving the _shhh metadata key alone.
+// Like processValue it works in place; renamed keys are moved once all keys
+// were visited, so none is visited twice or overwritten.
+func (p *JSONParser) processKeys(value interface{}, transform func(string) (string, error), encrypting bool, depth int) (interface{}, error) {
+	if depth > MaxNestingDepth {
+		return nil, fmt.Errorf("maximum nesting depth exceeded")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		type rename struct {
+			key string
+			val interface{}
+		}
+		var renames []rename
+		for key, val := range v {
+			if key == "_shhh" {
+				continue
+			}
+
+			newKey := key
+			if encrypting && !IsEncrypted(key) && key != "" {
+				encrypted, err := transform(key)
+				if err != nil {
+					return nil, fmt.Errorf("failed to encrypt key: %w", err)
+				}
+				newKey = encrypted
+			} else if !encrypting && IsEncrypted(key) {
+				decrypted, err := transform(key)
+				if err != nil {
+					return nil, fmt.Errorf("failed to decrypt key: %w", err)
+				}
+				newKey = decrypted
+			}
+
+			processed, err := p.processKeys(val, transform, encrypting, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			if newKey == key {
+				v[key] = processed
+			} else {
+				renames = append(renames, rename{key: newKey, val: processed})
+				delete(v, key)
+			}
+		}
+		for _, rn := range renames {
+			v[rn.key] = rn.val
+		}
+		return v, nil
+
+	case []interface{}:
+		for i, val := range v {
+			processed, err := p.processKeys(val, transform, encrypting, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = processed
+		}
+		return v, nil
+
+	default:
+		return v, nil
+	}
+}
+
 func AddJSONMetadata(content []byte, metadata map[string]interface{}) ([]byte, error) {
 	var data map[string]interface{}
 	if err := json.Unmarshal(content, &data); err != nil {
@@ -0,0 +1,8 @@
+package parser
+
+// KeyEncrypter is implemented by parsers whose documents have mapping keys
+// that can be encrypted along with the values. DecryptValues of such parsers
+// decrypts encrypted keys as well, so no option is needed to reverse it.
+type KeyEncrypter interface {
+	EncryptKeysAndValues(content []byte, encrypt EncryptFunc) ([]byte, error)
+}
@@ -0,0 +1,46 @@
+package parser
+
+import (
+	"strings"
+)
+
+// LineOptions adapt the line-based ENV parser to KEY=value variants, such as
+// Procfile-style "KEY: value" files or files using ";" comments. The zero
+// value is standard .env syntax.
+type LineOptions struct {
+	Delimiter string   `yaml:"delimiter,omitempty"`
+	Comments  []string `yaml:"comments,omitempty"`
+}
+
+func (o LineOptions) delimiter() string {
+	if o.Delimiter == "" {
+		return "="
+	}
+	return o.Delimiter
+}
+
+func (o LineOptions) isComment(trimmed string) bool {
+	comments := o.Comments
+	if len(comments) == 0 {
+		comments = []string{"#"}
+	}
+	for _, c := range comments {
+		if strings.HasPrefix(trimmed, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// FlattenFile is FlattenValues using the format and line options spec gives
+// filename.
+func FlattenFile(content []byte, filename string, spec Spec) ([]KeyValue, error) {
+	format := spec.FormatOf(filename)
+	if format == FormatENV {
+		if err := ValidateContentSize(content); err != nil {
+			return nil, err
+		}
+		return flattenENV(content, spec.Lines)
+	}
+	return FlattenValues(content, format)
+}
@@ -2,7 +2,6 @@ package parser
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 )
 
@@ -13,7 +12,51 @@ const (
 	MaxFileSize     = 50 * 1024 * 1024 // 50MB
 )
 
-var encPattern = regexp.MustCompile(`^ENC\[v1:([A-Za-z0-9+/=\s]+)\]$`)
+// An ENC token may end with "|k=" and the comma-separated key IDs it was
+// encrypted to, e.g. ENC[v1:hQEMA...|k=4C0668623651ECA4,01E1D31F5313EF71].
+const keyIDsMarker = "|k="
+
+// splitToken returns the base64 data and the key IDs of an ENC token. It
+// accepts what ^ENC\[v1:([A-Za-z0-9+/=\s]+)(?:\|k=([0-9A-F,]+))?\]$ would,
+// without a regular expression: tokens are long, and files can hold tens of
+// thousands of them.
+func splitToken(encoded string) (data, keyIDs string, ok bool) {
+	if len(encoded) < len(EncPrefix)+len(EncSuffix) || !strings.HasPrefix(encoded, EncPrefix) || !strings.HasSuffix(encoded, EncSuffix) {
+		return "", "", false
+	}
+	data = encoded[len(EncPrefix) : len(encoded)-len(EncSuffix)]
+	if i := strings.Index(data, keyIDsMarker); i >= 0 {
+		data, keyIDs = data[:i], data[i+len(keyIDsMarker):]
+		if keyIDs == "" || !allBytes(keyIDs, isKeyIDByte) {
+			return "", "", false
+		}
+	}
+	if data == "" || !allBytes(data, isBase64Byte) {
+		return "", "", false
+	}
+	return data, keyIDs, true
+}
+
+func allBytes(s string, valid func(byte) bool) bool {
+	for i := 0; i < len(s); i++ {
+		if !valid(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isBase64Byte(c byte) bool {
+	switch {
+	case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.IndexByte("+/= \t\n\f\r", c) >= 0
+}
+
+func isKeyIDByte(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'F' || c == ','
+}
 
 type EncryptFunc func(plaintext string) (string, error)
 type DecryptFunc func(ciphertext string) (string, error)
@@ -28,18 +71,75 @@ func EncodeValue(encryptedData []byte) string {
 	return EncPrefix + string(encryptedData) + EncSuffix
 }
 
+// EncodeAnnotatedValue is EncodeValue with the key IDs the value is
+// encrypted to recorded in the token.
+func EncodeAnnotatedValue(encryptedData []byte, keyIDs []string) string {
+	if len(keyIDs) == 0 {
+		return EncodeValue(encryptedData)
+	}
+	return EncPrefix + string(encryptedData) + "|k=" + strings.Join(keyIDs, ",") + EncSuffix
+}
+
+// Tokens longer than WrapThreshold, e.g. of embedded certificates, are
+// wrapped at WrapWidth in formats with multi-line values, so editors and
+// diffs do not face a single line of many kilobytes.
+const (
+	WrapThreshold = 4096
+	WrapWidth     = 76
+)
+
+// MaxUnwrappedToken is the longest token written in formats whose values
+// cannot span lines (JSON, INI, ENV). A file with a longer one is encrypted
+// whole instead.
+const MaxUnwrappedToken = 1 << 20
+
+// WrapToken breaks the data of a long ENC token into lines. DecodeValue
+// ignores the line breaks, so wrapped and unwrapped tokens are equivalent.
+func WrapToken(token string) string {
+	data, keyIDs, ok := splitToken(token)
+	if !ok || len(token) <= WrapThreshold || strings.ContainsAny(data, " \t\n\f\r") {
+		return token
+	}
+
+	var b strings.Builder
+	b.Grow(len(token) + len(data)/WrapWidth)
+	b.WriteString(EncPrefix)
+	for len(data) > WrapWidth {
+		b.WriteString(data[:WrapWidth])
+		b.WriteByte('\n')
+		data = data[WrapWidth:]
+	}
+	b.WriteString(data)
+	if keyIDs != "" {
+		b.WriteString(keyIDsMarker + keyIDs)
+	}
+	b.WriteString(EncSuffix)
+	return b.String()
+}
+
+// ValueKeyIDs returns the key IDs recorded in an ENC token, or nil when the
+// token has none.
+func ValueKeyIDs(encoded string) []string {
+	_, keyIDs, ok := splitToken(encoded)
+	if !ok || keyIDs == "" {
+		return nil
+	}
+	return strings.Split(keyIDs, ",")
+}
+
 func DecodeValue(encoded string) ([]byte, bool) {
-	matches := encPattern.FindStringSubmatch(encoded)
-	if len(matches) != 2 {
+	data, _, ok := splitToken(encoded)
+	if !ok {
 		return nil, false
 	}
-	cleaned := strings.ReplaceAll(matches[1], "\n", "")
+	cleaned := strings.ReplaceAll(data, "\n", "")
 	cleaned = strings.ReplaceAll(cleaned, " ", "")
 	return []byte(cleaned), true
 }
 
 func IsEncrypted(value string) bool {
-	return encPattern.MatchString(value)
+	_, _, ok := splitToken(value)
+	return ok
 }
 
 func ValidateContentSize(content []byte) error {
@@ -0,0 +1,474 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Patch is a batch of changes to a document, addressed by the key paths of
+// FlattenValues. Set values are applied in order, creating keys that do not
+// exist yet, before keys are removed.
+type Patch struct {
+	Set    []KeyValue
+	Remove []string
+}
+
+// ParsePatch reads a patch document in YAML or JSON:
+//
+//	set:
+//	  database.password: n3w-s3cret
+//	remove:
+//	  - legacy.token
+func ParsePatch(data []byte) (*Patch, error) {
+	if err := ValidateContentSize(data); err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse patch: %w", err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("patch must be a mapping with set and/or remove")
+	}
+
+	patch := &Patch{}
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		section, value := doc.Content[i].Value, doc.Content[i+1]
+		switch section {
+		case "set":
+			if value.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("patch set must map key paths to values")
+			}
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				key, v := value.Content[j].Value, value.Content[j+1]
+				if v.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("patch value for %s must be a scalar", key)
+				}
+				patch.Set = append(patch.Set, KeyValue{Key: key, Value: v.Value})
+			}
+		case "remove":
+			if value.Kind != yaml.SequenceNode {
+				return nil, fmt.Errorf("patch remove must be a list of key paths")
+			}
+			for _, k := range value.Content {
+				if k.Kind != yaml.ScalarNode {
+					return nil, fmt.Errorf("patch remove must be a list of key paths")
+				}
+				patch.Remove = append(patch.Remove, k.Value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown patch section %q (use set or remove)", section)
+		}
+	}
+
+	if len(patch.Set) == 0 && len(patch.Remove) == 0 {
+		return nil, fmt.Errorf("patch has no changes")
+	}
+	set := make(map[string]bool, len(patch.Set))
+	for _, kv := range patch.Set {
+		if kv.Key == "" {
+			return nil, fmt.Errorf("patch has an empty key path")
+		}
+		set[kv.Key] = true
+	}
+	for _, key := range patch.Remove {
+		if set[key] {
+			return nil, fmt.Errorf("patch both sets and removes %s", key)
+		}
+	}
+	return patch, nil
+}
+
+// ApplyPatch applies a patch to a plaintext document, using the format and
+// line options spec gives filename. It fails without partial changes if
+// any key path cannot be set or removed.
+func ApplyPatch(content []byte, filename string, spec Spec, patch *Patch) ([]byte, error) {
+	if err := ValidateContentSize(content); err != nil {
+		return nil, err
+	}
+
+	switch format := spec.FormatOf(filename); format {
+	case FormatYAML:
+		return patchYAML(content, patch)
+	case FormatJSON:
+		return patchJSON(content, patch)
+	case FormatINI:
+		return patchINI(content, patch)
+	case FormatENV:
+		return patchENV(content, spec.Lines, patch)
+	default:
+		return nil, fmt.Errorf("cannot patch %s files", format)
+	}
+}
+
+func patchYAML(content []byte, patch *Patch) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if len(root.Content) == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	doc := root.Content[0]
+
+	for _, kv := range patch.Set {
+		if err := setYAMLPath(doc, strings.Split(kv.Key, "."), kv.Value); err != nil {
+			return nil, fmt.Errorf("cannot set %s: %w", kv.Key, err)
+		}
+	}
+	for _, key := range patch.Remove {
+		if err := removeYAMLPath(doc, strings.Split(key, ".")); err != nil {
+			return nil, fmt.Errorf("cannot remove %s: %w", key, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, fmt.Errorf("failed to encode YAML: %w", err)
+	}
+	encoder.Close()
+
+	return buf.Bytes(), nil
+}
+
+func setYAMLPath(node *yaml.Node, path []string, value string) error {
+	for i, seg := range path {
+		last := i == len(path)-1
+		var child *yaml.Node
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == seg {
+					child = node.Content[j+1]
+					break
+				}
+			}
+			if child == nil {
+				child = newYAMLNode(last)
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: seg}, child)
+			}
+		case yaml.SequenceNode:
+			n, err := strconv.Atoi(seg)
+			if err != nil || n < 0 || n > len(node.Content) {
+				return fmt.Errorf("%s has no element %s", pathPrefix(path, i), seg)
+			}
+			if n == len(node.Content) {
+				node.Content = append(node.Content, newYAMLNode(last))
+			}
+			child = node.Content[n]
+		default:
+			return fmt.Errorf("%s is not a mapping or list", pathPrefix(path, i))
+		}
+
+		if child.Kind == yaml.AliasNode {
+			return fmt.Errorf("%s is an alias", strings.Join(path[:i+1], "."))
+		}
+		node = child
+	}
+
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("it is not a single value")
+	}
+	node.Value = value
+	node.Tag = "!!str"
+	node.Style = inferStyle(value)
+	return nil
+}
+
+func newYAMLNode(scalar bool) *yaml.Node {
+	if scalar {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str"}
+	}
+	return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+}
+
+func removeYAMLPath(node *yaml.Node, path []string) error {
+	for i, seg := range path {
+		last := i == len(path)-1
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			found := -1
+			for j := 0; j+1 < len(node.Content); j += 2 {
+				if node.Content[j].Value == seg {
+					found = j
+					break
+				}
+			}
+			if found == -1 {
+				return fmt.Errorf("not found")
+			}
+			if last {
+				node.Content = append(node.Content[:found], node.Content[found+2:]...)
+				return nil
+			}
+			node = node.Content[found+1]
+		case yaml.SequenceNode:
+			n, err := strconv.Atoi(seg)
+			if err != nil || n < 0 || n >= len(node.Content) {
+				return fmt.Errorf("not found")
+			}
+			if last {
+				node.Content = append(node.Content[:n], node.Content[n+1:]...)
+				return nil
+			}
+			node = node.Content[n]
+		default:
+			return fmt.Errorf("not found")
+		}
+	}
+	return nil
+}
+
+// pathPrefix names the first i segments of path, or the document itself.
+func pathPrefix(path []string, i int) string {
+	if i == 0 {
+		return "the document"
+	}
+	return strings.Join(path[:i], ".")
+}
+
+func patchJSON(content []byte, patch *Patch) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	var err error
+	for _, kv := range patch.Set {
+		if data, err = setJSONPath(data, strings.Split(kv.Key, "."), 0, kv.Value); err != nil {
+			return nil, fmt.Errorf("cannot set %s: %w", kv.Key, err)
+		}
+	}
+	for _, key := range patch.Remove {
+		if data, err = removeJSONPath(data, strings.Split(key, ".")); err != nil {
+			return nil, fmt.Errorf("cannot remove %s: %w", key, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(data); err != nil {
+		return nil, fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// setJSONPath returns value with path[i:] set, creating objects for
+// missing keys.
+func setJSONPath(value interface{}, path []string, i int, newValue string) (interface{}, error) {
+	if i == len(path) {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("it is not a single value")
+		}
+		return newValue, nil
+	}
+
+	seg := path[i]
+	switch v := value.(type) {
+	case map[string]interface{}:
+		child, ok := v[seg]
+		if !ok && i < len(path)-1 {
+			child = map[string]interface{}{}
+		}
+		updated, err := setJSONPath(child, path, i+1, newValue)
+		if err != nil {
+			return nil, err
+		}
+		v[seg] = updated
+		return v, nil
+	case []interface{}:
+		n, err := strconv.Atoi(seg)
+		if err != nil || n < 0 || n > len(v) {
+			return nil, fmt.Errorf("%s has no element %s", pathPrefix(path, i), seg)
+		}
+		if n == len(v) {
+			var child interface{}
+			if i < len(path)-1 {
+				child = map[string]interface{}{}
+			}
+			v = append(v, child)
+		}
+		updated, err := setJSONPath(v[n], path, i+1, newValue)
+		if err != nil {
+			return nil, err
+		}
+		v[n] = updated
+		return v, nil
+	case nil:
+		if i == 0 {
+			return setJSONPath(map[string]interface{}{}, path, i, newValue)
+		}
+	}
+	return nil, fmt.Errorf("%s is not an object or array", pathPrefix(path, i))
+}
+
+// removeJSONPath returns value with path removed.
+func removeJSONPath(value interface{}, path []string) (interface{}, error) {
+	seg, last := path[0], len(path) == 1
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		child, ok := v[seg]
+		if !ok {
+			return nil, fmt.Errorf("not found")
+		}
+		if last {
+			delete(v, seg)
+			return v, nil
+		}
+		updated, err := removeJSONPath(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		v[seg] = updated
+		return v, nil
+	case []interface{}:
+		n, err := strconv.Atoi(seg)
+		if err != nil || n < 0 || n >= len(v) {
+			return nil, fmt.Errorf("not found")
+		}
+		if last {
+			return append(v[:n], v[n+1:]...), nil
+		}
+		updated, err := removeJSONPath(v[n], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		v[n] = updated
+		return v, nil
+	}
+	return nil, fmt.Errorf("not found")
+}
+
+func patchINI(content []byte, patch *Patch) ([]byte, error) {
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse INI: %w", err)
+	}
+
+	for _, kv := range patch.Set {
+		section, key := splitINIPath(kv.Key)
+		cfg.Section(section).Key(key).SetValue(kv.Value)
+	}
+	for _, path := range patch.Remove {
+		section, key := splitINIPath(path)
+		s, err := cfg.GetSection(section)
+		if err != nil || !s.HasKey(key) {
+			return nil, fmt.Errorf("cannot remove %s: not found", path)
+		}
+		s.DeleteKey(key)
+	}
+
+	var buf bytes.Buffer
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode INI: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// splitINIPath splits a key path into its section, the default section when
+// there is none, and key.
+func splitINIPath(path string) (string, string) {
+	if i := strings.LastIndex(path, "."); i != -1 {
+		return path[:i], path[i+1:]
+	}
+	return ini.DefaultSection, path
+}
+
+func patchENV(content []byte, opts LineOptions, patch *Patch) ([]byte, error) {
+	set := make(map[string]string, len(patch.Set))
+	for _, kv := range patch.Set {
+		set[kv.Key] = kv.Value
+	}
+	remove := make(map[string]bool, len(patch.Remove))
+	for _, key := range patch.Remove {
+		remove[key] = true
+	}
+
+	delim := opts.delimiter()
+	found := make(map[string]bool)
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		eqIndex := strings.Index(line, delim)
+		if trimmed == "" || opts.isComment(trimmed) || eqIndex == -1 {
+			buf.WriteString(line + "\n")
+			continue
+		}
+
+		key := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[:eqIndex]), "export "))
+		if remove[key] {
+			found[key] = true
+			continue
+		}
+		value, ok := set[key]
+		if !ok {
+			buf.WriteString(line + "\n")
+			continue
+		}
+		found[key] = true
+
+		rest := line[eqIndex+len(delim):]
+		pad := rest[:len(rest)-len(strings.TrimLeft(rest, " \t"))]
+		_, wasQuoted, quoteChar := unquoteValue(rest)
+		buf.WriteString(line[:eqIndex] + delim + pad + envValue(value, opts, wasQuoted, quoteChar) + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+
+	for _, key := range patch.Remove {
+		if !found[key] {
+			return nil, fmt.Errorf("cannot remove %s: not found", key)
+		}
+	}
+	for _, kv := range patch.Set {
+		if found[kv.Key] {
+			continue
+		}
+		if strings.Contains(kv.Key, delim) || strings.ContainsAny(kv.Key, " \t\n") {
+			return nil, fmt.Errorf("cannot set %s: invalid key", kv.Key)
+		}
+		pad := ""
+		if delim != "=" {
+			pad = " "
+		}
+		buf.WriteString(kv.Key + delim + pad + envValue(kv.Value, opts, false, 0) + "\n")
+		found[kv.Key] = true
+	}
+
+	return buf.Bytes(), nil
+}
+
+// envValue formats a value for an ENV line, quoting it as needed in dotenv
+// syntax and as the line was quoted otherwise.
+func envValue(value string, opts LineOptions, wasQuoted bool, quoteChar byte) string {
+	if opts.Delimiter != "" && opts.Delimiter != "=" {
+		if wasQuoted {
+			return quoteValue(value, true, quoteChar)
+		}
+		return value
+	}
+	return quoteValue(value, wasQuoted || needsQuoting(value), quoteChar)
+}
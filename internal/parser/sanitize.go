@@ -0,0 +1,314 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v3"
+)
+
+// Placeholder is the value written in place of a secret at key path key.
+func Placeholder(key string) string {
+	return "<" + key + ">"
+}
+
+// Mask hides value behind one "*" per character, so its length stays
+// visible.
+func Mask(value string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(value))
+}
+
+// ExampleFile returns a copy of a plaintext document in which every value is
+// replaced by its Placeholder, using the format and line options spec gives
+// filename. Comments and the _shhh metadata block are dropped, since
+// either may reveal secrets.
+func ExampleFile(content []byte, filename string, spec Spec) ([]byte, error) {
+	return sanitize(content, filename, spec, sanitizer{
+		replace: func(key, _ string) string { return Placeholder(key) },
+	})
+}
+
+// PartialMask hides all but the first and last two characters of value,
+// enough to tell credentials apart. Values shorter than
+// minPartialMaskLen are masked entirely.
+func PartialMask(value string) string {
+	runes := []rune(value)
+	if len(runes) < minPartialMaskLen {
+		return Mask(value)
+	}
+	return string(runes[:2]) + strings.Repeat("*", len(runes)-4) + string(runes[len(runes)-2:])
+}
+
+// minPartialMaskLen is the shortest value PartialMask shows any of.
+const minPartialMaskLen = 8
+
+// RedactFile returns a copy of a plaintext document in which every value is
+// masked, keeping keys, structure and value lengths. Like ExampleFile, it
+// drops comments and the _shhh metadata block.
+func RedactFile(content []byte, filename string, spec Spec) ([]byte, error) {
+	return maskFile(content, filename, spec, Mask)
+}
+
+// MaskFile is RedactFile using PartialMask, for showing which credentials a
+// file holds without exposing them.
+func MaskFile(content []byte, filename string, spec Spec) ([]byte, error) {
+	return maskFile(content, filename, spec, PartialMask)
+}
+
+func maskFile(content []byte, filename string, spec Spec, mask func(string) string) ([]byte, error) {
+	masked, err := sanitize(content, filename, spec, sanitizer{
+		replace:    func(_, value string) string { return mask(value) },
+		keepQuotes: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to return a copy that still contains a value, in case a
+	// format quirk let one through.
+	values, err := FlattenFile(content, filename, spec)
+	if err != nil {
+		return nil, err
+	}
+	for _, kv := range values {
+		if len(kv.Value) >= minMaskCheckLen && bytes.Contains(masked, []byte(kv.Value)) {
+			return nil, fmt.Errorf("masked copy still contains the value of %s", kv.Key)
+		}
+	}
+	return masked, nil
+}
+
+// minMaskCheckLen is the shortest value maskFile checks for in its output;
+// shorter values such as "true" or "1" also occur in key names.
+const minMaskCheckLen = 6
+
+// sanitizer rewrites every value of a document.
+type sanitizer struct {
+	// replace returns the text written in place of value at key path key.
+	replace func(key, value string) string
+	// keepQuotes keeps the quotes around quoted ENV values.
+	keepQuotes bool
+}
+
+func sanitize(content []byte, filename string, spec Spec, s sanitizer) ([]byte, error) {
+	if err := ValidateContentSize(content); err != nil {
+		return nil, err
+	}
+
+	switch format := spec.FormatOf(filename); format {
+	case FormatYAML:
+		return sanitizeYAML(content, s)
+	case FormatJSON:
+		return sanitizeJSON(content, s)
+	case FormatINI:
+		return sanitizeINI(content, s)
+	case FormatENV:
+		return sanitizeENV(content, spec.Lines, s)
+	default:
+		return nil, fmt.Errorf("cannot sanitize %s files", format)
+	}
+}
+
+func sanitizeYAML(content []byte, s sanitizer) ([]byte, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	var walk func(node *yaml.Node, prefix string, depth int) error
+	walk = func(node *yaml.Node, prefix string, depth int) error {
+		if depth > MaxNestingDepth {
+			return fmt.Errorf("maximum nesting depth exceeded")
+		}
+		node.HeadComment, node.LineComment, node.FootComment = "", "", ""
+
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				if err := walk(child, prefix, depth+1); err != nil {
+					return err
+				}
+			}
+		case yaml.MappingNode:
+			var kept []*yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				keyNode, valueNode := node.Content[i], node.Content[i+1]
+				if prefix == "" && keyNode.Value == "_shhh" {
+					continue
+				}
+				keyNode.HeadComment, keyNode.LineComment, keyNode.FootComment = "", "", ""
+				if err := walk(valueNode, joinKey(prefix, keyNode.Value), depth+1); err != nil {
+					return err
+				}
+				kept = append(kept, keyNode, valueNode)
+			}
+			node.Content = kept
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				if err := walk(child, joinKey(prefix, strconv.Itoa(i)), depth+1); err != nil {
+					return err
+				}
+			}
+		case yaml.ScalarNode:
+			node.Value = s.replace(prefix, node.Value)
+			node.Tag = "!!str"
+			node.Style = 0
+		case yaml.AliasNode:
+			// Aliases keep pointing at the anchored node, which is replaced
+			// where it is defined.
+		}
+		return nil
+	}
+
+	if err := walk(&root, "", 0); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return nil, fmt.Errorf("failed to encode YAML: %w", err)
+	}
+	encoder.Close()
+
+	return buf.Bytes(), nil
+}
+
+func sanitizeJSON(content []byte, s sanitizer) ([]byte, error) {
+	// Decode numbers as written, so their length is kept when masked.
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+
+	var walk func(value interface{}, prefix string, depth int) (interface{}, error)
+	walk = func(value interface{}, prefix string, depth int) (interface{}, error) {
+		if depth > MaxNestingDepth {
+			return nil, fmt.Errorf("maximum nesting depth exceeded")
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			result := make(map[string]interface{}, len(v))
+			for _, k := range keys {
+				if prefix == "" && k == "_shhh" {
+					continue
+				}
+				processed, err := walk(v[k], joinKey(prefix, k), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				result[k] = processed
+			}
+			return result, nil
+		case []interface{}:
+			result := make([]interface{}, len(v))
+			for i, item := range v {
+				processed, err := walk(item, joinKey(prefix, strconv.Itoa(i)), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				result[i] = processed
+			}
+			return result, nil
+		case nil:
+			return nil, nil
+		default:
+			return s.replace(prefix, fmt.Sprint(v)), nil
+		}
+	}
+
+	result, err := walk(data, "", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep "<" and ">" readable instead of escaping them for HTML.
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		return nil, fmt.Errorf("failed to encode JSON: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func sanitizeINI(content []byte, s sanitizer) ([]byte, error) {
+	cfg, err := ini.Load(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse INI: %w", err)
+	}
+	cfg.DeleteSection("_shhh")
+
+	for _, section := range cfg.Sections() {
+		section.Comment = ""
+		prefix := section.Name()
+		if prefix == ini.DefaultSection {
+			prefix = ""
+		}
+		for _, key := range section.Keys() {
+			key.Comment = ""
+			key.SetValue(s.replace(joinKey(prefix, key.Name()), key.Value()))
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := cfg.WriteTo(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode INI: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func sanitizeENV(content []byte, opts LineOptions, s sanitizer) ([]byte, error) {
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			buf.WriteString("\n")
+			continue
+		}
+		if opts.isComment(trimmed) || strings.HasPrefix(trimmed, "_SHHH_") {
+			continue
+		}
+
+		delim := opts.delimiter()
+		eqIndex := strings.Index(line, delim)
+		if eqIndex == -1 {
+			continue
+		}
+
+		value := line[eqIndex+len(delim):]
+		pad := value[:len(value)-len(strings.TrimLeft(value, " \t"))]
+		key := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line[:eqIndex]), "export "))
+		unquoted, wasQuoted, quoteChar := unquoteValue(value)
+		replaced := s.replace(key, unquoted)
+		if wasQuoted && s.keepQuotes {
+			replaced = string(quoteChar) + replaced + string(quoteChar)
+		}
+		buf.WriteString(line[:eqIndex] + delim + pad + replaced + "\n")
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read content: %w", err)
+	}
+	return buf.Bytes(), nil
+}
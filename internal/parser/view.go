@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FormatValuesView renders flattened values as a YAML mapping of key paths
+// to values, under a comment explaining how to edit it, so a document can
+// be edited without its structure.
+func FormatValuesView(values []KeyValue, header string) ([]byte, error) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, kv := range values {
+		mapping.Content = append(mapping.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: kv.Key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: kv.Value, Style: inferStyle(kv.Value)})
+	}
+	doc := &yaml.Node{Kind: yaml.DocumentNode, HeadComment: header, Content: []*yaml.Node{mapping}}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to encode values: %w", err)
+	}
+	encoder.Close()
+	return buf.Bytes(), nil
+}
+
+// ParseValuesView reads back a view written by FormatValuesView.
+func ParseValuesView(data []byte) ([]KeyValue, error) {
+	if err := ValidateContentSize(data); err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse values: %w", err)
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("values must be a mapping of key paths to values")
+	}
+
+	seen := make(map[string]bool)
+	values := make([]KeyValue, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key, value := mapping.Content[i].Value, mapping.Content[i+1]
+		if key == "" {
+			return nil, fmt.Errorf("values have an empty key path")
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("key path %s appears twice", key)
+		}
+		seen[key] = true
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("value for %s must be a scalar", key)
+		}
+		values = append(values, KeyValue{Key: key, Value: value.Value})
+	}
+	return values, nil
+}
+
+// DiffValues returns the patch that turns the before values into the
+// after values: changed and added key paths are set, missing ones removed.
+// It has no changes when the values are the same.
+func DiffValues(before, after []KeyValue) *Patch {
+	old := make(map[string]string, len(before))
+	for _, kv := range before {
+		old[kv.Key] = kv.Value
+	}
+
+	patch := &Patch{}
+	kept := make(map[string]bool, len(after))
+	for _, kv := range after {
+		kept[kv.Key] = true
+		if value, ok := old[kv.Key]; !ok || value != kv.Value {
+			patch.Set = append(patch.Set, kv)
+		}
+	}
+	// Last first, so removing list elements does not shift the indices of
+	// the ones still to remove.
+	for i := len(before) - 1; i >= 0; i-- {
+		if !kept[before[i].Key] {
+			patch.Remove = append(patch.Remove, before[i].Key)
+		}
+	}
+	return patch
+}
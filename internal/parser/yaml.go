@@ -3,6 +3,7 @@ package parser
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 
 	"gopkg.in/yaml.v3"
@@ -15,6 +16,16 @@ func (p *YAMLParser) FileType() string {
 }
 
 func (p *YAMLParser) EncryptValues(content []byte, encrypt EncryptFunc) ([]byte, error) {
+	return p.encrypt(content, encrypt, false)
+}
+
+// EncryptKeysAndValues encrypts mapping keys as well as values. Keys keep
+// their position, so decryption restores the original order.
+func (p *YAMLParser) EncryptKeysAndValues(content []byte, encrypt EncryptFunc) ([]byte, error) {
+	return p.encrypt(content, encrypt, true)
+}
+
+func (p *YAMLParser) encrypt(content []byte, encrypt EncryptFunc, keys bool) ([]byte, error) {
 	if err := ValidateContentSize(content); err != nil {
 		return nil, err
 	}
@@ -28,6 +39,12 @@ func (p *YAMLParser) EncryptValues(content []byte, encrypt EncryptFunc) ([]byte,
 		return nil, err
 	}
 
+	if keys {
+		if err := p.processKeys(&root, encrypt, true, 0); err != nil {
+			return nil, err
+		}
+	}
+
 	var buf bytes.Buffer
 	encoder := yaml.NewEncoder(&buf)
 	encoder.SetIndent(2)
@@ -53,6 +70,10 @@ func (p *YAMLParser) DecryptValues(content []byte, decrypt DecryptFunc) ([]byte,
 		return nil, err
 	}
 
+	if err := p.processKeys(&root, decrypt, false, 0); err != nil {
+		return nil, err
+	}
+
 	var buf bytes.Buffer
 	encoder := yaml.NewEncoder(&buf)
 	encoder.SetIndent(2)
@@ -131,6 +152,58 @@ func (p *YAMLParser) processNode(node *yaml.Node, transform func(string) (string
 	return nil
 }
 
+// processKeys transforms mapping keys. The _shhh metadata key and YAML merge
+// keys ("<<") are left alone so the document keeps its structure.
+func (p *YAMLParser) processKeys(node *yaml.Node, transform func(string) (string, error), encrypting bool, depth int) error {
+	if depth > MaxNestingDepth {
+		return fmt.Errorf("maximum nesting depth exceeded")
+	}
+
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			if err := p.processKeys(child, transform, encrypting, depth+1); err != nil {
+				return err
+			}
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			keyNode := node.Content[i]
+			valueNode := node.Content[i+1]
+
+			if keyNode.Value == "_shhh" {
+				continue
+			}
+
+			if keyNode.Kind == yaml.ScalarNode && keyNode.Value != "<<" {
+				if encrypting && !IsEncrypted(keyNode.Value) && keyNode.Value != "" {
+					encrypted, err := transform(keyNode.Value)
+					if err != nil {
+						return fmt.Errorf("failed to encrypt key: %w", err)
+					}
+					keyNode.Value = encrypted
+					keyNode.Tag = "!!str"
+					keyNode.Style = 0
+				} else if !encrypting && IsEncrypted(keyNode.Value) {
+					decrypted, err := transform(keyNode.Value)
+					if err != nil {
+						return fmt.Errorf("failed to decrypt key: %w", err)
+					}
+					keyNode.Value = decrypted
+					keyNode.Style = inferStyle(decrypted)
+				}
+			}
+
+			if err := p.processKeys(valueNode, transform, encrypting, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func inferStyle(value string) yaml.Style {
 	if strings.Contains(value, "\n") {
 		return yaml.LiteralStyle
@@ -138,6 +211,17 @@ func inferStyle(value string) yaml.Style {
 	return 0
 }
 
+// sortedMetadataKeys returns the keys of metadata in a fixed order, so
+// encrypting the same file twice writes the same metadata block.
+func sortedMetadataKeys(metadata map[string]interface{}) []string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func AddShhhMetadata(content []byte, metadata map[string]interface{}) ([]byte, error) {
 	var root yaml.Node
 	if err := yaml.Unmarshal(content, &root); err != nil {
@@ -154,9 +238,9 @@ func AddShhhMetadata(content []byte, metadata map[string]interface{}) ([]byte, e
 	}
 
 	metaNode := &yaml.Node{Kind: yaml.MappingNode}
-	for k, v := range metadata {
+	for _, k := range sortedMetadataKeys(metadata) {
 		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Value: k}
-		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprintf("%v", v)}
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprintf("%v", metadata[k])}
 		metaNode.Content = append(metaNode.Content, keyNode, valueNode)
 	}
 
@@ -175,22 +259,16 @@ func AddShhhMetadata(content []byte, metadata map[string]interface{}) ([]byte, e
 }
 
 func GetShhhMetadata(content []byte) (map[string]string, error) {
-	var data map[string]interface{}
+	// Decode into strings so scalars keep their written form; decoding into
+	// interface{} would turn encrypted_at into a time.Time.
+	var data struct {
+		Shhh map[string]string `yaml:"_shhh"`
+	}
 	if err := yaml.Unmarshal(content, &data); err != nil {
 		return nil, err
 	}
 
-	shhh, ok := data["_shhh"].(map[string]interface{})
-	if !ok {
-		return nil, nil
-	}
-
-	result := make(map[string]string)
-	for k, v := range shhh {
-		result[k] = fmt.Sprintf("%v", v)
-	}
-
-	return result, nil
+	return data.Shhh, nil
 }
 
 func RemoveShhhMetadata(content []byte) ([]byte, error) {
@@ -0,0 +1,107 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// AllowEntry is one line of the coverage allowlist: a gitignore-style
+// pattern for files that look like secrets but are not, and why.
+type AllowEntry struct {
+	Pattern string
+	Reason  string
+	matcher *gitignore.Matcher
+}
+
+// Allowlist holds the files 'shhh coverage' accepts without registration.
+// Unlike .shhhignore, allowed files are still scanned and reported, and
+// entries that no longer match anything are flagged so the list stays
+// honest.
+type Allowlist struct {
+	Entries []AllowEntry
+	used    []bool
+}
+
+// LoadAllowlist reads .shhh/coverage-allow. Each line holds a pattern,
+// optionally followed by " # reason". A missing file is an empty list.
+func LoadAllowlist(s *store.Store) (*Allowlist, error) {
+	a := &Allowlist{}
+
+	f, err := os.Open(s.AllowlistPath())
+	if os.IsNotExist(err) {
+		return a, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open coverage allowlist: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pattern, reason, _ := strings.Cut(line, " #")
+		pattern = strings.TrimSpace(pattern)
+		patterns := gitignore.ParsePatterns([]string{pattern}, "")
+		if len(patterns) == 0 {
+			continue
+		}
+		a.Entries = append(a.Entries, AllowEntry{
+			Pattern: pattern,
+			Reason:  strings.TrimSpace(reason),
+			matcher: gitignore.NewMatcher(patterns),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read coverage allowlist: %w", err)
+	}
+	a.used = make([]bool, len(a.Entries))
+	return a, nil
+}
+
+// Match returns the entry that allows relPath, if any, and marks it used.
+func (a *Allowlist) Match(relPath string) (*AllowEntry, bool) {
+	for i := range a.Entries {
+		if a.Entries[i].matcher.Ignored(relPath) {
+			a.used[i] = true
+			return &a.Entries[i], true
+		}
+	}
+	return nil, false
+}
+
+// Unused returns the entries that matched no file since the list was
+// loaded.
+func (a *Allowlist) Unused() []AllowEntry {
+	var unused []AllowEntry
+	for i, used := range a.used {
+		if !used {
+			unused = append(unused, a.Entries[i])
+		}
+	}
+	return unused
+}
+
+// AddAllowed appends a pattern, with an optional reason, to the allowlist.
+func AddAllowed(s *store.Store, pattern, reason string) error {
+	f, err := os.OpenFile(s.AllowlistPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, store.FilePerms)
+	if err != nil {
+		return fmt.Errorf("failed to open coverage allowlist: %w", err)
+	}
+	defer f.Close()
+
+	line := pattern
+	if reason != "" {
+		line += " # " + reason
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("failed to write coverage allowlist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package scan
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/store"
+)
+
+// IgnoreFile holds gitignore-style patterns for paths shhh should never scan
+// or register, such as vendored and generated trees.
+const IgnoreFile = ".shhhignore"
+
+// alwaysIgnored is applied before .shhhignore.
+var alwaysIgnored = []string{
+	".git/",
+	".shhh/",
+	"*.enc",
+	"*.gpg",
+	"*.enc.meta",
+	"*.example",
+	IgnoreFile,
+}
+
+// LoadIgnore builds a matcher from the built-in exclusions plus the
+// project's .shhhignore, if any.
+func LoadIgnore(root string) *gitignore.Matcher {
+	patterns := gitignore.ParsePatterns(alwaysIgnored, "")
+
+	f, err := os.Open(filepath.Join(root, IgnoreFile))
+	if err != nil {
+		return gitignore.NewMatcher(patterns)
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return gitignore.NewMatcher(patterns, gitignore.ParsePatterns(lines, ""))
+}
+
+// Walk calls fn for every regular file under dir (relative to root) that the
+// ignore matcher does not exclude. Nested shhh projects are skipped. Paths
+// passed to fn are relative to root.
+func Walk(root, dir string, ignore *gitignore.Matcher, fn func(relPath string) error) error {
+	start := filepath.Join(root, dir)
+
+	return filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		slashPath := filepath.ToSlash(relPath)
+		if d.IsDir() {
+			if ignore.Match(slashPath, true) || store.IsNestedRoot(root, p) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.Type().IsRegular() || ignore.Match(slashPath, false) {
+			return nil
+		}
+
+		return fn(relPath)
+	})
+}
+
+var candidateNames = []string{
+	".env", ".env.*", "*.env",
+	"secrets.*", "secret.*", "*secrets*.*",
+	"credentials*", "*credentials*.*",
+	"*.pem", "*.key", "*.p12", "*.pfx", "*.jks", "*.keystore",
+	"id_rsa", "id_ecdsa", "id_ed25519",
+	".npmrc", ".pypirc", ".netrc", ".htpasswd",
+	"*.tfvars",
+}
+
+var configExtensions = map[string]bool{
+	".yaml": true, ".yml": true, ".json": true, ".ini": true, ".env": true,
+	".conf": true, ".cfg": true, ".properties": true, ".toml": true,
+}
+
+var secretKeyPattern = regexp.MustCompile(`(?i)^\s*(export\s+)?["']?[\w.-]*(password|passwd|secret|token|api[_-]?key|private[_-]?key|access[_-]?key|client[_-]?secret)[\w.-]*["']?\s*[:=]\s*\S`)
+
+var privateKeyHeader = regexp.MustCompile(`-----BEGIN ([A-Z]+ )?PRIVATE KEY-----`)
+
+// Candidate reports whether a file looks like it holds secrets, with a short
+// reason. Names are checked first; content is only read for small files.
+func Candidate(root, relPath string) (string, bool) {
+	base := path.Base(filepath.ToSlash(relPath))
+	for _, pattern := range candidateNames {
+		if ok, _ := path.Match(pattern, strings.ToLower(base)); ok {
+			return "file name matches " + pattern, true
+		}
+	}
+
+	fullPath := filepath.Join(root, relPath)
+	info, err := os.Stat(fullPath)
+	if err != nil || info.Size() > 1<<20 {
+		return "", false
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil || isBinary(content) {
+		return "", false
+	}
+
+	if privateKeyHeader.Match(content) {
+		return "contains a private key", true
+	}
+
+	// Key/value heuristics are limited to configuration files; source code
+	// mentions "password" far too often to be useful.
+	if !configExtensions[strings.ToLower(path.Ext(base))] {
+		return "", false
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.Contains(text, "ENC[v1:") {
+			continue
+		}
+		if secretKeyPattern.MatchString(text) {
+			return "secret-like key on line " + strconv.Itoa(line), true
+		}
+	}
+
+	return "", false
+}
+
+func isBinary(content []byte) bool {
+	n := len(content)
+	if n > 8000 {
+		n = 8000
+	}
+	for _, b := range content[:n] {
+		if b == 0 {
+			return true
+		}
+	}
+	return false
+}
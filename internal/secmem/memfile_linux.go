@@ -0,0 +1,50 @@
+package secmem
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// MemFile is an anonymous in-memory file (memfd_create) with no directory
+// entry, for handing plaintext to a program that needs a path. Programs
+// must write the path in place: replacing it by rename fails.
+type MemFile struct {
+	f *os.File
+}
+
+// NewMemFile creates an anonymous file holding content.
+func NewMemFile(name string, content []byte) (*MemFile, error) {
+	fd, err := unix.MemfdCreate(name, unix.MFD_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("memfd_create: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), name)
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &MemFile{f: f}, nil
+}
+
+// Path is a name through which other processes of the same user can open
+// the file while it is open here.
+func (m *MemFile) Path() string {
+	return fmt.Sprintf("/proc/%d/fd/%d", os.Getpid(), m.f.Fd())
+}
+
+// ReadAll returns the current content of the file.
+func (m *MemFile) ReadAll() ([]byte, error) {
+	if _, err := m.f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(m.f)
+}
+
+// Close discards the content and releases the file.
+func (m *MemFile) Close() error {
+	m.f.Truncate(0)
+	return m.f.Close()
+}
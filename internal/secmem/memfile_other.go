@@ -0,0 +1,21 @@
+//go:build !linux
+
+package secmem
+
+import "errors"
+
+// MemFile is an anonymous in-memory file. It is only available on Linux.
+type MemFile struct{}
+
+// NewMemFile reports that anonymous files are not supported.
+func NewMemFile(name string, content []byte) (*MemFile, error) {
+	return nil, errors.New("anonymous in-memory files are only supported on Linux")
+}
+
+func (m *MemFile) Path() string { return "" }
+
+func (m *MemFile) ReadAll() ([]byte, error) {
+	return nil, errors.New("anonymous in-memory files are only supported on Linux")
+}
+
+func (m *MemFile) Close() error { return nil }
@@ -0,0 +1,70 @@
+// Package secmem is a best-effort memory hygiene layer for plaintext
+// secrets: buffers are zeroed after use and, when hardening is enabled,
+// locked into RAM so they are not swapped out, with core dumps disabled.
+//
+// Go strings are immutable and the garbage collector may copy memory, so
+// this narrows the window in which secrets sit in memory; it cannot
+// guarantee that no copy remains.
+package secmem
+
+import (
+	"os"
+	"runtime"
+	"sync"
+)
+
+var (
+	mu        sync.Mutex
+	hardening bool
+)
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+	clear(b)
+	runtime.KeepAlive(b)
+}
+
+// Harden disables core dumps and makes Protect lock buffers into RAM. It
+// returns the first error from the platform, after which the remaining
+// measures still apply where possible.
+func Harden() error {
+	mu.Lock()
+	hardening = true
+	mu.Unlock()
+	return disableCoreDumps()
+}
+
+// Protect locks b into RAM when hardening is enabled and returns a function
+// that wipes and unlocks it, meant to be deferred:
+//
+//	defer secmem.Protect(plaintext)()
+func Protect(b []byte) func() {
+	mu.Lock()
+	locked := hardening && len(b) > 0 && lock(b) == nil
+	mu.Unlock()
+
+	return func() {
+		Wipe(b)
+		if locked {
+			unlock(b)
+		}
+	}
+}
+
+// ShredFile overwrites a plaintext file with zeros before it is removed, so
+// its blocks do not keep the content. Errors are ignored: the file may
+// already be gone, and journaling or copy-on-write filesystems may keep
+// old blocks regardless.
+func ShredFile(path string) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(make([]byte, info.Size()))
+	f.Sync()
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package secmem
+
+import "errors"
+
+var errUnsupported = errors.New("memory locking is not supported on this platform")
+
+func lock(b []byte) error {
+	return errUnsupported
+}
+
+func unlock(b []byte) {}
+
+func disableCoreDumps() error {
+	return errUnsupported
+}
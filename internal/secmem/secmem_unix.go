@@ -0,0 +1,17 @@
+//go:build unix
+
+package secmem
+
+import "golang.org/x/sys/unix"
+
+func lock(b []byte) error {
+	return unix.Mlock(b)
+}
+
+func unlock(b []byte) {
+	unix.Munlock(b)
+}
+
+func disableCoreDumps() error {
+	return unix.Setrlimit(unix.RLIMIT_CORE, &unix.Rlimit{Cur: 0, Max: 0})
+}
@@ -0,0 +1,17 @@
+package secmem
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// InMemoryFS reports whether path is on a filesystem kept in memory, tmpfs
+// or ramfs, so files written there never reach a disk (unless swapped).
+func InMemoryFS(path string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, fmt.Errorf("statfs %s: %w", path, err)
+	}
+	return st.Type == unix.TMPFS_MAGIC || st.Type == unix.RAMFS_MAGIC, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package secmem
+
+// InMemoryFS reports whether path is on a filesystem kept in memory. It can
+// only tell on Linux, and reports false elsewhere.
+func InMemoryFS(path string) (bool, error) {
+	return false, nil
+}
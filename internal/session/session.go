@@ -0,0 +1,106 @@
+// Package session caches approvals for the lifetime of a terminal session,
+// so a confirmation given once is not asked for again by later commands
+// run from the same terminal, and is not valid in any other.
+package session
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxAge bounds how long an approval lasts, in case the session outlives a
+// working day or its ID is reused after a reboot.
+const MaxAge = 12 * time.Hour
+
+// dir returns the private directory holding approval caches: under
+// $XDG_RUNTIME_DIR, which is cleared at logout, or the temp directory.
+func dir() (string, error) {
+	base := os.Getenv("XDG_RUNTIME_DIR")
+	name := "shhh"
+	if base == "" {
+		base = os.TempDir()
+		name = fmt.Sprintf("shhh-%d", os.Getuid())
+	}
+	path := filepath.Join(base, name)
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return "", fmt.Errorf("failed to create session directory: %w", err)
+	}
+	// A directory someone else created, or a symlink, could let them
+	// read or plant approvals.
+	if err := checkPrivate(path); err != nil {
+		return "", fmt.Errorf("session directory %s: %w", path, err)
+	}
+	return path, nil
+}
+
+func cachePath() (string, error) {
+	d, err := dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(d, "approvals-"+ID()), nil
+}
+
+// Approved reports whether scope was approved in this session within
+// MaxAge.
+func Approved(scope string) bool {
+	path, err := cachePath()
+	if err != nil {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		at, approved, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok || approved != scope {
+			continue
+		}
+		if unix, err := strconv.ParseInt(at, 10, 64); err == nil && time.Since(time.Unix(unix, 0)) < MaxAge {
+			return true
+		}
+	}
+	return false
+}
+
+// Approve records that scope was approved in this session.
+func Approve(scope string) error {
+	if strings.ContainsAny(scope, "\n") {
+		return fmt.Errorf("invalid approval scope %q", scope)
+	}
+	path, err := cachePath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open session approvals: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := fmt.Fprintf(f, "%d\t%s\n", time.Now().Unix(), scope); err != nil {
+		return fmt.Errorf("failed to write session approvals: %w", err)
+	}
+	return nil
+}
+
+// Revoke forgets every approval given in this session.
+func Revoke() error {
+	path, err := cachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove session approvals: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package session
+
+import (
+	"os"
+	"strconv"
+)
+
+// ID identifies the terminal session by the parent process, usually the
+// shell, where sessions are not available.
+func ID() string {
+	return "ppid" + strconv.Itoa(os.Getppid())
+}
+
+// checkPrivate relies on the temp directory's own permissions where Unix
+// ownership is not available.
+func checkPrivate(path string) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package session
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ID identifies the terminal session: the Unix session ID, shared by every
+// process started from the same terminal.
+func ID() string {
+	sid, err := unix.Getsid(0)
+	if err != nil {
+		return "ppid" + strconv.Itoa(os.Getppid())
+	}
+	return strconv.Itoa(sid)
+}
+
+// checkPrivate fails unless path is a real directory owned by the current
+// user and closed to everyone else.
+func checkPrivate(path string) error {
+	var st unix.Stat_t
+	if err := unix.Lstat(path, &st); err != nil {
+		return err
+	}
+	switch {
+	case st.Mode&unix.S_IFMT != unix.S_IFDIR:
+		return fmt.Errorf("not a directory")
+	case int(st.Uid) != os.Getuid():
+		return fmt.Errorf("owned by another user")
+	case st.Mode&0077 != 0:
+		return fmt.Errorf("accessible by other users")
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package store
+
+// lockDir is a no-op where advisory locks are not supported; writes are
+// still atomic and serialised within the process.
+func lockDir(dir string) (func(), error) {
+	return func() {}, nil
+}
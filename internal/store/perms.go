@@ -0,0 +1,79 @@
+package store
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// PermissionIssue is a path in .shhh that other users can read or write.
+type PermissionIssue struct {
+	// Path is relative to the project root.
+	Path    string
+	Mode    fs.FileMode
+	Problem string
+}
+
+// CheckPermissions reports the directories and files in .shhh that other
+// users can read, or that anyone but the owner can write. shhh creates them
+// with DirPerms and FilePerms, but a git checkout uses the umask instead.
+// Permission bits are not checked on Windows.
+func (s *Store) CheckPermissions() ([]PermissionIssue, error) {
+	if runtime.GOOS == "windows" {
+		return nil, nil
+	}
+
+	var issues []PermissionIssue
+	err := filepath.WalkDir(s.ShhhPath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		mode := info.Mode().Perm()
+		readable := fs.FileMode(0004)
+		if d.IsDir() {
+			readable = 0005
+		}
+		var problem string
+		switch {
+		case mode&0022 != 0:
+			problem = "writable by other users"
+		case mode&readable != 0:
+			problem = "world-readable"
+		default:
+			return nil
+		}
+
+		rel, _ := filepath.Rel(s.root, path)
+		issues = append(issues, PermissionIssue{Path: rel, Mode: mode, Problem: problem})
+		return nil
+	})
+	return issues, err
+}
+
+// FixPermissions resets the paths of issues to DirPerms or FilePerms.
+func (s *Store) FixPermissions(issues []PermissionIssue) error {
+	for _, issue := range issues {
+		path := filepath.Join(s.root, issue.Path)
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		perms := fs.FileMode(FilePerms)
+		if info.IsDir() {
+			perms = DirPerms
+		}
+		if err := os.Chmod(path, perms); err != nil {
+			return err
+		}
+	}
+	return nil
+}
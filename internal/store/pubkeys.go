@@ -0,0 +1,75 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// pubkeysMu serialises pubkey cache writes within the process; lockDir
+// does the same across processes where the platform allows.
+var pubkeysMu sync.Mutex
+
+// WritePubkey caches the public key of email in .shhh/pubkeys. Writers hold
+// a lock on the directory and replace the file atomically, so concurrent
+// runs never leave a truncated key, and the file is read back to verify it.
+func (s *Store) WritePubkey(email string, key []byte) error {
+	dir := s.PubkeysPath()
+	if err := os.MkdirAll(dir, DirPerms); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	pubkeysMu.Lock()
+	defer pubkeysMu.Unlock()
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to lock public key cache: %w", err)
+	}
+	defer unlock()
+
+	path := s.PubkeyPath(email)
+	if err := writeAtomic(path, key); err != nil {
+		return err
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify cached public key: %w", err)
+	}
+	if !bytes.Equal(written, key) {
+		return fmt.Errorf("cached public key of %s does not match what was written", email)
+	}
+	return nil
+}
+
+// writeAtomic writes data to a temporary file next to path and renames it
+// over path, so readers see either the old or the new content.
+func writeAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := tmp.Chmod(FilePerms); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
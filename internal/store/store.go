@@ -13,6 +13,10 @@ const (
 	VaultsDir    = "vaults"
 	PubkeysDir   = "pubkeys"
 	VaultFile    = "vault.yaml"
+	HooksFile    = "hooks.yaml"
+	AuditLogFile = "audit.log"
+	JournalFile  = "reencrypt.journal"
+	AllowFile    = "coverage-allow"
 	DirPerms     = 0700
 	FilePerms    = 0600
 	DefaultVault = "default"
@@ -52,6 +56,43 @@ func (s *Store) VaultConfigPath(vault string) string {
 	return filepath.Join(s.VaultPath(vault), VaultFile)
 }
 
+func (s *Store) HooksPath() string {
+	return filepath.Join(s.ShhhPath(), HooksFile)
+}
+
+// AuditLogPath is the local, git-ignored log of encryptions, decryptions
+// and shares.
+func (s *Store) AuditLogPath() string {
+	return filepath.Join(s.ShhhPath(), AuditLogFile)
+}
+
+// LocalGitignorePath is .shhh/.gitignore, which keeps the files of .shhh
+// that only matter on one machine out of git.
+func (s *Store) LocalGitignorePath() string {
+	return filepath.Join(s.ShhhPath(), ".gitignore")
+}
+
+// EnsureLocalIgnores writes .shhh/.gitignore unless it exists, so that
+// writing the audit log or re-encryption journal never needs to change the
+// project's own .gitignore.
+func (s *Store) EnsureLocalIgnores() error {
+	if _, err := os.Stat(s.LocalGitignorePath()); err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	return WriteFile(s.LocalGitignorePath(), []byte("/"+AuditLogFile+"\n/"+JournalFile+"\n"))
+}
+
+// ReencryptJournalPath is the local, git-ignored record of the files a bulk
+// re-encryption has finished, used to resume it after an interruption.
+func (s *Store) ReencryptJournalPath() string {
+	return filepath.Join(s.ShhhPath(), JournalFile)
+}
+
+// AllowlistPath lists files 'shhh coverage' accepts without registration.
+func (s *Store) AllowlistPath() string {
+	return filepath.Join(s.ShhhPath(), AllowFile)
+}
+
 func (s *Store) PubkeysPath() string {
 	return filepath.Join(s.ShhhPath(), PubkeysDir)
 }
@@ -86,7 +127,7 @@ func (s *Store) Initialize() error {
 		}
 	}
 
-	return nil
+	return s.EnsureLocalIgnores()
 }
 
 func (s *Store) EnsureInitialized() error {
@@ -172,7 +213,26 @@ func validateName(name string) error {
 	return nil
 }
 
+var rootOverride string
+
+// SetRoot pins the project root returned by FindRoot instead of searching
+// upwards from the working directory. An empty path restores discovery.
+func SetRoot(path string) {
+	rootOverride = path
+}
+
 func FindRoot() (string, error) {
+	if rootOverride != "" {
+		root, err := filepath.Abs(rootOverride)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve root: %w", err)
+		}
+		if info, err := os.Stat(filepath.Join(root, ShhhDir)); err != nil || !info.IsDir() {
+			return "", fmt.Errorf("no shhh project at %s", root)
+		}
+		return root, nil
+	}
+
 	dir, err := os.Getwd()
 	if err != nil {
 		return "", fmt.Errorf("failed to get working directory: %w", err)
@@ -192,6 +252,43 @@ func FindRoot() (string, error) {
 	}
 }
 
+// FindRoots returns every shhh project root at or below dir, for
+// repositories that hold several independent projects (e.g. one per
+// service). .git directories are not searched.
+func FindRoots(dir string) ([]string, error) {
+	var roots []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		switch d.Name() {
+		case ".git", "node_modules":
+			return filepath.SkipDir
+		case ShhhDir:
+			roots = append(roots, filepath.Dir(path))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search for shhh projects: %w", err)
+	}
+	return roots, nil
+}
+
+// IsNestedRoot reports whether dir is a separate shhh project below root,
+// so walks over root can leave it to its own project.
+func IsNestedRoot(root, dir string) bool {
+	if filepath.Clean(dir) == filepath.Clean(root) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, ShhhDir))
+	return err == nil && info.IsDir()
+}
+
 func GetStore() (*Store, error) {
 	root, err := FindRoot()
 	if err != nil {
@@ -1,14 +1,41 @@
 package integration
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/cychiuae/shhh/internal/backup"
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/crypto"
+	"github.com/cychiuae/shhh/internal/git"
+	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/hooks"
+	"github.com/cychiuae/shhh/internal/k8s"
+	"github.com/cychiuae/shhh/internal/parser"
+	"github.com/cychiuae/shhh/internal/scan"
+	"github.com/cychiuae/shhh/internal/schema"
+	"github.com/cychiuae/shhh/internal/session"
 	"github.com/cychiuae/shhh/internal/store"
+	"gopkg.in/yaml.v3"
 )
 
 func TestFullWorkflow(t *testing.T) {
@@ -18,15 +45,7 @@ func TestFullWorkflow(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	alice, err := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
-	if err != nil {
-		t.Fatalf("failed to create alice entity: %v", err)
-	}
-
-	gpg := crypto.NewNativeGPG()
-	gpg.AddEntity(alice)
-	crypto.SetProvider(gpg)
-	defer crypto.SetProvider(nil)
+	setupTestGPG(t)
 
 	s := store.New(tmpDir)
 	if err := s.Initialize(); err != nil {
@@ -94,7 +113,7 @@ func TestFullWorkflow(t *testing.T) {
 		t.Fatalf("failed to read encrypted file: %v", err)
 	}
 
-	decrypted, err := crypto.DecryptFileContent(encContent, "secrets.yaml")
+	decrypted, err := crypto.DecryptFileContent(encContent, "secrets.yaml", parser.Spec{})
 	if err != nil {
 		t.Fatalf("decryption failed: %v", err)
 	}
@@ -111,14 +130,9 @@ func TestMultiVaultWorkflow(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	alice, _ := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
+	gpg, _ := setupTestGPG(t)
 	bob, _ := openpgp.NewEntity("Bob", "Test User", "bob@test.com", nil)
-
-	gpg := crypto.NewNativeGPG()
-	gpg.AddEntity(alice)
 	gpg.AddEntity(bob)
-	crypto.SetProvider(gpg)
-	defer crypto.SetProvider(nil)
 
 	s := store.New(tmpDir)
 	s.Initialize()
@@ -175,14 +189,9 @@ func TestPerFileRecipients(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	alice, _ := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
+	gpg, _ := setupTestGPG(t)
 	bob, _ := openpgp.NewEntity("Bob", "Test User", "bob@test.com", nil)
-
-	gpg := crypto.NewNativeGPG()
-	gpg.AddEntity(alice)
 	gpg.AddEntity(bob)
-	crypto.SetProvider(gpg)
-	defer crypto.SetProvider(nil)
 
 	s := store.New(tmpDir)
 	s.Initialize()
@@ -265,7 +274,3640 @@ func TestFileStateDetection(t *testing.T) {
 	}
 }
 
+// setupTestGPG installs a native provider holding a new key for
+// alice@test.com, and removes the provider when the test ends.
+func setupTestGPG(t *testing.T) (*crypto.NativeGPG, *openpgp.Entity) {
+	t.Helper()
+
+	alice, err := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create alice entity: %v", err)
+	}
+
+	gpg := crypto.NewNativeGPG()
+	gpg.AddEntity(alice)
+	crypto.SetProvider(gpg)
+	t.Cleanup(func() { crypto.SetProvider(nil) })
+
+	return gpg, alice
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
+
+var (
+	shhhBinOnce sync.Once
+	shhhBinDir  string
+	shhhBin     string
+	shhhBinErr  error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if shhhBinDir != "" {
+		os.RemoveAll(shhhBinDir)
+	}
+	os.Exit(code)
+}
+
+// runShhh runs the shhh binary in dir, building it on first use. HOME,
+// XDG_CONFIG_HOME and GNUPGHOME point at an empty directory unless env
+// overrides them, so the user's own keys and trust settings are not used.
+func runShhh(t *testing.T, dir string, env []string, args ...string) (string, error) {
+	t.Helper()
+
+	shhhBinOnce.Do(func() {
+		shhhBinDir, shhhBinErr = os.MkdirTemp("", "shhh-bin-*")
+		if shhhBinErr != nil {
+			return
+		}
+		shhhBin = filepath.Join(shhhBinDir, "shhh")
+		out, err := exec.Command("go", "build", "-o", shhhBin, "github.com/cychiuae/shhh").CombinedOutput()
+		if err != nil {
+			shhhBinErr = fmt.Errorf("%v\n%s", err, out)
+		}
+	})
+	if shhhBinErr != nil {
+		t.Fatalf("failed to build shhh: %v", shhhBinErr)
+	}
+
+	home := t.TempDir()
+	cmd := exec.Command(shhhBin, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader("")
+	cmd.Env = append(os.Environ(),
+		"HOME="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(home, ".config"),
+		"GNUPGHOME="+filepath.Join(home, ".gnupg"),
+	)
+	cmd.Env = append(cmd.Env, env...)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// writeKeyring writes the entities' public and private keys to a GnuPG home
+// that the native provider of the shhh binary loads.
+func writeKeyring(t *testing.T, home string, entities ...*openpgp.Entity) {
+	t.Helper()
+
+	var pub, sec bytes.Buffer
+	for _, e := range entities {
+		if err := e.Serialize(&pub); err != nil {
+			t.Fatalf("failed to serialize public key: %v", err)
+		}
+		if err := e.SerializePrivate(&sec, nil); err != nil {
+			t.Fatalf("failed to serialize private key: %v", err)
+		}
+	}
+	if err := os.MkdirAll(home, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "pubring.gpg"), pub.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, "secring.gpg"), sec.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRecipientsFile(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-recipients-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	listPath := filepath.Join(tmpDir, "recipients.txt")
+	list := "# platform team\nalice@test.com\n0xb0b0 b0b0 b0b0 b0b0  # bob\n\nalice@test.com\n"
+	os.WriteFile(listPath, []byte(list), 0600)
+
+	ids, err := config.ReadRecipientsFile(listPath)
+	if err != nil {
+		t.Fatalf("failed to read recipients file: %v", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("expected 2 entries, got %v", ids)
+	}
+
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com", Fingerprint: "A11CEA11CEA11CEA"})
+	vault.AddUser(config.User{Email: "bob@test.com", Fingerprint: "B0B0B0B0B0B0B0B0"})
+
+	emails, err := vault.ResolveRecipients(store.DefaultVault, ids)
+	if err != nil {
+		t.Fatalf("failed to resolve recipients: %v", err)
+	}
+	if len(emails) != 2 || emails[0] != "alice@test.com" || emails[1] != "bob@test.com" {
+		t.Errorf("unexpected recipients: %v", emails)
+	}
+
+	if _, err := vault.ResolveRecipients(store.DefaultVault, []string{"DEADBEEF"}); err == nil {
+		t.Error("expected error for unknown fingerprint")
+	}
+}
+
+func TestWorkspaceRoots(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-workspace-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, dir := range []string{"services/a", "services/b"} {
+		if err := store.New(filepath.Join(tmpDir, dir)).Initialize(); err != nil {
+			t.Fatalf("failed to initialize %s: %v", dir, err)
+		}
+	}
+	os.MkdirAll(filepath.Join(tmpDir, "node_modules", "pkg", ".shhh"), 0700)
+
+	roots, err := store.FindRoots(tmpDir)
+	if err != nil {
+		t.Fatalf("failed to find roots: %v", err)
+	}
+	if len(roots) != 2 {
+		t.Fatalf("expected 2 roots, got %v", roots)
+	}
+
+	if !store.IsNestedRoot(tmpDir, filepath.Join(tmpDir, "services", "a")) {
+		t.Error("expected services/a to be a nested root")
+	}
+	if store.IsNestedRoot(roots[0], roots[0]) {
+		t.Error("a root should not be nested in itself")
+	}
+
+	store.SetRoot(filepath.Join(tmpDir, "services", "b"))
+	defer store.SetRoot("")
+
+	root, err := store.FindRoot()
+	if err != nil {
+		t.Fatalf("failed to find overridden root: %v", err)
+	}
+	if root != filepath.Join(tmpDir, "services", "b") {
+		t.Errorf("expected overridden root, got %s", root)
+	}
+}
+
+func TestFileModeMetadata(t *testing.T) {
+	setupTestGPG(t)
+
+	tests := []struct {
+		name    string
+		mode    string
+		content string
+	}{
+		{"deploy.sh", "full", "#!/bin/sh\necho deploy\n"},
+		{"secrets.yaml", "values", "password: hunter2\n"},
+		{"secrets.env", "values", "PASSWORD=hunter2\n"},
+	}
+
+	for _, tt := range tests {
+		opts := crypto.EncryptOptions{
+			Vault:      store.DefaultVault,
+			Mode:       tt.mode,
+			Recipients: []string{"alice@test.com"},
+			FileMode:   0750,
+		}
+		encrypted, err := crypto.EncryptFileContent([]byte(tt.content), tt.name, opts)
+		if err != nil {
+			t.Fatalf("%s: encryption failed: %v", tt.name, err)
+		}
+
+		meta, err := crypto.GetFileMetadata(encrypted, tt.name, parser.Spec{})
+		if err != nil || meta == nil {
+			t.Fatalf("%s: failed to read metadata: %v", tt.name, err)
+		}
+		if meta.FileMode != 0750 {
+			t.Errorf("%s: expected file mode 0750, got %#o", tt.name, meta.FileMode)
+		}
+		if meta.PlaintextMode() != 0700 {
+			t.Errorf("%s: expected plaintext mode 0700, got %#o", tt.name, meta.PlaintextMode())
+		}
+
+		decrypted, err := crypto.DecryptFileContent(encrypted, tt.name, parser.Spec{})
+		if err != nil {
+			t.Fatalf("%s: decryption failed: %v", tt.name, err)
+		}
+		if string(decrypted) != tt.content {
+			t.Errorf("%s: content mismatch: %q", tt.name, decrypted)
+		}
+	}
+}
+
+func TestDedupeValues(t *testing.T) {
+	setupTestGPG(t)
+
+	content := []byte("primary: hunter2\nreplica: hunter2\nother: swordfish\n")
+
+	for _, dedupe := range []bool{true, false} {
+		opts := crypto.EncryptOptions{
+			Vault:        store.DefaultVault,
+			Mode:         "values",
+			Recipients:   []string{"alice@test.com"},
+			DedupeValues: dedupe,
+		}
+		encrypted, err := crypto.EncryptFileContent(content, "secrets.yaml", opts)
+		if err != nil {
+			t.Fatalf("encryption failed: %v", err)
+		}
+
+		var doc map[string]interface{}
+		if err := yaml.Unmarshal(encrypted, &doc); err != nil {
+			t.Fatalf("failed to parse encrypted YAML: %v", err)
+		}
+		if same := doc["primary"] == doc["replica"]; same != dedupe {
+			t.Errorf("dedupe=%v: repeated values share ciphertext = %v", dedupe, same)
+		}
+		if doc["primary"] == doc["other"] {
+			t.Errorf("dedupe=%v: distinct values share ciphertext", dedupe)
+		}
+
+		decrypted, err := crypto.DecryptFileContent(encrypted, "secrets.yaml", parser.Spec{})
+		if err != nil {
+			t.Fatalf("decryption failed: %v", err)
+		}
+		if string(decrypted) != string(content) {
+			t.Errorf("dedupe=%v: content mismatch: %q", dedupe, decrypted)
+		}
+	}
+}
+
+func TestObfuscateKeys(t *testing.T) {
+	setupTestGPG(t)
+
+	opts := crypto.EncryptOptions{
+		Vault:         store.DefaultVault,
+		Mode:          "values",
+		Recipients:    []string{"alice@test.com"},
+		ObfuscateKeys: true,
+	}
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"secrets.yaml", "stripe_production_key: sk_live_123\ndatabase:\n  password: hunter2\n  host: db.internal\n"},
+		{"secrets.json", "{\n  \"database\": {\n    \"password\": \"hunter2\"\n  },\n  \"stripe_production_key\": \"sk_live_123\"\n}\n"},
+	}
+
+	for _, tt := range tests {
+		encrypted, err := crypto.EncryptFileContent([]byte(tt.content), tt.name, opts)
+		if err != nil {
+			t.Fatalf("%s: encryption failed: %v", tt.name, err)
+		}
+
+		for _, leak := range []string{"stripe_production_key", "password", "database"} {
+			if strings.Contains(string(encrypted), leak) {
+				t.Errorf("%s: key %q visible in encrypted output", tt.name, leak)
+			}
+		}
+
+		meta, err := crypto.GetFileMetadata(encrypted, tt.name, parser.Spec{})
+		if err != nil || meta == nil || meta.Vault != store.DefaultVault {
+			t.Errorf("%s: metadata not readable: %v", tt.name, err)
+		}
+
+		decrypted, err := crypto.DecryptFileContent(encrypted, tt.name, parser.Spec{})
+		if err != nil {
+			t.Fatalf("%s: decryption failed: %v", tt.name, err)
+		}
+		if string(decrypted) != tt.content {
+			t.Errorf("%s: round trip mismatch:\n%s", tt.name, decrypted)
+		}
+	}
+
+	if _, err := crypto.EncryptFileContent([]byte("KEY=value\n"), "secrets.env", opts); err == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestVerifyEncrypt(t *testing.T) {
+	setupTestGPG(t)
+
+	tests := []struct {
+		name    string
+		mode    string
+		content string
+	}{
+		{"secrets.yaml", "values", "db:\n  password: hunter2\n  port: 5432\nlist:\n  - a\n  - b\n"},
+		{"secrets.json", "values", "{\"db\": {\"password\": \"hunter2\", \"port\": 5432}}\n"},
+		{"secrets.ini", "values", "[db]\npassword = hunter2\n"},
+		{"secrets.env", "values", "PASSWORD=\"hunter 2\"\nexport TOKEN=abc\n"},
+		{"secrets.bin", "full", "raw bytes\n"},
+	}
+
+	for _, tt := range tests {
+		opts := crypto.EncryptOptions{
+			Vault:      store.DefaultVault,
+			Mode:       tt.mode,
+			Recipients: []string{"alice@test.com"},
+			Verify:     true,
+		}
+		if _, err := crypto.EncryptFileContent([]byte(tt.content), tt.name, opts); err != nil {
+			t.Errorf("%s: verified encryption failed: %v", tt.name, err)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "shhh-verify-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := os.WriteFile(s.ConfigPath(), []byte("version: \"1\"\ngpg_copy: false\ndefault_vault: default\n"), 0600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	cfg, err := config.Load(s)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if !cfg.VerifyEncrypt {
+		t.Error("verify_encrypt should default to true for configs without the key")
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	sch, err := schema.Parse([]byte(`{
+  "type": "object",
+  "required": ["database"],
+  "additionalProperties": false,
+  "properties": {
+    "database": {
+      "type": "object",
+      "required": ["password", "port"],
+      "properties": {
+        "password": {"type": "string", "minLength": 8},
+        "port": {"type": "integer", "minimum": 1, "maximum": 65535}
+      }
+    },
+    "tier": {"enum": ["dev", "prod"]}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		format  parser.FileFormat
+		content string
+		errors  int
+	}{
+		{"valid yaml", parser.FormatYAML, "database:\n  password: hunter2hunter2\n  port: 5432\ntier: prod\n", 0},
+		{"valid json", parser.FormatJSON, `{"database": {"password": "hunter2hunter2", "port": 5432}}`, 0},
+		{"missing and short", parser.FormatYAML, "database:\n  password: short\n", 2},
+		{"wrong type", parser.FormatYAML, "database:\n  password: hunter2hunter2\n  port: \"5432\"\n", 1},
+		{"extra property", parser.FormatJSON, `{"database": {"password": "hunter2hunter2", "port": 1}, "debug": true}`, 1},
+		{"bad enum", parser.FormatYAML, "database:\n  password: hunter2hunter2\n  port: 1\ntier: staging\n", 1},
+	}
+
+	for _, tt := range tests {
+		doc, err := schema.Decode([]byte(tt.content), tt.format)
+		if err != nil {
+			t.Fatalf("%s: failed to decode: %v", tt.name, err)
+		}
+		errs := sch.Validate(doc)
+		if len(errs) != tt.errors {
+			t.Errorf("%s: expected %d errors, got %v", tt.name, tt.errors, errs)
+		}
+		for _, e := range errs {
+			if strings.Contains(e.Error(), "hunter2") {
+				t.Errorf("%s: error leaks a value: %v", tt.name, e)
+			}
+		}
+	}
+
+	envSchema, err := schema.Parse([]byte(`{"type": "object", "required": ["API_KEY"], "properties": {"API_KEY": {"type": "string", "pattern": "^sk_"}}}`))
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+	doc, err := schema.Decode([]byte("API_KEY=pk_live_123\n"), parser.FormatENV)
+	if err != nil {
+		t.Fatalf("failed to decode env: %v", err)
+	}
+	if errs := envSchema.Validate(doc); len(errs) != 1 {
+		t.Errorf("expected pattern violation, got %v", errs)
+	}
+}
+
+func TestMetadataPrivacy(t *testing.T) {
+	setupTestGPG(t)
+
+	for _, name := range []string{"secrets.yaml", "secrets.bin"} {
+		mode := "values"
+		if name == "secrets.bin" {
+			mode = "full"
+		}
+
+		for _, privacy := range []string{crypto.PrivacyHash, crypto.PrivacyOmit} {
+			opts := crypto.EncryptOptions{
+				Vault:           "production",
+				Mode:            mode,
+				Recipients:      []string{"alice@test.com"},
+				MetadataPrivacy: privacy,
+			}
+			encrypted, err := crypto.EncryptFileContent([]byte("password: hunter2\n"), name, opts)
+			if err != nil {
+				t.Fatalf("%s/%s: encryption failed: %v", name, privacy, err)
+			}
+
+			for _, leak := range []string{"alice@test.com", "production"} {
+				if strings.Contains(string(encrypted), leak) {
+					t.Errorf("%s/%s: %q visible in metadata", name, privacy, leak)
+				}
+			}
+
+			meta, err := crypto.GetFileMetadata(encrypted, name, parser.Spec{})
+			if err != nil || meta == nil {
+				t.Fatalf("%s/%s: failed to read metadata: %v", name, privacy, err)
+			}
+			if meta.Privacy != privacy {
+				t.Errorf("%s/%s: expected privacy %q, got %q", name, privacy, privacy, meta.Privacy)
+			}
+
+			match, known := meta.MatchesRecipients([]string{"Alice@Test.com"}, name)
+			if privacy == crypto.PrivacyHash && (!known || !match) {
+				t.Errorf("%s: hashed recipients should match", name)
+			}
+			if privacy == crypto.PrivacyOmit && known {
+				t.Errorf("%s: omitted recipients should be unknown", name)
+			}
+			has, known := meta.HasRecipient("ALICE@test.com", name)
+			if privacy == crypto.PrivacyHash && (!known || !has) {
+				t.Errorf("%s: HasRecipient() should find alice in hashed recipients", name)
+			}
+			if privacy == crypto.PrivacyOmit && known {
+				t.Errorf("%s: HasRecipient() should be unknown for omitted recipients", name)
+			}
+			if has, _ := meta.HasRecipient("bob@test.com", name); has {
+				t.Errorf("%s/%s: HasRecipient() found a user who is not a recipient", name, privacy)
+			}
+			if privacy == crypto.PrivacyHash {
+				if match, _ := meta.MatchesRecipients([]string{"bob@test.com"}, name); match {
+					t.Errorf("%s: hashed recipients matched the wrong user", name)
+				}
+				if !meta.MatchesVault("production", name) || meta.MatchesVault("default", name) {
+					t.Errorf("%s: hashed vault did not match", name)
+				}
+			}
+		}
+	}
+}
+
+func TestSidecarMetadata(t *testing.T) {
+	setupTestGPG(t)
+
+	tmpDir, err := os.MkdirTemp("", "shhh-sidecar-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, name := range []string{"secrets.yaml", "secrets.json", "secrets.env"} {
+		content := map[string]string{
+			"secrets.yaml": "password: hunter2\n",
+			"secrets.json": "{\n  \"password\": \"hunter2\"\n}\n",
+			"secrets.env":  "PASSWORD=hunter2\n",
+		}[name]
+
+		opts := crypto.EncryptOptions{
+			Vault:      store.DefaultVault,
+			Mode:       "values",
+			Recipients: []string{"alice@test.com"},
+		}
+		encrypted, err := crypto.EncryptFileContent([]byte(content), name, opts)
+		if err != nil {
+			t.Fatalf("%s: encryption failed: %v", name, err)
+		}
+
+		doc, meta, err := crypto.SplitMetadata(encrypted, name, parser.Spec{})
+		if err != nil {
+			t.Fatalf("%s: failed to split metadata: %v", name, err)
+		}
+		if meta == nil || strings.Contains(string(doc), "_shhh") || strings.Contains(string(doc), "_SHHH_") {
+			t.Fatalf("%s: metadata not moved out of the document:\n%s", name, doc)
+		}
+
+		encPath := filepath.Join(tmpDir, name+".enc")
+		os.WriteFile(encPath, doc, 0600)
+
+		if m, _ := crypto.ReadFileMetadata(doc, encPath, name, parser.Spec{}); m != nil {
+			t.Errorf("%s: expected no metadata without a sidecar", name)
+		}
+
+		os.WriteFile(crypto.SidecarPath(encPath), meta, 0600)
+		m, err := crypto.ReadFileMetadata(doc, encPath, name, parser.Spec{})
+		if err != nil || m == nil {
+			t.Fatalf("%s: failed to read sidecar metadata: %v", name, err)
+		}
+		if m.Vault != store.DefaultVault || len(m.Recipients) != 1 || m.EncryptedAt.IsZero() {
+			t.Errorf("%s: unexpected sidecar metadata: %+v", name, m)
+		}
+
+		decrypted, err := crypto.DecryptFileContent(doc, name, parser.Spec{})
+		if err != nil {
+			t.Fatalf("%s: decryption failed: %v", name, err)
+		}
+		if string(decrypted) != content {
+			t.Errorf("%s: content mismatch: %q", name, decrypted)
+		}
+	}
+}
+
+func TestFormatOverride(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-format-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewVault().Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	if parser.DetectFormat("config/notes.txt") != parser.FormatUnknown {
+		t.Fatal("expected notes.txt to be unknown before override")
+	}
+
+	if err := config.RegisterFile(s, store.DefaultVault, "config/notes.txt", "values", nil); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if err := config.SetFileFormat(s, store.DefaultVault, "config/notes.txt", "env"); err != nil {
+		t.Fatalf("failed to set format: %v", err)
+	}
+
+	vault, err := config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("failed to load vault: %v", err)
+	}
+	f := vault.GetFile("config/notes.txt")
+	if f == nil || f.Format != "env" {
+		t.Fatalf("format not persisted: %+v", f)
+	}
+	if f.Spec().FormatOf(f.Path) != parser.FormatENV {
+		t.Error("expected the registered format to apply")
+	}
+	values, err := parser.FlattenFile([]byte("TOKEN=s3cret\n"), f.Path, f.Spec())
+	if err != nil || len(values) != 1 || values[0].Value != "s3cret" {
+		t.Errorf("FlattenFile() = %+v, %v", values, err)
+	}
+
+	// The format belongs to this project's registration only: the same
+	// relative path elsewhere, e.g. in another project loaded by the same
+	// process, is still detected by extension.
+	if parser.DetectFormat("config/notes.txt") != parser.FormatUnknown {
+		t.Error("registering a format should not change detection")
+	}
+	other := &config.RegisteredFile{Path: "config/notes.txt", Mode: config.ModeValues}
+	if other.Spec().FormatOf(other.Path) != parser.FormatUnknown || !other.FallsBackToFull() {
+		t.Error("another registration of the same path should not see the format")
+	}
+
+	if _, err := parser.ParseFormat("toml"); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestLineOptions(t *testing.T) {
+	content := []byte("web: bundle exec puma\n; worker: ignored\nTOKEN: \"s3cret\"\n")
+	opts := parser.LineOptions{Delimiter: ":", Comments: []string{";"}}
+
+	encrypt := func(v string) (string, error) { return "ENC[v1:" + strings.ReplaceAll(v, " ", "+") + "]", nil }
+	decrypt := func(v string) (string, error) {
+		return strings.ReplaceAll(strings.TrimSuffix(strings.TrimPrefix(v, "ENC[v1:"), "]"), "+", " "), nil
+	}
+
+	p := &parser.ENVParser{Options: opts}
+	encrypted, err := p.EncryptValues(content, encrypt)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	want := "web: ENC[v1:bundle+exec+puma]\n; worker: ignored\nTOKEN: \"ENC[v1:s3cret]\"\n"
+	if string(encrypted) != want {
+		t.Errorf("encrypted = %q, want %q", encrypted, want)
+	}
+
+	decrypted, err := p.DecryptValues(encrypted, decrypt)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %v", err)
+	}
+	if string(decrypted) != string(content) {
+		t.Errorf("round trip = %q, want %q", decrypted, content)
+	}
+
+	values, err := parser.FlattenFile(content, "Procfile", parser.Spec{Format: parser.FormatENV, Lines: opts})
+	if err != nil {
+		t.Fatalf("failed to flatten: %v", err)
+	}
+	if len(values) != 2 || values[0].Key != "web" || values[1].Value != "s3cret" {
+		t.Errorf("unexpected values: %+v", values)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-hooks-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	hooksYAML := "hooks:\n" +
+		"  - events: [user_added]\n" +
+		"    exec: cat > event.json && echo \"$SHHH_USER\" > user.txt\n" +
+		"  - events: [stale_detected]\n" +
+		"    url: " + server.URL + "/token\n" +
+		"    format: slack\n"
+	if err := os.WriteFile(s.HooksPath(), []byte(hooksYAML), 0600); err != nil {
+		t.Fatalf("failed to write hooks: %v", err)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	errs := hooks.Fire(s, hooks.Event{Name: hooks.UserAdded, Vault: "default", User: "carol@test.com"})
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "not trusted") {
+		t.Errorf("untrusted exec hook: errors = %v", errs)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "user.txt")); err == nil {
+		t.Error("untrusted exec hook ran")
+	}
+
+	if err := hooks.Trust(s.Root(), `cat > event.json && echo "$SHHH_USER" > user.txt`); err != nil {
+		t.Fatalf("Trust() error = %v", err)
+	}
+	if errs := hooks.Fire(s, hooks.Event{Name: hooks.UserAdded, Vault: "default", User: "carol@test.com"}); len(errs) > 0 {
+		t.Fatalf("hook failed: %v", errs)
+	}
+	user, _ := os.ReadFile(filepath.Join(tmpDir, "user.txt"))
+	if strings.TrimSpace(string(user)) != "carol@test.com" {
+		t.Errorf("exec hook env SHHH_USER = %q", user)
+	}
+	payload, _ := os.ReadFile(filepath.Join(tmpDir, "event.json"))
+	if !strings.Contains(string(payload), `"event":"user_added"`) {
+		t.Errorf("exec hook stdin = %s", payload)
+	}
+
+	if errs := hooks.Fire(s, hooks.Event{Name: hooks.StaleDetected, Files: []string{"a.yaml"}}); len(errs) > 0 {
+		t.Fatalf("webhook failed: %v", errs)
+	}
+	if text, _ := received["text"].(string); !strings.Contains(text, "a.yaml") {
+		t.Errorf("slack payload = %v", received)
+	}
+
+	// Events nobody subscribed to run nothing.
+	os.Remove(filepath.Join(tmpDir, "user.txt"))
+	hooks.Fire(s, hooks.Event{Name: hooks.UserRemoved})
+	if _, err := os.Stat(filepath.Join(tmpDir, "user.txt")); err == nil {
+		t.Error("hook fired for an unsubscribed event")
+	}
+
+	os.WriteFile(s.HooksPath(), []byte("hooks:\n  - events: [bogus]\n    exec: true\n"), 0600)
+	if _, err := hooks.Load(s); err == nil {
+		t.Error("expected error for unknown event")
+	}
+}
+
+func TestCommandSettingsNeedTrust(t *testing.T) {
+	s := store.New(t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	cfg := config.NewConfig()
+	if cfg.GPGTrustModel != "" {
+		t.Errorf("default gpg_trust_model = %q, want gpg's own", cfg.GPGTrustModel)
+	}
+	if len(cfg.CommandSettings()) != 0 {
+		t.Errorf("default config has command settings: %v", cfg.CommandSettings())
+	}
+
+	cfg.GPGBinary = "./tools/gpg"
+	cfg.GPGArgs = "--no-options"
+	settings := cfg.CommandSettings()
+	if len(settings) != 2 || settings[0].String() != "gpg_binary: ./tools/gpg" {
+		t.Fatalf("CommandSettings() = %v", settings)
+	}
+
+	// Committed settings that run a program are ignored until trusted.
+	if config.SettingTrusted(s, "gpg_binary", cfg.GPGBinary) {
+		t.Error("gpg_binary should not be trusted before 'shhh hooks trust'")
+	}
+	if !config.SettingTrusted(s, "gpg_binary", "") {
+		t.Error("an unset setting needs no trust")
+	}
+	if err := hooks.Trust(s.Root(), settings[0].String()); err != nil {
+		t.Fatalf("Trust() error = %v", err)
+	}
+	if !config.SettingTrusted(s, "gpg_binary", cfg.GPGBinary) {
+		t.Error("trusted gpg_binary should be used")
+	}
+	if config.SettingTrusted(s, "gpg_binary", "./tools/other-gpg") || config.SettingTrusted(s, "gpg_args", cfg.GPGArgs) {
+		t.Error("trust should cover only the exact setting")
+	}
+}
+
+func TestFileHooks(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-file-hooks-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	stage, err := hooks.ParseStage("post-decrypt")
+	if err != nil || stage != hooks.PostDecrypt {
+		t.Fatalf("ParseStage(post-decrypt) = %q, %v", stage, err)
+	}
+	if _, err := hooks.ParseStage("after_edit"); err == nil {
+		t.Error("expected error for unknown stage")
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	h := &hooks.FileHooks{}
+	h.Set(hooks.PostDecrypt, `echo "$SHHH_HOOK $SHHH_FILE $SHHH_VAULT $SHHH_STATE" > hook.txt`)
+	h.Set(hooks.PreEncrypt, "exit 1")
+
+	// Hooks come from committed files and are skipped until trusted.
+	if err := hooks.RunFileHook(tmpDir, "default", "app/secrets.yaml", h, hooks.PreEncrypt); err != nil {
+		t.Errorf("untrusted hook should be skipped: %v", err)
+	}
+	if err := hooks.Trust(tmpDir, h.PostDecrypt, h.PreEncrypt); err != nil {
+		t.Fatalf("Trust() error = %v", err)
+	}
+	if hooks.Trusted(t.TempDir(), h.PreEncrypt) || hooks.Trusted(tmpDir, "exit 2") {
+		t.Error("trust should be limited to the project and the exact command")
+	}
+
+	if err := hooks.RunFileHook(tmpDir, "default", "app/secrets.yaml", h, hooks.PostDecrypt); err != nil {
+		t.Fatalf("RunFileHook() error = %v", err)
+	}
+	out, _ := os.ReadFile(filepath.Join(tmpDir, "hook.txt"))
+	if got := strings.TrimSpace(string(out)); got != "post_decrypt app/secrets.yaml default decrypted" {
+		t.Errorf("hook environment = %q", got)
+	}
+
+	if err := hooks.RunFileHook(tmpDir, "default", "app/secrets.yaml", h, hooks.PreEncrypt); err == nil {
+		t.Error("expected error from failing hook")
+	}
+	if err := hooks.RunFileHook(tmpDir, "default", "app/secrets.yaml", h, hooks.PreDecrypt); err != nil {
+		t.Errorf("unset hook should not run: %v", err)
+	}
+
+	if err := hooks.Untrust(tmpDir, h.PreEncrypt); err != nil {
+		t.Fatalf("Untrust() error = %v", err)
+	}
+	if err := hooks.RunFileHook(tmpDir, "default", "app/secrets.yaml", h, hooks.PreEncrypt); err != nil {
+		t.Errorf("untrusted hook should be skipped: %v", err)
+	}
+	hooks.Trust(tmpDir, h.PreEncrypt)
+
+	t.Setenv(hooks.DisableEnv, "1")
+	if err := hooks.RunFileHook(tmpDir, "default", "app/secrets.yaml", h, hooks.PreEncrypt); err != nil {
+		t.Errorf("hooks should be disabled by %s: %v", hooks.DisableEnv, err)
+	}
+
+	h.Set(hooks.PreEncrypt, "")
+	h.Set(hooks.PostDecrypt, "")
+	if !h.IsZero() {
+		t.Error("hooks should be empty after clearing every stage")
+	}
+}
+
+func TestGnuPGHomeConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-gnupghome-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	cfg := config.NewConfig()
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if home := cfg.GnuPGHomePath(s); home != "" {
+		t.Errorf("expected no keyring override, got %q", home)
+	}
+
+	cfg.Set("gnupg_home", "./ci/keyring")
+	if home := cfg.GnuPGHomePath(s); home != filepath.Join(tmpDir, "ci", "keyring") {
+		t.Errorf("relative gnupg_home resolved to %q", home)
+	}
+
+	// A committed keyring can bring its own gpg.conf and keys, so it is a
+	// command setting that needs trusting.
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	settings := cfg.CommandSettings()
+	if len(settings) != 1 || settings[0].String() != "gnupg_home: ./ci/keyring" {
+		t.Fatalf("CommandSettings() = %v", settings)
+	}
+	if config.SettingTrusted(s, "gnupg_home", cfg.GnuPGHome) {
+		t.Error("gnupg_home should not be trusted before 'shhh hooks trust'")
+	}
+
+	cfg.Set("gnupg_home", "/srv/keyring")
+	if home := cfg.GnuPGHomePath(s); home != "/srv/keyring" {
+		t.Errorf("absolute gnupg_home resolved to %q", home)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-fsck-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if err := s.CreateVault("prod"); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "app.yaml"), []byte("key: value\n"), 0644)
+
+	def := config.NewVault()
+	def.Files = []config.RegisteredFile{
+		{Path: "app.yaml", Mode: config.ModeValues},
+		{Path: "./app.yaml", Mode: config.ModeValues},
+	}
+	if err := def.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	prod := config.NewVault()
+	prod.Users = []config.User{{Email: "alice@test.com", Fingerprint: "ABCD"}}
+	prod.Files = []config.RegisteredFile{{Path: "app.yaml", Mode: config.ModeValues}}
+	if err := prod.Save(s, "prod"); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	issues, err := config.Fsck(s, true)
+	if err != nil {
+		t.Fatalf("fsck failed: %v", err)
+	}
+	want := map[string]bool{
+		"file app.yaml is registered twice":              false,
+		"public key cache for alice@test.com is missing": false,
+		"file app.yaml is registered in several vaults: default, prod (keep one with 'shhh file move app.yaml <vault>')": false,
+	}
+	for _, issue := range issues {
+		if _, ok := want[issue.Message]; ok {
+			want[issue.Message] = true
+		}
+	}
+	for msg, found := range want {
+		if !found {
+			t.Errorf("expected issue %q, got %+v", msg, issues)
+		}
+	}
+
+	vault, err := config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("failed to load vault: %v", err)
+	}
+	if len(vault.Files) != 1 || vault.Files[0].Path != "app.yaml" {
+		t.Errorf("--fix should drop the duplicate entry, got %+v", vault.Files)
+	}
+
+	os.WriteFile(s.ConfigPath(), []byte("version: \"1\"\ndefault_vualt: default\n"), 0600)
+	issues, _ = config.Fsck(s, false)
+	if len(issues) == 0 || !strings.Contains(issues[0].Message, "default_vualt") {
+		t.Errorf("expected unknown config key to be reported, got %+v", issues)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-upgrade-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	// A store as written by format version 1.
+	os.WriteFile(s.ConfigPath(), []byte("version: \"1\"\ngpg_copy: false\ndefault_vault: default\n"), 0600)
+	vaultYAML := "users: []\nfiles:\n  - path: ./config/app.yaml\n    mode: values\n"
+	os.WriteFile(s.VaultConfigPath(store.DefaultVault), []byte(vaultYAML), 0600)
+
+	if !config.IsOutdated(s) {
+		t.Fatal("version 1 store should be reported as outdated")
+	}
+	pending, err := config.PendingMigrations(s)
+	if err != nil || len(pending) != 1 {
+		t.Fatalf("expected one pending migration, got %v (%v)", pending, err)
+	}
+
+	if _, err := config.Upgrade(s); err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+	if version, _ := config.StoreVersion(s); version != config.CurrentVersion {
+		t.Errorf("store version after upgrade = %s", version)
+	}
+	data, _ := os.ReadFile(s.VaultConfigPath(store.DefaultVault))
+	if !strings.Contains(string(data), "version: \"2\"") || !strings.Contains(string(data), "path: config/app.yaml") {
+		t.Errorf("vault not migrated:\n%s", data)
+	}
+	data, _ = os.ReadFile(s.ConfigPath())
+	if !strings.Contains(string(data), "verify_encrypt: true") {
+		t.Errorf("config defaults not written:\n%s", data)
+	}
+	if pending, _ := config.PendingMigrations(s); len(pending) != 0 {
+		t.Errorf("upgrade should be idempotent, %d migrations pending", len(pending))
+	}
+
+	os.WriteFile(s.ConfigPath(), []byte("version: \"99\"\n"), 0600)
+	if _, err := config.Load(s); err == nil {
+		t.Error("loading a config from a newer format version should fail")
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-duplicate-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := s.CreateVault("prod"); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "values", nil); err != nil {
+		t.Fatalf("failed to register: %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "./app.yaml", "full", nil); err != nil {
+		t.Errorf("re-registering in the same vault should succeed: %v", err)
+	}
+	if err := config.RegisterFile(s, "prod", "app.yaml", "values", nil); err == nil {
+		t.Error("registering a file in a second vault should fail")
+	}
+
+	// Duplicates written by older releases make lookups ambiguous until
+	// resolved with MoveFile.
+	prod := config.NewVault()
+	prod.Files = []config.RegisteredFile{{Path: "app.yaml", Mode: config.ModeValues}}
+	if err := prod.Save(s, "prod"); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if _, _, err := config.FindFileVault(s, "app.yaml"); err == nil {
+		t.Error("FindFileVault should reject a file registered in several vaults")
+	}
+	if err := config.MoveFile(s, "app.yaml", "", "staging"); err == nil {
+		t.Error("moving to a missing vault should fail")
+	}
+
+	if err := config.MoveFile(s, "app.yaml", store.DefaultVault, "prod"); err != nil {
+		t.Fatalf("move failed: %v", err)
+	}
+	vault, fileReg, err := config.FindFileVault(s, "app.yaml")
+	if err != nil {
+		t.Fatalf("file should be registered once after move: %v", err)
+	}
+	if vault != "prod" || fileReg.Mode != config.ModeFull {
+		t.Errorf("expected the default vault's full-mode entry in prod, got %s/%s", vault, fileReg.Mode)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-backup-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	setupTestGPG(t)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com", Fingerprint: "TESTFINGERPRINT"})
+	vault.RegisterFile(config.RegisteredFile{Path: "secrets.yaml", Mode: config.ModeValues})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	fileReg := vault.GetFile("secrets.yaml")
+
+	content := []byte("password: supersecret123\n")
+	encrypted, err := crypto.EncryptFileContent(content, "secrets.yaml", crypto.EncryptOptions{
+		Vault: store.DefaultVault, Mode: config.ModeValues, Recipients: []string{"alice@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	os.WriteFile(filepath.Join(tmpDir, "secrets.yaml.enc"), encrypted, 0600)
+
+	cfg := config.NewConfig()
+	cfg.BackupDir = "backups"
+	cfg.BackupArmor = false
+	cfg.BackupMetadata = true
+
+	if r := backup.Verify(s, cfg, store.DefaultVault, fileReg); r.OK() {
+		t.Error("verify should fail when the backup is missing")
+	}
+
+	path, err := backup.Write(s, cfg, store.DefaultVault, "secrets.yaml", content, []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	if path != filepath.Join(tmpDir, "backups", "secrets.yaml.gpg") {
+		t.Errorf("backup written to %s", path)
+	}
+	data, _ := os.ReadFile(path)
+	if crypto.IsArmored(data) {
+		t.Error("backup_armor false should write a binary backup")
+	}
+	if r := backup.Verify(s, cfg, store.DefaultVault, fileReg); !r.OK() || len(r.Warnings) > 0 {
+		t.Errorf("fresh backup failed verification: %+v", r)
+	}
+
+	// A backup from before the file's recipients changed is flagged.
+	vault.AddUser(config.User{Email: "bob@test.com", Fingerprint: "BOBFINGERPRINT"})
+	vault.Save(s, store.DefaultVault)
+	if r := backup.Verify(s, cfg, store.DefaultVault, fileReg); r.OK() {
+		t.Error("verify should flag outdated backup recipients")
+	}
+
+	if _, err := backup.Write(s, cfg, store.DefaultVault, "secrets.yaml", []byte("password: old\n"), []string{"alice@test.com"}); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+	r := backup.Verify(s, cfg, store.DefaultVault, fileReg)
+	if !strings.Contains(strings.Join(r.Problems, "\n"), "content differs") {
+		t.Errorf("verify should detect content drift, got %+v", r)
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		want     []string
+		leaked   []string
+		filename string
+	}{
+		{
+			name:     "yaml",
+			filename: "secrets.yaml",
+			content:  "# old password: hunter2\ndatabase:\n  password: s3cret # rotate\n  port: 5432\nhosts:\n  - db1\n_shhh:\n  vault: default\n",
+			want:     []string{"password: <database.password>", "port: <database.port>", "- <hosts.0>"},
+			leaked:   []string{"hunter2", "s3cret", "rotate", "5432", "_shhh"},
+		},
+		{
+			name:     "json",
+			filename: "secrets.json",
+			content:  `{"api": {"key": "abc123", "enabled": true}, "_shhh": {"vault": "default"}}`,
+			want:     []string{`"key": "<api.key>"`, `"enabled": "<api.enabled>"`},
+			leaked:   []string{"abc123", "_shhh"},
+		},
+		{
+			name:     "env",
+			filename: "secrets.env",
+			content:  "# token for prod: xyz\nexport API_KEY=\"abc123\"\n_SHHH_VAULT=default\n",
+			want:     []string{"export API_KEY=<API_KEY>"},
+			leaked:   []string{"xyz", "abc123", "_SHHH_"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			example, err := parser.ExampleFile([]byte(tt.content), tt.filename, parser.Spec{})
+			if err != nil {
+				t.Fatalf("ExampleFile() error = %v", err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(example), w) {
+					t.Errorf("example missing %q:\n%s", w, example)
+				}
+			}
+			for _, l := range tt.leaked {
+				if strings.Contains(string(example), l) {
+					t.Errorf("example leaks %q:\n%s", l, example)
+				}
+			}
+		})
+	}
+
+	if _, err := parser.ExampleFile([]byte("data"), "secret.bin", parser.Spec{}); err == nil {
+		t.Error("expected an error for files without a known format")
+	}
+}
+
+func TestRedactFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		content  string
+		want     []string
+		leaked   []string
+	}{
+		{
+			name:     "yaml",
+			filename: "secrets.yaml",
+			content:  "# prod password: hunter2\ndatabase:\n  password: s3cret-pw\n  port: 5432\n",
+			want:     []string{"password: '*********'", "port: '****'"},
+			leaked:   []string{"hunter2", "s3cret-pw", "5432"},
+		},
+		{
+			name:     "json",
+			filename: "secrets.json",
+			content:  `{"api": {"key": "abc123", "retries": 3.50}}`,
+			want:     []string{`"key": "******"`, `"retries": "****"`},
+			leaked:   []string{"abc123", "3.5"},
+		},
+		{
+			name:     "env",
+			filename: "secrets.env",
+			content:  "API_KEY='abc 123'\nEMPTY=\n",
+			want:     []string{"API_KEY='*******'", "EMPTY=\n"},
+			leaked:   []string{"abc 123"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redacted, err := parser.RedactFile([]byte(tt.content), tt.filename, parser.Spec{})
+			if err != nil {
+				t.Fatalf("RedactFile() error = %v", err)
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(string(redacted), w) {
+					t.Errorf("redacted copy missing %q:\n%s", w, redacted)
+				}
+			}
+			for _, l := range tt.leaked {
+				if strings.Contains(string(redacted), l) {
+					t.Errorf("redacted copy leaks %q:\n%s", l, redacted)
+				}
+			}
+		})
+	}
+}
+
+func TestMaskFile(t *testing.T) {
+	for value, want := range map[string]string{
+		"sk-live-abc123": "sk**********23",
+		"short":          "*****",
+		"":               "",
+	} {
+		if got := parser.PartialMask(value); got != want {
+			t.Errorf("PartialMask(%q) = %q, want %q", value, got, want)
+		}
+	}
+
+	masked, err := parser.MaskFile([]byte("api:\n  key: sk-live-abc123 # prod\n"), "secrets.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("MaskFile() error = %v", err)
+	}
+	if !strings.Contains(string(masked), "key: sk**********23") {
+		t.Errorf("masked copy missing partially masked value:\n%s", masked)
+	}
+	if strings.Contains(string(masked), "live-abc") || strings.Contains(string(masked), "prod") {
+		t.Errorf("masked copy leaks the value or comment:\n%s", masked)
+	}
+}
+
+func TestReadonlyConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-readonly-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	t.Setenv(config.ReadonlyEnv, "")
+	t.Setenv("CI", "")
+
+	cfg := config.NewConfig()
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if config.IsReadonly(s) {
+		t.Error("readonly should be off by default")
+	}
+
+	cfg.Set("readonly", "ci")
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if config.IsReadonly(s) {
+		t.Error("readonly ci should be off outside CI")
+	}
+	t.Setenv("CI", "true")
+	if !config.IsReadonly(s) {
+		t.Error("readonly ci should be on when CI is set")
+	}
+
+	t.Setenv("CI", "")
+	cfg.Set("readonly", "false")
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if v, _ := cfg.Get("readonly"); v != "false" {
+		t.Errorf("readonly = %q, want false", v)
+	}
+	t.Setenv(config.ReadonlyEnv, "1")
+	if !config.IsReadonly(s) {
+		t.Errorf("%s should force readonly", config.ReadonlyEnv)
+	}
+
+	if cfg.Set("readonly", "maybe") {
+		t.Error("expected invalid readonly value to be rejected")
+	}
+}
+
+func TestVaultCanary(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-canary-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	gpg, alice := setupTestGPG(t)
+	bob, err := openpgp.NewEntity("Bob", "Test User", "bob@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create bob entity: %v", err)
+	}
+	gpg.AddEntity(bob)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	for name, email := range map[string]string{"team": "alice@test.com", "ops": "bob@test.com"} {
+		vault := config.NewVault()
+		vault.AddUser(config.User{Email: email})
+		if err := vault.Save(s, name); err != nil {
+			t.Fatalf("failed to save vault: %v", err)
+		}
+		if access, _ := config.CheckVaultAccess(s, name); access != config.AccessUnknown {
+			t.Errorf("vault %s without canary: access = %s", name, access)
+		}
+		if err := config.RefreshCanary(s, name); err != nil {
+			t.Fatalf("RefreshCanary(%s) error = %v", name, err)
+		}
+	}
+
+	// Alice alone can read the team vault but not the ops vault.
+	aliceOnly := crypto.NewNativeGPG()
+	aliceOnly.AddEntity(alice)
+	crypto.SetProvider(aliceOnly)
+
+	if access, err := config.CheckVaultAccess(s, "team"); access != config.AccessGranted {
+		t.Errorf("team access = %s, %v", access, err)
+	}
+	if access, _ := config.CheckVaultAccess(s, "ops"); access != config.AccessDenied {
+		t.Errorf("ops access = %s, want denied", access)
+	}
+}
+
+func TestCheckAllUserKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-usercheck-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	gpg := crypto.NewNativeGPG()
+	for _, name := range []string{"alice", "bob"} {
+		entity, err := openpgp.NewEntity(name, "Test User", name+"@test.com", nil)
+		if err != nil {
+			t.Fatalf("failed to create %s entity: %v", name, err)
+		}
+		gpg.AddEntity(entity)
+	}
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	fingerprint := func(email string) string {
+		key, err := gpg.LookupKey(email)
+		if err != nil {
+			t.Fatalf("LookupKey(%s) error = %v", email, err)
+		}
+		return key.Fingerprint
+	}
+
+	team := config.NewVault()
+	team.AddUser(config.User{Email: "alice@test.com", Fingerprint: fingerprint("alice@test.com")})
+	team.AddUser(config.User{Email: "bob@test.com", Fingerprint: fingerprint("bob@test.com")})
+	if err := team.Save(s, "team"); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	// ops still records an old key for alice and does not include bob.
+	ops := config.NewVault()
+	ops.AddUser(config.User{Email: "alice@test.com", Fingerprint: "0000000000000000000000000000000000000000"})
+	if err := ops.Save(s, "ops"); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	vaults, users, err := config.CheckAllUserKeys(s)
+	if err != nil {
+		t.Fatalf("CheckAllUserKeys() error = %v", err)
+	}
+	// The empty default vault is listed but does not count as missing users.
+	if len(vaults) != 3 || len(users) != 2 {
+		t.Fatalf("got %d vaults and %d users, want 3 and 2", len(vaults), len(users))
+	}
+
+	alice, bob := users[0], users[1]
+	if alice.Email != "alice@test.com" || bob.Email != "bob@test.com" {
+		t.Fatalf("users not sorted: %s, %s", alice.Email, bob.Email)
+	}
+	if alice.Vaults["team"].Status != "valid" || alice.Vaults["ops"].Status != "changed" {
+		t.Errorf("alice statuses = %s/%s, want valid/changed", alice.Vaults["team"].Status, alice.Vaults["ops"].Status)
+	}
+	if len(alice.Problems) != 1 || !strings.Contains(alice.Problems[0], "different fingerprints") {
+		t.Errorf("alice problems = %v", alice.Problems)
+	}
+	if _, ok := bob.Vaults["ops"]; ok {
+		t.Error("bob should not have a status in ops")
+	}
+	if len(bob.Problems) != 1 || bob.Problems[0] != "not in ops" {
+		t.Errorf("bob problems = %v", bob.Problems)
+	}
+}
+
+func TestArchivedVault(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-archive-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := s.CreateVault("legacy"); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+
+	if err := config.RegisterFile(s, "legacy", "old.env", config.ModeValues, nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "new.env", config.ModeValues, nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+
+	vault, err := config.LoadVault(s, "legacy")
+	if err != nil {
+		t.Fatalf("LoadVault() error = %v", err)
+	}
+	vault.Archived = true
+	if err := vault.Save(s, "legacy"); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	if err := config.CheckVaultWritable(s, "legacy"); err == nil {
+		t.Error("CheckVaultWritable() should fail for an archived vault")
+	}
+	if err := config.CheckVaultWritable(s, store.DefaultVault); err != nil {
+		t.Errorf("CheckVaultWritable(default) error = %v", err)
+	}
+	if err := config.RegisterFile(s, "legacy", "other.env", config.ModeValues, nil); err == nil {
+		t.Error("RegisterFile() should fail in an archived vault")
+	}
+	if err := config.MoveFile(s, "new.env", "", "legacy"); err == nil {
+		t.Error("MoveFile() should fail into an archived vault")
+	}
+
+	// Existing registrations are kept and can be looked up for decryption.
+	if name, _, err := config.FindFileVault(s, "old.env"); err != nil || name != "legacy" {
+		t.Errorf("FindFileVault(old.env) = %s, %v", name, err)
+	}
+}
+
+func TestReregisterKeepsSettings(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-reregister-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com"})
+	vault.AddUser(config.User{Email: "bob@test.com"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", config.ModeFull, []string{"alice@test.com"}); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+	if err := config.SetFileGPGCopy(s, store.DefaultVault, "app.yaml", true); err != nil {
+		t.Fatalf("SetFileGPGCopy() error = %v", err)
+	}
+
+	// Without a mode or recipients, re-registering changes nothing.
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "", nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+	_, fileReg, err := config.FindFileVault(s, "app.yaml")
+	if err != nil {
+		t.Fatalf("FindFileVault() error = %v", err)
+	}
+	if fileReg.Mode != config.ModeFull || len(fileReg.Recipients) != 1 || fileReg.GPGCopy == nil || !*fileReg.GPGCopy {
+		t.Errorf("settings lost on re-register: mode=%s recipients=%v gpg_copy=%v", fileReg.Mode, fileReg.Recipients, fileReg.GPGCopy)
+	}
+
+	// Given settings are updated, the rest is kept.
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", config.ModeValues, nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+	_, fileReg, _ = config.FindFileVault(s, "app.yaml")
+	if fileReg.Mode != config.ModeValues || len(fileReg.Recipients) != 1 {
+		t.Errorf("after mode change: mode=%s recipients=%v", fileReg.Mode, fileReg.Recipients)
+	}
+
+	if err := config.ReplaceFileRegistration(s, store.DefaultVault, "app.yaml", "", nil); err != nil {
+		t.Fatalf("ReplaceFileRegistration() error = %v", err)
+	}
+	_, fileReg, _ = config.FindFileVault(s, "app.yaml")
+	if fileReg.Mode != config.ModeValues || len(fileReg.Recipients) != 0 || fileReg.GPGCopy != nil {
+		t.Errorf("replace should reset settings: mode=%s recipients=%v gpg_copy=%v", fileReg.Mode, fileReg.Recipients, fileReg.GPGCopy)
+	}
+}
+
+func TestGPGCopyTriState(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-gpgcopy-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	cfg := config.NewConfig()
+	cfg.GPGCopy = true
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+
+	for _, path := range []string{"inherit.env", "off.env"} {
+		if err := config.RegisterFile(s, store.DefaultVault, path, config.ModeValues, nil); err != nil {
+			t.Fatalf("RegisterFile(%s) error = %v", path, err)
+		}
+	}
+	if err := config.SetFileGPGCopy(s, store.DefaultVault, "off.env", false); err != nil {
+		t.Fatalf("SetFileGPGCopy() error = %v", err)
+	}
+
+	// An explicit false must survive a save and reload rather than be
+	// dropped as the zero value.
+	vault, err := config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("LoadVault() error = %v", err)
+	}
+	inherit, off := vault.GetFile("inherit.env"), vault.GetFile("off.env")
+	if inherit.GPGCopy != nil || !config.GetEffectiveGPGCopy(s, inherit) {
+		t.Errorf("inherit.env: gpg_copy = %v, effective %v; want nil, true", inherit.GPGCopy, config.GetEffectiveGPGCopy(s, inherit))
+	}
+	if off.GPGCopy == nil || *off.GPGCopy || config.GetEffectiveGPGCopy(s, off) {
+		t.Errorf("off.env: gpg_copy = %v; want explicit false", off.GPGCopy)
+	}
+
+	if err := config.ClearFileGPGCopy(s, store.DefaultVault, "off.env"); err != nil {
+		t.Fatalf("ClearFileGPGCopy() error = %v", err)
+	}
+	vault, _ = config.LoadVault(s, store.DefaultVault)
+	if off := vault.GetFile("off.env"); off.GPGCopy != nil {
+		t.Errorf("off.env: gpg_copy = %v after clear, want nil", *off.GPGCopy)
+	}
+}
+
+func TestValueKeyIDs(t *testing.T) {
+	_, alice := setupTestGPG(t)
+
+	content := []byte("api_key: abc123\nhost: db.internal\n")
+	opts := crypto.EncryptOptions{Mode: "values", Recipients: []string{"alice@test.com"}, AnnotateKeyIDs: true}
+	encrypted, err := crypto.EncryptFileContent(content, "app.yaml", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+
+	values, err := crypto.EncryptedValues(encrypted, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("EncryptedValues() error = %v", err)
+	}
+	want := crypto.LongKeyID(hex.EncodeToString(alice.PrimaryKey.Fingerprint))
+	for _, kv := range values {
+		ids := parser.ValueKeyIDs(kv.Value)
+		if len(ids) != 1 || ids[0] != want {
+			t.Errorf("%s: key IDs = %v, want [%s]", kv.Key, ids, want)
+		}
+	}
+
+	decrypted, err := crypto.DecryptFileContent(encrypted, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("DecryptFileContent() error = %v", err)
+	}
+	if string(decrypted) != string(content) {
+		t.Errorf("round trip = %q, want %q", decrypted, content)
+	}
+
+	// Tokens without annotations are still recognised.
+	plain, err := crypto.EncryptValue("abc123", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("EncryptValue() error = %v", err)
+	}
+	if !parser.IsEncrypted(plain) || parser.ValueKeyIDs(plain) != nil {
+		t.Errorf("unannotated token: encrypted=%v key IDs=%v", parser.IsEncrypted(plain), parser.ValueKeyIDs(plain))
+	}
+}
+
+func TestReencryptStaleValues(t *testing.T) {
+	gpg := crypto.NewNativeGPG()
+	for _, u := range []string{"alice", "bob"} {
+		e, err := openpgp.NewEntity(u, "Test User", u+"@test.com", nil)
+		if err != nil {
+			t.Fatalf("failed to create %s entity: %v", u, err)
+		}
+		gpg.AddEntity(e)
+	}
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	content := []byte("api_key: abc123\nhost: db.internal\n")
+	opts := crypto.EncryptOptions{Mode: "values", Recipients: []string{"alice@test.com"}, AnnotateKeyIDs: true}
+	encrypted, err := crypto.EncryptFileContent(content, "app.yaml", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+
+	updated, changed, err := crypto.ReencryptStaleValues(encrypted, "app.yaml", opts)
+	if err != nil {
+		t.Fatalf("ReencryptStaleValues() error = %v", err)
+	}
+	if changed != 0 || updated != nil {
+		t.Errorf("same recipients: changed = %d, want 0", changed)
+	}
+
+	opts.Recipients = []string{"alice@test.com", "bob@test.com"}
+	updated, changed, err = crypto.ReencryptStaleValues(encrypted, "app.yaml", opts)
+	if err != nil {
+		t.Fatalf("ReencryptStaleValues() error = %v", err)
+	}
+	if changed != 2 {
+		t.Errorf("new recipient: changed = %d, want 2", changed)
+	}
+
+	values, err := crypto.EncryptedValues(updated, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("EncryptedValues() error = %v", err)
+	}
+	for _, kv := range values {
+		if ids := parser.ValueKeyIDs(kv.Value); len(ids) != 2 {
+			t.Errorf("%s: key IDs = %v, want 2", kv.Key, ids)
+		}
+	}
+
+	decrypted, err := crypto.DecryptFileContent(updated, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("DecryptFileContent() error = %v", err)
+	}
+	if string(decrypted) != string(content) {
+		t.Errorf("round trip = %q, want %q", decrypted, content)
+	}
+}
+
+func TestReencryptStaleValuesKeepsDedupe(t *testing.T) {
+	gpg := crypto.NewNativeGPG()
+	for _, u := range []string{"alice", "bob"} {
+		e, err := openpgp.NewEntity(u, "Test User", u+"@test.com", nil)
+		if err != nil {
+			t.Fatalf("failed to create %s entity: %v", u, err)
+		}
+		gpg.AddEntity(e)
+	}
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	content := []byte("primary: hunter2\nreplica: hunter2\nother: swordfish\n")
+	opts := crypto.EncryptOptions{
+		Mode:           "values",
+		Recipients:     []string{"alice@test.com"},
+		AnnotateKeyIDs: true,
+		DedupeValues:   true,
+	}
+	encrypted, err := crypto.EncryptFileContent(content, "secrets.yaml", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+
+	opts.Recipients = []string{"alice@test.com", "bob@test.com"}
+	updated, changed, err := crypto.ReencryptStaleValues(encrypted, "secrets.yaml", opts)
+	if err != nil {
+		t.Fatalf("ReencryptStaleValues() error = %v", err)
+	}
+	if changed != 3 {
+		t.Errorf("changed = %d, want 3", changed)
+	}
+
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(updated, &doc); err != nil {
+		t.Fatalf("failed to parse re-encrypted YAML: %v", err)
+	}
+	if doc["primary"] != doc["replica"] {
+		t.Error("equal values should still share ciphertext after re-encrypting")
+	}
+	if doc["primary"] == doc["other"] {
+		t.Error("distinct values share ciphertext")
+	}
+	if doc["primary"] == nil || strings.Contains(string(encrypted), doc["primary"].(string)) {
+		t.Error("stale values should have been re-encrypted")
+	}
+}
+
+func TestSamePlaintextIgnoresRoundTripFormatting(t *testing.T) {
+	setupTestGPG(t)
+
+	// Never edited since it was encrypted, but formatted unlike the
+	// decrypted output.
+	plaintext := []byte("db:\n    host: \"localhost\"   # primary\n    pass: hunter2\nlist: [a, b]\n")
+	opts := crypto.EncryptOptions{Vault: store.DefaultVault, Mode: "values", Recipients: []string{"alice@test.com"}}
+	encrypted, err := crypto.EncryptFileContent(plaintext, "app.yaml", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+	decrypted, err := crypto.DecryptFileContent(encrypted, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("DecryptFileContent() error = %v", err)
+	}
+	if string(decrypted) == string(plaintext) {
+		t.Fatal("expected the round trip to reformat the file")
+	}
+
+	if !crypto.SamePlaintext(plaintext, decrypted, "app.yaml", parser.Spec{}) {
+		t.Error("unedited plaintext should match its decryption")
+	}
+	edited := bytes.Replace(plaintext, []byte("hunter2"), []byte("hunter3"), 1)
+	if crypto.SamePlaintext(edited, decrypted, "app.yaml", parser.Spec{}) {
+		t.Error("an edited value should not match")
+	}
+	commented := bytes.Replace(plaintext, []byte("# primary"), []byte("# replica"), 1)
+	if crypto.SamePlaintext(commented, decrypted, "app.yaml", parser.Spec{}) {
+		t.Error("an edited comment should not match")
+	}
+}
+
+func TestValueDigestCache(t *testing.T) {
+	setupTestGPG(t)
+
+	token, err := crypto.EncryptValue("s3cret", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("EncryptValue() error = %v", err)
+	}
+	digest, err := crypto.ValueDigest(token, "app.yaml")
+	if err != nil {
+		t.Fatalf("ValueDigest() error = %v", err)
+	}
+	if digest != crypto.PlaintextDigest("s3cret") {
+		t.Errorf("ValueDigest() does not match PlaintextDigest() of the plaintext")
+	}
+	if digest == crypto.PlaintextDigest("other") {
+		t.Errorf("different plaintexts have the same digest")
+	}
+
+	// Without the private key, a token seen before is served from the cache.
+	crypto.SetProvider(crypto.NewNativeGPG())
+	if _, err := crypto.DecryptValue(token); err == nil {
+		t.Fatal("DecryptValue() without a private key succeeded")
+	}
+	cached, err := crypto.ValueDigest(token, "app.yaml")
+	if err != nil {
+		t.Fatalf("ValueDigest() of a cached token error = %v", err)
+	}
+	if cached != digest {
+		t.Errorf("cached digest = %s, want %s", cached, digest)
+	}
+}
+
+func TestMetadataIsDeterministic(t *testing.T) {
+	gpg := crypto.NewNativeGPG()
+	for _, u := range []string{"alice", "bob"} {
+		e, err := openpgp.NewEntity(u, "Test User", u+"@test.com", nil)
+		if err != nil {
+			t.Fatalf("failed to create %s entity: %v", u, err)
+		}
+		gpg.AddEntity(e)
+	}
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	metadataLines := func(recipients []string) []string {
+		opts := crypto.EncryptOptions{Mode: "values", Vault: "default", Recipients: recipients, FileMode: 0600}
+		encrypted, err := crypto.EncryptFileContent([]byte("API_KEY=abc123\n"), ".env", opts)
+		if err != nil {
+			t.Fatalf("EncryptFileContent() error = %v", err)
+		}
+		var lines []string
+		for _, line := range strings.Split(string(encrypted), "\n") {
+			if strings.HasPrefix(line, "_SHHH_") && !strings.HasPrefix(line, "_SHHH_ENCRYPTED_AT=") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	for i := 0; i < 5; i++ {
+		first := metadataLines([]string{"bob@test.com", "alice@test.com"})
+		second := metadataLines([]string{"alice@test.com", "bob@test.com"})
+		if strings.Join(first, "\n") != strings.Join(second, "\n") {
+			t.Fatalf("metadata differs between encryptions:\n%s\n---\n%s", strings.Join(first, "\n"), strings.Join(second, "\n"))
+		}
+		if !sort.StringsAreSorted(first) {
+			t.Errorf("metadata keys are not sorted: %v", first)
+		}
+		if !strings.Contains(strings.Join(first, "\n"), "_SHHH_RECIPIENTS=alice@test.com, bob@test.com") {
+			t.Errorf("recipients are not sorted: %v", first)
+		}
+	}
+}
+
+func TestEncryptedAtSettings(t *testing.T) {
+	setupTestGPG(t)
+
+	encrypt := func(filename string, opts crypto.EncryptOptions) *crypto.FileMetadata {
+		opts.Recipients = []string{"alice@test.com"}
+		encrypted, err := crypto.EncryptFileContent([]byte("API_KEY=abc123\n"), filename, opts)
+		if err != nil {
+			t.Fatalf("EncryptFileContent() error = %v", err)
+		}
+		meta, err := crypto.GetFileMetadata(encrypted, filename, parser.Spec{})
+		if err != nil || meta == nil {
+			t.Fatalf("GetFileMetadata() = %v, %v", meta, err)
+		}
+		return meta
+	}
+
+	for _, mode := range []string{"values", "full"} {
+		meta := encrypt(".env", crypto.EncryptOptions{Mode: mode, Timestamp: crypto.TimestampDay})
+		if !meta.EncryptedAt.Equal(meta.EncryptedAt.Truncate(24 * time.Hour)) {
+			t.Errorf("%s day: encrypted_at = %v, want midnight UTC", mode, meta.EncryptedAt)
+		}
+
+		meta = encrypt(".env", crypto.EncryptOptions{Mode: mode, Timestamp: crypto.TimestampCounter, Revision: 3})
+		if !meta.EncryptedAt.IsZero() || meta.Revision != 3 {
+			t.Errorf("%s counter: encrypted_at = %v, revision = %d, want none and 3", mode, meta.EncryptedAt, meta.Revision)
+		}
+
+		meta = encrypt(".env", crypto.EncryptOptions{Mode: mode, Timestamp: crypto.TimestampOmit})
+		if !meta.EncryptedAt.IsZero() || meta.Revision != 0 {
+			t.Errorf("%s omit: encrypted_at = %v, revision = %d, want neither", mode, meta.EncryptedAt, meta.Revision)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "shhh-audit-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if _, ok := config.LastEncryption(s, ".env"); ok {
+		t.Error("LastEncryption() found an entry in an empty log")
+	}
+	first := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	for _, at := range []time.Time{first, first.Add(time.Hour)} {
+		if err := config.RecordEncryption(s, ".env", at); err != nil {
+			t.Fatalf("RecordEncryption() error = %v", err)
+		}
+	}
+	if at, ok := config.LastEncryption(s, ".env"); !ok || !at.Equal(first.Add(time.Hour)) {
+		t.Errorf("LastEncryption() = %v, %v, want %v", at, ok, first.Add(time.Hour))
+	}
+	if !gitignore.IsIgnored(tmpDir, filepath.Join(".shhh", "audit.log")) {
+		t.Error("audit log is not git-ignored")
+	}
+
+	// Logging a decryption, a read, must not change the project's files.
+	if err := os.Remove(s.LocalGitignorePath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.RecordDecryption(s, ".env", []string{"alice@test.com"}, "shhh cat", first); err != nil {
+		t.Fatalf("RecordDecryption() error = %v", err)
+	}
+	if fileExists(s.LocalGitignorePath()) || fileExists(filepath.Join(tmpDir, ".gitignore")) {
+		t.Error("RecordDecryption() wrote a .gitignore")
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	patch, err := parser.ParsePatch([]byte("set:\n  db.password: n3w\n  db.user: app\nremove:\n  - legacy\n"))
+	if err != nil {
+		t.Fatalf("ParsePatch() error = %v", err)
+	}
+
+	tests := []struct {
+		filename string
+		content  string
+	}{
+		{"app.yaml", "# settings\ndb:\n  password: old\nlegacy: x\n"},
+		{"app.json", `{"db": {"password": "old"}, "legacy": "x"}`},
+		{"app.ini", "legacy = x\n\n[db]\npassword = old\n"},
+	}
+	for _, tt := range tests {
+		patched, err := parser.ApplyPatch([]byte(tt.content), tt.filename, parser.Spec{}, patch)
+		if err != nil {
+			t.Fatalf("%s: ApplyPatch() error = %v", tt.filename, err)
+		}
+		values, err := parser.FlattenFile(patched, tt.filename, parser.Spec{})
+		if err != nil {
+			t.Fatalf("%s: FlattenFile() error = %v", tt.filename, err)
+		}
+		got := map[string]string{}
+		for _, kv := range values {
+			got[kv.Key] = kv.Value
+		}
+		if len(got) != 2 || got["db.password"] != "n3w" || got["db.user"] != "app" {
+			t.Errorf("%s: patched values = %v", tt.filename, got)
+		}
+	}
+
+	envPatch, err := parser.ParsePatch([]byte(`{"set": {"API_KEY": "a b", "NEW": "1"}, "remove": ["OLD"]}`))
+	if err != nil {
+		t.Fatalf("ParsePatch() error = %v", err)
+	}
+	patched, err := parser.ApplyPatch([]byte("# keys\nAPI_KEY=old\nOLD=x\n"), ".env", parser.Spec{}, envPatch)
+	if err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if want := "# keys\nAPI_KEY=\"a b\"\nNEW=1\n"; string(patched) != want {
+		t.Errorf("patched .env = %q, want %q", patched, want)
+	}
+
+	// A change that cannot be applied fails the whole patch.
+	bad, err := parser.ParsePatch([]byte("set:\n  db: x\n"))
+	if err != nil {
+		t.Fatalf("ParsePatch() error = %v", err)
+	}
+	if _, err := parser.ApplyPatch([]byte("db:\n  password: old\n"), "app.yaml", parser.Spec{}, bad); err == nil {
+		t.Error("setting a mapping to a value succeeded")
+	}
+	for _, doc := range []string{"set: {}", "set:\n  a: x\nremove: [a]", "other: 1", "set:\n  a: [1]"} {
+		if _, err := parser.ParsePatch([]byte(doc)); err == nil {
+			t.Errorf("ParsePatch(%q) succeeded", doc)
+		}
+	}
+}
+
+func TestRotationMetadata(t *testing.T) {
+	setupTestGPG(t)
+
+	rotated := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+	for _, tt := range []struct {
+		filename, mode, timestamp string
+		want                      time.Time
+	}{
+		{"app.yaml", "values", crypto.TimestampPrecise, rotated},
+		{".env", "values", crypto.TimestampDay, rotated.Truncate(24 * time.Hour)},
+		{"app.json", "full", crypto.TimestampPrecise, rotated},
+	} {
+		content := []byte("api_key: abc123\n")
+		if tt.filename == ".env" {
+			content = []byte("API_KEY=abc123\n")
+		} else if tt.filename == "app.json" {
+			content = []byte(`{"api_key": "abc123"}`)
+		}
+		opts := crypto.EncryptOptions{
+			Mode:       tt.mode,
+			Recipients: []string{"alice@test.com"},
+			Timestamp:  tt.timestamp,
+			Rotations:  map[string]time.Time{"api_key": rotated, "db.password": rotated},
+		}
+		encrypted, err := crypto.EncryptFileContent(content, tt.filename, opts)
+		if err != nil {
+			t.Fatalf("%s: EncryptFileContent() error = %v", tt.filename, err)
+		}
+		meta, err := crypto.GetFileMetadata(encrypted, tt.filename, parser.Spec{})
+		if err != nil || meta == nil {
+			t.Fatalf("%s: GetFileMetadata() = %v, %v", tt.filename, meta, err)
+		}
+		if len(meta.Rotations) != 2 || !meta.Rotations["api_key"].Equal(tt.want) || !meta.Rotations["db.password"].Equal(tt.want) {
+			t.Errorf("%s: rotations = %v, want both at %v", tt.filename, meta.Rotations, tt.want)
+		}
+	}
+}
+
+func TestExecValues(t *testing.T) {
+	content := []byte("db:\n  password: !shhh-exec \"vault kv get -field=pass db\"\n  user: &u !shhh-exec 'echo admin'\n  again: *u\n  host: localhost\n")
+
+	execs, err := parser.ExecValues(content, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("ExecValues() error = %v", err)
+	}
+	want := []parser.KeyValue{
+		{Key: "db.password", Value: "vault kv get -field=pass db"},
+		{Key: "db.user", Value: "echo admin"},
+	}
+	if len(execs) != len(want) {
+		t.Fatalf("ExecValues() = %v, want %v", execs, want)
+	}
+	for i := range want {
+		if execs[i] != want[i] {
+			t.Errorf("ExecValues()[%d] = %v, want %v", i, execs[i], want[i])
+		}
+	}
+
+	if execs, err := parser.ExecValues([]byte("PASSWORD=!shhh-exec \"x\"\n"), ".env", parser.Spec{}); err != nil || len(execs) != 0 {
+		t.Errorf("ExecValues(.env) = %v, %v, want none", execs, err)
+	}
+}
+
+func TestKRMSecretGenerator(t *testing.T) {
+	input := []byte(`apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+items:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: settings
+functionConfig:
+  apiVersion: shhh.dev/v1
+  kind: SecretGenerator
+  metadata:
+    name: app-secrets
+    namespace: prod
+  files:
+    - config/app.yaml
+`)
+
+	rl, gen, err := k8s.ParseResourceList(input)
+	if err != nil {
+		t.Fatalf("ParseResourceList() error = %v", err)
+	}
+	if gen.Metadata.Name != "app-secrets" || gen.Type != "Opaque" || len(gen.Files) != 1 {
+		t.Errorf("generator = %+v", gen)
+	}
+
+	secret, err := k8s.NewSecret(gen, []parser.KeyValue{{Key: "database.password", Value: "s3cret"}})
+	if err != nil {
+		t.Fatalf("NewSecret() error = %v", err)
+	}
+	if secret.Data["database.password"] != "czNjcmV0" {
+		t.Errorf("secret data = %v", secret.Data)
+	}
+	if err := rl.Append(secret); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+
+	out, err := k8s.Marshal(rl)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var result struct {
+		Kind  string `yaml:"kind"`
+		Items []struct {
+			Kind     string `yaml:"kind"`
+			Metadata struct {
+				Name        string            `yaml:"name"`
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"metadata"`
+		} `yaml:"items"`
+	}
+	if err := yaml.Unmarshal(out, &result); err != nil {
+		t.Fatalf("output is not valid YAML: %v", err)
+	}
+	if result.Kind != "ResourceList" || len(result.Items) != 2 || result.Items[0].Kind != "ConfigMap" || result.Items[1].Kind != "Secret" {
+		t.Fatalf("output = %s", out)
+	}
+	if result.Items[1].Metadata.Annotations["kustomize.config.k8s.io/needs-hash"] != "true" {
+		t.Errorf("generated Secret should ask kustomize for a hash suffix:\n%s", out)
+	}
+
+	if _, _, err := k8s.ParseResourceList([]byte("kind: ResourceList\nfunctionConfig:\n  metadata:\n    name: x\n")); err == nil {
+		t.Error("expected an error for a generator without files")
+	}
+}
+
+func TestTrackedFiles(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	gitCmd := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@test.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	gitCmd("init", "-q")
+	for _, name := range []string{"committed.yaml", "staged.yaml", "ignored.yaml"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("a: b\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gitCmd("add", "committed.yaml")
+	gitCmd("commit", "-q", "-m", "add")
+	// Removed from the index, but still in HEAD until the removal is committed.
+	gitCmd("rm", "-q", "--cached", "committed.yaml")
+	gitCmd("add", "staged.yaml")
+
+	tracked, err := git.TrackedFiles(dir)
+	if err != nil {
+		t.Fatalf("TrackedFiles() error = %v", err)
+	}
+	if !tracked["committed.yaml"] || !tracked["staged.yaml"] || tracked["ignored.yaml"] {
+		t.Errorf("TrackedFiles() = %v, want committed.yaml and staged.yaml", tracked)
+	}
+}
+
+func TestExpiryWarningDays(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-expiry-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	cfg := config.NewConfig()
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	if days := config.ExpiryWarningDays(s, vault); days != 30 {
+		t.Errorf("default threshold = %d, want 30", days)
+	}
+
+	if !cfg.Set("expiry_warning_days", "60") {
+		t.Fatal("expected expiry_warning_days to be set")
+	}
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if days := config.ExpiryWarningDays(s, vault); days != 60 {
+		t.Errorf("global threshold = %d, want 60", days)
+	}
+
+	override := 0
+	vault.ExpiryWarningDays = &override
+	if days := config.ExpiryWarningDays(s, vault); days != 0 {
+		t.Errorf("vault threshold = %d, want 0", days)
+	}
+
+	if cfg.Set("expiry_warning_days", "-5") {
+		t.Error("expected negative expiry_warning_days to be rejected")
+	}
+}
+
+func TestRefreshUserKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-keyrefresh-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	gpg, _ := setupTestGPG(t)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	key, err := gpg.LookupKey("alice@test.com")
+	if err != nil {
+		t.Fatalf("LookupKey() error = %v", err)
+	}
+	// The vault recorded an expiry that has since been extended.
+	oldExpiry := time.Now().Add(24 * time.Hour)
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com", KeyID: key.KeyID, Fingerprint: key.Fingerprint, ExpiresAt: &oldExpiry})
+	vault.AddUser(config.User{Email: "carol@test.com", Fingerprint: "0000000000000000000000000000000000000000"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	results, err := config.RefreshUserKeys(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("RefreshUserKeys() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	alice, carol := results[0], results[1]
+	if alice.Status != "valid" || len(alice.Changes) != 2 {
+		t.Errorf("alice = %s %v, want valid with expiry and cache changes", alice.Status, alice.Changes)
+	}
+	if carol.Status != "missing" || len(carol.Changes) != 0 {
+		t.Errorf("carol = %s %v, want missing and unchanged", carol.Status, carol.Changes)
+	}
+
+	reloaded, err := config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("failed to load vault: %v", err)
+	}
+	if reloaded.Users[0].ExpiresAt != nil {
+		t.Errorf("expiry = %v, want none", reloaded.Users[0].ExpiresAt)
+	}
+	if _, err := os.Stat(s.PubkeyPath("alice@test.com")); err != nil {
+		t.Errorf("public key not cached: %v", err)
+	}
+
+	results, err = config.RefreshUserKeys(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("RefreshUserKeys() error = %v", err)
+	}
+	if len(results[0].Changes) != 0 {
+		t.Errorf("second refresh changed %v", results[0].Changes)
+	}
+}
+
+func TestUserIDSelection(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-uid-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Alice has a personal and a work key for the same email; the work key
+	// has a second user ID.
+	personal, err := openpgp.NewEntity("Alice", "personal", "alice@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create personal entity: %v", err)
+	}
+	work, err := openpgp.NewEntity("Alice", "work", "alice@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create work entity: %v", err)
+	}
+	if err := work.AddUserId("Alice", "", "a.smith@test.com", nil); err != nil {
+		t.Fatalf("failed to add user ID: %v", err)
+	}
+	workFingerprint := fmt.Sprintf("%X", work.PrimaryKey.Fingerprint)
+
+	gpg := crypto.NewNativeGPG()
+	gpg.AddEntity(personal)
+	gpg.AddEntity(work)
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	if _, err := config.AddUser(s, store.DefaultVault, "alice@test.com", "Alice <a.smith@test.com>"); err == nil {
+		t.Error("expected a user ID for another email to be rejected")
+	}
+
+	user, err := config.AddUser(s, store.DefaultVault, "alice@test.com", "Alice (work) <alice@test.com>")
+	if err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if user.Fingerprint != workFingerprint || user.UID != "Alice (work) <alice@test.com>" {
+		t.Errorf("added %s (%s), want the work key", user.Fingerprint, user.UID)
+	}
+
+	// The recorded user ID keeps lookups on the work key, although the
+	// personal key comes first for the email.
+	statuses, err := config.CheckUserKeys(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("CheckUserKeys() error = %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Status != "valid" {
+		t.Errorf("statuses = %+v, want alice valid", statuses)
+	}
+
+	other, err := config.AddUser(s, store.DefaultVault, "a.smith@test.com", "")
+	if err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if other.Fingerprint != workFingerprint || other.UID != "Alice <a.smith@test.com>" {
+		t.Errorf("added %s (%s), want the work key by its second user ID", other.Fingerprint, other.UID)
+	}
+}
+
+func TestReencryptJournal(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-journal-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	if _, found, err := config.LoadReencryptJournal(s); err != nil || found {
+		t.Fatalf("LoadReencryptJournal() found = %v, err = %v; want no journal", found, err)
+	}
+
+	recipients := []string{"bob@test.com", "alice@test.com"}
+	if err := config.RecordReencrypted(s, "default", "app.yaml", recipients); err != nil {
+		t.Fatalf("RecordReencrypted() error = %v", err)
+	}
+
+	journal, found, err := config.LoadReencryptJournal(s)
+	if err != nil || !found {
+		t.Fatalf("LoadReencryptJournal() found = %v, err = %v", found, err)
+	}
+	if !journal.Done("default", "app.yaml", []string{"alice@test.com", "bob@test.com"}) {
+		t.Error("file re-encrypted to the same recipients should be done")
+	}
+	if journal.Done("default", "app.yaml", []string{"alice@test.com"}) {
+		t.Error("file whose recipients changed should not be done")
+	}
+	if journal.Done("ops", "app.yaml", recipients) {
+		t.Error("file of another vault should not be done")
+	}
+
+	if !gitignore.IsIgnored(tmpDir, filepath.Join(".shhh", "reencrypt.journal")) {
+		t.Error("journal should be git-ignored")
+	}
+
+	if err := config.ClearReencryptJournal(s); err != nil {
+		t.Fatalf("ClearReencryptJournal() error = %v", err)
+	}
+	if _, found, _ := config.LoadReencryptJournal(s); found {
+		t.Error("journal should be removed")
+	}
+}
+
+func TestConcurrentPubkeyWrites(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-pubkeys-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	// Keys of different lengths, so a torn or truncated write shows.
+	keys := make([][]byte, 8)
+	for i := range keys {
+		keys[i] = []byte(strings.Repeat(fmt.Sprintf("key %d\n", i), 1000*(i+1)))
+	}
+
+	var wg sync.WaitGroup
+	for i := range 32 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.WritePubkey("alice@test.com", keys[i%len(keys)]); err != nil {
+				t.Errorf("WritePubkey() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cached, err := os.ReadFile(s.PubkeyPath("alice@test.com"))
+	if err != nil {
+		t.Fatalf("failed to read cached key: %v", err)
+	}
+	intact := false
+	for _, key := range keys {
+		intact = intact || string(cached) == string(key)
+	}
+	if !intact {
+		t.Errorf("cached key is not one of the written keys (%d bytes)", len(cached))
+	}
+
+	entries, err := os.ReadDir(s.PubkeysPath())
+	if err != nil {
+		t.Fatalf("failed to read pubkeys: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("pubkeys holds %d files, want only alice@test.com.asc", len(entries))
+	}
+}
+
+func TestProviderLoadsCachedKeys(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-cachedkeys-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	entity, err := openpgp.NewEntity("bob", "Teammate", "bob@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	pubKey, err := crypto.ArmorPublicKey(entity)
+	if err != nil {
+		t.Fatalf("ArmorPublicKey() error = %v", err)
+	}
+	if err := s.WritePubkey("bob@test.com", pubKey); err != nil {
+		t.Fatalf("WritePubkey() error = %v", err)
+	}
+
+	if err := crypto.SetProviderMode(crypto.ProviderNative); err != nil {
+		t.Fatalf("SetProviderMode() error = %v", err)
+	}
+	crypto.SetPubkeysDir(s.PubkeysPath())
+	defer func() {
+		crypto.SetPubkeysDir("")
+		crypto.SetProviderMode(crypto.ProviderAuto)
+	}()
+
+	// A teammate's key that is only cached in the project can be encrypted to.
+	if _, err := crypto.GetProvider().Encrypt([]byte("secret"), []string{"bob@test.com"}); err != nil {
+		t.Errorf("Encrypt() to a cached key error = %v", err)
+	}
+}
+
+func TestProviderFallbackLog(t *testing.T) {
+	t.Setenv("GNUPGHOME", t.TempDir())
+	defer crypto.SetProviderMode(crypto.ProviderAuto)
+	defer crypto.SetFallback(true)
+
+	if err := crypto.SetProviderMode(crypto.ProviderAuto); err != nil {
+		t.Fatalf("SetProviderMode() error = %v", err)
+	}
+	crypto.SetFallback(true)
+	if name := crypto.BackendName(crypto.GetProvider()); name != "auto" {
+		t.Fatalf("backend with fallback = %q, want auto", name)
+	}
+
+	native := crypto.NativeKeyring()
+	entity, err := openpgp.NewEntity("alice", "Test User", "alice@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	native.AddEntity(entity)
+	for range 2 {
+		if _, err := crypto.GetProvider().Encrypt([]byte("secret"), []string{"alice@test.com"}); err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+	}
+	if log := crypto.ProviderLog(); !slices.Contains(log, "encrypt: native x2") {
+		t.Errorf("ProviderLog() = %q, want encrypt handled natively twice", log)
+	}
+
+	// Without fallback and without gpg private keys, native handles the run.
+	crypto.SetFallback(false)
+	if name := crypto.BackendName(crypto.GetProvider()); name != "native" {
+		t.Errorf("backend without fallback = %q, want native", name)
+	}
+	if log := crypto.ProviderLog(); len(log) != 1 || !strings.Contains(log[0], "fallback disabled") {
+		t.Errorf("ProviderLog() = %q, want a single line noting fallback is disabled", log)
+	}
+}
+
+func TestLongValuesWrapped(t *testing.T) {
+	setupTestGPG(t)
+
+	// Random data does not compress, so its token stays long.
+	blob := make([]byte, 6000)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatalf("failed to generate value: %v", err)
+	}
+	cert := base64.StdEncoding.EncodeToString(blob)
+	content := []byte("cert: " + cert + "\nhost: db.internal\n")
+	opts := crypto.EncryptOptions{Mode: "values", Recipients: []string{"alice@test.com"}, AnnotateKeyIDs: true, Verify: true}
+	encrypted, err := crypto.EncryptFileContent(content, "app.yaml", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+
+	for _, line := range strings.Split(string(encrypted), "\n") {
+		if len(line) > parser.WrapThreshold {
+			t.Fatalf("line of %d bytes in wrapped output: %.40s...", len(line), line)
+		}
+	}
+	values, err := crypto.EncryptedValues(encrypted, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("EncryptedValues() error = %v", err)
+	}
+	for _, kv := range values {
+		wrapped := strings.Contains(kv.Value, "\n")
+		if wrapped != (kv.Key == "cert") {
+			t.Errorf("%s: wrapped = %v", kv.Key, wrapped)
+		}
+		if len(parser.ValueKeyIDs(kv.Value)) != 1 {
+			t.Errorf("%s: key IDs lost in wrapping", kv.Key)
+		}
+	}
+
+	decrypted, err := crypto.DecryptFileContent(encrypted, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("DecryptFileContent() error = %v", err)
+	}
+	if string(decrypted) != string(content) {
+		t.Error("long value did not round-trip")
+	}
+
+	// Values in other formats cannot span lines and are left unwrapped.
+	encrypted, err = crypto.EncryptFileContent([]byte("CERT="+cert+"\n"), "app.env", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+	if decrypted, err := crypto.DecryptFileContent(encrypted, "app.env", parser.Spec{}); err != nil || string(decrypted) != "CERT="+cert+"\n" {
+		t.Errorf("env round trip failed: %v", err)
+	}
+}
+
+func TestHugeValuesInSingleLineFormats(t *testing.T) {
+	setupTestGPG(t)
+
+	blob := make([]byte, parser.MaxUnwrappedToken/2)
+	if _, err := rand.Read(blob); err != nil {
+		t.Fatalf("failed to generate value: %v", err)
+	}
+	huge, _ := json.Marshal(map[string]string{"blob": base64.StdEncoding.EncodeToString(blob), "host": "db.internal"})
+	opts := crypto.EncryptOptions{Mode: "values", Recipients: []string{"alice@test.com"}}
+
+	// JSON strings cannot span lines, so rather than write a token that
+	// would be a multi-megabyte line, the whole file is encrypted.
+	encrypted, err := crypto.EncryptFileContent(huge, "app.json", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() of a huge JSON value error = %v", err)
+	}
+	if !crypto.IsFullyEncrypted(encrypted) {
+		t.Error("expected a file with a huge JSON value to be encrypted whole")
+	}
+	if decrypted, err := crypto.DecryptFileContent(encrypted, "app.json", parser.Spec{}); err != nil || !bytes.Equal(decrypted, huge) {
+		t.Errorf("huge JSON value did not round-trip: %v", err)
+	}
+
+	// Long tokens below the limit are still written, on one line.
+	long, _ := json.Marshal(map[string]string{"cert": base64.StdEncoding.EncodeToString(blob[:6000])})
+	encrypted, err = crypto.EncryptFileContent(long, "app.json", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+	if crypto.IsFullyEncrypted(encrypted) {
+		t.Error("expected a long JSON value to stay in values mode")
+	}
+	if decrypted, err := crypto.DecryptFileContent(encrypted, "app.json", parser.Spec{}); err != nil || !crypto.SamePlaintext(long, decrypted, "app.json", parser.Spec{}) {
+		t.Errorf("long JSON value did not round-trip: %v", err)
+	}
+}
+
+func TestFullModeCompression(t *testing.T) {
+	setupTestGPG(t)
+
+	dump := []byte(strings.Repeat("INSERT INTO users (id, name) VALUES (1, 'alice');\n", 2000))
+	opts := crypto.EncryptOptions{Mode: "full", Recipients: []string{"alice@test.com"}, Verify: true}
+	plain, err := crypto.EncryptFileContent(dump, "dump.sql", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() error = %v", err)
+	}
+
+	opts.Compression = crypto.CompressionZstd
+	compressed, err := crypto.EncryptFileContent(dump, "dump.sql", opts)
+	if err != nil {
+		t.Fatalf("EncryptFileContent() with zstd error = %v", err)
+	}
+	if len(compressed) >= len(plain)/2 {
+		t.Errorf("compressed file is %d bytes, uncompressed %d", len(compressed), len(plain))
+	}
+
+	meta, err := crypto.GetFileMetadata(compressed, "dump.sql", parser.Spec{})
+	if err != nil || meta.Compression != crypto.CompressionZstd {
+		t.Errorf("metadata compression = %v (err %v), want zstd", meta, err)
+	}
+	if meta, _ := crypto.GetFileMetadata(plain, "dump.sql", parser.Spec{}); meta.Compression != "" {
+		t.Errorf("uncompressed file records compression %q", meta.Compression)
+	}
+
+	for _, enc := range [][]byte{plain, compressed} {
+		decrypted, err := crypto.DecryptFileContent(enc, "dump.sql", parser.Spec{})
+		if err != nil {
+			t.Fatalf("DecryptFileContent() error = %v", err)
+		}
+		if string(decrypted) != string(dump) {
+			t.Error("dump did not round-trip")
+		}
+	}
+
+	// A file compressed with an unknown algorithm is refused, not returned
+	// compressed.
+	unknown := []byte(strings.Replace(string(compressed), "Compression: zstd", "Compression: brotli", 1))
+	if _, err := crypto.DecryptFileContent(unknown, "dump.sql", parser.Spec{}); err == nil || !strings.Contains(err.Error(), "brotli") {
+		t.Errorf("DecryptFileContent() with unknown compression error = %v", err)
+	}
+}
+
+func TestEditorConfig(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-editor-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	cfg := config.NewConfig()
+	if editor := cfg.EditorFor("yaml"); editor != "" {
+		t.Errorf("EditorFor() without config = %q, want none", editor)
+	}
+	if !cfg.Set("editor", "code --wait {file}") || !cfg.Set("editor.env", "|vipe") {
+		t.Fatal("Set() rejected an editor key")
+	}
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+
+	cfg, err = config.Load(s)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if editor := cfg.EditorFor("env"); editor != "|vipe" {
+		t.Errorf("EditorFor(env) = %q, want the env editor", editor)
+	}
+	if editor := cfg.EditorFor("yaml"); editor != "code --wait {file}" {
+		t.Errorf("EditorFor(yaml) = %q, want the default editor", editor)
+	}
+	if value, ok := cfg.Get("editor.env"); !ok || value != "|vipe" {
+		t.Errorf("Get(editor.env) = %q, %v", value, ok)
+	}
+	if cfg.List()["editor.env"] != "|vipe" {
+		t.Error("List() does not include editor.env")
+	}
+
+	// The editor runs on the plaintext, so a committed one needs trusting.
+	settings := cfg.CommandSettings()
+	if len(settings) != 2 || settings[0].String() != "editor: code --wait {file}" || settings[1].String() != "editor.env: |vipe" {
+		t.Errorf("CommandSettings() = %v", settings)
+	}
+	if cs := cfg.EditorSetting("env"); cs.Key != "editor.env" {
+		t.Errorf("EditorSetting(env) = %v", cs)
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if config.SettingTrusted(s, "editor.env", "|vipe") {
+		t.Error("editor.env should not be trusted before 'shhh hooks trust'")
+	}
+
+	cfg.Set("editor.env", "")
+	if editor := cfg.EditorFor("env"); editor != "code --wait {file}" {
+		t.Errorf("EditorFor(env) after clearing = %q", editor)
+	}
+	if cfg.Set("editor.", "vim") {
+		t.Error("Set() accepted an editor key without a file type")
+	}
+}
+
+func TestValuesView(t *testing.T) {
+	content := []byte("db:\n  password: old\n  port: 5432\nhosts:\n  - a\n  - b\n  - c\nnote: |\n  line one\n  line two\n")
+	values, err := parser.FlattenFile(content, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("FlattenFile() error = %v", err)
+	}
+	view, err := parser.FormatValuesView(values, "Values of app.yaml")
+	if err != nil {
+		t.Fatalf("FormatValuesView() error = %v", err)
+	}
+
+	unchanged, err := parser.ParseValuesView(view)
+	if err != nil {
+		t.Fatalf("ParseValuesView() error = %v", err)
+	}
+	if patch := parser.DiffValues(values, unchanged); len(patch.Set) != 0 || len(patch.Remove) != 0 {
+		t.Fatalf("unedited view has changes: %+v", patch)
+	}
+
+	edited := strings.Replace(string(view), "db.password: old", "db.password: new", 1)
+	edited = strings.Replace(edited, "hosts.0: a\n", "", 1)
+	edited = strings.Replace(edited, "hosts.1: b\n", "", 1)
+	edited += "db.user: admin\n"
+	after, err := parser.ParseValuesView([]byte(edited))
+	if err != nil {
+		t.Fatalf("ParseValuesView() error = %v", err)
+	}
+	patched, err := parser.ApplyPatch(content, "app.yaml", parser.Spec{}, parser.DiffValues(values, after))
+	if err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+
+	got, err := parser.FlattenFile(patched, "app.yaml", parser.Spec{})
+	if err != nil {
+		t.Fatalf("FlattenFile() error = %v", err)
+	}
+	want := map[string]string{"db.password": "new", "db.port": "5432", "db.user": "admin", "hosts.0": "c", "note": "line one\nline two\n"}
+	if len(got) != len(want) {
+		t.Errorf("patched values = %v, want %v", got, want)
+	}
+	for _, kv := range got {
+		if want[kv.Key] != kv.Value {
+			t.Errorf("%s = %q, want %q", kv.Key, kv.Value, want[kv.Key])
+		}
+	}
+
+	if _, err := parser.ParseValuesView([]byte("a: 1\na: 2\n")); err == nil {
+		t.Error("ParseValuesView() accepted a key path twice")
+	}
+}
+
+func TestValidateHook(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-validate-hook-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	h := &hooks.FileHooks{}
+	if err := hooks.RunValidateHook(tmpDir, "default", "app.json", h, []byte("{")); err != nil {
+		t.Errorf("unset validate hook should not run: %v", err)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	// An untrusted hook is skipped and never receives the plaintext.
+	h.Set(hooks.Validate, `cat > leaked.txt; exit 1`)
+	if err := hooks.RunValidateHook(tmpDir, "default", "app.json", h, []byte(`{"password": "s3cret"}`)); err != nil {
+		t.Errorf("untrusted validate hook should be skipped: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "leaked.txt")); err == nil {
+		t.Error("untrusted validate hook was given the plaintext")
+	}
+
+	h.Set(hooks.Validate, `test "$SHHH_FILE" = app.json && grep -q '^{.*}$'`)
+	hooks.Trust(tmpDir, h.Validate)
+	if err := hooks.RunValidateHook(tmpDir, "default", "app.json", h, []byte(`{"password": "s3cret"}`)); err != nil {
+		t.Errorf("valid content rejected: %v", err)
+	}
+
+	// The output of a failing hook is part of the error.
+	h.Set(hooks.Validate, `cat; echo "parse error" >&2; exit 1`)
+	hooks.Trust(tmpDir, h.Validate)
+	err = hooks.RunValidateHook(tmpDir, "default", "app.json", h, []byte(`{"password":`))
+	if err == nil || !strings.Contains(err.Error(), "parse error") {
+		t.Errorf("RunValidateHook() error = %v, want the hook's output", err)
+	}
+
+	h.ValidateWarn = true
+	h.Set(hooks.Validate, "")
+	if h.ValidateWarn || !h.IsZero() {
+		t.Error("clearing the validate hook should clear validate_warn")
+	}
+}
+
+func TestCoverageAllowlist(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-coverage-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	if err := scan.AddAllowed(s, "testdata/", "test fixtures"); err != nil {
+		t.Fatalf("AddAllowed() error = %v", err)
+	}
+	if err := scan.AddAllowed(s, "old.pem", ""); err != nil {
+		t.Fatalf("AddAllowed() error = %v", err)
+	}
+
+	allowlist, err := scan.LoadAllowlist(s)
+	if err != nil {
+		t.Fatalf("LoadAllowlist() error = %v", err)
+	}
+	entry, ok := allowlist.Match("testdata/certs/server.key")
+	if !ok || entry.Reason != "test fixtures" {
+		t.Errorf("Match(testdata/certs/server.key) = %v, %v; want the testdata/ entry", entry, ok)
+	}
+	if _, ok := allowlist.Match("config/.env"); ok {
+		t.Error("config/.env should not be allowed")
+	}
+
+	unused := allowlist.Unused()
+	if len(unused) != 1 || unused[0].Pattern != "old.pem" {
+		t.Errorf("Unused() = %v, want only old.pem", unused)
+	}
+}
+
+func TestShareToOneOffKey(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-share-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	vendor, err := openpgp.NewEntity("Vendor", "", "ext@vendor.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create vendor entity: %v", err)
+	}
+	var pubkey strings.Builder
+	w, err := armor.Encode(&pubkey, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("failed to armor key: %v", err)
+	}
+	if err := vendor.Serialize(w); err != nil {
+		t.Fatalf("failed to serialize key: %v", err)
+	}
+	w.Close()
+
+	gpg := crypto.NewNativeGPG()
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	encrypted, err := crypto.EncryptToKey([]byte("token: s3cret\n"), []byte(pubkey.String()), "ext@vendor.com")
+	if err != nil {
+		t.Fatalf("EncryptToKey() error = %v", err)
+	}
+	if _, err := gpg.Encrypt([]byte("x"), []string{"ext@vendor.com"}); err == nil {
+		t.Error("the one-off key should not be added to the keyring")
+	}
+	if _, err := crypto.EncryptToKey([]byte("x"), []byte(pubkey.String()), "other@vendor.com"); err == nil {
+		t.Error("EncryptToKey() should reject a recipient the key does not belong to")
+	}
+
+	recipient := crypto.NewNativeGPG()
+	recipient.AddEntity(vendor)
+	decrypted, err := recipient.Decrypt(encrypted)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(decrypted) != "token: s3cret\n" {
+		t.Errorf("decrypted = %q", decrypted)
+	}
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	now := time.Now()
+	if err := config.RecordShare(s, "app.yaml", "ext@vendor.com", now, now.Add(24*time.Hour)); err != nil {
+		t.Fatalf("RecordShare() error = %v", err)
+	}
+	if _, ok := config.LastEncryption(s, "app.yaml"); ok {
+		t.Error("a share should not count as an encryption")
+	}
+}
+
+func TestFullFallbackRecorded(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-fallback-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if err := config.NewVault().Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	for _, path := range []string{"app.yaml", "blob.dat", "keys.dat"} {
+		if err := config.RegisterFile(s, store.DefaultVault, path, "", nil); err != nil {
+			t.Fatalf("RegisterFile(%s) error = %v", path, err)
+		}
+	}
+	if err := config.SetFileFormat(s, store.DefaultVault, "keys.dat", "env"); err != nil {
+		t.Fatalf("SetFileFormat() error = %v", err)
+	}
+
+	vault, err := config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("LoadVault() error = %v", err)
+	}
+	want := map[string]bool{"app.yaml": false, "blob.dat": true, "keys.dat": false}
+	for path, fallback := range want {
+		if got := vault.GetFile(path).FullFallback; got != fallback {
+			t.Errorf("%s: FullFallback = %v, want %v", path, got, fallback)
+		}
+	}
+
+	if err := config.SetFileMode(s, store.DefaultVault, "blob.dat", config.ModeFull); err != nil {
+		t.Fatalf("SetFileMode() error = %v", err)
+	}
+	vault, _ = config.LoadVault(s, store.DefaultVault)
+	if vault.GetFile("blob.dat").FullFallback {
+		t.Error("a full-mode file should not record a fallback")
+	}
+
+	// Vaults written before the flag existed are reported and repaired.
+	content, _ := os.ReadFile(s.VaultConfigPath(store.DefaultVault))
+	content = []byte(strings.Replace(string(content), "path: blob.dat\n    mode: full", "path: blob.dat\n    mode: values", 1))
+	os.WriteFile(s.VaultConfigPath(store.DefaultVault), content, 0600)
+
+	issues, err := config.Fsck(s, true)
+	if err != nil {
+		t.Fatalf("Fsck() error = %v", err)
+	}
+	found := false
+	for _, issue := range issues {
+		if strings.Contains(issue.Message, "blob.dat has an outdated full_fallback") && issue.Fixed {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Fsck() did not fix the missing full_fallback: %+v", issues)
+	}
+	vault, _ = config.LoadVault(s, store.DefaultVault)
+	if !vault.GetFile("blob.dat").FullFallback {
+		t.Error("Fsck() --fix should record the fallback")
+	}
+}
+
+func TestRecipientTemplates(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-template-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com", KeyID: "ALICE"})
+	vault.AddUser(config.User{Email: "bob@test.com", KeyID: "BOB"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+
+	// Registered before the template existed, so encrypted for everyone.
+	if err := config.RegisterFile(s, store.DefaultVault, "payments/old.yaml", "", nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+	if err := config.SetRecipientTemplate(s, store.DefaultVault, "payments/", []string{"carol@test.com"}); err == nil {
+		t.Error("SetRecipientTemplate() should reject recipients who are not vault users")
+	}
+	if err := config.SetRecipientTemplate(s, store.DefaultVault, "payments/", []string{"alice@test.com"}); err != nil {
+		t.Fatalf("SetRecipientTemplate() error = %v", err)
+	}
+
+	if err := config.RegisterFile(s, store.DefaultVault, "payments/stripe.yaml", "", nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "payments/bank.yaml", "", []string{"bob@test.com"}); err == nil {
+		t.Error("RegisterFile() should reject recipients outside the template")
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "", nil); err != nil {
+		t.Fatalf("RegisterFile() error = %v", err)
+	}
+
+	vault, err = config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("LoadVault() error = %v", err)
+	}
+	if got := vault.GetFile("payments/stripe.yaml").Recipients; len(got) != 1 || got[0] != "alice@test.com" {
+		t.Errorf("template recipients not applied: %v", got)
+	}
+	if vault.TemplateFor("app.yaml") != nil {
+		t.Error("TemplateFor(app.yaml) should not match payments/")
+	}
+
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"payments/stripe.yaml", false},
+		{"payments/old.yaml", true},
+		{"app.yaml", false},
+	}
+	for _, tt := range tests {
+		recipients, err := config.EncryptionRecipients(s, store.DefaultVault, vault.GetFile(tt.path))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("EncryptionRecipients(%s) = %v, %v; wantErr %v", tt.path, recipients, err, tt.wantErr)
+		}
+	}
+
+	if err := config.RemoveRecipientTemplate(s, store.DefaultVault, "payments/"); err != nil {
+		t.Fatalf("RemoveRecipientTemplate() error = %v", err)
+	}
+	if _, err := config.EncryptionRecipients(s, store.DefaultVault, vault.GetFile("payments/old.yaml")); err != nil {
+		t.Errorf("EncryptionRecipients() after removing the template error = %v", err)
+	}
+}
+
+func TestSessionApprovals(t *testing.T) {
+	runtimeDir := t.TempDir()
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+
+	scope := "/project\tproduction"
+	if session.Approved(scope) {
+		t.Fatal("nothing should be approved in a new session")
+	}
+	if err := session.Approve(scope); err != nil {
+		t.Fatalf("Approve() error = %v", err)
+	}
+	if !session.Approved(scope) {
+		t.Error("Approved() = false after Approve()")
+	}
+	if session.Approved("/project\tstaging") {
+		t.Error("approving one vault should not approve another")
+	}
+
+	// Approvals from another session, or that are too old, do not count.
+	path := filepath.Join(runtimeDir, "shhh", "approvals-"+session.ID())
+	stale := fmt.Sprintf("%d\t/project\tstaging\n", time.Now().Add(-session.MaxAge-time.Minute).Unix())
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(stale)
+	f.Close()
+	if session.Approved("/project\tstaging") {
+		t.Error("an approval older than MaxAge should have expired")
+	}
+	os.Chmod(filepath.Join(runtimeDir, "shhh"), 0755)
+	if session.Approved(scope) {
+		t.Error("approvals in a directory others can read should be ignored")
+	}
+	os.Chmod(filepath.Join(runtimeDir, "shhh"), 0700)
+
+	if err := session.Revoke(); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if session.Approved(scope) {
+		t.Error("Approved() = true after Revoke()")
+	}
+
+	cfg := config.NewConfig()
+	if !cfg.Set("confirm_decrypt", "true") || !cfg.ConfirmDecrypt {
+		t.Error("confirm_decrypt should be settable")
+	}
+}
+
+func TestFileSettingsRoundTrip(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-settings-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	for _, name := range []string{store.DefaultVault, "prod"} {
+		vault := config.NewVault()
+		vault.AddUser(config.User{Email: "alice@test.com", KeyID: "ALICE"})
+		vault.AddUser(config.User{Email: "bob@test.com", KeyID: "BOB"})
+		if err := vault.Save(s, name); err != nil {
+			t.Fatalf("failed to save vault: %v", err)
+		}
+	}
+	for _, path := range []string{"app.yaml", "cert.pem", "old.env"} {
+		if err := config.RegisterFile(s, store.DefaultVault, path, "", nil); err != nil {
+			t.Fatalf("RegisterFile(%s) error = %v", path, err)
+		}
+	}
+
+	doc, err := config.ExportSettings(s)
+	if err != nil {
+		t.Fatalf("ExportSettings() error = %v", err)
+	}
+	data, err := yaml.Marshal(doc)
+	if err != nil {
+		t.Fatalf("failed to marshal settings: %v", err)
+	}
+
+	// An unchanged export applies as a no-op.
+	doc, err = config.ParseSettings(data)
+	if err != nil {
+		t.Fatalf("ParseSettings() error = %v", err)
+	}
+	changes, err := config.ApplySettings(s, doc, false, false)
+	if err != nil || len(changes) != 0 {
+		t.Fatalf("ApplySettings() of an unchanged export = %+v, %v", changes, err)
+	}
+
+	// Move cert.pem to prod, restrict app.yaml, register new.json and
+	// leave old.env out.
+	doc.Vaults[store.DefaultVault] = []config.FileSettings{
+		{Path: "app.yaml", Mode: config.ModeValues, Recipients: []string{"alice@test.com"}},
+	}
+	doc.Vaults["prod"] = []config.FileSettings{
+		{Path: "cert.pem", Mode: config.ModeFull},
+		{Path: "new.json"},
+	}
+
+	// Rejected documents change nothing.
+	bad := &config.SettingsDocument{Format: config.SettingsFormat, Vaults: map[string][]config.FileSettings{
+		"prod": {{Path: "cert.pem", Recipients: []string{"carol@test.com"}}},
+	}}
+	if _, err := config.ApplySettings(s, bad, false, false); err == nil {
+		t.Error("ApplySettings() should reject recipients who are not vault users")
+	}
+	if _, err := config.ParseSettings(append(data, "unknown: true\n"...)); err == nil {
+		t.Error("ParseSettings() should reject unknown keys")
+	}
+
+	changes, err = config.ApplySettings(s, doc, false, true)
+	if err != nil {
+		t.Fatalf("ApplySettings() dry run error = %v", err)
+	}
+	if defaultVault, _ := config.LoadVault(s, store.DefaultVault); !defaultVault.HasFile("cert.pem") {
+		t.Error("a dry run should not change registrations")
+	}
+
+	changes, err = config.ApplySettings(s, doc, false, false)
+	if err != nil {
+		t.Fatalf("ApplySettings() error = %v", err)
+	}
+	actions := map[string]string{}
+	for _, c := range changes {
+		actions[c.Path] = c.Action
+	}
+	want := map[string]string{"app.yaml": "update", "cert.pem": "move", "new.json": "register", "old.env": "unlisted"}
+	for path, action := range want {
+		if actions[path] != action {
+			t.Errorf("%s: action = %q, want %q", path, actions[path], action)
+		}
+	}
+
+	defaultVault, _ := config.LoadVault(s, store.DefaultVault)
+	prodVault, _ := config.LoadVault(s, "prod")
+	if got := defaultVault.GetFile("app.yaml").Recipients; len(got) != 1 || got[0] != "alice@test.com" {
+		t.Errorf("app.yaml recipients = %v", got)
+	}
+	if defaultVault.HasFile("cert.pem") || prodVault.GetFile("cert.pem") == nil || prodVault.GetFile("cert.pem").Mode != config.ModeFull {
+		t.Error("cert.pem should have moved to prod in full mode")
+	}
+	if f := prodVault.GetFile("new.json"); f == nil || f.Mode != config.ModeValues {
+		t.Error("new.json should be registered in prod in values mode")
+	}
+	if !defaultVault.HasFile("old.env") {
+		t.Error("old.env should stay registered without --prune")
+	}
+
+	changes, err = config.ApplySettings(s, doc, true, false)
+	if err != nil || len(changes) != 1 || changes[0].Action != "unregister" {
+		t.Errorf("ApplySettings() with prune = %+v, %v", changes, err)
+	}
+}
+
+func TestPruneAbortsOnBrokenVault(t *testing.T) {
+	dir := t.TempDir()
+
+	setupTestGPG(t)
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if err := config.NewVault().Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := s.CreateVault("ops"); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := config.RegisterFile(s, "ops", "db.yaml", "values", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+
+	encrypted, err := crypto.EncryptFileContent([]byte("password: hunter2\n"), "db.yaml", crypto.EncryptOptions{
+		Vault:      "ops",
+		Mode:       "values",
+		Recipients: []string{"alice@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	encPath := filepath.Join(dir, "db.yaml.enc")
+	if err := os.WriteFile(encPath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A vault that fails to load must not make its files look unregistered.
+	if err := os.WriteFile(s.VaultConfigPath("ops"), []byte("files: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runShhh(t, dir, nil, "prune", "--force")
+	if err == nil {
+		t.Errorf("expected prune to fail with a broken vault, got:\n%s", out)
+	}
+	if !strings.Contains(out, "failed to load vault ops") {
+		t.Errorf("expected the vault load error, got:\n%s", out)
+	}
+	if !fileExists(encPath) {
+		t.Error("prune deleted the ciphertext of a file registered in a broken vault")
+	}
+}
+
+func TestCIVerifyJSONLiterals(t *testing.T) {
+	dir := t.TempDir()
+
+	setupTestGPG(t)
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "app.json", "values", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+
+	content := []byte(`{"password": "hunter2", "port": 8080, "debug": true, "proxy": null}`)
+	encrypted, err := crypto.EncryptFileContent(content, "app.json", crypto.EncryptOptions{
+		Vault:      store.DefaultVault,
+		Mode:       "values",
+		Recipients: []string{"alice@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	encPath := filepath.Join(dir, "app.json.enc")
+	if err := os.WriteFile(encPath, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := parser.FlattenFile(encrypted, "app.json", parser.Spec{})
+	if err != nil {
+		t.Fatalf("FlattenFile() error = %v", err)
+	}
+	for _, v := range values {
+		if literal := v.Key == "port" || v.Key == "debug"; v.Literal != literal {
+			t.Errorf("%s: Literal = %v, want %v", v.Key, v.Literal, literal)
+		}
+	}
+
+	// Numbers and booleans are never encrypted, so they are not plaintext leaks.
+	out, err := runShhh(t, dir, nil, "ci", "verify")
+	if err != nil {
+		t.Errorf("ci verify failed: %v\n%s", err, out)
+	}
+
+	leaked := strings.Replace(string(encrypted), `"port": 8080`, `"port": 8080, "token": "s3cret"`, 1)
+	if leaked == string(encrypted) {
+		t.Fatalf("unexpected encrypted JSON:\n%s", encrypted)
+	}
+	if err := os.WriteFile(encPath, []byte(leaked), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err = runShhh(t, dir, nil, "ci", "verify")
+	if err == nil || !strings.Contains(out, "plaintext value at token") {
+		t.Errorf("expected a plaintext string to fail verification, got %v:\n%s", err, out)
+	}
+}
+
+func TestRenderTreeOutput(t *testing.T) {
+	dir := t.TempDir()
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if err := config.NewVault().Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "manifests"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "manifests", "app.yaml"), []byte("replicas: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := runShhh(t, dir, []string{config.ReadonlyEnv + "=1"}, "render-tree", "--in", "manifests", "--out", "rendered")
+	if err == nil || !strings.Contains(out, "readonly mode") {
+		t.Errorf("expected readonly mode to refuse an in-project --out, got %v:\n%s", err, out)
+	}
+	if fileExists(filepath.Join(dir, "rendered")) {
+		t.Error("render-tree wrote to --out in readonly mode")
+	}
+
+	if out, err := runShhh(t, dir, nil, "render-tree", "--in", "manifests", "--out", "rendered"); err != nil {
+		t.Fatalf("render-tree failed: %v\n%s", err, out)
+	}
+	if !fileExists(filepath.Join(dir, "rendered", "app.yaml")) {
+		t.Error("render-tree did not copy app.yaml")
+	}
+	if !gitignore.LoadMatcher(dir, "rendered/app.yaml").Ignored("rendered/app.yaml") {
+		t.Error("expected an in-project --out to be ignored by git")
+	}
+}
+
+func TestCIExportCommands(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+	dir := t.TempDir()
+
+	_, alice := setupTestGPG(t)
+
+	home := t.TempDir()
+	writeKeyring(t, home, alice)
+	env := []string{"GNUPGHOME=" + home}
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "values", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+
+	secret := "it's $HOME `s3cret`"
+	content := []byte("db:\n  password: " + strconv.Quote(secret) + "\n")
+	encrypted, err := crypto.EncryptFileContent(content, "app.yaml", crypto.EncryptOptions{
+		Vault:      store.DefaultVault,
+		Mode:       "values",
+		Recipients: []string{"alice@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml.enc"), encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Fake CLIs record their arguments and the value they read on stdin.
+	bin := t.TempDir()
+	record := t.TempDir()
+	for _, name := range []string{"gh", "glab"} {
+		script := "#!/bin/sh\necho \"$@\" > " + filepath.Join(record, name+".args") + "\ncat > " + filepath.Join(record, name+".stdin") + "\n"
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, format := range []string{"github", "gitlab"} {
+		out, err := runShhh(t, dir, env, "ci", "export", "--all", "--format", format)
+		if err != nil {
+			t.Fatalf("ci export --format %s failed: %v\n%s", format, err, out)
+		}
+		sh := exec.Command("sh")
+		sh.Stdin = strings.NewReader(out)
+		sh.Env = append(os.Environ(), "PATH="+bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+		if shOut, err := sh.CombinedOutput(); err != nil {
+			t.Fatalf("running the %s commands failed: %v\n%s", format, err, shOut)
+		}
+
+		name := map[string]string{"github": "gh", "gitlab": "glab"}[format]
+		args, _ := os.ReadFile(filepath.Join(record, name+".args"))
+		if strings.Contains(string(args), "s3cret") || !strings.Contains(string(args), "DB_PASSWORD") {
+			t.Errorf("%s was run with arguments %q", name, args)
+		}
+		if got, _ := os.ReadFile(filepath.Join(record, name+".stdin")); string(got) != secret {
+			t.Errorf("%s read %q on stdin, want %q", name, got, secret)
+		}
+	}
+
+	// A vault that fails to load must not be left out of --all silently.
+	if err := s.CreateVault("ops"); err != nil {
+		t.Fatalf("failed to create vault: %v", err)
+	}
+	if err := os.WriteFile(s.VaultConfigPath("ops"), []byte("files: [\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	out, err := runShhh(t, dir, env, "ci", "export", "--all")
+	if err == nil || !strings.Contains(out, "failed to load vault ops") {
+		t.Errorf("expected ci export --all to fail on a broken vault, got %v:\n%s", err, out)
+	}
+}
+
+func TestEditTmpfile(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memfd_create is Linux only")
+	}
+	dir := t.TempDir()
+
+	_, alice := setupTestGPG(t)
+
+	home := t.TempDir()
+	writeKeyring(t, home, alice)
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "values", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+	encrypted, err := crypto.EncryptFileContent([]byte("password: hunter2\n"), "app.yaml", crypto.EncryptOptions{
+		Vault:      store.DefaultVault,
+		Mode:       "values",
+		Recipients: []string{"alice@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml.enc"), encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The editors record the path they were given and append a line in place.
+	bin := t.TempDir()
+	script := "#!/bin/sh\necho \"$1\" > " + filepath.Join(bin, "path") + "\necho \"n$$: x\" >> \"$1\"\n"
+	for _, name := range []string{"vi", "emacs"} {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		editor, mode string
+		inMemory     bool
+	}{
+		{"vi", "", true},
+		{"emacs", "", false},
+		{"emacs", config.EditTmpfileMemfd, true},
+		{"vi", config.EditTmpfileDir, false},
+	}
+	for _, tt := range tests {
+		cfg := config.NewConfig()
+		cfg.EditTmpfile = tt.mode
+		if err := cfg.Save(s); err != nil {
+			t.Fatalf("failed to save config: %v", err)
+		}
+		env := []string{"GNUPGHOME=" + home, "VISUAL=", "EDITOR=" + filepath.Join(bin, tt.editor)}
+		if out, err := runShhh(t, dir, env, "edit", "app.yaml"); err != nil || !strings.Contains(out, "Updated app.yaml.enc") {
+			t.Fatalf("%s with edit_tmpfile %q: edit failed: %v\n%s", tt.editor, tt.mode, err, out)
+		}
+		path, err := os.ReadFile(filepath.Join(bin, "path"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inMemory := strings.HasPrefix(string(path), "/proc/"); inMemory != tt.inMemory {
+			t.Errorf("%s with edit_tmpfile %q edited %s, want in memory %v", tt.editor, tt.mode, path, tt.inMemory)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+
+	_, alice := setupTestGPG(t)
+
+	home := t.TempDir()
+	writeKeyring(t, home, alice)
+	env := []string{"GNUPGHOME=" + home}
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "values", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+
+	plainPath := filepath.Join(dir, "app.yaml")
+	encPath := plainPath + ".enc"
+	runSync := func(want string) string {
+		t.Helper()
+		out, err := runShhh(t, dir, env, "sync")
+		if err != nil {
+			t.Fatalf("sync failed: %v\n%s", err, out)
+		}
+		if !strings.Contains(out, want) {
+			t.Errorf("expected sync to report %q, got:\n%s", want, out)
+		}
+		return out
+	}
+	later := func(path string, d time.Duration) {
+		t.Helper()
+		at := time.Now().Add(d)
+		if err := os.Chtimes(path, at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Formatted unlike the values-mode round trip, so plaintext and
+	// decryption differ byte for byte even when nothing was edited.
+	plaintext := []byte("db:\n    host: \"localhost\"   # primary\n    pass: hunter2\n")
+	if err := os.WriteFile(plainPath, plaintext, 0600); err != nil {
+		t.Fatal(err)
+	}
+	runSync("1 encrypted, 0 decrypted, 0 up to date")
+	encrypted, err := os.ReadFile(encPath)
+	if err != nil {
+		t.Fatalf("sync did not write the .enc: %v", err)
+	}
+
+	// Unchanged plaintext is left alone, and not reported as a conflict
+	// although the .enc is newer.
+	later(encPath, time.Minute)
+	out := runSync("0 encrypted, 0 decrypted, 1 up to date")
+	if strings.Contains(out, "differs from plaintext") {
+		t.Errorf("unchanged plaintext reported as a conflict:\n%s", out)
+	}
+	if got, _ := os.ReadFile(encPath); !bytes.Equal(got, encrypted) {
+		t.Error("sync re-encrypted unchanged plaintext")
+	}
+
+	// Edited plaintext is encrypted.
+	edited := bytes.Replace(plaintext, []byte("hunter2"), []byte("hunter3"), 1)
+	if err := os.WriteFile(plainPath, edited, 0600); err != nil {
+		t.Fatal(err)
+	}
+	later(plainPath, 2*time.Minute)
+	runSync("1 encrypted, 0 decrypted, 0 up to date")
+	encrypted, _ = os.ReadFile(encPath)
+	if decrypted, err := crypto.DecryptFileContent(encrypted, "app.yaml", parser.Spec{}); err != nil || !crypto.SamePlaintext(edited, decrypted, "app.yaml", parser.Spec{}) {
+		t.Errorf("sync did not encrypt the edit: %v\n%s", err, decrypted)
+	}
+
+	// A pulled .enc that differs from local plaintext is a conflict.
+	pulled, err := crypto.EncryptFileContent([]byte("db:\n  host: localhost\n  pass: pulled\n"), "app.yaml", crypto.EncryptOptions{
+		Vault:      store.DefaultVault,
+		Mode:       "values",
+		Recipients: []string{"alice@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if err := os.WriteFile(encPath, pulled, 0600); err != nil {
+		t.Fatal(err)
+	}
+	later(encPath, 3*time.Minute)
+	runSync(".enc changed and differs from plaintext")
+	if got, _ := os.ReadFile(plainPath); !bytes.Equal(got, edited) {
+		t.Error("sync overwrote plaintext in a conflict")
+	}
+
+	// Without plaintext, the pulled .enc is decrypted.
+	if err := os.Remove(plainPath); err != nil {
+		t.Fatal(err)
+	}
+	runSync("0 encrypted, 1 decrypted, 0 up to date")
+	if got, _ := os.ReadFile(plainPath); !strings.Contains(string(got), "pass: pulled") {
+		t.Errorf("sync decrypted %q", got)
+	}
+}
+
+func TestRestoredFileModeIsPrivate(t *testing.T) {
+	dir := t.TempDir()
+
+	_, alice := setupTestGPG(t)
+
+	home := t.TempDir()
+	writeKeyring(t, home, alice)
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	cfg := config.NewConfig()
+	cfg.PreservePermissions = true
+	if err := cfg.Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	vault := config.NewVault()
+	vault.AddUser(config.User{Email: "alice@test.com"})
+	if err := vault.Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "deploy.sh", "full", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+
+	// Committed metadata asking for a world-readable plaintext.
+	encrypted, err := crypto.EncryptFileContent([]byte("#!/bin/sh\necho $TOKEN\n"), "deploy.sh", crypto.EncryptOptions{
+		Vault:      store.DefaultVault,
+		Mode:       "full",
+		Recipients: []string{"alice@test.com"},
+		FileMode:   0755,
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "deploy.sh.enc"), encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := runShhh(t, dir, []string{"GNUPGHOME=" + home}, "decrypt", "deploy.sh"); err != nil {
+		t.Fatalf("decrypt failed: %v\n%s", err, out)
+	}
+	info, err := os.Stat(filepath.Join(dir, "deploy.sh"))
+	if err != nil {
+		t.Fatalf("decrypt did not write the plaintext: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("restored mode = %#o, want 0700", info.Mode().Perm())
+	}
+}
+
+func TestEncryptAdhocOnlyFlags(t *testing.T) {
+	dir := t.TempDir()
+
+	s := store.New(dir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if err := config.NewVault().Save(s, store.DefaultVault); err != nil {
+		t.Fatalf("failed to save vault: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "app.yaml"), []byte("password: hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.RegisterFile(s, store.DefaultVault, "app.yaml", "full", nil); err != nil {
+		t.Fatalf("failed to register file: %v", err)
+	}
+
+	for _, args := range [][]string{
+		{"--mode", "values"},
+		{"--recipients", "bob@test.com"},
+		{"--recipients-file", "recipients.txt"},
+	} {
+		out, err := runShhh(t, dir, nil, append([]string{"encrypt", "app.yaml"}, args...)...)
+		if err == nil || !strings.Contains(out, "can only be used with --adhoc") {
+			t.Errorf("encrypt %v: expected a refusal, got %v:\n%s", args, err, out)
+		}
+	}
+	if fileExists(filepath.Join(dir, "app.yaml.enc")) {
+		t.Error("encrypt wrote a .enc despite an --adhoc only flag")
+	}
+}
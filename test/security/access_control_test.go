@@ -1,9 +1,15 @@
 package security
 
 import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/cychiuae/shhh/internal/crypto"
 )
 
@@ -164,3 +170,104 @@ func TestSingleRecipientExcludesOthers(t *testing.T) {
 
 	crypto.SetProvider(nil)
 }
+
+func TestEncryptionSkipsUnusableKeys(t *testing.T) {
+	// An old key for alice that expired a year ago, and her current key.
+	old, err := openpgp.NewEntity("Alice", "Old key", "alice@test.com", &packet.Config{
+		Time:            func() time.Time { return time.Now().AddDate(-2, 0, 0) },
+		KeyLifetimeSecs: 86400,
+	})
+	if err != nil {
+		t.Fatalf("failed to create old entity: %v", err)
+	}
+	current, err := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create current entity: %v", err)
+	}
+
+	gpg := crypto.NewNativeGPG()
+	gpg.AddEntity(old)
+	gpg.AddEntity(current)
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+
+	encrypted, err := crypto.EncryptValue("secret", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+
+	currentGPG := crypto.NewNativeGPG()
+	currentGPG.AddEntity(current)
+	crypto.SetProvider(currentGPG)
+	if decrypted, err := crypto.DecryptValue(encrypted); err != nil || decrypted != "secret" {
+		t.Errorf("current key should decrypt, got %q, %v", decrypted, err)
+	}
+
+	// A key without an encryption subkey cannot be encrypted to.
+	signOnly, err := openpgp.NewEntity("Bob", "Test User", "bob@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create bob entity: %v", err)
+	}
+	signOnly.Subkeys = nil
+	signOnlyGPG := crypto.NewNativeGPG()
+	signOnlyGPG.AddEntity(signOnly)
+	crypto.SetProvider(signOnlyGPG)
+
+	_, err = crypto.EncryptValue("secret", []string{"bob@test.com"})
+	if err == nil || !strings.Contains(err.Error(), "no valid encryption subkey") {
+		t.Errorf("expected a sign-only key to be rejected, got %v", err)
+	}
+}
+
+func TestDecryptionKeyHints(t *testing.T) {
+	alice, err := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create alice entity: %v", err)
+	}
+	charlie, err := openpgp.NewEntity("Charlie", "Test User", "charlie@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create charlie entity: %v", err)
+	}
+	aliceKeyID := fmt.Sprintf("%016X", alice.Subkeys[0].PublicKey.KeyId)
+	charlieKeyID := fmt.Sprintf("%016X", charlie.PrimaryKey.KeyId)
+
+	aliceGPG := crypto.NewNativeGPG()
+	aliceGPG.AddEntity(alice)
+	crypto.SetProvider(aliceGPG)
+	defer crypto.SetProvider(nil)
+
+	encrypted, err := crypto.EncryptValue("secret", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+
+	// Charlie holds his own private key and only alice's public key.
+	var alicePublic openpgp.Entity = *alice
+	alicePublic.PrivateKey = nil
+	alicePublic.Subkeys = append([]openpgp.Subkey{}, alice.Subkeys...)
+	for i := range alicePublic.Subkeys {
+		alicePublic.Subkeys[i].PrivateKey = nil
+	}
+	charlieGPG := crypto.NewNativeGPG()
+	charlieGPG.AddEntity(charlie)
+	charlieGPG.AddEntity(&alicePublic)
+	crypto.SetProvider(charlieGPG)
+
+	_, err = crypto.DecryptValue(encrypted)
+	if !errors.Is(err, crypto.ErrNoPrivateKey) {
+		t.Fatalf("expected ErrNoPrivateKey, got %v", err)
+	}
+	var noKey *crypto.NoKeyError
+	if !errors.As(err, &noKey) {
+		t.Fatalf("expected a NoKeyError, got %T", err)
+	}
+	if len(noKey.EncryptedFor) != 1 || noKey.EncryptedFor[0] != aliceKeyID {
+		t.Errorf("EncryptedFor = %v, want [%s]", noKey.EncryptedFor, aliceKeyID)
+	}
+	if !slices.Contains(noKey.Available, charlieKeyID) || slices.Contains(noKey.Available, aliceKeyID) {
+		t.Errorf("Available = %v, want charlie's keys only", noKey.Available)
+	}
+	if hint := noKey.Hint(); !strings.Contains(hint, aliceKeyID+" (Alice (Test User) <alice@test.com>)") {
+		t.Errorf("hint %q does not name alice's key", hint)
+	}
+}
@@ -2,6 +2,9 @@ package security
 
 import (
 	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -141,7 +144,7 @@ func TestFullFileEncryption(t *testing.T) {
 		t.Error("encrypted file contains plaintext secret")
 	}
 
-	decrypted, err := crypto.DecryptFileContent(encrypted, "test.txt")
+	decrypted, err := crypto.DecryptFileContent(encrypted, "test.txt", parser.Spec{})
 	if err != nil {
 		t.Fatalf("decryption failed: %v", err)
 	}
@@ -185,6 +188,92 @@ func TestValueModePreservesStructure(t *testing.T) {
 	}
 }
 
+func TestDecryptionKeyRecorded(t *testing.T) {
+	gpg, cleanup := setupTestGPGWithBob(t)
+	defer cleanup()
+	crypto.SetProvider(gpg)
+
+	type record struct {
+		what string
+		keys []crypto.DecryptionKey
+	}
+	var records []record
+	crypto.SetDecryptionRecorder(func(what string, keys []crypto.DecryptionKey) {
+		records = append(records, record{what, keys})
+	})
+	defer crypto.SetDecryptionRecorder(nil)
+
+	content := []byte("database:\n  password: secret123\n  user: admin\n")
+	encrypted, err := crypto.EncryptFileContent(content, "app.yaml", crypto.EncryptOptions{
+		Vault:      "default",
+		Mode:       "values",
+		Recipients: []string{"bob@test.com"},
+	})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if _, err := crypto.DecryptFileContent(encrypted, "app.yaml", parser.Spec{}); err != nil {
+		t.Fatalf("decryption failed: %v", err)
+	}
+
+	// One record for the file, not one per value.
+	if len(records) != 1 || records[0].what != "app.yaml" || len(records[0].keys) != 1 {
+		t.Fatalf("records = %+v, want one for app.yaml with one key", records)
+	}
+	key := records[0].keys[0]
+	if key.Email != "bob@test.com" {
+		t.Errorf("Email = %q, want bob@test.com", key.Email)
+	}
+	if key.KeyID == "" || key.KeyID == key.PrimaryKeyID {
+		t.Errorf("KeyID = %q, want the encryption subkey of %s", key.KeyID, key.PrimaryKeyID)
+	}
+
+	if _, err := crypto.DecryptFileContent([]byte("password: ENC[v1:bm90LWdwZw==]\n"), "bad.yaml", parser.Spec{}); err == nil {
+		t.Fatal("decrypting garbage should fail")
+	}
+	if len(records) != 1 {
+		t.Errorf("failed decryption was recorded: %+v", records[1:])
+	}
+}
+
+func TestDecryptGateCoversValues(t *testing.T) {
+	gpg, cleanup := setupTestGPG(t)
+	defer cleanup()
+	crypto.SetProvider(gpg)
+
+	token, err := crypto.EncryptValue("secret123", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("EncryptValue() error = %v", err)
+	}
+	content := []byte("password: " + token + "\n")
+
+	var asked []string
+	crypto.SetDecryptGate(func(filename string, content []byte) error {
+		asked = append(asked, filename)
+		return errors.New("not approved")
+	})
+	defer crypto.SetDecryptGate(nil)
+
+	// decrypt-value decrypts tokens on their own.
+	if plaintext, err := crypto.DecryptValue(token); err == nil || plaintext != "" {
+		t.Errorf("DecryptValue() = %q, %v; want the gate's refusal", plaintext, err)
+	}
+	if _, err := crypto.DecryptFileContent(content, "app.yaml", parser.Spec{}); err == nil {
+		t.Error("DecryptFileContent() should be refused")
+	}
+	if _, err := crypto.ValueDigest(token, "app.yaml"); err == nil {
+		t.Error("ValueDigest() should be refused")
+	}
+	if len(asked) != 3 || asked[0] != "" || asked[1] != "app.yaml" {
+		t.Errorf("gate asked about %q", asked)
+	}
+
+	crypto.SetDecryptGate(nil)
+	if plaintext, err := crypto.DecryptValue(token); err != nil || plaintext != "secret123" {
+		t.Errorf("DecryptValue() without a gate = %q, %v", plaintext, err)
+	}
+}
+
 // Test helper functions
 
 func setupTestGPG(t *testing.T) (*crypto.NativeGPG, func()) {
@@ -225,3 +314,39 @@ func setupTestGPGWithBob(t *testing.T) (*crypto.NativeGPG, func()) {
 		crypto.SetProvider(nil)
 	}
 }
+
+func TestCLIOptions(t *testing.T) {
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	fakeGPG := filepath.Join(dir, "fake-gpg")
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > " + argsFile + "\ncat\n"
+	if err := os.WriteFile(fakeGPG, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake gpg: %v", err)
+	}
+
+	defer crypto.SetCLIOptions(crypto.CLIOptions{})
+
+	encrypt := func(opts crypto.CLIOptions) []string {
+		crypto.SetCLIOptions(opts)
+		if _, err := crypto.NewCLIGPG().Encrypt([]byte("secret"), []string{"alice@test.com"}); err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		data, _ := os.ReadFile(argsFile)
+		return strings.Fields(string(data))
+	}
+
+	args := encrypt(crypto.CLIOptions{Binary: fakeGPG, Args: []string{"--no-options"}, TrustModel: "always"})
+	if args[0] != "--no-options" {
+		t.Errorf("extra args not passed first: %v", args)
+	}
+	if !strings.Contains(strings.Join(args, " "), "--trust-model always") {
+		t.Errorf("trust model not passed: %v", args)
+	}
+
+	args = encrypt(crypto.CLIOptions{Binary: fakeGPG})
+	for _, a := range args {
+		if a == "--trust-model" {
+			t.Errorf("trust model forced although unset: %v", args)
+		}
+	}
+}
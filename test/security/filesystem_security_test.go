@@ -1,12 +1,16 @@
 package security
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/cychiuae/shhh/internal/config"
 	"github.com/cychiuae/shhh/internal/gitignore"
+	"github.com/cychiuae/shhh/internal/scan"
+	"github.com/cychiuae/shhh/internal/secmem"
 	"github.com/cychiuae/shhh/internal/store"
 )
 
@@ -58,6 +62,42 @@ func TestPathTraversalPrevention(t *testing.T) {
 	}
 }
 
+func TestRegisteredPathsAreNormalized(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-paths-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+
+	// A registration written on Windows.
+	windowsVault := "users: []\nfiles:\n  - path: config\\prod\\secrets.yaml\n    mode: values\n"
+	if err := os.WriteFile(s.VaultConfigPath(store.DefaultVault), []byte(windowsVault), 0600); err != nil {
+		t.Fatalf("failed to write vault: %v", err)
+	}
+
+	vault, err := config.LoadVault(s, store.DefaultVault)
+	if err != nil {
+		t.Fatalf("failed to load vault: %v", err)
+	}
+
+	if got := vault.Files[0].Path; got != "config/prod/secrets.yaml" {
+		t.Errorf("path = %q, want forward slashes", got)
+	}
+
+	if _, _, err := config.FindFileVault(s, filepath.Join("config", "prod", "secrets.yaml")); err != nil {
+		t.Errorf("failed to find file by OS path: %v", err)
+	}
+
+	if err := config.ValidateFilePath(`..\outside.yaml`); err == nil {
+		t.Error("expected error for backslash parent traversal")
+	}
+}
+
 func TestGitignoreEnsureIgnored(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "shhh-gitignore-test-*")
 	if err != nil {
@@ -122,6 +162,110 @@ node_modules/
 	}
 }
 
+func TestGitignoreSemantics(t *testing.T) {
+	m := gitignore.NewMatcher(gitignore.ParsePatterns([]string{
+		"# comment",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/config/*.yaml",
+		"!/config/public.yaml",
+		"secrets/**/prod.env",
+	}, ""))
+
+	tests := []struct {
+		path    string
+		ignored bool
+	}{
+		{"debug.log", true},
+		{"nested/dir/debug.log", true},
+		{"keep.log", false},
+		{"build/output.bin", true},
+		{"src/build/output.bin", true},
+		{"config/app.yaml", true},
+		{"config/public.yaml", false},
+		{"other/config/app.yaml", false},
+		{"secrets/prod.env", true},
+		{"secrets/eu/west/prod.env", true},
+		{"secrets/dev.env", false},
+	}
+
+	for _, tt := range tests {
+		if got := m.Ignored(tt.path); got != tt.ignored {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.ignored)
+		}
+	}
+}
+
+func TestGitignoreManagedBlock(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-gitignore-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	gitignorePath := filepath.Join(tmpDir, ".gitignore")
+	os.WriteFile(gitignorePath, []byte("*.log\n/legacy.yaml\n"), 0644)
+
+	for _, f := range []string{"a.yaml", "legacy.yaml", "a.yaml", "debug.log"} {
+		if err := gitignore.EnsureIgnored(tmpDir, f); err != nil {
+			t.Fatalf("failed to ensure ignored: %v", err)
+		}
+	}
+
+	content, _ := os.ReadFile(gitignorePath)
+	want := "*.log\n\n# BEGIN shhh\n/a.yaml\n/legacy.yaml\n# END shhh\n"
+	if string(content) != want {
+		t.Errorf("unexpected .gitignore:\n%s\nwant:\n%s", content, want)
+	}
+
+	gitignore.RemoveIgnored(tmpDir, "a.yaml")
+	gitignore.RemoveIgnored(tmpDir, "legacy.yaml")
+
+	content, _ = os.ReadFile(gitignorePath)
+	if string(content) != "*.log\n" {
+		t.Errorf("expected managed block to be removed, got:\n%s", content)
+	}
+}
+
+func TestShhhignoreSkipsPaths(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-scan-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	files := map[string]string{
+		".shhhignore":              "vendor/\n*.generated.env\n",
+		"config/.env":              "API_KEY=abc\n",
+		"config/app.generated.env": "API_KEY=abc\n",
+		"config/app.yaml.enc":      "ENC[v1:abc]\n",
+		"vendor/lib/secrets.yaml":  "password: x\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tmpDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0600)
+	}
+
+	var seen []string
+	err = scan.Walk(tmpDir, ".", scan.LoadIgnore(tmpDir), func(relPath string) error {
+		seen = append(seen, filepath.ToSlash(relPath))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+
+	if len(seen) != 1 || seen[0] != "config/.env" {
+		t.Errorf("expected only config/.env to be walked, got %v", seen)
+	}
+
+	if _, ok := scan.Candidate(tmpDir, "config/.env"); !ok {
+		t.Error("expected config/.env to be flagged as a secret candidate")
+	}
+}
+
 func TestVaultNameValidation(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "shhh-vault-test-*")
 	if err != nil {
@@ -280,3 +424,110 @@ func TestFindRootErrorsWhenNotInitialized(t *testing.T) {
 		t.Error("FindRoot should error when not initialized")
 	}
 }
+
+func TestSecretMemoryIsWiped(t *testing.T) {
+	secret := []byte("hunter2-password")
+	done := secmem.Protect(secret)
+	done()
+	for i, b := range secret {
+		if b != 0 {
+			t.Fatalf("byte %d not wiped: %q", i, secret)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "shhh-shred-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path := filepath.Join(tmpDir, "secret.txt")
+	if err := os.WriteFile(path, []byte("hunter2"), 0600); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	secmem.ShredFile(path)
+	data, _ := os.ReadFile(path)
+	if !bytes.Equal(data, make([]byte, len("hunter2"))) {
+		t.Errorf("ShredFile left %q", data)
+	}
+}
+
+func TestMemFileHasNoDirectoryEntry(t *testing.T) {
+	mf, err := secmem.NewMemFile("shhh-test", []byte("hunter2"))
+	if err != nil {
+		t.Skipf("anonymous files not supported: %v", err)
+	}
+	defer mf.Close()
+
+	target, err := os.Readlink(mf.Path())
+	if err != nil {
+		t.Fatalf("failed to resolve %s: %v", mf.Path(), err)
+	}
+	if !strings.HasPrefix(target, "/memfd:") {
+		t.Errorf("memfd resolves to %q, expected an anonymous file", target)
+	}
+
+	if err := os.WriteFile(mf.Path(), []byte("rotated"), 0600); err != nil {
+		t.Fatalf("failed to write through path: %v", err)
+	}
+	data, err := mf.ReadAll()
+	if err != nil || string(data) != "rotated" {
+		t.Errorf("ReadAll() = %q, %v", data, err)
+	}
+}
+
+func TestStorePermissionsCheckedAndFixed(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "shhh-perms-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	s := store.New(tmpDir)
+	if err := s.Initialize(); err != nil {
+		t.Fatalf("failed to initialize store: %v", err)
+	}
+	if err := config.NewConfig().Save(s); err != nil {
+		t.Fatalf("failed to save config: %v", err)
+	}
+	if issues, err := s.CheckPermissions(); err != nil || len(issues) != 0 {
+		t.Fatalf("CheckPermissions() of a new store = %+v, %v", issues, err)
+	}
+
+	// A checkout with a 022 umask leaves everything readable by others.
+	os.Chmod(s.ConfigPath(), 0644)
+	os.Chmod(s.ShhhPath(), 0755)
+	os.Chmod(filepath.Join(s.ShhhPath(), store.PubkeysDir), 0777)
+	issues, err := s.CheckPermissions()
+	if err != nil {
+		t.Fatalf("CheckPermissions() error = %v", err)
+	}
+	problems := map[string]string{}
+	for _, issue := range issues {
+		problems[filepath.ToSlash(issue.Path)] = issue.Problem
+	}
+	if problems[".shhh/config.yaml"] != "world-readable" || problems[".shhh"] != "world-readable" {
+		t.Errorf("expected config.yaml and .shhh to be world-readable, got %v", problems)
+	}
+	if problems[".shhh/pubkeys"] != "writable by other users" {
+		t.Errorf("expected pubkeys to be writable by other users, got %v", problems)
+	}
+
+	if err := s.FixPermissions(issues); err != nil {
+		t.Fatalf("FixPermissions() error = %v", err)
+	}
+	if issues, _ := s.CheckPermissions(); len(issues) != 0 {
+		t.Errorf("issues left after FixPermissions(): %+v", issues)
+	}
+	if info, _ := os.Stat(s.ConfigPath()); info.Mode().Perm() != store.FilePerms {
+		t.Errorf("config.yaml mode = %o, want %o", info.Mode().Perm(), store.FilePerms)
+	}
+
+	cfg := config.NewConfig()
+	if cfg.StoreGitValue() != config.StoreGitAny {
+		t.Errorf("default StoreGitValue() = %q, want any", cfg.StoreGitValue())
+	}
+	if !cfg.Set("store_git", "ignored") || cfg.StoreGitValue() != config.StoreGitIgnored {
+		t.Errorf("StoreGitValue() = %q, want ignored", cfg.StoreGitValue())
+	}
+}
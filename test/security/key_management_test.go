@@ -1,9 +1,13 @@
 package security
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 	"github.com/cychiuae/shhh/internal/crypto"
 )
 
@@ -130,3 +134,194 @@ func TestKeyLookupNotFound(t *testing.T) {
 func timePtr(t time.Time) *time.Time {
 	return &t
 }
+
+func TestGenerateKey(t *testing.T) {
+	entity, err := crypto.GenerateKey("carol", "carol@test.com", "ed25519", 365*24*time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	armored, err := crypto.ArmorPublicKey(entity)
+	if err != nil {
+		t.Fatalf("ArmorPublicKey() error = %v", err)
+	}
+	if strings.Contains(string(armored), "PRIVATE KEY") {
+		t.Fatal("public key export contains private key material")
+	}
+
+	// The exported public key alone can encrypt; only the full entity decrypts.
+	public := crypto.NewNativeGPG()
+	info, err := public.ImportPublicKey(armored)
+	if err != nil {
+		t.Fatalf("ImportPublicKey() error = %v", err)
+	}
+	if info.Email != "carol@test.com" || info.ExpiresAt == nil {
+		t.Errorf("unexpected key info: %+v", info)
+	}
+
+	ciphertext, err := public.Encrypt([]byte("secret"), []string{"carol@test.com"})
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, err := public.Decrypt(ciphertext); err == nil {
+		t.Error("decrypted without the private key")
+	}
+
+	private := crypto.NewNativeGPG()
+	private.AddEntity(entity)
+	plaintext, err := private.Decrypt(ciphertext)
+	if err != nil || string(plaintext) != "secret" {
+		t.Errorf("Decrypt() = %q, %v", plaintext, err)
+	}
+
+	if _, err := crypto.GenerateKey("carol", "carol@test.com", "dsa", 0); err == nil {
+		t.Error("expected error for unsupported algorithm")
+	}
+}
+
+func TestProviderMode(t *testing.T) {
+	defer crypto.SetProviderMode(crypto.ProviderAuto)
+
+	if err := crypto.SetProviderMode("bogus"); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+
+	if err := crypto.SetProviderMode(crypto.ProviderCLI); err != nil {
+		t.Fatalf("SetProviderMode() error = %v", err)
+	}
+	if _, ok := crypto.GetProvider().(*crypto.CLIGPG); !ok {
+		t.Errorf("cli mode returned %T", crypto.GetProvider())
+	}
+	if crypto.NativeKeyring() != nil {
+		t.Error("cli mode should not use the native keyring")
+	}
+
+	if err := crypto.SetProviderMode(crypto.ProviderNative); err != nil {
+		t.Fatalf("SetProviderMode() error = %v", err)
+	}
+	if _, ok := crypto.GetProvider().(*crypto.NativeGPG); !ok {
+		t.Errorf("native mode returned %T", crypto.GetProvider())
+	}
+}
+
+func TestCheckRecipients(t *testing.T) {
+	gpg, cleanup := setupTestGPG(t)
+	defer cleanup()
+	crypto.SetProvider(gpg)
+
+	past := time.Now().Add(-48 * time.Hour)
+	expired, err := openpgp.NewEntity("Bob", "", "bob@test.com", &packet.Config{
+		Time:            func() time.Time { return past },
+		KeyLifetimeSecs: 3600,
+	})
+	if err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	gpg.AddEntity(expired)
+
+	revoked, err := openpgp.NewEntity("Carol", "", "carol@test.com", nil)
+	if err != nil {
+		t.Fatalf("failed to create entity: %v", err)
+	}
+	if err := revoked.RevokeKey(packet.KeyCompromised, "", nil); err != nil {
+		t.Fatalf("failed to revoke key: %v", err)
+	}
+	gpg.AddEntity(revoked)
+
+	if err := crypto.CheckRecipients([]string{"alice@test.com"}); err != nil {
+		t.Errorf("valid recipient rejected: %v", err)
+	}
+
+	recipients := []string{"alice@test.com", "bob@test.com", "carol@test.com", "dave@test.com"}
+	err = crypto.CheckRecipients(recipients)
+	var recErr *crypto.RecipientsError
+	if !errors.As(err, &recErr) {
+		t.Fatalf("expected RecipientsError, got %v", err)
+	}
+	want := map[string]string{"bob@test.com": "key expired", "carol@test.com": "key revoked", "dave@test.com": "key not found"}
+	if len(recErr.Problems) != len(want) {
+		t.Fatalf("expected %d problems, got %+v", len(want), recErr.Problems)
+	}
+	for _, p := range recErr.Problems {
+		if !strings.HasPrefix(p.Reason, want[p.Recipient]) {
+			t.Errorf("%s: reason %q, want %q", p.Recipient, p.Reason, want[p.Recipient])
+		}
+	}
+
+	_, err = crypto.EncryptFileContent([]byte("password: secret\n"), "secrets.yaml", crypto.EncryptOptions{Mode: "values", Recipients: recipients})
+	if err == nil || !strings.Contains(err.Error(), "3 recipient(s)") {
+		t.Errorf("encryption should fail listing all bad recipients, got %v", err)
+	}
+}
+
+func TestLockedPrivateKey(t *testing.T) {
+	defer crypto.SetPassphrasePrompt(nil)
+	t.Setenv(crypto.PassphraseEnv, "")
+
+	lockedGPG := func(passphrase string) *crypto.NativeGPG {
+		entity, err := openpgp.NewEntity("Alice", "Test User", "alice@test.com", nil)
+		if err != nil {
+			t.Fatalf("failed to create entity: %v", err)
+		}
+		if err := entity.EncryptPrivateKeys([]byte(passphrase), nil); err != nil {
+			t.Fatalf("failed to lock private keys: %v", err)
+		}
+		gpg := crypto.NewNativeGPG()
+		gpg.AddEntity(entity)
+		return gpg
+	}
+
+	gpg := lockedGPG("first passphrase")
+	crypto.SetProvider(gpg)
+	defer crypto.SetProvider(nil)
+	encrypted, err := crypto.EncryptValue("secret", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+
+	crypto.SetPassphrasePrompt(nil)
+	if _, err := crypto.DecryptValue(encrypted); !errors.Is(err, crypto.ErrKeyLocked) {
+		t.Errorf("expected ErrKeyLocked without a passphrase, got %v", err)
+	}
+
+	asked := 0
+	crypto.SetPassphrasePrompt(func(key string) ([]byte, error) {
+		asked++
+		if !strings.Contains(key, "alice@test.com") {
+			t.Errorf("prompt for %q does not name the key", key)
+		}
+		return []byte("wrong"), nil
+	})
+	if _, err := crypto.DecryptValue(encrypted); !errors.Is(err, crypto.ErrKeyLocked) {
+		t.Errorf("expected ErrKeyLocked for a wrong passphrase, got %v", err)
+	}
+	if asked != 3 {
+		t.Errorf("asked %d times, want 3", asked)
+	}
+
+	asked = 0
+	crypto.SetPassphrasePrompt(func(key string) ([]byte, error) {
+		asked++
+		return []byte("first passphrase"), nil
+	})
+	for range 2 {
+		if decrypted, err := crypto.DecryptValue(encrypted); err != nil || decrypted != "secret" {
+			t.Fatalf("DecryptValue() = %q, %v", decrypted, err)
+		}
+	}
+	if asked != 1 {
+		t.Errorf("asked %d times, want the unlocked key to be reused", asked)
+	}
+
+	// A fresh key with the same passphrase comes from the environment.
+	crypto.SetPassphrasePrompt(nil)
+	t.Setenv(crypto.PassphraseEnv, "second passphrase")
+	crypto.SetProvider(lockedGPG("second passphrase"))
+	encrypted, err = crypto.EncryptValue("secret", []string{"alice@test.com"})
+	if err != nil {
+		t.Fatalf("encryption failed: %v", err)
+	}
+	if decrypted, err := crypto.DecryptValue(encrypted); err != nil || decrypted != "secret" {
+		t.Errorf("DecryptValue() with %s = %q, %v", crypto.PassphraseEnv, decrypted, err)
+	}
+}
@@ -256,6 +256,15 @@ func TestIsEncrypted(t *testing.T) {
 		{"plaintext", false},
 		{"ENC[abc]", false},
 		{"ENC[v2:abc]", false},
+		{parser.EncPrefix + "YWJj\nMTIz" + parser.EncSuffix, true},
+		{parser.EncPrefix + "YWJj|k=4C0668623651ECA4,01E1D31F5313EF71" + parser.EncSuffix, true},
+		{parser.EncPrefix + "YWJj|k=" + parser.EncSuffix, false},
+		{parser.EncPrefix + "YWJj|k=4c06" + parser.EncSuffix, false},
+		{parser.EncPrefix + "YWJj|x=4C06" + parser.EncSuffix, false},
+		{parser.EncPrefix + "|k=4C06" + parser.EncSuffix, false},
+		{parser.EncPrefix + "YW]Jj" + parser.EncSuffix, false},
+		{parser.EncPrefix + "YWJj" + parser.EncSuffix + "x", false},
+		{parser.EncPrefix + parser.EncSuffix, false},
 		{parser.EncPrefix, false},
 		{parser.EncSuffix, false},
 		{"", false},
@@ -315,3 +324,61 @@ func TestFileTypeDetection(t *testing.T) {
 		})
 	}
 }
+
+func TestFlattenValuesSkipsMetadata(t *testing.T) {
+	content := []byte(`database:
+  password: secret
+hosts:
+  - a
+  - b
+_shhh:
+  vault: default
+`)
+
+	values, err := parser.FlattenValues(content, parser.FormatYAML)
+	if err != nil {
+		t.Fatalf("flatten failed: %v", err)
+	}
+
+	want := []parser.KeyValue{
+		{Key: "database.password", Value: "secret"},
+		{Key: "hosts.0", Value: "a"},
+		{Key: "hosts.1", Value: "b"},
+	}
+
+	if len(values) != len(want) {
+		t.Fatalf("expected %d values, got %d: %v", len(want), len(values), values)
+	}
+
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("value %d = %v, want %v", i, values[i], want[i])
+		}
+	}
+}
+
+func TestFlattenJSONNumbers(t *testing.T) {
+	content := []byte(`{"replicas": 1000000, "ratio": 0.25, "limit": 1e21, "debug": false}`)
+
+	values, err := parser.FlattenValues(content, parser.FormatJSON)
+	if err != nil {
+		t.Fatalf("flatten failed: %v", err)
+	}
+
+	want := []parser.KeyValue{
+		{Key: "debug", Value: "false", Literal: true},
+		{Key: "limit", Value: "1000000000000000000000", Literal: true},
+		{Key: "ratio", Value: "0.25", Literal: true},
+		{Key: "replicas", Value: "1000000", Literal: true},
+	}
+
+	if len(values) != len(want) {
+		t.Fatalf("expected %d values, got %d: %v", len(want), len(values), values)
+	}
+
+	for i := range want {
+		if values[i] != want[i] {
+			t.Errorf("value %d = %v, want %v", i, values[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cychiuae/shhh/internal/k8s"
+	"github.com/cychiuae/shhh/internal/parser"
+)
+
+func TestSealedSecretRoundTrip(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+
+	values := []parser.KeyValue{{Key: "database.password", Value: "supersecret123"}}
+	sealed, err := k8s.NewSealedSecret(&priv.PublicKey, "app", "prod", k8s.ScopeStrict, values)
+	if err != nil {
+		t.Fatalf("seal failed: %v", err)
+	}
+
+	encoded, ok := sealed.Spec.EncryptedData["database.password"]
+	if !ok {
+		t.Fatal("sealed secret missing database.password")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("invalid base64: %v", err)
+	}
+
+	rsaLen := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, ciphertext[2:2+rsaLen], []byte("prod/app"))
+	if err != nil {
+		t.Fatalf("failed to unwrap session key: %v", err)
+	}
+
+	block, _ := aes.NewCipher(sessionKey)
+	aed, _ := cipher.NewGCM(block)
+	plaintext, err := aed.Open(nil, make([]byte, aed.NonceSize()), ciphertext[2+rsaLen:], nil)
+	if err != nil {
+		t.Fatalf("failed to open sealed value: %v", err)
+	}
+
+	if string(plaintext) != "supersecret123" {
+		t.Errorf("unsealed value %q does not match", plaintext)
+	}
+
+	if _, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, ciphertext[2:2+rsaLen], []byte("other/app")); err == nil {
+		t.Error("strict scope ciphertext should not unseal under a different name")
+	}
+}